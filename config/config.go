@@ -0,0 +1,215 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Server settings. Defaults match the production deployment and can be
+// overridden by a JSON file at $FITM_CONFIG_FILE and then by individual
+// $FITM_* env vars (env wins).
+type Config struct {
+	ListenAddr  string     `json:"listen_addr"`
+	TLS         bool       `json:"tls"`
+	TLSCertFile string     `json:"tls_cert_file"`
+	TLSKeyFile  string     `json:"tls_key_file"`
+	RateLimits  RateLimits `json:"rate_limits"`
+	CORSOrigins []string   `json:"cors_origins"`
+	// empty: db/fitm.db
+	DBPath string `json:"db_path"`
+	// time allowed for in-flight requests to finish after SIGTERM/SIGINT
+	// (update_and_restart_backend.sh sends SIGKILL after 10s)
+	ShutdownTimeoutSeconds int `json:"shutdown_timeout_seconds"`
+	// may manage server-side data such as cat aliases
+	AdminLoginNames []string `json:"admin_login_names"`
+	// see handler/util GLOBAL_CATS_STRATEGIES
+	GlobalCatsStrategy string `json:"global_cats_strategy"`
+	// how often global cats, global summaries and spellfix ranks are
+	// recomputed for all links (<= 0 disables; can still be triggered
+	// by admins)
+	RecomputeIntervalMinutes int `json:"recompute_interval_minutes"`
+	// links per recompute transaction
+	RecomputeBatchSize int `json:"recompute_batch_size"`
+	// rebuild global_cats_spellfix from Links.global_cats before serving
+	// (also done after every recompute)
+	ReconcileSpellfixOnStartup bool `json:"reconcile_spellfix_on_startup"`
+	// how long anonymous GET /links, /cats, /contributors, /totals and
+	// /feeds responses are cached unless a write clears them first (<= 0
+	// disables)
+	ResponseCacheSeconds int `json:"response_cache_seconds"`
+	// how often each link is re-fetched to update its health (<= 0
+	// disables the link checker; dead links are checked less often: see
+	// handler/util GetNextLinkCheckDelay)
+	LinkCheckIntervalHours int `json:"link_check_interval_hours"`
+	// minimum time between link checker requests to the same host
+	LinkCheckHostDelaySeconds int `json:"link_check_host_delay_seconds"`
+}
+
+// Values <= 0 disable the corresponding limiter
+type RateLimits struct {
+	AllPerMinute    int `json:"all_per_minute"`
+	IPPerMinute     int `json:"ip_per_minute"`
+	IPPerSecond     int `json:"ip_per_second"`
+	ClicksPerSecond int `json:"clicks_per_second"`
+}
+
+var Current *Config
+
+func init() {
+	var err error
+	Current, err = Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func Default() *Config {
+	return &Config{
+		ListenAddr:  "api.fitm.online:1999",
+		TLS:         true,
+		TLSCertFile: "/etc/letsencrypt/live/api.fitm.online/fullchain.pem",
+		TLSKeyFile:  "/etc/letsencrypt/live/api.fitm.online/privkey.pem",
+		RateLimits: RateLimits{
+			AllPerMinute:    4000,
+			IPPerMinute:     2400,
+			IPPerSecond:     100,
+			ClicksPerSecond: 2,
+		},
+		ShutdownTimeoutSeconds:    8,
+		GlobalCatsStrategy:        "lifespan_overlap",
+		RecomputeIntervalMinutes:  24 * 60,
+		RecomputeBatchSize:        100,
+		ResponseCacheSeconds:      5 * 60,
+		LinkCheckIntervalHours:    7 * 24,
+		LinkCheckHostDelaySeconds: 10,
+	}
+}
+
+func Load() (*Config, error) {
+	cfg := Default()
+
+	if config_file := os.Getenv("FITM_CONFIG_FILE"); config_file != "" {
+		if err := cfg.ApplyFile(config_file); err != nil {
+			return nil, err
+		}
+		log.Printf("Loaded config from %s", config_file)
+	}
+
+	if err := cfg.ApplyEnv(os.Getenv); err != nil {
+		return nil, err
+	}
+
+	if cfg.TLS && (cfg.TLSCertFile == "" || cfg.TLSKeyFile == "") {
+		return nil, fmt.Errorf("TLS enabled but cert or key file not set")
+	}
+
+	if cfg.RecomputeBatchSize <= 0 {
+		return nil, fmt.Errorf("recompute batch size must be positive")
+	}
+
+	return cfg, nil
+}
+
+// Fields missing from the file keep their current values
+func (c *Config) ApplyFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read config file: %w", err)
+	}
+
+	if err = json.Unmarshal(data, c); err != nil {
+		return fmt.Errorf("could not parse config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (c *Config) ApplyEnv(getenv func(string) string) error {
+	if addr := getenv("FITM_LISTEN_ADDR"); addr != "" {
+		c.ListenAddr = addr
+	}
+
+	if tls := getenv("FITM_TLS"); tls != "" {
+		enabled, err := strconv.ParseBool(tls)
+		if err != nil {
+			return fmt.Errorf("invalid $FITM_TLS %q: %w", tls, err)
+		}
+		c.TLS = enabled
+	}
+	if cert := getenv("FITM_TLS_CERT_FILE"); cert != "" {
+		c.TLSCertFile = cert
+	}
+	if key := getenv("FITM_TLS_KEY_FILE"); key != "" {
+		c.TLSKeyFile = key
+	}
+
+	if reconcile := getenv("FITM_RECONCILE_SPELLFIX_ON_STARTUP"); reconcile != "" {
+		enabled, err := strconv.ParseBool(reconcile)
+		if err != nil {
+			return fmt.Errorf("invalid $FITM_RECONCILE_SPELLFIX_ON_STARTUP %q: %w", reconcile, err)
+		}
+		c.ReconcileSpellfixOnStartup = enabled
+	}
+
+	var int_vars = []struct {
+		EnvVar string
+		Field  *int
+	}{
+		{"FITM_RATE_LIMIT_ALL_PER_MINUTE", &c.RateLimits.AllPerMinute},
+		{"FITM_RATE_LIMIT_IP_PER_MINUTE", &c.RateLimits.IPPerMinute},
+		{"FITM_RATE_LIMIT_IP_PER_SECOND", &c.RateLimits.IPPerSecond},
+		{"FITM_RATE_LIMIT_CLICKS_PER_SECOND", &c.RateLimits.ClicksPerSecond},
+		{"FITM_SHUTDOWN_TIMEOUT_SECONDS", &c.ShutdownTimeoutSeconds},
+		{"FITM_RECOMPUTE_INTERVAL_MINUTES", &c.RecomputeIntervalMinutes},
+		{"FITM_RECOMPUTE_BATCH_SIZE", &c.RecomputeBatchSize},
+		{"FITM_RESPONSE_CACHE_SECONDS", &c.ResponseCacheSeconds},
+		{"FITM_LINK_CHECK_INTERVAL_HOURS", &c.LinkCheckIntervalHours},
+		{"FITM_LINK_CHECK_HOST_DELAY_SECONDS", &c.LinkCheckHostDelaySeconds},
+	}
+	for _, iv := range int_vars {
+		val := getenv(iv.EnvVar)
+		if val == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return fmt.Errorf("invalid $%s %q: %w", iv.EnvVar, val, err)
+		}
+		*iv.Field = n
+	}
+
+	if origins := getenv("FITM_CORS_ORIGINS"); origins != "" {
+		c.CORSOrigins = SplitList(origins)
+	}
+
+	if db_path := getenv("FITM_DB_PATH"); db_path != "" {
+		c.DBPath = db_path
+	}
+
+	if admins := getenv("FITM_ADMIN_LOGIN_NAMES"); admins != "" {
+		c.AdminLoginNames = SplitList(admins)
+	}
+
+	if strategy := getenv("FITM_GLOBAL_CATS_STRATEGY"); strategy != "" {
+		c.GlobalCatsStrategy = strategy
+	}
+
+	return nil
+}
+
+// Comma-separated, surrounding spaces and empty entries dropped
+func SplitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestApplyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fitm.json")
+	err := os.WriteFile(path, []byte(`{
+		"listen_addr": "localhost:1999",
+		"tls": false,
+		"rate_limits": {"ip_per_second": 0}
+	}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := Default()
+	if err = cfg.ApplyFile(path); err != nil {
+		t.Fatalf("failed with error: %s", err)
+	}
+
+	if cfg.ListenAddr != "localhost:1999" {
+		t.Fatalf("got listen addr %s, want localhost:1999", cfg.ListenAddr)
+	} else if cfg.TLS {
+		t.Fatal("expected TLS disabled")
+	} else if cfg.RateLimits.IPPerSecond != 0 {
+		t.Fatalf("got IP limit %d, want 0", cfg.RateLimits.IPPerSecond)
+	}
+
+	// unset fields keep defaults
+	if cfg.RateLimits.AllPerMinute != Default().RateLimits.AllPerMinute {
+		t.Fatalf(
+			"got overall limit %d, want default %d",
+			cfg.RateLimits.AllPerMinute,
+			Default().RateLimits.AllPerMinute,
+		)
+	}
+
+	if err = cfg.ApplyFile(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Fatal("expected error for missing config file")
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	var test_cases = []struct {
+		Env   map[string]string
+		Valid bool
+	}{
+		{map[string]string{}, true},
+		{map[string]string{
+			"FITM_LISTEN_ADDR":               ":8080",
+			"FITM_TLS":                       "false",
+			"FITM_RATE_LIMIT_ALL_PER_MINUTE": "10",
+			"FITM_CORS_ORIGINS":              "https://fitm.online, http://localhost:5173",
+			"FITM_DB_PATH":                   "/tmp/fitm.db",
+			"FITM_ADMIN_LOGIN_NAMES":         "jlk,",
+		}, true},
+		{map[string]string{"FITM_TLS": "maybe"}, false},
+		{map[string]string{"FITM_RATE_LIMIT_IP_PER_SECOND": "lots"}, false},
+		{map[string]string{"FITM_RECOMPUTE_INTERVAL_MINUTES": "often"}, false},
+		{map[string]string{"FITM_RECONCILE_SPELLFIX_ON_STARTUP": "sometimes"}, false},
+	}
+
+	for _, tc := range test_cases {
+		cfg := Default()
+		err := cfg.ApplyEnv(func(key string) string {
+			return tc.Env[key]
+		})
+		if tc.Valid && err != nil {
+			t.Fatalf("failed with error: %s for env %v", err, tc.Env)
+		} else if !tc.Valid && err == nil {
+			t.Fatalf("expected error for env %v", tc.Env)
+		}
+	}
+
+	cfg := Default()
+	cfg.ApplyEnv(func(key string) string {
+		return test_cases[1].Env[key]
+	})
+	if cfg.ListenAddr != ":8080" {
+		t.Fatalf("got listen addr %s, want :8080", cfg.ListenAddr)
+	} else if cfg.TLS {
+		t.Fatal("expected TLS disabled")
+	} else if cfg.RateLimits.AllPerMinute != 10 {
+		t.Fatalf("got overall limit %d, want 10", cfg.RateLimits.AllPerMinute)
+	} else if !slices.Equal(
+		cfg.CORSOrigins,
+		[]string{"https://fitm.online", "http://localhost:5173"},
+	) {
+		t.Fatalf("got CORS origins %v", cfg.CORSOrigins)
+	} else if cfg.DBPath != "/tmp/fitm.db" {
+		t.Fatalf("got DB path %s, want /tmp/fitm.db", cfg.DBPath)
+	} else if !slices.Equal(cfg.AdminLoginNames, []string{"jlk"}) {
+		t.Fatalf("got admin login names %v", cfg.AdminLoginNames)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"runtime"
 
+	"github.com/julianlk522/fitm/config"
 	"github.com/mattn/go-sqlite3"
 )
 
@@ -28,8 +29,13 @@ func init() {
 func Connect() error {
 	LoadSpellfix()
 
+	db_path := config.Current.DBPath
+	if db_path == "" {
+		db_path = filepath.Join(db_dir, "fitm.db")
+	}
+
 	var err error
-	Client, err = sql.Open("sqlite-spellfix1", db_dir+"/fitm.db?_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=5000&_cache_size=100000000")
+	Client, err = sql.Open("sqlite-spellfix1", db_path+"?_journal_mode=WAL&_synchronous=NORMAL&_busy_timeout=5000&_cache_size=100000000")
 	if err != nil {
 		return err
 	}
@@ -40,9 +46,27 @@ func Connect() error {
 
 	log.Print("DB connection verified")
 
+	if err = Migrate(Client); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// Checkpoints the WAL into the main DB file before closing so that
+// nothing is left for the next startup to recover
+func Close() error {
+	if Client == nil {
+		return nil
+	}
+
+	if _, err := Client.Exec("PRAGMA wal_checkpoint(TRUNCATE);"); err != nil {
+		log.Printf("WAL checkpoint failed: %s", err)
+	}
+
+	return Client.Close()
+}
+
 func LoadSpellfix() {
 	var spellfix_path string
 
@@ -0,0 +1,206 @@
+package db
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	mutil "github.com/julianlk522/fitm/model/util"
+)
+
+// Migrations are db/migrations/NNNN_description.sql, applied in order of
+// NNNN. Never edit one that has been deployed: add a new one instead.
+//
+//go:embed migrations/*.sql
+var migrations_fs embed.FS
+
+const BASELINE_MIGRATION_VERSION = 1
+
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+func LoadMigrations() ([]Migration, error) {
+	files, err := fs.Glob(migrations_fs, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	for _, file := range files {
+		name := strings.TrimSuffix(strings.TrimPrefix(file, "migrations/"), ".sql")
+		version_str, _, found := strings.Cut(name, "_")
+		if !found {
+			return nil, fmt.Errorf("migration %s missing version prefix", file)
+		}
+
+		version, err := strconv.Atoi(version_str)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s has invalid version: %w", file, err)
+		}
+
+		contents, err := migrations_fs.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, Migration{
+			Version: version,
+			Name:    name,
+			SQL:     string(contents),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf(
+				"duplicate migration version %d (%s, %s)",
+				migrations[i].Version,
+				migrations[i-1].Name,
+				migrations[i].Name,
+			)
+		}
+	}
+
+	return migrations, nil
+}
+
+// Applies all pending migrations, each in its own transaction.
+// DBs created before migrations existed (i.e., with tables but no
+// schema_migrations) are marked as already at the baseline.
+func Migrate(client *sql.DB) error {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return err
+	}
+
+	if _, err = client.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied TEXT NOT NULL
+	);`); err != nil {
+		return err
+	}
+
+	applied, err := GetAppliedMigrationVersions(client)
+	if err != nil {
+		return err
+	}
+
+	if len(applied) == 0 {
+		is_pre_migrations_db, err := TableExists(client, "Links")
+		if err != nil {
+			return err
+		} else if is_pre_migrations_db {
+			log.Print("existing DB without schema_migrations, marking baseline as applied")
+			for _, migration := range migrations {
+				if migration.Version != BASELINE_MIGRATION_VERSION {
+					continue
+				}
+				if err = RecordMigration(client, migration); err != nil {
+					return err
+				}
+				applied[migration.Version] = true
+			}
+		}
+	}
+
+	for _, migration := range migrations {
+		if applied[migration.Version] {
+			continue
+		}
+
+		if err = ApplyMigration(client, migration); err != nil {
+			return fmt.Errorf("migration %s failed: %w", migration.Name, err)
+		}
+		log.Printf("applied migration %s", migration.Name)
+	}
+
+	return nil
+}
+
+func GetAppliedMigrationVersions(client *sql.DB) (map[int]bool, error) {
+	rows, err := client.Query("SELECT version FROM schema_migrations;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]bool{}
+	for rows.Next() {
+		var version int
+		if err = rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+
+	return applied, rows.Err()
+}
+
+func ApplyMigration(client *sql.DB, migration Migration) error {
+	tx, err := client.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(migration.SQL); err != nil {
+		return err
+	}
+
+	if err = RecordMigration(tx, migration); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// *sql.DB or *sql.Tx
+type Execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// *sql.DB or *sql.Tx
+type RowQuerier interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// *sql.DB or *sql.Tx
+type Querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func RecordMigration(ex Execer, migration Migration) error {
+	_, err := ex.Exec(
+		"INSERT INTO schema_migrations (version, name, applied) VALUES (?, ?, ?);",
+		migration.Version,
+		migration.Name,
+		mutil.NEW_LONG_TIMESTAMP(),
+	)
+
+	return err
+}
+
+func TableExists(client *sql.DB, table string) (bool, error) {
+	var count int
+	err := client.QueryRow(
+		"SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?;",
+		table,
+	).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
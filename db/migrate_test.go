@@ -0,0 +1,203 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(migrations) == 0 {
+		t.Fatal("no migrations found")
+	} else if migrations[0].Version != BASELINE_MIGRATION_VERSION {
+		t.Fatalf(
+			"got first migration version %d, want baseline %d",
+			migrations[0].Version,
+			BASELINE_MIGRATION_VERSION,
+		)
+	}
+
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version <= migrations[i-1].Version {
+			t.Fatalf(
+				"migrations out of order: %s after %s",
+				migrations[i].Name,
+				migrations[i-1].Name,
+			)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// fresh DB: all migrations run
+	fresh, err := sql.Open("sqlite-spellfix1", "file:migrate_fresh?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("could not open in-memory DB: %s", err)
+	}
+	defer fresh.Close()
+
+	if err = Migrate(fresh); err != nil {
+		t.Fatalf("failed with error: %s", err)
+	}
+	// running again is a no-op
+	if err = Migrate(fresh); err != nil {
+		t.Fatalf("failed on second run with error: %s", err)
+	}
+
+	applied, err := GetAppliedMigrationVersions(fresh)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(applied) != len(migrations) {
+		t.Fatalf("got %d applied migrations, want %d", len(applied), len(migrations))
+	}
+
+	for _, table := range []string{"Links", "Tags", "Summaries", "Summary Revisions"} {
+		exists, err := TableExists(fresh, table)
+		if err != nil {
+			t.Fatal(err)
+		} else if !exists {
+			t.Fatalf("table %s not created", table)
+		}
+	}
+
+	// FTS triggers from baseline
+	if _, err = fresh.Exec(
+		`INSERT INTO Links (id, url, submitted_by, submit_date, global_cats)
+		VALUES ('1', 'https://example.com', 'jlk', '2024-01-01', 'go,test');`,
+	); err != nil {
+		t.Fatal(err)
+	}
+	var link_id string
+	if err = fresh.QueryRow(
+		"SELECT link_id FROM global_cats_fts WHERE global_cats MATCH 'go';",
+	).Scan(&link_id); err != nil {
+		t.Fatalf("global_cats_fts not updated on insert: %s", err)
+	}
+
+	// Link Stats triggers
+	if _, err = fresh.Exec(
+		`INSERT INTO Users (id, login_name) VALUES ('u1', 'jlk');
+		INSERT INTO "Link Likes" (id, link_id, user_id, timestamp)
+		VALUES ('l1', '1', 'u1', '2024-01-02');
+		INSERT INTO Clicks (id, link_id, timestamp) VALUES ('c1', '1', '2024-01-02');`,
+	); err != nil {
+		t.Fatal(err)
+	}
+	var like_count, click_count int
+	var earliest_likers string
+	if err = fresh.QueryRow(
+		`SELECT like_count, earliest_likers, click_count
+		FROM "Link Stats"
+		WHERE link_id = '1';`,
+	).Scan(&like_count, &earliest_likers, &click_count); err != nil {
+		t.Fatalf("Link Stats not created on insert: %s", err)
+	} else if like_count != 1 || earliest_likers != "jlk" || click_count != 1 {
+		t.Fatalf(
+			"got like_count %d, earliest_likers %q, click_count %d, want 1, jlk, 1",
+			like_count,
+			earliest_likers,
+			click_count,
+		)
+	}
+
+	if _, err = fresh.Exec(`DELETE FROM "Link Likes" WHERE id = 'l1';`); err != nil {
+		t.Fatal(err)
+	}
+	if err = fresh.QueryRow(
+		`SELECT like_count, earliest_likers
+		FROM "Link Stats"
+		WHERE link_id = '1';`,
+	).Scan(&like_count, &earliest_likers); err != nil {
+		t.Fatal(err)
+	} else if like_count != 0 || earliest_likers != "" {
+		t.Fatalf("got like_count %d, earliest_likers %q after unlike, want 0, \"\"", like_count, earliest_likers)
+	}
+
+	// pre-migrations DB: baseline skipped, later migrations applied
+	legacy, err := sql.Open("sqlite-spellfix1", "file:migrate_legacy?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("could not open in-memory DB: %s", err)
+	}
+	defer legacy.Close()
+
+	if _, err = legacy.Exec(`
+		CREATE TABLE Links (id TEXT PRIMARY KEY, url TEXT, global_summary TEXT, global_cats TEXT);
+		CREATE TABLE Summaries (id TEXT PRIMARY KEY, text TEXT, link_id TEXT);
+		CREATE TABLE Tags (id TEXT PRIMARY KEY, cats TEXT, link_id TEXT);
+		CREATE TABLE Users (id TEXT PRIMARY KEY, login_name TEXT);
+		CREATE TABLE "Link Likes" (id TEXT PRIMARY KEY, link_id TEXT, user_id TEXT, timestamp TEXT);
+		CREATE TABLE "Link Copies" (id TEXT PRIMARY KEY, link_id TEXT, user_id TEXT, timestamp TEXT);
+		CREATE TABLE Clicks (id TEXT PRIMARY KEY, link_id TEXT, user_id TEXT, timestamp TEXT);`,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err = Migrate(legacy); err != nil {
+		t.Fatalf("failed with error: %s", err)
+	}
+
+	// baseline would have created Summary Likes
+	exists, err := TableExists(legacy, "Summary Likes")
+	if err != nil {
+		t.Fatal(err)
+	} else if exists {
+		t.Fatal("baseline migration ran on pre-migrations DB")
+	}
+
+	applied, err = GetAppliedMigrationVersions(legacy)
+	if err != nil {
+		t.Fatal(err)
+	} else if !applied[BASELINE_MIGRATION_VERSION] {
+		t.Fatal("baseline not marked as applied")
+	} else if len(applied) != len(migrations) {
+		t.Fatalf("got %d applied migrations, want %d", len(applied), len(migrations))
+	}
+}
+
+// Tags and summaries inserted before their link are counted once the
+// link is (see 0011_link_stats_initial_counts)
+func TestLinkStatsCountRowsAddedBeforeLink(t *testing.T) {
+	client, err := sql.Open("sqlite-spellfix1", "file:link_stats_before_link?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatalf("could not open in-memory DB: %s", err)
+	}
+	defer client.Close()
+
+	if err = Migrate(client); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := client.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range []string{
+		`INSERT INTO Tags (id, link_id, cats, submitted_by, last_updated)
+		VALUES ('t1', 'l1', 'test', 'jlk', '2024-01-01 00:00:00');`,
+		`INSERT INTO Summaries (id, text, link_id, submitted_by, last_updated)
+		VALUES ('s1', 'test', 'l1', '3', '2024-01-01 00:00:00');`,
+		`INSERT INTO Links (id, url, submitted_by, submit_date, global_cats, global_summary, img_file)
+		VALUES ('l1', 'https://example.com', 'jlk', '2024-01-01 00:00:00', 'test', 'test', '');`,
+	} {
+		if _, err = tx.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var tag_count, summary_count int
+	if err = tx.QueryRow(
+		`SELECT tag_count, summary_count FROM "Link Stats" WHERE link_id = 'l1';`,
+	).Scan(&tag_count, &summary_count); err != nil {
+		t.Fatal(err)
+	} else if tag_count != 1 || summary_count != 1 {
+		t.Fatalf("got %d tags, %d summaries, want 1 each", tag_count, summary_count)
+	}
+}
@@ -0,0 +1,110 @@
+-- Schema as of the introduction of migrations.
+-- Only run on fresh DBs: existing DBs (Links table present) are marked
+-- as already at this version without executing it.
+
+CREATE TABLE IF NOT EXISTS Users (
+	id TEXT PRIMARY KEY,
+	login_name TEXT UNIQUE NOT NULL,
+	password TEXT,
+	about TEXT,
+	pfp TEXT,
+	created TEXT,
+	email TEXT
+);
+
+CREATE TABLE IF NOT EXISTS Links (
+	id TEXT PRIMARY KEY,
+	url TEXT UNIQUE NOT NULL,
+	submitted_by TEXT NOT NULL,
+	submit_date TEXT NOT NULL,
+	global_cats TEXT DEFAULT '',
+	global_summary TEXT DEFAULT '',
+	img_file TEXT
+);
+
+CREATE TABLE IF NOT EXISTS Summaries (
+	id TEXT PRIMARY KEY,
+	text TEXT NOT NULL,
+	link_id TEXT NOT NULL REFERENCES Links(id) ON DELETE CASCADE,
+	submitted_by TEXT NOT NULL,
+	last_updated TEXT
+);
+
+CREATE TABLE IF NOT EXISTS "Summary Likes" (
+	id TEXT PRIMARY KEY,
+	summary_id TEXT NOT NULL REFERENCES Summaries(id) ON DELETE CASCADE,
+	user_id TEXT NOT NULL,
+	timestamp TEXT
+);
+
+-- submitted_by: login name
+CREATE TABLE IF NOT EXISTS Tags (
+	id TEXT PRIMARY KEY,
+	link_id TEXT NOT NULL REFERENCES Links(id) ON DELETE CASCADE,
+	cats TEXT NOT NULL,
+	submitted_by TEXT NOT NULL,
+	last_updated TEXT
+);
+
+CREATE TABLE IF NOT EXISTS "Link Likes" (
+	id TEXT PRIMARY KEY,
+	link_id TEXT NOT NULL REFERENCES Links(id) ON DELETE CASCADE,
+	user_id TEXT NOT NULL,
+	timestamp TEXT
+);
+
+CREATE TABLE IF NOT EXISTS "Link Copies" (
+	id TEXT PRIMARY KEY,
+	link_id TEXT NOT NULL REFERENCES Links(id) ON DELETE CASCADE,
+	user_id TEXT NOT NULL,
+	timestamp TEXT
+);
+
+CREATE TABLE IF NOT EXISTS Clicks (
+	id TEXT PRIMARY KEY,
+	link_id TEXT NOT NULL REFERENCES Links(id) ON DELETE CASCADE,
+	user_id TEXT,
+	ip_addr TEXT,
+	timestamp TEXT
+);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS global_cats_fts USING fts5(
+	link_id UNINDEXED,
+	global_cats
+);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS user_cats_fts USING fts5(
+	link_id UNINDEXED,
+	cats,
+	submitted_by UNINDEXED
+);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS global_cats_spellfix USING spellfix1;
+
+-- keep FTS tables in sync
+CREATE TRIGGER IF NOT EXISTS links_ai AFTER INSERT ON Links BEGIN
+	INSERT INTO global_cats_fts(link_id, global_cats) 
+	VALUES (new.id, new.global_cats);
+END;
+CREATE TRIGGER IF NOT EXISTS links_au AFTER UPDATE OF global_cats ON Links BEGIN
+	UPDATE global_cats_fts 
+	SET global_cats = new.global_cats 
+	WHERE link_id = old.id;
+END;
+CREATE TRIGGER IF NOT EXISTS links_ad AFTER DELETE ON Links BEGIN
+	DELETE FROM global_cats_fts WHERE link_id = old.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS tags_ai AFTER INSERT ON Tags BEGIN
+	INSERT INTO user_cats_fts(link_id, cats, submitted_by) 
+	VALUES (new.link_id, new.cats, new.submitted_by);
+END;
+CREATE TRIGGER IF NOT EXISTS tags_au AFTER UPDATE OF cats ON Tags BEGIN
+	UPDATE user_cats_fts 
+	SET cats = new.cats 
+	WHERE link_id = old.link_id AND submitted_by = old.submitted_by;
+END;
+CREATE TRIGGER IF NOT EXISTS tags_ad AFTER DELETE ON Tags BEGIN
+	DELETE FROM user_cats_fts 
+	WHERE link_id = old.link_id AND submitted_by = old.submitted_by;
+END;
@@ -0,0 +1,12 @@
+-- last_updated: when the revision text was originally written
+-- replaced: when it was overwritten by a newer version
+CREATE TABLE IF NOT EXISTS "Summary Revisions" (
+	id TEXT PRIMARY KEY,
+	summary_id TEXT NOT NULL REFERENCES Summaries(id) ON DELETE CASCADE,
+	text TEXT NOT NULL,
+	submitted_by TEXT NOT NULL,
+	last_updated TEXT NOT NULL,
+	replaced TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS summary_revisions_summary_id 
+ON "Summary Revisions"(summary_id);
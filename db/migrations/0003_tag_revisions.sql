@@ -0,0 +1,12 @@
+-- submitted_by: login name (same as Tags)
+CREATE TABLE IF NOT EXISTS "Tag Revisions" (
+	id TEXT PRIMARY KEY,
+	tag_id TEXT NOT NULL,
+	link_id TEXT NOT NULL REFERENCES Links(id) ON DELETE CASCADE,
+	cats TEXT NOT NULL,
+	submitted_by TEXT NOT NULL,
+	last_updated TEXT NOT NULL,
+	replaced TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS tag_revisions_link_id 
+ON "Tag Revisions"(link_id);
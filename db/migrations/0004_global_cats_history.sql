@@ -0,0 +1,11 @@
+-- added, removed: comma-separated like global_cats
+CREATE TABLE IF NOT EXISTS "Global Cats History" (
+	id TEXT PRIMARY KEY,
+	link_id TEXT NOT NULL REFERENCES Links(id) ON DELETE CASCADE,
+	global_cats TEXT NOT NULL,
+	added TEXT NOT NULL,
+	removed TEXT NOT NULL,
+	timestamp TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS global_cats_history_link_id 
+ON "Global Cats History"(link_id);
@@ -0,0 +1,87 @@
+-- Full-text index over each link's URL, global summary, all summaries
+-- and all cats (global + every user's tag), one row per link.
+-- Rebuilt for a link by triggers whenever any of those change.
+CREATE VIRTUAL TABLE IF NOT EXISTS link_search_fts USING fts5(
+	link_id UNINDEXED,
+	url,
+	global_summary,
+	summaries,
+	cats,
+	tokenize = 'porter unicode61'
+);
+
+CREATE VIEW IF NOT EXISTS link_search_docs AS
+SELECT
+	l.id AS link_id,
+	l.url,
+	COALESCE(l.global_summary, '') AS global_summary,
+	COALESCE(
+		(SELECT GROUP_CONCAT(s.text, ' ') FROM Summaries s WHERE s.link_id = l.id),
+		''
+	) AS summaries,
+	COALESCE(l.global_cats, '') || ' ' || COALESCE(
+		(SELECT GROUP_CONCAT(t.cats, ' ') FROM Tags t WHERE t.link_id = l.id),
+		''
+	) AS cats
+FROM Links l;
+
+INSERT INTO link_search_fts (link_id, url, global_summary, summaries, cats)
+SELECT link_id, url, global_summary, summaries, cats
+FROM link_search_docs;
+
+-- Links
+CREATE TRIGGER IF NOT EXISTS link_search_links_ai AFTER INSERT ON Links BEGIN
+	INSERT INTO link_search_fts (link_id, url, global_summary, summaries, cats)
+	SELECT link_id, url, global_summary, summaries, cats
+	FROM link_search_docs WHERE link_id = new.id;
+END;
+CREATE TRIGGER IF NOT EXISTS link_search_links_au 
+AFTER UPDATE OF url, global_summary, global_cats ON Links BEGIN
+	DELETE FROM link_search_fts WHERE link_id = old.id;
+	INSERT INTO link_search_fts (link_id, url, global_summary, summaries, cats)
+	SELECT link_id, url, global_summary, summaries, cats
+	FROM link_search_docs WHERE link_id = new.id;
+END;
+CREATE TRIGGER IF NOT EXISTS link_search_links_ad AFTER DELETE ON Links BEGIN
+	DELETE FROM link_search_fts WHERE link_id = old.id;
+END;
+
+-- Summaries
+CREATE TRIGGER IF NOT EXISTS link_search_summaries_ai AFTER INSERT ON Summaries BEGIN
+	DELETE FROM link_search_fts WHERE link_id = new.link_id;
+	INSERT INTO link_search_fts (link_id, url, global_summary, summaries, cats)
+	SELECT link_id, url, global_summary, summaries, cats
+	FROM link_search_docs WHERE link_id = new.link_id;
+END;
+CREATE TRIGGER IF NOT EXISTS link_search_summaries_au AFTER UPDATE OF text ON Summaries BEGIN
+	DELETE FROM link_search_fts WHERE link_id = new.link_id;
+	INSERT INTO link_search_fts (link_id, url, global_summary, summaries, cats)
+	SELECT link_id, url, global_summary, summaries, cats
+	FROM link_search_docs WHERE link_id = new.link_id;
+END;
+CREATE TRIGGER IF NOT EXISTS link_search_summaries_ad AFTER DELETE ON Summaries BEGIN
+	DELETE FROM link_search_fts WHERE link_id = old.link_id;
+	INSERT INTO link_search_fts (link_id, url, global_summary, summaries, cats)
+	SELECT link_id, url, global_summary, summaries, cats
+	FROM link_search_docs WHERE link_id = old.link_id;
+END;
+
+-- Tags
+CREATE TRIGGER IF NOT EXISTS link_search_tags_ai AFTER INSERT ON Tags BEGIN
+	DELETE FROM link_search_fts WHERE link_id = new.link_id;
+	INSERT INTO link_search_fts (link_id, url, global_summary, summaries, cats)
+	SELECT link_id, url, global_summary, summaries, cats
+	FROM link_search_docs WHERE link_id = new.link_id;
+END;
+CREATE TRIGGER IF NOT EXISTS link_search_tags_au AFTER UPDATE OF cats ON Tags BEGIN
+	DELETE FROM link_search_fts WHERE link_id = new.link_id;
+	INSERT INTO link_search_fts (link_id, url, global_summary, summaries, cats)
+	SELECT link_id, url, global_summary, summaries, cats
+	FROM link_search_docs WHERE link_id = new.link_id;
+END;
+CREATE TRIGGER IF NOT EXISTS link_search_tags_ad AFTER DELETE ON Tags BEGIN
+	DELETE FROM link_search_fts WHERE link_id = old.link_id;
+	INSERT INTO link_search_fts (link_id, url, global_summary, summaries, cats)
+	SELECT link_id, url, global_summary, summaries, cats
+	FROM link_search_docs WHERE link_id = old.link_id;
+END;
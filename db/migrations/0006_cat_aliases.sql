@@ -0,0 +1,8 @@
+-- alias is rewritten to cat wherever cats are submitted or matched
+-- created_by: login name of the admin who added it
+CREATE TABLE IF NOT EXISTS "Cat Aliases" (
+	alias TEXT PRIMARY KEY COLLATE NOCASE,
+	cat TEXT NOT NULL,
+	created_by TEXT NOT NULL,
+	created TEXT NOT NULL
+);
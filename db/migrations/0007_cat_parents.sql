@@ -0,0 +1,11 @@
+-- each cat has at most one parent; filtering by a cat includes its
+-- descendants
+-- created_by: login name of the admin who set it
+CREATE TABLE IF NOT EXISTS "Cat Parents" (
+	cat TEXT PRIMARY KEY COLLATE NOCASE,
+	parent TEXT NOT NULL COLLATE NOCASE,
+	created_by TEXT NOT NULL,
+	created TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS cat_parents_parent
+ON "Cat Parents"(parent);
@@ -0,0 +1,176 @@
+-- Like, copy, click, tag and summary counts and earliest likers/copiers
+-- per link, so link listings join one row per link instead of
+-- aggregating those tables on every request. Kept in sync by triggers:
+-- counts are incremented/decremented, earliest likers/copiers (first 10
+-- by timestamp: model/util EARLIEST_LIKERS_AND_COPIERS_LIMIT) are
+-- rebuilt for the link.
+CREATE TABLE IF NOT EXISTS "Link Stats" (
+	link_id TEXT PRIMARY KEY,
+	like_count INTEGER NOT NULL DEFAULT 0,
+	earliest_likers TEXT NOT NULL DEFAULT '',
+	copy_count INTEGER NOT NULL DEFAULT 0,
+	earliest_copiers TEXT NOT NULL DEFAULT '',
+	click_count INTEGER NOT NULL DEFAULT 0,
+	tag_count INTEGER NOT NULL DEFAULT 0,
+	summary_count INTEGER NOT NULL DEFAULT 0
+);
+
+-- for rebuilding earliest likers/copiers of one link
+CREATE INDEX IF NOT EXISTS link_likes_link_id
+ON "Link Likes"(link_id);
+CREATE INDEX IF NOT EXISTS link_copies_link_id
+ON "Link Copies"(link_id);
+
+CREATE VIEW IF NOT EXISTS link_earliest_likers AS
+SELECT
+	l.id AS link_id,
+	COALESCE((
+		SELECT GROUP_CONCAT(login_name, ', ')
+		FROM (
+			SELECT u.login_name
+			FROM "Link Likes" ll
+			JOIN Users u ON ll.user_id = u.id
+			WHERE ll.link_id = l.id
+			ORDER BY ll.timestamp ASC, u.login_name ASC
+			LIMIT 10
+		)
+	), '') AS earliest_likers
+FROM Links l;
+
+CREATE VIEW IF NOT EXISTS link_earliest_copiers AS
+SELECT
+	l.id AS link_id,
+	COALESCE((
+		SELECT GROUP_CONCAT(login_name, ', ')
+		FROM (
+			SELECT u.login_name
+			FROM "Link Copies" lc
+			JOIN Users u ON lc.user_id = u.id
+			WHERE lc.link_id = l.id
+			ORDER BY lc.timestamp ASC, u.login_name ASC
+			LIMIT 10
+		)
+	), '') AS earliest_copiers
+FROM Links l;
+
+INSERT OR REPLACE INTO "Link Stats" (
+	link_id,
+	like_count,
+	earliest_likers,
+	copy_count,
+	earliest_copiers,
+	click_count,
+	tag_count,
+	summary_count
+)
+SELECT
+	l.id,
+	(SELECT COUNT(*) FROM "Link Likes" WHERE link_id = l.id),
+	el.earliest_likers,
+	(SELECT COUNT(*) FROM "Link Copies" WHERE link_id = l.id),
+	ec.earliest_copiers,
+	(SELECT COUNT(*) FROM Clicks WHERE link_id = l.id),
+	(SELECT COUNT(*) FROM Tags WHERE link_id = l.id),
+	(SELECT COUNT(*) FROM Summaries WHERE link_id = l.id)
+FROM Links l
+JOIN link_earliest_likers el ON el.link_id = l.id
+JOIN link_earliest_copiers ec ON ec.link_id = l.id;
+
+-- Links
+CREATE TRIGGER IF NOT EXISTS link_stats_links_ai AFTER INSERT ON Links BEGIN
+	INSERT OR IGNORE INTO "Link Stats" (link_id) VALUES (new.id);
+END;
+CREATE TRIGGER IF NOT EXISTS link_stats_links_ad AFTER DELETE ON Links BEGIN
+	DELETE FROM "Link Stats" WHERE link_id = old.id;
+END;
+
+-- Insert triggers only create a missing stats row if the link exists,
+-- so rows deleted along with a link don't come back
+
+-- Link Likes
+CREATE TRIGGER IF NOT EXISTS link_stats_likes_ai AFTER INSERT ON "Link Likes" BEGIN
+	INSERT OR IGNORE INTO "Link Stats" (link_id)
+	SELECT id FROM Links WHERE id = new.link_id;
+	UPDATE "Link Stats"
+	SET
+		like_count = like_count + 1,
+		earliest_likers = (
+			SELECT earliest_likers
+			FROM link_earliest_likers
+			WHERE link_id = new.link_id
+		)
+	WHERE link_id = new.link_id;
+END;
+CREATE TRIGGER IF NOT EXISTS link_stats_likes_ad AFTER DELETE ON "Link Likes" BEGIN
+	UPDATE "Link Stats"
+	SET
+		like_count = MAX(like_count - 1, 0),
+		earliest_likers = COALESCE((
+			SELECT earliest_likers
+			FROM link_earliest_likers
+			WHERE link_id = old.link_id
+		), '')
+	WHERE link_id = old.link_id;
+END;
+
+-- Link Copies
+CREATE TRIGGER IF NOT EXISTS link_stats_copies_ai AFTER INSERT ON "Link Copies" BEGIN
+	INSERT OR IGNORE INTO "Link Stats" (link_id)
+	SELECT id FROM Links WHERE id = new.link_id;
+	UPDATE "Link Stats"
+	SET
+		copy_count = copy_count + 1,
+		earliest_copiers = (
+			SELECT earliest_copiers
+			FROM link_earliest_copiers
+			WHERE link_id = new.link_id
+		)
+	WHERE link_id = new.link_id;
+END;
+CREATE TRIGGER IF NOT EXISTS link_stats_copies_ad AFTER DELETE ON "Link Copies" BEGIN
+	UPDATE "Link Stats"
+	SET
+		copy_count = MAX(copy_count - 1, 0),
+		earliest_copiers = COALESCE((
+			SELECT earliest_copiers
+			FROM link_earliest_copiers
+			WHERE link_id = old.link_id
+		), '')
+	WHERE link_id = old.link_id;
+END;
+
+-- Clicks
+CREATE TRIGGER IF NOT EXISTS link_stats_clicks_ai AFTER INSERT ON Clicks BEGIN
+	INSERT OR IGNORE INTO "Link Stats" (link_id)
+	SELECT id FROM Links WHERE id = new.link_id;
+	UPDATE "Link Stats" SET click_count = click_count + 1
+	WHERE link_id = new.link_id;
+END;
+CREATE TRIGGER IF NOT EXISTS link_stats_clicks_ad AFTER DELETE ON Clicks BEGIN
+	UPDATE "Link Stats" SET click_count = MAX(click_count - 1, 0)
+	WHERE link_id = old.link_id;
+END;
+
+-- Tags
+CREATE TRIGGER IF NOT EXISTS link_stats_tags_ai AFTER INSERT ON Tags BEGIN
+	INSERT OR IGNORE INTO "Link Stats" (link_id)
+	SELECT id FROM Links WHERE id = new.link_id;
+	UPDATE "Link Stats" SET tag_count = tag_count + 1
+	WHERE link_id = new.link_id;
+END;
+CREATE TRIGGER IF NOT EXISTS link_stats_tags_ad AFTER DELETE ON Tags BEGIN
+	UPDATE "Link Stats" SET tag_count = MAX(tag_count - 1, 0)
+	WHERE link_id = old.link_id;
+END;
+
+-- Summaries
+CREATE TRIGGER IF NOT EXISTS link_stats_summaries_ai AFTER INSERT ON Summaries BEGIN
+	INSERT OR IGNORE INTO "Link Stats" (link_id)
+	SELECT id FROM Links WHERE id = new.link_id;
+	UPDATE "Link Stats" SET summary_count = summary_count + 1
+	WHERE link_id = new.link_id;
+END;
+CREATE TRIGGER IF NOT EXISTS link_stats_summaries_ad AFTER DELETE ON Summaries BEGIN
+	UPDATE "Link Stats" SET summary_count = MAX(summary_count - 1, 0)
+	WHERE link_id = old.link_id;
+END;
@@ -0,0 +1,22 @@
+-- Fetching a new link's metadata (resolved URL, auto summary, preview
+-- image), done in the background by handler/util RunIngestWorker.
+-- url: as submitted, before resolving
+-- status: pending, running, done or failed
+-- next_attempt: pending jobs are not run before it (failed attempts are
+-- retried later)
+CREATE TABLE IF NOT EXISTS "Ingest Jobs" (
+	id TEXT PRIMARY KEY,
+	link_id TEXT NOT NULL REFERENCES Links(id) ON DELETE CASCADE,
+	url TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	error TEXT,
+	created TEXT NOT NULL,
+	updated TEXT NOT NULL,
+	next_attempt TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS ingest_jobs_status_next_attempt
+ON "Ingest Jobs"(status, next_attempt);
+CREATE INDEX IF NOT EXISTS ingest_jobs_link_id
+ON "Ingest Jobs"(link_id);
@@ -0,0 +1,34 @@
+-- Results of re-fetching links (handler/util RunLinkChecker).
+-- status: see model LINK_HEALTH_* (never "unchecked")
+-- status_code: HTTP status, if there was a response
+-- final_url: after redirects, if different from the link's URL
+CREATE TABLE IF NOT EXISTS "Link Checks" (
+	id TEXT PRIMARY KEY,
+	link_id TEXT NOT NULL REFERENCES Links(id) ON DELETE CASCADE,
+	checked TEXT NOT NULL,
+	status TEXT NOT NULL,
+	status_code INTEGER,
+	final_url TEXT,
+	error TEXT
+);
+
+CREATE INDEX IF NOT EXISTS link_checks_link_id_checked
+ON "Link Checks"(link_id, checked);
+
+-- Latest check per link. Links without a row have not been checked
+-- yet (health "unchecked") and are checked first; a link whose host is
+-- backing off may have an "unchecked" row with only next_check set.
+-- consecutive_failures: checks since the last ok/redirected one, for
+-- backing off dead links
+CREATE TABLE IF NOT EXISTS "Link Health" (
+	link_id TEXT PRIMARY KEY REFERENCES Links(id) ON DELETE CASCADE,
+	health TEXT NOT NULL,
+	last_checked TEXT,
+	next_check TEXT NOT NULL,
+	consecutive_failures INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS link_health_next_check
+ON "Link Health"(next_check);
+CREATE INDEX IF NOT EXISTS link_health_health
+ON "Link Health"(health);
@@ -0,0 +1,53 @@
+-- Links inserted after their tags, summaries etc. (e.g. by AddLink
+-- before it inserted the link first) got stats rows with zero counts:
+-- the other tables' insert triggers ran before the link existed. The
+-- Links insert trigger now counts existing rows, and counts are
+-- rebuilt for links added since 0008_link_stats.
+DROP TRIGGER IF EXISTS link_stats_links_ai;
+CREATE TRIGGER link_stats_links_ai AFTER INSERT ON Links BEGIN
+	INSERT OR REPLACE INTO "Link Stats" (
+		link_id,
+		like_count,
+		earliest_likers,
+		copy_count,
+		earliest_copiers,
+		click_count,
+		tag_count,
+		summary_count
+	)
+	SELECT
+		new.id,
+		(SELECT COUNT(*) FROM "Link Likes" WHERE link_id = new.id),
+		el.earliest_likers,
+		(SELECT COUNT(*) FROM "Link Copies" WHERE link_id = new.id),
+		ec.earliest_copiers,
+		(SELECT COUNT(*) FROM Clicks WHERE link_id = new.id),
+		(SELECT COUNT(*) FROM Tags WHERE link_id = new.id),
+		(SELECT COUNT(*) FROM Summaries WHERE link_id = new.id)
+	FROM link_earliest_likers el
+	JOIN link_earliest_copiers ec ON ec.link_id = el.link_id
+	WHERE el.link_id = new.id;
+END;
+
+INSERT OR REPLACE INTO "Link Stats" (
+	link_id,
+	like_count,
+	earliest_likers,
+	copy_count,
+	earliest_copiers,
+	click_count,
+	tag_count,
+	summary_count
+)
+SELECT
+	l.id,
+	(SELECT COUNT(*) FROM "Link Likes" WHERE link_id = l.id),
+	el.earliest_likers,
+	(SELECT COUNT(*) FROM "Link Copies" WHERE link_id = l.id),
+	ec.earliest_copiers,
+	(SELECT COUNT(*) FROM Clicks WHERE link_id = l.id),
+	(SELECT COUNT(*) FROM Tags WHERE link_id = l.id),
+	(SELECT COUNT(*) FROM Summaries WHERE link_id = l.id)
+FROM Links l
+JOIN link_earliest_likers el ON el.link_id = l.id
+JOIN link_earliest_copiers ec ON ec.link_id = l.id;
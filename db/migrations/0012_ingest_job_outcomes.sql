@@ -0,0 +1,50 @@
+-- Jobs outlive the links they delete (see handler/util FinishIngestJob)
+-- so ingest-status can still say why. SQLite can't drop a foreign key:
+-- the table is rebuilt without it.
+-- status: pending, running, done, failed or duplicate
+-- duplicate_of: ID of the link the URL resolved to (status duplicate)
+-- link_deleted: 1 if the job's outcome deleted the link
+CREATE TABLE "Ingest Jobs Rebuilt" (
+	id TEXT PRIMARY KEY,
+	link_id TEXT NOT NULL,
+	url TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	error TEXT,
+	duplicate_of TEXT,
+	link_deleted INTEGER NOT NULL DEFAULT 0,
+	created TEXT NOT NULL,
+	updated TEXT NOT NULL,
+	next_attempt TEXT NOT NULL
+);
+
+INSERT INTO "Ingest Jobs Rebuilt" (
+	id,
+	link_id,
+	url,
+	status,
+	attempts,
+	error,
+	created,
+	updated,
+	next_attempt
+)
+SELECT
+	id,
+	link_id,
+	url,
+	status,
+	attempts,
+	error,
+	created,
+	updated,
+	next_attempt
+FROM "Ingest Jobs";
+
+DROP TABLE "Ingest Jobs";
+ALTER TABLE "Ingest Jobs Rebuilt" RENAME TO "Ingest Jobs";
+
+CREATE INDEX IF NOT EXISTS ingest_jobs_status_next_attempt
+ON "Ingest Jobs"(status, next_attempt);
+CREATE INDEX IF NOT EXISTS ingest_jobs_link_id
+ON "Ingest Jobs"(link_id);
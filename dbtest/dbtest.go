@@ -48,6 +48,10 @@ func SetupTestDB() error {
 	}
 	log.Printf("verified test DB dump data loaded")
 
+	if err = db.Migrate(TestClient); err != nil {
+		return fmt.Errorf("could not migrate test DB: %s", err)
+	}
+
 	// verify in-memory DB has spellfix1
 	if _, err = TestClient.Exec(`SELECT word, rank FROM global_cats_spellfix;`); err != nil {
 		return err
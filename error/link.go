@@ -11,11 +11,18 @@ var (
 	ErrInvalidPeriod       error = errors.New("invalid period provided")
 	ErrInvalidPageParams   error = errors.New("invalid page provided")
 	ErrInvalidNSFWParams   error = errors.New("invalid NSFW params provided")
+	ErrInvalidHealthParams error = errors.New("invalid health params provided (accepted: ok, redirected, not_found, http_error, tls_error, timeout, unreachable, unchecked, alive, dead)")
 	ErrInvalidSortByParams error = errors.New("invalid sort_by params provided")
+	ErrInvalidSearchParams error = errors.New("invalid search query provided: no searchable terms")
+	ErrRelevanceWithoutSearch error = errors.New("sort_by=relevance requires a search query (q)")
 	ErrNoLinkID            error = errors.New("no link ID provided")
 	ErrNoLinkWithID        error = errors.New("no link found with given ID")
 	ErrNoCats              error = errors.New("no cats provided")
+	ErrInvalidCatsFilter   error = errors.New("invalid cats filter provided: check for empty exclusions or unbalanced quotes")
 	ErrNoPeriod            error = errors.New("no period provided")
+	ErrInvalidCursor       error = errors.New("invalid cursor provided: cursors only resume the sort they came from")
+	ErrCursorWithRelevance error = errors.New("cursor cannot be used with sort_by=relevance")
+	ErrCursorWithPage      error = errors.New("cursor and page cannot be used together")
 	// Preview Img
 	ErrPreviewImgNotFound error = errors.New("preview image not found at specified path")
 	// Add link
@@ -29,16 +36,35 @@ var (
 	ErrCannotCopyOwnLink     error = errors.New("cannot copy your own link to your treasure map")
 	ErrLinkAlreadyCopied     error = errors.New("link already copied to treasure map")
 	ErrLinkNotCopied         error = errors.New("link not already copied")
+	// Import links
+	ErrInvalidBookmarksFormat  error = errors.New("invalid bookmarks format provided (accepted: html, csv, json)")
+	ErrNoBookmarks             error = errors.New("no bookmarks found in file")
+	ErrNoBookmarksURLColumn    error = errors.New("bookmarks CSV has no url column")
+	ErrBookmarksFileTooLarge   error = errors.New("bookmarks file too large")
+	ErrDuplicateBookmark       error = errors.New("URL already earlier in file")
+	ErrLinkAlreadySubmitted    error = errors.New("link already submitted by you")
+	// Ingest status
+	ErrNoIngestJob error = errors.New("no ingest job found for link")
 	// Delete link
 	ErrDoesntOwnLink error = errors.New("not your link; cannot delete")
 	// Click link
 	ErrNoUserOrIP error = errors.New("click cannot be recorded without either authorized user ID or IP (neither found)")
+	// Recompute
+	ErrRecomputeRunning error = errors.New("recompute already running")
 )
 
 func ErrMaxDailyLinkSubmissionsReached(limit int) error {
 	return fmt.Errorf("you have submitted the max amount of links for today (%d)", limit)
 }
 
+func ErrMaxDailyImportedLinksReached(limit int) error {
+	return fmt.Errorf("you have submitted the max amount of links that can be imported today (%d)", limit)
+}
+
+func ErrTooManyBookmarks(limit int) error {
+	return fmt.Errorf("too many bookmarks in file (max %d per import)", limit)
+}
+
 func ErrLinkURLCharsExceedLimit(limit int) error {
 	return fmt.Errorf("URL too long (max %d chars)", limit)
 }
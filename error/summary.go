@@ -14,6 +14,8 @@ var (
 	ErrCannotLikeOwnSummary     error = errors.New("cannot like your own summary")
 	ErrSummaryAlreadyLiked      error = errors.New("summary already liked")
 	ErrSummaryNotLiked          error = errors.New("summary not already liked")
+	ErrNoSummaryRevisionID      error = errors.New("no summary revision ID provided")
+	ErrNoSummaryRevisionWithID  error = errors.New("no revision found with given ID for this summary")
 )
 
 func SummaryLengthExceedsLimit(limit int) error {
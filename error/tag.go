@@ -6,17 +6,31 @@ import (
 )
 
 var (
-	ErrNoTagID             error = errors.New("no tag ID provided")
-	ErrNoTagCats           error = errors.New("no tag cat(s) provided")
-	ErrNoGlobalCatsSnippet error = errors.New("no global cats snippet provided")
-	ErrNoOmittedCats       error = errors.New("no omitted cats provided")
-	ErrNoTagWithID         error = errors.New("no tag found with given ID")
-	ErrNoUserWithLoginName error = errors.New("no user found with given login name")
-	ErrInvalidMoreFlag     error = errors.New("invalid value passed as \"more\" params. should be unset or \"true\"")
-	ErrDuplicateTag        error = errors.New("duplicate tag")
-	ErrDuplicateCats       error = errors.New("tag contains duplicate cat(s)")
-	ErrDoesntOwnTag        error = errors.New("not your tag")
-	ErrCantDeleteOnlyTag   error = errors.New("last tag for this link; cannot be deleted")
+	ErrNoTagID                   error = errors.New("no tag ID provided")
+	ErrNoTagCats                 error = errors.New("no tag cat(s) provided")
+	ErrNoGlobalCatsSnippet       error = errors.New("no global cats snippet provided")
+	ErrNoOmittedCats             error = errors.New("no omitted cats provided")
+	ErrNoTagWithID               error = errors.New("no tag found with given ID")
+	ErrNoUserWithLoginName       error = errors.New("no user found with given login name")
+	ErrInvalidMoreFlag           error = errors.New("invalid value passed as \"more\" params. should be unset or \"true\"")
+	ErrDuplicateTag              error = errors.New("duplicate tag")
+	ErrDuplicateCats             error = errors.New("tag contains duplicate cat(s)")
+	ErrDoesntOwnTag              error = errors.New("not your tag")
+	ErrCantDeleteOnlyTag         error = errors.New("last tag for this link; cannot be deleted")
+	ErrInvalidMinWeight          error = errors.New("invalid min_weight provided: must be a positive integer")
+	ErrNoCatAlias                error = errors.New("no cat alias provided")
+	ErrCatAliasIsCat             error = errors.New("alias and cat must differ")
+	ErrCatAliasChain             error = errors.New("alias cannot target another alias or be the target of one")
+	ErrCantAliasNSFW             error = errors.New("NSFW cannot be aliased")
+	ErrCatAliasExists            error = errors.New("alias already exists")
+	ErrNoCatAliasWithName        error = errors.New("no cat alias found with given name")
+	ErrNoCatParent               error = errors.New("no parent cat provided")
+	ErrCatParentIsCat            error = errors.New("cat cannot be its own parent")
+	ErrCatParentCycle            error = errors.New("parent cat is already a descendant of cat")
+	ErrNoCatParentForCat         error = errors.New("no parent found for given cat")
+	ErrInvalidTreeFlag           error = errors.New("invalid value passed as \"tree\" params. should be unset or \"true\"")
+	ErrInvalidGlobalCatsStrategy error = errors.New("unknown global cats strategy")
+	ErrInvalidGraphFormat        error = errors.New("invalid format provided: should be unset, \"json\" or \"dot\"")
 )
 
 func CatCharsExceedLimit(limit int) error {
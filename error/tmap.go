@@ -21,6 +21,10 @@ var (
 	ErrNoTmapOwnerLoginName error = errors.New("no login name provided for Treasure Map owner")
 	ErrInvalidSectionParams error = errors.New("invalid section params provided")
 	ErrInvalidOnlySectionParams error = errors.New("invalid params provided for single Treasure Map section")
+	ErrCursorWithoutSection error = errors.New("cursor requires section params")
+
+	// Export
+	ErrInvalidExportFormatParams error = errors.New("invalid format params provided (accepted: html, json, csv)")
 )
 
 func ProfileAboutLengthExceedsLimit(limit int) error {
@@ -14,6 +14,7 @@ var (
 	ErrLoginNameTaken                error = errors.New("login name taken")
 	ErrLoginNameContainsInvalidChars error = errors.New("name contains invalid characters ([a-zA-Z0-9_] allowed)")
 	ErrNoJWTSecretEnv                error = errors.New("FITM_JWT_SECRET env var not set")
+	ErrNotAdmin                      error = errors.New("admins only")
 )
 
 func LoginNameExceedsLowerLimit(limit int) error {
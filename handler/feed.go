@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+
+	e "github.com/julianlk522/fitm/error"
+	util "github.com/julianlk522/fitm/handler/util"
+	"github.com/julianlk522/fitm/model"
+	"github.com/julianlk522/fitm/query"
+)
+
+func GetLinksFeed(w http.ResponseWriter, r *http.Request) {
+	params := util.GetFeedParams(r.URL.Query())
+	links_sql := query.
+		NewTopLinks().
+		FromRequestParams(params).
+		Page(1)
+
+	if links_sql.Error != nil {
+		render.Render(w, r, e.ErrInvalidRequest(links_sql.Error))
+		return
+	}
+
+	links, err := util.ScanLinksFeedLinks(links_sql)
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	}
+
+	title := "FITM: newest links"
+	if cats_params := params.Get("cats"); cats_params != "" {
+		title += " in " + strings.ReplaceAll(cats_params, ",", ", ")
+	}
+
+	RenderAtomFeed(w, r, util.NewAtomFeed(title, r, links))
+}
+
+func GetTmapFeed(w http.ResponseWriter, r *http.Request) {
+	var login_name string = chi.URLParam(r, "login_name")
+	if login_name == "" {
+		render.Render(w, r, e.ErrInvalidRequest(e.ErrNoLoginName))
+		return
+	}
+
+	user_exists, err := util.UserExists(login_name)
+	if err != nil {
+		render.Render(w, r, e.ErrInvalidRequest(err))
+		return
+	} else if !user_exists {
+		render.Render(w, r, e.Err404(e.ErrNoUserWithLoginName))
+		return
+	}
+
+	opts, err := util.GetTmapOptsFromRequestParams(
+		util.GetFeedParams(r.URL.Query()),
+	)
+	if err != nil {
+		render.Render(w, r, e.ErrInvalidRequest(err))
+		return
+	}
+	opts.OwnerLoginName = login_name
+
+	section_sqls, err := util.BuildTmapFeedQueries(opts)
+	if err != nil {
+		render.Render(w, r, e.ErrInvalidRequest(err))
+		return
+	}
+
+	links, err := util.ScanTmapFeedLinks(section_sqls)
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	}
+
+	title := "FITM: " + login_name + "'s treasure map"
+	if opts.Section != "" {
+		title += " (" + opts.Section + ")"
+	}
+
+	RenderAtomFeed(w, r, util.NewAtomFeed(title, r, links))
+}
+
+func RenderAtomFeed(w http.ResponseWriter, r *http.Request, feed *model.AtomFeed) {
+	data, err := util.MarshalAtomFeed(feed)
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"encoding/xml"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/julianlk522/fitm/model"
+)
+
+func TestGetFeeds(t *testing.T) {
+	var test_requests = []struct {
+		Path               string
+		ExpectedStatusCode int
+	}{
+		{"/feeds/links.atom", 200},
+		{"/feeds/links.atom?cats=umvc3&period=year", 200},
+		{"/feeds/links.atom?cats=umvc3&sort_by=rating&page=3", 200},
+		{"/feeds/links.atom?period=fortnight", 400},
+		{"/feeds/links.atom?cats=-umvc3", 400},
+		{"/feeds/map/" + TEST_LOGIN_NAME + ".atom", 200},
+		{"/feeds/map/" + TEST_LOGIN_NAME + ".atom?section=submitted&cats=umvc3", 200},
+		{"/feeds/map/" + TEST_LOGIN_NAME + ".atom?section=notasection", 400},
+		{"/feeds/map/not_a_real_user_1234.atom", 404},
+	}
+
+	r := chi.NewRouter()
+	r.Get("/feeds/links.atom", GetLinksFeed)
+	r.Get("/feeds/map/{login_name}.atom", GetTmapFeed)
+
+	for _, tr := range test_requests {
+		req := httptest.NewRequest("GET", tr.Path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tr.ExpectedStatusCode {
+			t.Fatalf(
+				"expected status code %d, got %d (%s)\n%s",
+				tr.ExpectedStatusCode,
+				w.Code,
+				tr.Path,
+				w.Body.String(),
+			)
+		} else if w.Code > 200 {
+			continue
+		}
+
+		if !strings.HasPrefix(w.Header().Get("Content-Type"), "application/atom+xml") {
+			t.Fatalf("got Content-Type %s, want Atom", w.Header().Get("Content-Type"))
+		}
+
+		var feed model.AtomFeed
+		if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+			t.Fatalf("invalid feed for %s: %s", tr.Path, err)
+		} else if feed.Title == "" || feed.ID == "" {
+			t.Fatalf("missing feed title or ID for %s", tr.Path)
+		}
+
+		// newest first
+		for i := 1; i < len(feed.Entries); i++ {
+			if feed.Entries[i].Published > feed.Entries[i-1].Published {
+				t.Fatalf(
+					"entry %s (%s) after older entry %s (%s)",
+					feed.Entries[i].ID,
+					feed.Entries[i].Published,
+					feed.Entries[i-1].ID,
+					feed.Entries[i-1].Published,
+				)
+			}
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"database/sql"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -34,6 +36,10 @@ func GetLinks(w http.ResponseWriter, r *http.Request) {
 	}
 
 	page := ctx.Value(m.PageKey).(int)
+	if r.URL.Query().Get("cursor") != "" && page > 1 {
+		render.Render(w, r, e.ErrInvalidRequest(e.ErrCursorWithPage))
+		return
+	}
 	links_sql = links_sql.Page(page)
 
 	if links_sql.Error != nil {
@@ -44,9 +50,14 @@ func GetLinks(w http.ResponseWriter, r *http.Request) {
 	var resp any
 	var err error
 
+	sort_by := r.URL.Query().Get("sort_by")
+	if sort_by == "" && r.URL.Query().Get("q") != "" {
+		sort_by = "relevance"
+	}
 	page_opts := &model.LinksPageOptions{
-		Cats: r.URL.Query().Get("cats"),
-		NSFW: r.URL.Query().Get("nsfw") == "true",
+		Cats:   r.URL.Query().Get("cats"),
+		NSFW:   r.URL.Query().Get("nsfw") == "true",
+		SortBy: sort_by,
 	}
 
 	if req_user_id != "" {
@@ -93,175 +104,170 @@ func AddLink(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// test URL response
-	var resp *http.Response
-	resp, err := util.GetResolvedURLResponse(request.URL)
+	// the rest (resolving the URL, auto summary, preview image) can be
+	// slow: done by the ingest worker
+	link_url, err := util.GetSubmittedLinkURL(request.URL)
 	if err != nil {
 		render.Render(w, r, e.ErrUnprocessable(err))
 		return
 	}
-	log.Printf("resp.StatusCode: %d", resp.StatusCode)
-	defer resp.Body.Close()
-
-	// save adjusted URL (after any redirects e.g., to wwww.)
-	// unless modified due to 302/401/403/429 etc. redirect
-	url_after_redirects := resp.Request.URL.String()
-	var final_url string
-
-	is_302_redirect := resp.StatusCode == http.StatusFound
-	is_unauthorized := resp.StatusCode == http.StatusUnauthorized
-	is_forbidden := resp.StatusCode == http.StatusForbidden
-	is_too_many_requests := resp.StatusCode == http.StatusTooManyRequests
-	is_google_sorry_page := strings.Contains(url_after_redirects, "google.com/sorry")
-
-	if (is_302_redirect || is_unauthorized || is_forbidden || is_too_many_requests || is_google_sorry_page) {
-		final_url = strings.TrimSuffix(request.URL, "/")
-	} else {
-		final_url = strings.TrimSuffix(url_after_redirects, "/")
-	}
 
-	if is_duplicate, link_id := util.LinkAlreadyAdded(final_url); is_duplicate {
+	if is_duplicate, link_id := util.LinkAlreadyAdded(link_url); is_duplicate {
 		render.Status(r, http.StatusConflict)
 		render.Render(w, r, e.ErrConflict(
-			e.ErrDuplicateLink(final_url, link_id),
+			e.ErrDuplicateLink(link_url, link_id),
 		))
 		return
 	}
 
-	var new_link = &model.NewLink{
-		SubmittedBy:    req_login_name,
-		NewLinkRequest: &model.NewLinkRequest{},
+	submitted_url := request.URL
+	request.URL = link_url
+	req_user_id := r.Context().Value(m.JWTClaimsKey).(map[string]any)["user_id"].(string)
+
+	job, err := util.AddLinkAndQueueIngestJob(
+		request,
+		req_login_name,
+		request.Summary,
+		req_user_id,
+		submitted_url,
+	)
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
 	}
-	var x_md *model.LinkExtraMetadata
 
-	if util.IsYTVideo(final_url) {
-		if yt_md, err := util.GetYTVideoMetadata(final_url); err == nil {
-			new_link.URL = "https://www.youtube.com/watch?v=" + yt_md.ID
-			new_link.AutoSummary = yt_md.Items[0].Snippet.Title
-			new_link.PreviewImgURL = yt_md.Items[0].Snippet.Thumbnails.Default.URL
-		} else {
-			x_md = util.GetLinkExtraMetadataFromResponse(resp)
-		}
-	} else {
-		x_md = util.GetLinkExtraMetadataFromResponse(resp)
+	new_link := &model.NewLink{
+		NewLinkRequest: request,
+		SubmittedBy:    req_login_name,
+	}
+	new_link.Cats = util.AlphabetizeCats(request.Cats)
+	if new_link.Summary != "" {
+		new_link.SummaryCount = 1
 	}
-	if x_md != nil {
-		if x_md.AutoSummary != "" {
-			new_link.AutoSummary = x_md.AutoSummary
-		}
 
-		if x_md.PreviewImgURL != "" {
-			new_link.PreviewImgURL = x_md.PreviewImgURL
-		}
+	w.Header().Set("Location", "/links/"+request.LinkID+"/ingest-status")
+	render.Status(r, http.StatusAccepted)
+	render.JSON(w, r, model.NewLinkIngest{
+		NewLink:   new_link,
+		IngestJob: job,
+	})
+}
+
+func GetLinkIngestStatus(w http.ResponseWriter, r *http.Request) {
+	link_id := chi.URLParam(r, "link_id")
+	if link_id == "" {
+		render.Render(w, r, e.ErrInvalidRequest(e.ErrNoLinkID))
+		return
 	}
 
-	// Verified: add link
-	tx, err := db.Client.Begin()
-	if err != nil {
+	job, err := util.GetLatestIngestJob(link_id)
+	if err == sql.ErrNoRows {
+		render.Render(w, r, e.Err404(e.ErrNoIngestJob))
+		return
+	} else if err != nil {
 		render.Render(w, r, e.Err500(err))
 		return
 	}
-	defer tx.Rollback()
 
-	new_link.LinkID = request.LinkID
-	new_link.SubmitDate = request.SubmitDate
-
-	// Insert auto summary
-	if new_link.AutoSummary != "" {
-		if _, err := tx.Exec(
-			"INSERT INTO Summaries VALUES(?,?,?,?,?);",
-			uuid.New().String(),
-			new_link.AutoSummary,
-			new_link.LinkID,
-			db.AUTO_SUMMARY_USER_ID,
-			new_link.SubmitDate,
-		); err != nil {
-			log.Print("Error adding auto summary: ", err)
-		} else {
-			new_link.SummaryCount = 1
-		}
+	render.JSON(w, r, job)
+}
+
+// Latest check's status and the link's check history
+func GetLinkHealth(w http.ResponseWriter, r *http.Request) {
+	link_id := chi.URLParam(r, "link_id")
+	if link_id == "" {
+		render.Render(w, r, e.ErrInvalidRequest(e.ErrNoLinkID))
+		return
 	}
 
-	// Insert summary
-	new_link.Summary = request.Summary
-	if new_link.Summary != "" {
-		req_user_id := r.Context().Value(m.JWTClaimsKey).(map[string]any)["user_id"].(string)
-		if _, err := tx.Exec(
-			"INSERT INTO Summaries VALUES(?,?,?,?,?);",
-			uuid.New().String(),
-			new_link.Summary,
-			new_link.LinkID,
-			req_user_id,
-			new_link.SubmitDate,
-		); err != nil {
-			render.Render(w, r, e.Err500(err))
-			return
-		} else {
-			new_link.SummaryCount += 1
-		}
+	link_exists, err := util.LinkExists(link_id)
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	} else if !link_exists {
+		render.Render(w, r, e.Err404(e.ErrNoLinkWithID))
+		return
 	}
 
-	// Insert tag
-	new_link.Cats = util.AlphabetizeCats(request.Cats)
-	if _, err = tx.Exec(
-		"INSERT INTO Tags VALUES(?,?,?,?,?);",
-		uuid.New().String(),
-		new_link.LinkID,
-		new_link.Cats,
-		new_link.SubmittedBy,
-		new_link.SubmitDate,
-	); err != nil {
+	health, err := util.GetLinkHealth(link_id)
+	if err != nil {
 		render.Render(w, r, e.Err500(err))
 		return
 	}
 
-	// Insert link
-	new_link.URL = final_url
+	render.JSON(w, r, health)
+}
 
-	if new_link.Summary == "" && new_link.AutoSummary != "" {
-		new_link.Summary = new_link.AutoSummary
+// multipart form: "bookmarks" file (Netscape HTML, CSV or browser JSON:
+// see util.ParseBookmarks), optional "format" and "cats" added to every
+// link
+func ImportLinks(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(util.MAX_BOOKMARKS_FILE_BYTES); err != nil {
+		render.Render(w, r, e.ErrInvalidRequest(err))
+		return
 	}
 
-	if new_link.PreviewImgURL != "" {
-		new_link.PreviewImgFilename, err = util.SavePreviewImgAndGetFileName(
-			new_link.PreviewImgURL,
-			new_link.LinkID,
-		)
-		if err != nil {
-			// skip - link won't have a preview image
-		}
-	}
+	req_user_id := r.Context().Value(m.JWTClaimsKey).(map[string]any)["user_id"].(string)
+	req_login_name := r.Context().Value(m.JWTClaimsKey).(map[string]any)["login_name"].(string)
 
-	if _, err = tx.Exec(
-		"INSERT INTO Links VALUES(?,?,?,?,?,?,?);",
-		new_link.LinkID,
-		new_link.URL,
-		new_link.SubmittedBy,
-		new_link.SubmitDate,
-		new_link.Cats,
-		new_link.Summary,
-		new_link.PreviewImgFilename,
-	); err != nil {
+	daily_links_count, err := util.GetUserDailyLinksCount(req_login_name)
+	if err != nil {
 		render.Render(w, r, e.Err500(err))
 		return
+	} else if daily_links_count >= util.MAX_DAILY_IMPORTED_LINKS {
+		render.Render(w, r, e.ErrTooManyRequests(e.ErrMaxDailyImportedLinksReached(util.MAX_DAILY_IMPORTED_LINKS)))
+		return
 	}
 
-	// Increment spellfix ranks
-	if err = util.IncrementSpellfixRanksForCats(
-		tx,
-		strings.Split(request.Cats, ","),
-	); err != nil {
-		render.Render(w, r, e.Err500(err))
+	bookmarks_file, header, err := r.FormFile("bookmarks")
+	if err != nil {
+		render.Render(w, r, e.ErrInvalidRequest(err))
 		return
 	}
+	defer bookmarks_file.Close()
 
-	if err = tx.Commit(); err != nil {
+	data, err := io.ReadAll(io.LimitReader(bookmarks_file, util.MAX_BOOKMARKS_FILE_BYTES+1))
+	if err != nil {
 		render.Render(w, r, e.Err500(err))
 		return
+	} else if len(data) > util.MAX_BOOKMARKS_FILE_BYTES {
+		render.Render(w, r, e.ErrInvalidRequest(e.ErrBookmarksFileTooLarge))
+		return
 	}
 
-	render.Status(r, http.StatusCreated)
-	render.JSON(w, r, new_link)
+	format, err := util.GetBookmarksFormat(r.FormValue("format"), header.Filename, data)
+	if err != nil {
+		render.Render(w, r, e.ErrInvalidRequest(err))
+		return
+	}
+
+	bookmarks, err := util.ParseBookmarks(format, data)
+	if err != nil {
+		render.Render(w, r, e.ErrInvalidRequest(err))
+		return
+	} else if len(bookmarks) == 0 {
+		render.Render(w, r, e.ErrInvalidRequest(e.ErrNoBookmarks))
+		return
+	} else if len(bookmarks) > util.MAX_IMPORTED_BOOKMARKS {
+		render.Render(w, r, e.ErrInvalidRequest(e.ErrTooManyBookmarks(util.MAX_IMPORTED_BOOKMARKS)))
+		return
+	}
+
+	var extra_cats []string
+	if cats := r.FormValue("cats"); cats != "" {
+		extra_cats = strings.Split(cats, ",")
+	}
+
+	link_import := util.ImportBookmarks(
+		bookmarks,
+		extra_cats,
+		req_user_id,
+		req_login_name,
+		util.MAX_DAILY_IMPORTED_LINKS-daily_links_count,
+	)
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, link_import)
 }
 
 func DeleteLink(w http.ResponseWriter, r *http.Request) {
@@ -366,7 +372,7 @@ func LikeLink(w http.ResponseWriter, r *http.Request) {
 
 	new_like_id := uuid.New().String()
 	_, err := db.Client.Exec(
-		`INSERT INTO "Link Likes" VALUES(?,?,?,?);`,
+		`INSERT INTO "Link Likes" (id, link_id, user_id, timestamp) VALUES(?,?,?,?);`,
 		new_like_id,
 		link_id,
 		req_user_id,
@@ -429,7 +435,7 @@ func CopyLink(w http.ResponseWriter, r *http.Request) {
 	new_copy_id := uuid.New().String()
 
 	_, err := db.Client.Exec(
-		`INSERT INTO "Link Copies" VALUES(?,?,?,?);`,
+		`INSERT INTO "Link Copies" (id, link_id, user_id, timestamp) VALUES(?,?,?,?);`,
 		new_copy_id,
 		link_id,
 		req_user_id,
@@ -512,7 +518,7 @@ func ClickLink(w http.ResponseWriter, r *http.Request) {
 	result.ID = uuid.New().String()
 
 	if _, err = db.Client.Exec(
-		`INSERT INTO "Clicks" VALUES(?,?,?,?,?);`,
+		`INSERT INTO "Clicks" (id, link_id, user_id, ip_addr, timestamp) VALUES(?,?,?,?,?);`,
 		result.ID,
 		result.LinkID,
 		result.UserID,
@@ -11,10 +11,16 @@ import (
 
 	"testing"
 
+	"github.com/go-chi/chi/v5"
+
+	"github.com/julianlk522/fitm/db"
 	m "github.com/julianlk522/fitm/middleware"
+	"github.com/julianlk522/fitm/model"
 )
 
 func TestGetLinks(t *testing.T) {
+	test_cursor := model.NewLinksCursor("rating", model.Link{ID: "1"}).Encode()
+
 	test_get_links_requests := []struct {
 		Params map[string]string
 		Page   int
@@ -110,6 +116,57 @@ func TestGetLinks(t *testing.T) {
 			Page:   1,
 			Valid:  true,
 		},
+		{
+			Params: map[string]string{"cursor": test_cursor},
+			Page:   1,
+			Valid:  true,
+		},
+		// fails: cursor can't be decoded
+		{
+			Params: map[string]string{"cursor": "invalid"},
+			Page:   1,
+			Valid:  false,
+		},
+		// fails: cursor was made for another sort
+		{
+			Params: map[string]string{
+				"cursor":  test_cursor,
+				"sort_by": "newest",
+			},
+			Page:  1,
+			Valid: false,
+		},
+		// fails: relevance can't be resumed from a cursor
+		{
+			Params: map[string]string{
+				"cursor": test_cursor,
+				"q":      "go",
+			},
+			Page:  1,
+			Valid: false,
+		},
+		// fails: cursor and page > 1
+		{
+			Params: map[string]string{"cursor": test_cursor},
+			Page:   2,
+			Valid:  false,
+		},
+		{
+			Params: map[string]string{"health": "dead"},
+			Page:   1,
+			Valid:  true,
+		},
+		{
+			Params: map[string]string{"health": "ok,unchecked"},
+			Page:   1,
+			Valid:  true,
+		},
+		// fails: not a link health
+		{
+			Params: map[string]string{"health": "rotten"},
+			Page:   1,
+			Valid:  false,
+		},
 	}
 
 	for _, tglr := range test_get_links_requests {
@@ -227,7 +284,7 @@ func TestAddLink(t *testing.T) {
 				"cats":    "watermelon",
 				"summary": "test",
 			},
-			ExpectedStatusCode: 201,
+			ExpectedStatusCode: 202,
 		},
 		{
 			Payload: map[string]string{
@@ -235,7 +292,7 @@ func TestAddLink(t *testing.T) {
 				"cats":    "watermelon",
 				"summary": "testy",
 			},
-			ExpectedStatusCode: 201,
+			ExpectedStatusCode: 202,
 		},
 		{
 			Payload: map[string]string{
@@ -243,12 +300,13 @@ func TestAddLink(t *testing.T) {
 				"cats":    "watermelon",
 				"summary": "testiest",
 			},
-			ExpectedStatusCode: 201,
+			ExpectedStatusCode: 202,
 		},
-		// should fail due to duplicate from previous test with url "google.com"
+		// should fail due to duplicate from previous test with url
+		// "https://community.hubspot.com/" (trailing slash dropped)
 		{
 			Payload: map[string]string{
-				"url":     "https://www.google.com",
+				"url":     "community.hubspot.com",
 				"cats":    "test",
 				"summary": "",
 			},
@@ -289,6 +347,213 @@ func TestAddLink(t *testing.T) {
 	}
 }
 
+// New links start with their own tag and summary counted
+func TestAddLinkStats(t *testing.T) {
+	pl, _ := json.Marshal(map[string]string{
+		"url":     "example.com/add-link-stats-test",
+		"cats":    "testing",
+		"summary": "link stats test",
+	})
+	r := httptest.NewRequest(http.MethodPost, "/links", bytes.NewReader(pl))
+	r.Header.Set("Content-Type", "application/json")
+	r = r.WithContext(context.WithValue(
+		context.Background(),
+		m.JWTClaimsKey,
+		map[string]any{
+			"user_id":    TEST_USER_ID,
+			"login_name": TEST_LOGIN_NAME,
+		},
+	))
+
+	w := httptest.NewRecorder()
+	AddLink(w, r)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected status code 202, got %d\n%s", w.Code, w.Body.String())
+	}
+
+	var new_link model.NewLinkIngest
+	if err := json.Unmarshal(w.Body.Bytes(), &new_link); err != nil {
+		t.Fatal(err)
+	}
+	link_id := new_link.LinkID
+	t.Cleanup(func() {
+		db.Client.Exec("DELETE FROM Links WHERE id = ?;", link_id)
+		db.Client.Exec(`DELETE FROM "Ingest Jobs" WHERE link_id = ?;`, link_id)
+	})
+
+	var tag_count, summary_count int
+	if err := db.Client.QueryRow(
+		`SELECT tag_count, summary_count FROM "Link Stats" WHERE link_id = ?;`,
+		link_id,
+	).Scan(&tag_count, &summary_count); err != nil {
+		t.Fatal(err)
+	} else if tag_count != 1 || summary_count != 1 {
+		t.Fatalf("got %d tags, %d summaries, want 1 each", tag_count, summary_count)
+	}
+}
+
+func TestGetLinkIngestStatus(t *testing.T) {
+	pl, _ := json.Marshal(map[string]string{
+		"url":  "example.com/ingest-status-test",
+		"cats": "testing",
+	})
+	r := httptest.NewRequest(http.MethodPost, "/links", bytes.NewReader(pl))
+	r.Header.Set("Content-Type", "application/json")
+	r = r.WithContext(context.WithValue(
+		context.Background(),
+		m.JWTClaimsKey,
+		map[string]any{
+			"user_id":    TEST_USER_ID,
+			"login_name": TEST_LOGIN_NAME,
+		},
+	))
+
+	w := httptest.NewRecorder()
+	AddLink(w, r)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected status code 202, got %d\n%s", w.Code, w.Body.String())
+	}
+
+	var new_link model.NewLinkIngest
+	if err := json.Unmarshal(w.Body.Bytes(), &new_link); err != nil {
+		t.Fatal(err)
+	} else if new_link.NewLink == nil || new_link.IngestJob == nil {
+		t.Fatalf("got %s, want link and ingest job", w.Body.String())
+	}
+	link_id := new_link.LinkID
+	t.Cleanup(func() {
+		for _, table := range []string{"Summaries", "Tags"} {
+			db.Client.Exec("DELETE FROM "+table+" WHERE link_id = ?;", link_id)
+		}
+		db.Client.Exec("DELETE FROM Links WHERE id = ?;", link_id)
+		db.Client.Exec(`DELETE FROM "Ingest Jobs" WHERE link_id = ?;`, link_id)
+	})
+
+	if new_link.URL != "https://example.com/ingest-status-test" {
+		t.Fatalf("got URL %s, want https:// added", new_link.URL)
+	} else if location := w.Header().Get("Location"); location != "/links/"+link_id+"/ingest-status" {
+		t.Fatalf("got Location %s", location)
+	}
+
+	router := chi.NewRouter()
+	router.Get("/links/{link_id}/ingest-status", GetLinkIngestStatus)
+
+	var test_requests = []struct {
+		LinkID             string
+		ExpectedStatusCode int
+	}{
+		{link_id, 200},
+		{"-1", 404},
+	}
+
+	for _, tr := range test_requests {
+		r := httptest.NewRequest(http.MethodGet, "/links/"+tr.LinkID+"/ingest-status", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+
+		if w.Code != tr.ExpectedStatusCode {
+			t.Fatalf(
+				"expected status code %d for link %s, got %d",
+				tr.ExpectedStatusCode,
+				tr.LinkID,
+				w.Code,
+			)
+		} else if w.Code > 200 {
+			continue
+		}
+
+		var job model.IngestJob
+		if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+			t.Fatal(err)
+		} else if job.ID != new_link.IngestJob.ID || job.Status != model.INGEST_JOB_PENDING {
+			t.Fatalf("got %+v, want pending job %s", job, new_link.IngestJob.ID)
+		}
+	}
+}
+
+func TestGetLinkHealth(t *testing.T) {
+	router := chi.NewRouter()
+	router.Get("/links/{link_id}/health", GetLinkHealth)
+
+	var test_requests = []struct {
+		LinkID             string
+		ExpectedStatusCode int
+	}{
+		{"1", 200},
+		{"-1", 404},
+	}
+
+	for _, tr := range test_requests {
+		r := httptest.NewRequest(http.MethodGet, "/links/"+tr.LinkID+"/health", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+
+		if w.Code != tr.ExpectedStatusCode {
+			t.Fatalf(
+				"expected status code %d for link %s, got %d",
+				tr.ExpectedStatusCode,
+				tr.LinkID,
+				w.Code,
+			)
+		} else if w.Code > 200 {
+			continue
+		}
+
+		var health model.LinkHealth
+		if err := json.Unmarshal(w.Body.Bytes(), &health); err != nil {
+			t.Fatal(err)
+		} else if health.LinkID != tr.LinkID || health.Health == "" || health.Checks == nil {
+			t.Fatalf("got %s, want link health and checks", w.Body.String())
+		}
+	}
+}
+
+func TestImportLinks(t *testing.T) {
+	var test_requests = []struct {
+		ContentType        string
+		Body               string
+		ExpectedStatusCode int
+	}{
+		// not a multipart form
+		{"application/json", `{"bookmarks": []}`, 400},
+		// no boundary
+		{"multipart/form-data", "", 400},
+		{
+			"multipart/form-data; boundary=b",
+			"--b\r\n" +
+				`Content-Disposition: form-data; name="bookmarks"; filename="bookmarks.csv"` + "\r\n\r\n" +
+				"url,title\r\nhttps://go.dev,Go\r\n" +
+				"--b--\r\n",
+			200,
+		},
+	}
+
+	for _, tr := range test_requests {
+		r := httptest.NewRequest(http.MethodPost, "/links/import", strings.NewReader(tr.Body))
+		r.Header.Set("Content-Type", tr.ContentType)
+		r = r.WithContext(context.WithValue(
+			context.Background(),
+			m.JWTClaimsKey,
+			map[string]any{
+				"user_id":    TEST_USER_ID,
+				"login_name": TEST_LOGIN_NAME,
+			},
+		))
+
+		w := httptest.NewRecorder()
+		ImportLinks(w, r)
+		if w.Code != tr.ExpectedStatusCode {
+			t.Fatalf(
+				"expected status code %d for %s, got %d\n%s",
+				tr.ExpectedStatusCode,
+				tr.ContentType,
+				w.Code,
+				w.Body.String(),
+			)
+		}
+	}
+}
+
 func TestDeleteLink(t *testing.T) {
 	var test_requests = []struct {
 		LinkID             string
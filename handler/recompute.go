@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/go-chi/render"
+
+	e "github.com/julianlk522/fitm/error"
+	util "github.com/julianlk522/fitm/handler/util"
+)
+
+func GetRecomputeStatus(w http.ResponseWriter, r *http.Request) {
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, util.GetRecomputeStatus())
+}
+
+// Takes no params; the recompute runs in the background and its progress
+// can be polled with GetRecomputeStatus
+func TriggerRecompute(w http.ResponseWriter, r *http.Request) {
+	if err := util.StartRecompute(); err != nil {
+		render.Render(w, r, e.ErrConflict(err))
+		return
+	}
+
+	render.Status(r, http.StatusAccepted)
+	render.JSON(w, r, util.GetRecomputeStatus())
+}
+
+// Reports drift without changing anything
+func GetSpellfixDrift(w http.ResponseWriter, r *http.Request) {
+	reconciliation, err := util.ReconcileSpellfix(true)
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, reconciliation)
+}
+
+func ReconcileSpellfix(w http.ResponseWriter, r *http.Request) {
+	reconciliation, err := util.ReconcileSpellfix(false)
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	}
+	util.LogSpellfixReconciliation(reconciliation)
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, reconciliation)
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	util "github.com/julianlk522/fitm/handler/util"
+	"github.com/julianlk522/fitm/model"
+)
+
+func TestTriggerRecompute(t *testing.T) {
+	w := httptest.NewRecorder()
+	TriggerRecompute(w, httptest.NewRequest("POST", "/recompute", nil))
+	if w.Code != 202 {
+		t.Fatalf("expected status code 202, got %d \n%s", w.Code, w.Body.String())
+	}
+
+	// second trigger while the first is still running (or already done)
+	w = httptest.NewRecorder()
+	TriggerRecompute(w, httptest.NewRequest("POST", "/recompute", nil))
+	if w.Code != 202 && w.Code != 409 {
+		t.Fatalf("expected status code 202 or 409, got %d \n%s", w.Code, w.Body.String())
+	}
+	util.StopRecompute()
+
+	w = httptest.NewRecorder()
+	GetRecomputeStatus(w, httptest.NewRequest("GET", "/recompute", nil))
+	if w.Code != 200 {
+		t.Fatalf("expected status code 200, got %d", w.Code)
+	}
+
+	var status model.RecomputeStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	} else if status.Running {
+		t.Fatal("expected recompute stopped")
+	} else if status.StartedAt == "" || status.FinishedAt == "" {
+		t.Fatalf("expected start and finish times, got %+v", status)
+	}
+}
+
+func TestGetSpellfixDrift(t *testing.T) {
+	w := httptest.NewRecorder()
+	GetSpellfixDrift(w, httptest.NewRequest("GET", "/spellfix/drift", nil))
+	if w.Code != 200 {
+		t.Fatalf("expected status code 200, got %d \n%s", w.Code, w.Body.String())
+	}
+
+	var reconciliation model.SpellfixReconciliation
+	if err := json.Unmarshal(w.Body.Bytes(), &reconciliation); err != nil {
+		t.Fatal(err)
+	} else if reconciliation.Fixed {
+		t.Fatal("drift report should not fix drift")
+	} else if reconciliation.WordsChecked == 0 {
+		t.Fatal("expected words checked")
+	}
+}
@@ -13,6 +13,7 @@ import (
 	util "github.com/julianlk522/fitm/handler/util"
 	m "github.com/julianlk522/fitm/middleware"
 	"github.com/julianlk522/fitm/model"
+	mutil "github.com/julianlk522/fitm/model/util"
 )
 
 func GetSummaryPage(w http.ResponseWriter, r *http.Request) {
@@ -69,8 +70,8 @@ func AddSummary(w http.ResponseWriter, r *http.Request) {
 	// Create summary if not already exists
 	if err != nil {
 		if err == sql.ErrNoRows {
-			_, err = db.Client.Exec(
-				`INSERT INTO Summaries VALUES (?,?,?,?,?)`,
+			_, err = tx.Exec(
+				`INSERT INTO Summaries (id, text, link_id, submitted_by, last_updated) VALUES (?,?,?,?,?)`,
 				summary_data.ID,
 				summary_data.Text,
 				summary_data.LinkID,
@@ -89,29 +90,77 @@ func AddSummary(w http.ResponseWriter, r *http.Request) {
 
 		// Update summary if exists
 	} else {
-		_, err = db.Client.Exec(
-			`UPDATE Summaries SET text = ?, last_updated = ? WHERE submitted_by = ? AND link_id = ?`,
+		if _, err = util.ReplaceSummaryText(
+			tx,
+			summary_id,
 			summary_data.Text,
 			summary_data.LastUpdated,
-			req_user_id,
-			summary_data.LinkID,
-		)
-		if err != nil {
+		); err != nil {
 			render.Render(w, r, e.Err500(err))
 			return
 		}
+	}
 
-		_, err = db.Client.Exec(
-			`DELETE FROM "Summary Likes" WHERE summary_id = ?`,
-			summary_id,
-		)
-		if err != nil {
+	err = util.CalculateAndSetGlobalSummary(tx, summary_data.LinkID)
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func EditSummary(w http.ResponseWriter, r *http.Request) {
+	edit_summary_data := &model.EditSummaryRequest{}
+	if err := render.Bind(r, edit_summary_data); err != nil {
+		render.Render(w, r, e.ErrInvalidRequest(err))
+		return
+	}
+
+	req_user_id := r.Context().Value(m.JWTClaimsKey).(map[string]any)["user_id"].(string)
+	owns_summary, err := util.SummarySubmittedByUser(edit_summary_data.SummaryID, req_user_id)
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	} else if !owns_summary {
+		render.Render(w, r, e.ErrInvalidRequest(e.ErrDoesntOwnSummary))
+		return
+	}
+
+	link_id, err := util.GetLinkIDFromSummaryID(edit_summary_data.SummaryID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			render.Render(w, r, e.ErrInvalidRequest(e.ErrNoSummaryWithID))
+		} else {
 			render.Render(w, r, e.Err500(err))
-			return
 		}
+		return
+	}
+
+	tx, err := db.Client.Begin()
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	}
+	defer tx.Rollback()
+
+	edit_summary_data.LikesReset, err = util.ReplaceSummaryText(
+		tx,
+		edit_summary_data.SummaryID,
+		edit_summary_data.Text,
+		edit_summary_data.LastUpdated,
+	)
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
 	}
 
-	err = util.CalculateAndSetGlobalSummary(summary_data.LinkID)
+	err = util.CalculateAndSetGlobalSummary(tx, link_id)
 	if err != nil {
 		render.Render(w, r, e.Err500(err))
 		return
@@ -122,7 +171,110 @@ func AddSummary(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, edit_summary_data)
+}
+
+func GetSummaryHistory(w http.ResponseWriter, r *http.Request) {
+	summary_id := chi.URLParam(r, "summary_id")
+	if summary_id == "" {
+		render.Render(w, r, e.ErrInvalidRequest(e.ErrNoSummaryID))
+		return
+	}
+
+	history, err := util.BuildSummaryHistory(summary_id)
+	if err != nil {
+		if err == e.ErrNoSummaryWithID {
+			render.Render(w, r, e.ErrInvalidRequest(err))
+		} else {
+			render.Render(w, r, e.Err500(err))
+		}
+		return
+	}
+
+	render.JSON(w, r, history)
+}
+
+func RestoreSummaryRevision(w http.ResponseWriter, r *http.Request) {
+	summary_id := chi.URLParam(r, "summary_id")
+	if summary_id == "" {
+		render.Render(w, r, e.ErrInvalidRequest(e.ErrNoSummaryID))
+		return
+	}
+
+	rev_id := chi.URLParam(r, "rev_id")
+	if rev_id == "" {
+		render.Render(w, r, e.ErrInvalidRequest(e.ErrNoSummaryRevisionID))
+		return
+	}
+
+	req_user_id := r.Context().Value(m.JWTClaimsKey).(map[string]any)["user_id"].(string)
+	owns_summary, err := util.SummarySubmittedByUser(summary_id, req_user_id)
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	} else if !owns_summary {
+		render.Render(w, r, e.ErrInvalidRequest(e.ErrDoesntOwnSummary))
+		return
+	}
+
+	link_id, err := util.GetLinkIDFromSummaryID(summary_id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			render.Render(w, r, e.ErrInvalidRequest(e.ErrNoSummaryWithID))
+		} else {
+			render.Render(w, r, e.Err500(err))
+		}
+		return
+	}
+
+	rev_text, err := util.GetSummaryRevisionText(summary_id, rev_id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			render.Render(w, r, e.ErrInvalidRequest(e.ErrNoSummaryRevisionWithID))
+		} else {
+			render.Render(w, r, e.Err500(err))
+		}
+		return
+	}
+
+	restored := &model.EditSummaryRequest{
+		SummaryID:   summary_id,
+		Text:        rev_text,
+		LastUpdated: mutil.NEW_LONG_TIMESTAMP(),
+	}
+
+	tx, err := db.Client.Begin()
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	}
+	defer tx.Rollback()
+
+	restored.LikesReset, err = util.ReplaceSummaryText(
+		tx,
+		restored.SummaryID,
+		restored.Text,
+		restored.LastUpdated,
+	)
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	}
+
+	err = util.CalculateAndSetGlobalSummary(tx, link_id)
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, restored)
 }
 
 func DeleteSummary(w http.ResponseWriter, r *http.Request) {
@@ -155,7 +307,7 @@ func DeleteSummary(w http.ResponseWriter, r *http.Request) {
 	}
 	defer tx.Rollback()
 
-	_, err = db.Client.Exec(
+	_, err = tx.Exec(
 		`DELETE FROM Summaries WHERE id = ?`,
 		delete_data.SummaryID,
 	)
@@ -164,7 +316,7 @@ func DeleteSummary(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = util.CalculateAndSetGlobalSummary(link_id)
+	err = util.CalculateAndSetGlobalSummary(tx, link_id)
 	if err != nil {
 		render.Render(w, r, e.Err500(err))
 		return
@@ -230,18 +382,19 @@ func LikeSummary(w http.ResponseWriter, r *http.Request) {
 	}
 	defer tx.Rollback()
 
-	_, err = db.Client.Exec(
-		`INSERT INTO "Summary Likes" VALUES (?,?,?)`,
+	_, err = tx.Exec(
+		`INSERT INTO "Summary Likes" (id, summary_id, user_id, timestamp) VALUES (?,?,?,?)`,
 		uuid.New().String(),
 		summary_id,
 		req_user_id,
+		mutil.NEW_LONG_TIMESTAMP(),
 	)
 	if err != nil {
 		render.Render(w, r, e.Err500(err))
 		return
 	}
 
-	err = util.CalculateAndSetGlobalSummary(link_id.String)
+	err = util.CalculateAndSetGlobalSummary(tx, link_id.String)
 	if err != nil {
 		render.Render(w, r, e.Err500(err))
 		return
@@ -290,7 +443,7 @@ func UnlikeSummary(w http.ResponseWriter, r *http.Request) {
 	}
 	defer tx.Rollback()
 
-	_, err = db.Client.Exec(
+	_, err = tx.Exec(
 		`DELETE FROM "Summary Likes" WHERE user_id = ? AND summary_id = ?`, req_user_id,
 		summary_id,
 	)
@@ -299,7 +452,7 @@ func UnlikeSummary(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = util.CalculateAndSetGlobalSummary(link_id.String)
+	err = util.CalculateAndSetGlobalSummary(tx, link_id.String)
 	if err != nil {
 		render.Render(w, r, e.Err500(err))
 		return
@@ -9,6 +9,9 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/go-chi/chi/v5"
+
+	"github.com/julianlk522/fitm/db"
 	m "github.com/julianlk522/fitm/middleware"
 )
 
@@ -111,3 +114,238 @@ func TestAddSummary(t *testing.T) {
 		}
 	}
 }
+
+func TestEditSummary(t *testing.T) {
+	test_edit_summary_requests := []struct {
+		Payload map[string]string
+		Valid   bool
+	}{
+		{
+			Payload: map[string]string{
+				"summary_id": "",
+				"text":       "test",
+			},
+			Valid: false,
+		},
+		{
+			Payload: map[string]string{
+				"summary_id": "65",
+				"text":       "",
+			},
+			Valid: false,
+		},
+		// should fail because test user jlk did not submit summary with ID 7
+		{
+			Payload: map[string]string{
+				"summary_id": "7",
+				"text":       "test",
+			},
+			Valid: false,
+		},
+		{
+			Payload: map[string]string{
+				"summary_id": "-1",
+				"text":       "test",
+			},
+			Valid: false,
+		},
+		{
+			Payload: map[string]string{
+				"summary_id": "65",
+				"text":       "edited summary text",
+			},
+			Valid: true,
+		},
+	}
+
+	for _, tr := range test_edit_summary_requests {
+		pl, _ := json.Marshal(tr.Payload)
+		r := httptest.NewRequest(
+			http.MethodPut,
+			"/summaries",
+			bytes.NewReader(pl),
+		)
+		r.Header.Set("Content-Type", "application/json")
+
+		ctx := context.Background()
+		jwt_claims := map[string]any{
+			"user_id":    TEST_USER_ID,
+			"login_name": TEST_LOGIN_NAME,
+		}
+		ctx = context.WithValue(ctx, m.JWTClaimsKey, jwt_claims)
+		r = r.WithContext(ctx)
+
+		w := httptest.NewRecorder()
+		EditSummary(w, r)
+		res := w.Result()
+		defer res.Body.Close()
+
+		if tr.Valid && res.StatusCode != 200 {
+			text, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal("failed but unable to read request body bytes")
+			} else {
+				t.Fatalf(
+					"expected status code 200, got %d (test request %+v)\n%s", res.StatusCode,
+					tr.Payload,
+					text,
+				)
+			}
+		} else if !tr.Valid && res.StatusCode != 400 {
+			t.Fatalf("expected status code 400, got %d", res.StatusCode)
+		}
+	}
+}
+
+// A failure after the text is replaced (here, setting the new global
+// summary) leaves the summary, its likes and the link untouched
+func TestEditSummaryIsAtomic(t *testing.T) {
+	const (
+		test_link_id    = "edit-summary-atomic"
+		test_summary_id = "edit-summary-atomic"
+		original_text   = "original summary text"
+	)
+
+	for _, stmt := range []string{
+		`INSERT INTO Links (id, url, submitted_by, submit_date, global_cats, global_summary, img_file)
+		VALUES ('edit-summary-atomic', 'https://example.com/edit-summary-atomic', 'jlk', '2024-01-01 00:00:00', 'testing', 'original summary text', '');`,
+		`INSERT INTO Summaries (id, text, link_id, submitted_by, last_updated)
+		VALUES ('edit-summary-atomic', 'original summary text', 'edit-summary-atomic', '3', '2024-01-01 00:00:00');`,
+		`INSERT INTO "Summary Likes" (id, summary_id, user_id, timestamp)
+		VALUES ('edit-summary-atomic', 'edit-summary-atomic', '4', '2024-01-01 00:00:00');`,
+		`CREATE TRIGGER fail_edit_summary_atomic
+		BEFORE UPDATE OF global_summary ON Links
+		WHEN old.id = 'edit-summary-atomic'
+		BEGIN
+			SELECT RAISE(ABORT, 'forced failure');
+		END;`,
+	} {
+		if _, err := db.Client.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		db.Client.Exec("DROP TRIGGER IF EXISTS fail_edit_summary_atomic;")
+		db.Client.Exec("DELETE FROM Links WHERE id = ?;", test_link_id)
+	})
+
+	// rewritten enough to reset likes and change the global summary
+	pl, _ := json.Marshal(map[string]string{
+		"summary_id": test_summary_id,
+		"text":       "something else entirely",
+	})
+	r := httptest.NewRequest(http.MethodPut, "/summaries", bytes.NewReader(pl))
+	r.Header.Set("Content-Type", "application/json")
+	r = r.WithContext(context.WithValue(
+		context.Background(),
+		m.JWTClaimsKey,
+		map[string]any{
+			"user_id":    TEST_USER_ID,
+			"login_name": TEST_LOGIN_NAME,
+		},
+	))
+
+	w := httptest.NewRecorder()
+	EditSummary(w, r)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status code 500, got %d\n%s", w.Code, w.Body.String())
+	}
+
+	var text, global_summary string
+	var like_count int
+	if err := db.Client.QueryRow(
+		`SELECT 
+			s.text, 
+			l.global_summary, 
+			(SELECT count(*) FROM "Summary Likes" WHERE summary_id = s.id)
+		FROM Summaries s
+		JOIN Links l ON l.id = s.link_id
+		WHERE s.id = ?;`,
+		test_summary_id,
+	).Scan(&text, &global_summary, &like_count); err != nil {
+		t.Fatal(err)
+	} else if text != original_text || global_summary != original_text || like_count != 1 {
+		t.Fatalf(
+			"got text %q, global summary %q, %d likes after failed edit; want originals and 1 like",
+			text,
+			global_summary,
+			like_count,
+		)
+	}
+}
+
+// A failure replacing the text leaves no revision archived for it
+func TestRestoreSummaryRevisionIsAtomic(t *testing.T) {
+	const (
+		test_link_id    = "restore-summary-atomic"
+		test_summary_id = "restore-summary-atomic"
+		test_rev_id     = "restore-summary-atomic"
+		current_text    = "current summary text"
+	)
+
+	for _, stmt := range []string{
+		`INSERT INTO Links (id, url, submitted_by, submit_date, global_cats, global_summary, img_file)
+		VALUES ('restore-summary-atomic', 'https://example.com/restore-summary-atomic', 'jlk', '2024-01-01 00:00:00', 'testing', 'current summary text', '');`,
+		`INSERT INTO Summaries (id, text, link_id, submitted_by, last_updated)
+		VALUES ('restore-summary-atomic', 'current summary text', 'restore-summary-atomic', '3', '2024-01-02 00:00:00');`,
+		`INSERT INTO "Summary Revisions" (id, summary_id, text, submitted_by, last_updated, replaced)
+		VALUES ('restore-summary-atomic', 'restore-summary-atomic', 'older summary text', '3', '2024-01-01 00:00:00', '2024-01-02 00:00:00');`,
+		`CREATE TRIGGER fail_restore_summary_atomic
+		BEFORE UPDATE OF text ON Summaries
+		WHEN old.id = 'restore-summary-atomic'
+		BEGIN
+			SELECT RAISE(ABORT, 'forced failure');
+		END;`,
+	} {
+		if _, err := db.Client.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		db.Client.Exec("DROP TRIGGER IF EXISTS fail_restore_summary_atomic;")
+		db.Client.Exec("DELETE FROM Links WHERE id = ?;", test_link_id)
+	})
+
+	router := chi.NewRouter()
+	router.Post("/summaries/{summary_id}/restore/{rev_id}", RestoreSummaryRevision)
+
+	r := httptest.NewRequest(
+		http.MethodPost,
+		"/summaries/"+test_summary_id+"/restore/"+test_rev_id,
+		nil,
+	)
+	r = r.WithContext(context.WithValue(
+		context.Background(),
+		m.JWTClaimsKey,
+		map[string]any{
+			"user_id":    TEST_USER_ID,
+			"login_name": TEST_LOGIN_NAME,
+		},
+	))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status code 500, got %d\n%s", w.Code, w.Body.String())
+	}
+
+	var text string
+	var revision_count int
+	if err := db.Client.QueryRow(
+		`SELECT 
+			text, 
+			(SELECT count(*) FROM "Summary Revisions" WHERE summary_id = s.id)
+		FROM Summaries s
+		WHERE id = ?;`,
+		test_summary_id,
+	).Scan(&text, &revision_count); err != nil {
+		t.Fatal(err)
+	} else if text != current_text || revision_count != 1 {
+		t.Fatalf(
+			"got text %q and %d revisions after failed restore, want %q and 1",
+			text,
+			revision_count,
+			current_text,
+		)
+	}
+}
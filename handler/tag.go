@@ -2,6 +2,7 @@ package handler
 
 import (
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/go-chi/chi/v5"
@@ -13,6 +14,8 @@ import (
 	m "github.com/julianlk522/fitm/middleware"
 	"github.com/julianlk522/fitm/model"
 	"github.com/julianlk522/fitm/query"
+
+	mutil "github.com/julianlk522/fitm/model/util"
 )
 
 func GetTagPage(w http.ResponseWriter, r *http.Request) {
@@ -95,6 +98,12 @@ func GetTagPage(w http.ResponseWriter, r *http.Request) {
 func GetTopGlobalCats(w http.ResponseWriter, r *http.Request) {
 	query_params := r.URL.Query()
 
+	tree_params := query_params.Get("tree")
+	if tree_params != "" && tree_params != "true" {
+		render.Render(w, r, e.ErrInvalidRequest(e.ErrInvalidTreeFlag))
+		return
+	}
+
 	global_cats_sql := query.
 		NewTopGlobalCatCounts().
 		FromRequestParams(
@@ -102,7 +111,7 @@ func GetTopGlobalCats(w http.ResponseWriter, r *http.Request) {
 		)
 
 	if global_cats_sql.Error != nil {
-		render.Render(w, r, e.Err500(global_cats_sql.Error))
+		render.Render(w, r, e.ErrInvalidRequest(global_cats_sql.Error))
 		return
 	}
 
@@ -112,6 +121,13 @@ func GetTopGlobalCats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// counted cats nested under their parents (see "Cat Parents")
+	if tree_params == "true" {
+		render.Status(r, http.StatusOK)
+		render.JSON(w, r, util.BuildCatTree(*counts))
+		return
+	}
+
 	// if "more" params passed: need to run through the results to check
 	// if any cat plural/singular spelling variations were merged
 
@@ -127,7 +143,9 @@ func GetTopGlobalCats(w http.ResponseWriter, r *http.Request) {
 	cats_params := query_params.Get("cats")
 
 	if more_params == "true" && cats_params != "" {
-		split_cats_params := strings.Split(cats_params, ",")
+		// already validated by FromRequestParams
+		cats_filter, _ := query.ParseCatsFilter(cats_params)
+		split_cats_params := cats_filter.Cats()
 		merged_cats := []string{}
 
 		for _, count := range *counts {
@@ -155,6 +173,42 @@ func GetTopGlobalCats(w http.ResponseWriter, r *http.Request) {
 	render.JSON(w, r, counts)
 }
 
+func GetCatGraph(w http.ResponseWriter, r *http.Request) {
+	query_params := r.URL.Query()
+
+	format_params := query_params.Get("format")
+	if format_params != "" && format_params != "json" && format_params != "dot" {
+		render.Render(w, r, e.ErrInvalidRequest(e.ErrInvalidGraphFormat))
+		return
+	}
+
+	cat_graph_sql := query.
+		NewCatGraph().
+		FromRequestParams(
+			query_params,
+		)
+	if cat_graph_sql.Error != nil {
+		render.Render(w, r, e.ErrInvalidRequest(cat_graph_sql.Error))
+		return
+	}
+
+	graph, err := util.ScanCatGraph(cat_graph_sql)
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	}
+
+	if format_params == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(util.CatGraphToDOT(graph)))
+		return
+	}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, graph)
+}
+
 func GetSpellfixMatchesForSnippet(w http.ResponseWriter, r *http.Request) {
 	snippet := chi.URLParam(r, "*")
 	if snippet == "" {
@@ -162,13 +216,34 @@ func GetSpellfixMatchesForSnippet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	params := r.URL.Query()
+	has_context := util.HasCatSuggestionContext(params)
+
 	spfx_sql := query.NewSpellfixMatchesForSnippet(snippet)
+	if has_context {
+		spfx_sql = spfx_sql.ForContext()
+	}
 
-	omitted_params := r.URL.Query().Get("omitted")
+	// lowercase to ensure all case variations are returned
+	var omitted_words []string
+	omitted_params := params.Get("omitted")
 	if omitted_params != "" {
+		omitted_words = strings.Split(strings.ToLower(omitted_params), ",")
+	}
 
-		// lowercase to ensure all case variations are returned
-		omitted_words := strings.Split(strings.ToLower(omitted_params), ",")
+	// cats already filtered by aren't suggested again
+	if cats_params := params.Get("cats"); cats_params != "" {
+		cats_filter, err := query.ParseCatsFilter(cats_params)
+		if err != nil {
+			render.Render(w, r, e.ErrInvalidRequest(err))
+			return
+		}
+		for _, cat := range cats_filter.Cats() {
+			omitted_words = append(omitted_words, strings.ToLower(cat))
+		}
+	}
+
+	if len(omitted_words) > 0 {
 		err := spfx_sql.OmitCats(omitted_words)
 		if err != nil {
 			render.Render(w, r, e.Err500(err))
@@ -191,16 +266,64 @@ func GetSpellfixMatchesForSnippet(w http.ResponseWriter, r *http.Request) {
 			render.Render(w, r, e.Err500(err))
 			return
 		}
+
+		// aliases are suggested as the cat they point to
+		word = mutil.ResolveCatAlias(word)
+		if slices.Contains(omitted_words, strings.ToLower(word)) {
+			continue
+		} else if i := slices.IndexFunc(matches, func(cc model.CatCount) bool {
+			return strings.EqualFold(cc.Category, word)
+		}); i != -1 {
+			matches[i].Count += rank
+			continue
+		}
+
 		matches = append(matches, model.CatCount{
 			Category: word,
 			Count:    rank,
 		})
 	}
 
+	if has_context {
+		matches, err = util.WeightSpellfixMatchesByContext(matches, params)
+		if err == e.ErrNoUserWithLoginName {
+			render.Render(w, r, e.Err404(err))
+			return
+		} else if err != nil {
+			render.Render(w, r, e.ErrInvalidRequest(err))
+			return
+		}
+	}
+
 	render.JSON(w, r, matches)
 	render.Status(r, http.StatusOK)
 }
 
+func GetTagHistory(w http.ResponseWriter, r *http.Request) {
+	link_id := chi.URLParam(r, "link_id")
+	if link_id == "" {
+		render.Render(w, r, e.ErrInvalidRequest(e.ErrNoLinkID))
+		return
+	}
+
+	link_exists, err := util.LinkExists(link_id)
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	} else if !link_exists {
+		render.Render(w, r, e.ErrInvalidRequest(e.ErrNoLinkWithID))
+		return
+	}
+
+	history, err := util.BuildTagHistory(link_id)
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	}
+
+	render.JSON(w, r, history)
+}
+
 func AddTag(w http.ResponseWriter, r *http.Request) {
 	tag_data := &model.NewTagRequest{}
 	if err := render.Bind(r, tag_data); err != nil {
@@ -230,7 +353,7 @@ func AddTag(w http.ResponseWriter, r *http.Request) {
 	tag_data.Cats = util.AlphabetizeCats(tag_data.Cats)
 
 	_, err = db.Client.Exec(
-		"INSERT INTO Tags VALUES(?,?,?,?,?);",
+		"INSERT INTO Tags (id, link_id, cats, submitted_by, last_updated) VALUES(?,?,?,?,?);",
 		tag_data.ID,
 		tag_data.LinkID,
 		tag_data.Cats,
@@ -270,7 +393,25 @@ func EditTag(w http.ResponseWriter, r *http.Request) {
 
 	edit_tag_data.Cats = util.AlphabetizeCats(edit_tag_data.Cats)
 
-	_, err = db.Client.Exec(
+	link_id, err := util.GetLinkIDFromTagID(edit_tag_data.ID)
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	}
+
+	tx, err := db.Client.Begin()
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	}
+	defer tx.Rollback()
+
+	if err = util.ArchiveTagRevision(tx, edit_tag_data.ID); err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	}
+
+	_, err = tx.Exec(
 		`UPDATE Tags 
 		SET cats = ?, last_updated = ? 
 		WHERE id = ?;`,
@@ -283,11 +424,12 @@ func EditTag(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	link_id, err := util.GetLinkIDFromTagID(edit_tag_data.ID)
-	if err != nil {
+	if err = util.CalculateAndSetGlobalCatsInTx(tx, link_id); err != nil {
 		render.Render(w, r, e.Err500(err))
 		return
-	} else if err = util.CalculateAndSetGlobalCats(link_id); err != nil {
+	}
+
+	if err = tx.Commit(); err != nil {
 		render.Render(w, r, e.Err500(err))
 		return
 	}
@@ -338,7 +480,19 @@ func DeleteTag(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = db.Client.Exec(
+	tx, err := db.Client.Begin()
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	}
+	defer tx.Rollback()
+
+	if err = util.ArchiveTagRevision(tx, delete_tag_data.ID); err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	}
+
+	_, err = tx.Exec(
 		"DELETE FROM Tags WHERE id = ?;",
 		delete_tag_data.ID,
 	)
@@ -347,10 +501,211 @@ func DeleteTag(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err = util.CalculateAndSetGlobalCats(link_id); err != nil {
+	if err = util.CalculateAndSetGlobalCatsInTx(tx, link_id); err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func GetCatAliases(w http.ResponseWriter, r *http.Request) {
+	aliases, err := util.ScanCatAliases(db.Client)
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, aliases)
+}
+
+func AddCatAlias(w http.ResponseWriter, r *http.Request) {
+	alias_data := &model.NewCatAliasRequest{}
+	if err := render.Bind(r, alias_data); err != nil {
+		render.Render(w, r, e.ErrInvalidRequest(err))
+		return
+	}
+
+	if err := util.ValidateNewCatAlias(alias_data.Alias, alias_data.Cat); err != nil {
+		if err == e.ErrCatAliasExists {
+			render.Render(w, r, e.ErrConflict(err))
+		} else if err == e.ErrCatAliasChain {
+			render.Render(w, r, e.ErrInvalidRequest(err))
+		} else {
+			render.Render(w, r, e.Err500(err))
+		}
+		return
+	}
+
+	req_login_name := r.Context().Value(m.JWTClaimsKey).(map[string]any)["login_name"].(string)
+	alias := model.CatAlias{
+		Alias:     alias_data.Alias,
+		Cat:       alias_data.Cat,
+		CreatedBy: req_login_name,
+		Created:   alias_data.Created,
+	}
+
+	tags_rewritten, links_recalculated, err := util.AddCatAliasAndRewriteTags(alias)
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, model.CatAliasRewrite{
+		CatAlias:          alias,
+		TagsRewritten:     tags_rewritten,
+		LinksRecalculated: links_recalculated,
+	})
+}
+
+// Rewritten tags are not restored
+func DeleteCatAlias(w http.ResponseWriter, r *http.Request) {
+	delete_alias_data := &model.DeleteCatAliasRequest{}
+	if err := render.Bind(r, delete_alias_data); err != nil {
+		render.Render(w, r, e.ErrInvalidRequest(err))
+		return
+	}
+
+	alias_exists, err := util.CatAliasExists(delete_alias_data.Alias)
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	} else if !alias_exists {
+		render.Render(w, r, e.ErrInvalidRequest(e.ErrNoCatAliasWithName))
+		return
+	}
+
+	_, err = db.Client.Exec(
+		`DELETE FROM "Cat Aliases" WHERE alias = ?;`,
+		delete_alias_data.Alias,
+	)
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	}
+
+	if err = util.LoadCatAliases(db.Client); err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func GetCatParents(w http.ResponseWriter, r *http.Request) {
+	parents, err := util.ScanCatParents()
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, parents)
+}
+
+// Replaces any existing parent of the cat
+func SetCatParent(w http.ResponseWriter, r *http.Request) {
+	parent_data := &model.SetCatParentRequest{}
+	if err := render.Bind(r, parent_data); err != nil {
+		render.Render(w, r, e.ErrInvalidRequest(err))
+		return
+	}
+
+	req_login_name := r.Context().Value(m.JWTClaimsKey).(map[string]any)["login_name"].(string)
+	_, err := db.Client.Exec(
+		`INSERT INTO "Cat Parents" (cat, parent, created_by, created)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(cat) DO UPDATE SET
+			parent = excluded.parent,
+			created_by = excluded.created_by,
+			created = excluded.created;`,
+		parent_data.Cat,
+		parent_data.Parent,
+		req_login_name,
+		parent_data.Created,
+	)
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	}
+
+	if err = util.LoadCatParents(); err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, model.CatParent{
+		Cat:       parent_data.Cat,
+		Parent:    parent_data.Parent,
+		CreatedBy: req_login_name,
+		Created:   parent_data.Created,
+	})
+}
+
+// Children of the cat keep their own parent relationship to it
+func DeleteCatParent(w http.ResponseWriter, r *http.Request) {
+	delete_parent_data := &model.DeleteCatParentRequest{}
+	if err := render.Bind(r, delete_parent_data); err != nil {
+		render.Render(w, r, e.ErrInvalidRequest(err))
+		return
+	}
+
+	has_parent, err := util.CatHasParent(delete_parent_data.Cat)
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	} else if !has_parent {
+		render.Render(w, r, e.ErrInvalidRequest(e.ErrNoCatParentForCat))
+		return
+	}
+
+	_, err = db.Client.Exec(
+		`DELETE FROM "Cat Parents" WHERE cat = ?;`,
+		delete_parent_data.Cat,
+	)
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	}
+
+	if err = util.LoadCatParents(); err != nil {
 		render.Render(w, r, e.Err500(err))
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+func GetGlobalCatsDryRun(w http.ResponseWriter, r *http.Request) {
+	link_id := chi.URLParam(r, "link_id")
+	if link_id == "" {
+		render.Render(w, r, e.ErrInvalidRequest(e.ErrNoLinkID))
+		return
+	}
+
+	link_exists, err := util.LinkExists(link_id)
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	} else if !link_exists {
+		render.Render(w, r, e.ErrInvalidRequest(e.ErrNoLinkWithID))
+		return
+	}
+
+	dry_run, err := util.BuildGlobalCatsDryRun(link_id)
+	if err != nil {
+		render.Render(w, r, e.Err500(err))
+		return
+	}
+
+	render.Status(r, http.StatusOK)
+	render.JSON(w, r, dry_run)
+}
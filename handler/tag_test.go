@@ -7,11 +7,16 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/julianlk522/fitm/config"
+	"github.com/julianlk522/fitm/db"
 	m "github.com/julianlk522/fitm/middleware"
 	"github.com/julianlk522/fitm/model"
+	mutil "github.com/julianlk522/fitm/model/util"
+	"github.com/julianlk522/fitm/query"
 )
 
 func TestAddTag(t *testing.T) {
@@ -254,6 +259,96 @@ func TestEditTag(t *testing.T) {
 	}
 }
 
+// A failure after the tag is changed (here, setting the new global cats)
+// leaves the tag and its history untouched
+func TestEditAndDeleteTagAreAtomic(t *testing.T) {
+	const (
+		test_link_id = "edit-tag-atomic"
+		test_tag_id  = "edit-tag-atomic"
+		test_cats    = "edit-tag-atomic,other"
+	)
+
+	for _, stmt := range []string{
+		`INSERT INTO Links (id, url, submitted_by, submit_date, global_cats, global_summary, img_file)
+		VALUES ('edit-tag-atomic', 'https://example.com/edit-tag-atomic', 'jlk', '2024-01-01 00:00:00', 'edit-tag-atomic,other', '', '');`,
+		`INSERT INTO Tags (id, link_id, cats, submitted_by, last_updated)
+		VALUES ('edit-tag-atomic', 'edit-tag-atomic', 'edit-tag-atomic,other', 'jlk', '2024-01-01 00:00:00');`,
+		`INSERT INTO Tags (id, link_id, cats, submitted_by, last_updated)
+		VALUES ('edit-tag-atomic-bob', 'edit-tag-atomic', 'bobs-cat', 'bob', '2024-01-01 00:00:00');`,
+		`CREATE TRIGGER fail_edit_tag_atomic
+		BEFORE UPDATE OF global_cats ON Links
+		WHEN old.id = 'edit-tag-atomic'
+		BEGIN
+			SELECT RAISE(ABORT, 'forced failure');
+		END;`,
+	} {
+		if _, err := db.Client.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		db.Client.Exec("DROP TRIGGER IF EXISTS fail_edit_tag_atomic;")
+		db.Client.Exec(`DELETE FROM "Tag Revisions" WHERE link_id = ?;`, test_link_id)
+		db.Client.Exec("DELETE FROM Tags WHERE link_id = ?;", test_link_id)
+		db.Client.Exec("DELETE FROM Links WHERE id = ?;", test_link_id)
+	})
+
+	for _, tc := range []struct {
+		Method  string
+		Payload map[string]string
+		Handler http.HandlerFunc
+	}{
+		{
+			Method:  http.MethodPut,
+			Payload: map[string]string{"tag_id": test_tag_id, "cats": "something,else"},
+			Handler: EditTag,
+		},
+		{
+			Method:  http.MethodDelete,
+			Payload: map[string]string{"tag_id": test_tag_id},
+			Handler: DeleteTag,
+		},
+	} {
+		pl, _ := json.Marshal(tc.Payload)
+		r := httptest.NewRequest(tc.Method, "/tags", bytes.NewReader(pl))
+		r.Header.Set("Content-Type", "application/json")
+		r = r.WithContext(context.WithValue(
+			context.Background(),
+			m.JWTClaimsKey,
+			map[string]any{
+				"user_id":    TEST_USER_ID,
+				"login_name": TEST_LOGIN_NAME,
+			},
+		))
+
+		w := httptest.NewRecorder()
+		tc.Handler(w, r)
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("%s: expected status code 500, got %d\n%s", tc.Method, w.Code, w.Body.String())
+		}
+
+		var cats string
+		var revisions_count int
+		if err := db.Client.QueryRow(
+			`SELECT
+				(SELECT cats FROM Tags WHERE id = ?),
+				(SELECT count(*) FROM "Tag Revisions" WHERE tag_id = ?);`,
+			test_tag_id,
+			test_tag_id,
+		).Scan(&cats, &revisions_count); err != nil {
+			t.Fatal(err)
+		} else if cats != test_cats || revisions_count != 0 {
+			t.Fatalf(
+				"%s: got tag cats %q, %d revisions after failure; want %q, 0",
+				tc.Method,
+				cats,
+				revisions_count,
+				test_cats,
+			)
+		}
+	}
+}
+
 func TestDeleteTag(t *testing.T) {
 	var test_requests = []struct {
 		TagID              string
@@ -441,3 +536,391 @@ func TestGetSpellfixMatchesForSnippet(t *testing.T) {
 		}
 	}
 }
+
+func TestGetCatGraph(t *testing.T) {
+	var test_requests = []struct {
+		Params             string
+		ExpectedStatusCode int
+	}{
+		{"", 200},
+		{"?cats=umvc3&min_weight=1", 200},
+		{"?cats=search|umvc3,-NSFW&format=json", 200},
+		{"?format=dot", 200},
+		{"?min_weight=0", 400},
+		{"?min_weight=lots", 400},
+		{"?cats=-umvc3", 400},
+		{"?format=svg", 400},
+	}
+
+	for _, tr := range test_requests {
+		req := httptest.NewRequest("GET", "/cats/graph"+tr.Params, nil)
+		w := httptest.NewRecorder()
+		GetCatGraph(w, req)
+
+		if w.Code != tr.ExpectedStatusCode {
+			t.Fatalf(
+				"expected status code %d, got %d (params %s) \n%s",
+				tr.ExpectedStatusCode,
+				w.Code,
+				tr.Params,
+				w.Body.String(),
+			)
+		} else if w.Code > 200 {
+			continue
+		}
+
+		if bytes.HasPrefix(w.Body.Bytes(), []byte("graph cats {")) != (tr.Params == "?format=dot") {
+			t.Fatalf("unexpected body for params %s: %s", tr.Params, w.Body.String())
+		} else if tr.Params == "?format=dot" {
+			continue
+		}
+
+		var graph model.CatGraph
+		if err := json.Unmarshal(w.Body.Bytes(), &graph); err != nil {
+			t.Fatal(err)
+		}
+
+		nodes := map[string]int32{}
+		for _, node := range graph.Nodes {
+			nodes[node.Category] = node.Count
+		}
+		for _, edge := range graph.Edges {
+			if edge.Source >= edge.Target {
+				t.Fatalf("edge %+v not in alphabetical order", edge)
+			} else if edge.Weight > nodes[edge.Source] || edge.Weight > nodes[edge.Target] {
+				t.Fatalf("edge %+v heavier than its nodes", edge)
+			}
+		}
+	}
+}
+
+func TestAddAndDeleteCatAlias(t *testing.T) {
+	config.Current.AdminLoginNames = []string{"jlk"}
+	defer func() { config.Current.AdminLoginNames = nil }()
+
+	var test_requests = []struct {
+		Method             string
+		LoginName          string
+		Payload            map[string]string
+		ExpectedStatusCode int
+	}{
+		// not an admin
+		{http.MethodPost, "bob", map[string]string{"alias": "test_alias", "cat": "test_cat"}, 403},
+		{http.MethodPost, "jlk", map[string]string{"alias": "", "cat": "test_cat"}, 400},
+		{http.MethodPost, "jlk", map[string]string{"alias": "test_alias", "cat": ""}, 400},
+		{http.MethodPost, "jlk", map[string]string{"alias": "Test_Cat", "cat": "test_cat"}, 400},
+		{http.MethodPost, "jlk", map[string]string{"alias": "nsfw", "cat": "adult"}, 400},
+		{http.MethodPost, "jlk", map[string]string{"alias": "test_alias,test_alias_2", "cat": "test_cat"}, 400},
+		{http.MethodPost, "jlk", map[string]string{"alias": "test_alias", "cat": "test_cat"}, 201},
+		{http.MethodPost, "jlk", map[string]string{"alias": "TEST_ALIAS", "cat": "python"}, 409},
+		// chains
+		{http.MethodPost, "jlk", map[string]string{"alias": "test_alias_2", "cat": "test_alias"}, 400},
+		{http.MethodPost, "jlk", map[string]string{"alias": "test_cat", "cat": "test_alias_2"}, 400},
+		{http.MethodDelete, "bob", map[string]string{"alias": "test_alias"}, 403},
+		{http.MethodDelete, "jlk", map[string]string{"alias": "test_alias_2"}, 400},
+		{http.MethodDelete, "jlk", map[string]string{"alias": "test_alias"}, 204},
+	}
+
+	for _, tr := range test_requests {
+		pl, _ := json.Marshal(tr.Payload)
+		r := httptest.NewRequest(tr.Method, "/cats/aliases", bytes.NewReader(pl))
+		r.Header.Set("Content-Type", "application/json")
+
+		ctx := context.WithValue(context.Background(), m.JWTClaimsKey, map[string]any{
+			"user_id":    "",
+			"login_name": tr.LoginName,
+		})
+		r = r.WithContext(ctx)
+
+		handler := AddCatAlias
+		if tr.Method == http.MethodDelete {
+			handler = DeleteCatAlias
+		}
+
+		w := httptest.NewRecorder()
+		m.AdminOnly(http.HandlerFunc(handler)).ServeHTTP(w, r)
+		if w.Code != tr.ExpectedStatusCode {
+			t.Fatalf(
+				"expected status code %d, got %d (%s %+v as %s)\n%s",
+				tr.ExpectedStatusCode,
+				w.Code,
+				tr.Method,
+				tr.Payload,
+				tr.LoginName,
+				w.Body.String(),
+			)
+		}
+
+		// alias applied while it exists
+		if tr.ExpectedStatusCode == 201 && mutil.ResolveCatAlias("test_alias") != "test_cat" {
+			t.Fatal("alias not applied after creation")
+		} else if tr.ExpectedStatusCode == 204 && mutil.ResolveCatAlias("test_alias") != "test_alias" {
+			t.Fatal("alias still applied after deletion")
+		}
+	}
+}
+
+func TestAddCatAliasIsAtomic(t *testing.T) {
+	config.Current.AdminLoginNames = []string{"jlk"}
+	defer func() { config.Current.AdminLoginNames = nil }()
+
+	const (
+		test_alias = "add-cat-alias-atomic"
+		test_cats  = "add-cat-alias-atomic,other"
+	)
+
+	for _, stmt := range []string{
+		`INSERT INTO Links (id, url, submitted_by, submit_date, global_cats, global_summary, img_file)
+		VALUES ('add-cat-alias-atomic', 'https://example.com/add-cat-alias-atomic', 'jlk', '2024-01-01 00:00:00', 'add-cat-alias-atomic,other', '', '');`,
+		`INSERT INTO Tags (id, link_id, cats, submitted_by, last_updated)
+		VALUES ('add-cat-alias-atomic', 'add-cat-alias-atomic', 'add-cat-alias-atomic,other', 'jlk', '2024-01-01 00:00:00');`,
+		`CREATE TRIGGER fail_add_cat_alias_atomic
+		BEFORE UPDATE OF global_cats ON Links
+		WHEN old.id = 'add-cat-alias-atomic'
+		BEGIN
+			SELECT RAISE(ABORT, 'forced failure');
+		END;`,
+	} {
+		if _, err := db.Client.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		db.Client.Exec("DROP TRIGGER IF EXISTS fail_add_cat_alias_atomic;")
+		db.Client.Exec(`DELETE FROM "Cat Aliases" WHERE alias = ?;`, test_alias)
+		db.Client.Exec(`DELETE FROM "Tag Revisions" WHERE tag_id = ?;`, test_alias)
+		db.Client.Exec("DELETE FROM Tags WHERE id = ?;", test_alias)
+		db.Client.Exec("DELETE FROM Links WHERE id = ?;", test_alias)
+	})
+
+	// global cats recalculation fails after the tag is rewritten
+	pl, _ := json.Marshal(map[string]string{"alias": test_alias, "cat": "test_cat"})
+	r := httptest.NewRequest(http.MethodPost, "/cats/aliases", bytes.NewReader(pl))
+	r.Header.Set("Content-Type", "application/json")
+	r = r.WithContext(context.WithValue(context.Background(), m.JWTClaimsKey, map[string]any{
+		"user_id":    "",
+		"login_name": "jlk",
+	}))
+
+	w := httptest.NewRecorder()
+	m.AdminOnly(http.HandlerFunc(AddCatAlias)).ServeHTTP(w, r)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status code 500, got %d\n%s", w.Code, w.Body.String())
+	}
+
+	var alias_exists bool
+	var cats string
+	var revisions_count int
+	if err := db.Client.QueryRow(
+		`SELECT
+			EXISTS (SELECT 1 FROM "Cat Aliases" WHERE alias = ?),
+			(SELECT cats FROM Tags WHERE id = ?),
+			(SELECT count(*) FROM "Tag Revisions" WHERE tag_id = ?);`,
+		test_alias,
+		test_alias,
+		test_alias,
+	).Scan(&alias_exists, &cats, &revisions_count); err != nil {
+		t.Fatal(err)
+	} else if alias_exists || cats != test_cats || revisions_count != 0 {
+		t.Fatalf(
+			"got alias saved: %t, tag cats %q, %d revisions after failed add; want none saved, %q, 0",
+			alias_exists,
+			cats,
+			revisions_count,
+			test_cats,
+		)
+	} else if mutil.ResolveCatAlias(test_alias) != test_alias {
+		t.Fatal("alias applied after failed add")
+	}
+}
+
+func TestSetAndDeleteCatParent(t *testing.T) {
+	config.Current.AdminLoginNames = []string{"jlk"}
+	defer func() { config.Current.AdminLoginNames = nil }()
+
+	var test_requests = []struct {
+		Method             string
+		LoginName          string
+		Payload            map[string]string
+		ExpectedStatusCode int
+	}{
+		// not an admin
+		{http.MethodPut, "bob", map[string]string{"cat": "test_child", "parent": "test_parent"}, 403},
+		{http.MethodPut, "jlk", map[string]string{"cat": "", "parent": "test_parent"}, 400},
+		{http.MethodPut, "jlk", map[string]string{"cat": "test_child", "parent": ""}, 400},
+		{http.MethodPut, "jlk", map[string]string{"cat": "test_child", "parent": "Test_Child"}, 400},
+		{http.MethodPut, "jlk", map[string]string{"cat": "test_child", "parent": "test_parent"}, 200},
+		{http.MethodPut, "jlk", map[string]string{"cat": "test_grandchild", "parent": "test_child"}, 200},
+		// cycle
+		{http.MethodPut, "jlk", map[string]string{"cat": "test_parent", "parent": "test_grandchild"}, 400},
+		{http.MethodDelete, "bob", map[string]string{"cat": "test_child"}, 403},
+		{http.MethodDelete, "jlk", map[string]string{"cat": "test_parent"}, 400},
+		{http.MethodDelete, "jlk", map[string]string{"cat": "test_grandchild"}, 204},
+		{http.MethodDelete, "jlk", map[string]string{"cat": "test_child"}, 204},
+	}
+
+	for _, tr := range test_requests {
+		pl, _ := json.Marshal(tr.Payload)
+		r := httptest.NewRequest(tr.Method, "/cats/parents", bytes.NewReader(pl))
+		r.Header.Set("Content-Type", "application/json")
+
+		ctx := context.WithValue(context.Background(), m.JWTClaimsKey, map[string]any{
+			"user_id":    "",
+			"login_name": tr.LoginName,
+		})
+		r = r.WithContext(ctx)
+
+		handler := SetCatParent
+		if tr.Method == http.MethodDelete {
+			handler = DeleteCatParent
+		}
+
+		w := httptest.NewRecorder()
+		m.AdminOnly(http.HandlerFunc(handler)).ServeHTTP(w, r)
+		if w.Code != tr.ExpectedStatusCode {
+			t.Fatalf(
+				"expected status code %d, got %d (%s %+v as %s)\n%s",
+				tr.ExpectedStatusCode,
+				w.Code,
+				tr.Method,
+				tr.Payload,
+				tr.LoginName,
+				w.Body.String(),
+			)
+		}
+	}
+
+	if descendants := mutil.GetCatDescendants("test_parent"); len(descendants) != 0 {
+		t.Fatalf("got descendants %v after deleting parents", descendants)
+	}
+}
+
+func TestGetTopGlobalCatsTree(t *testing.T) {
+	var test_requests = []struct {
+		Params             string
+		ExpectedStatusCode int
+	}{
+		{"?tree=true", 200},
+		{"?tree=true&cats=umvc3", 200},
+		{"?tree=yes", 400},
+	}
+
+	for _, tr := range test_requests {
+		req := httptest.NewRequest("GET", "/cats"+tr.Params, nil)
+		w := httptest.NewRecorder()
+		GetTopGlobalCats(w, req)
+
+		if w.Code != tr.ExpectedStatusCode {
+			t.Fatalf(
+				"expected status code %d, got %d (params %s) \n%s",
+				tr.ExpectedStatusCode,
+				w.Code,
+				tr.Params,
+				w.Body.String(),
+			)
+		} else if w.Code > 200 {
+			continue
+		}
+
+		var tree []model.CatTreeNode
+		if err := json.Unmarshal(w.Body.Bytes(), &tree); err != nil {
+			t.Fatalf("failed with error: %s (params %s)", err, tr.Params)
+		}
+	}
+}
+
+func TestGetGlobalCatsDryRun(t *testing.T) {
+	var test_requests = []struct {
+		LinkID             string
+		ExpectedStatusCode int
+	}{
+		{"1", 200},
+		{"-1", 400},
+	}
+
+	r := chi.NewRouter()
+	r.Get("/tags/{link_id}/global-cats/dry-run", GetGlobalCatsDryRun)
+
+	for _, tr := range test_requests {
+		req := httptest.NewRequest("GET", "/tags/"+tr.LinkID+"/global-cats/dry-run", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tr.ExpectedStatusCode {
+			t.Fatalf(
+				"expected status code %d, got %d (link ID %s) \n%s",
+				tr.ExpectedStatusCode,
+				w.Code,
+				tr.LinkID,
+				w.Body.String(),
+			)
+		} else if w.Code > 200 {
+			continue
+		}
+
+		var dry_run model.GlobalCatsDryRun
+		if err := json.Unmarshal(w.Body.Bytes(), &dry_run); err != nil {
+			t.Fatal(err)
+		} else if dry_run.ActiveStrategy != "lifespan_overlap" {
+			t.Fatalf("got active strategy %s, want lifespan_overlap", dry_run.ActiveStrategy)
+		} else if len(dry_run.Results) == 0 {
+			t.Fatal("expected strategy results")
+		}
+	}
+}
+
+func TestGetSpellfixMatchesForSnippetInContext(t *testing.T) {
+	var test_requests = []struct {
+		Snippet            string
+		Params             string
+		ExpectedStatusCode int
+	}{
+		{"umv", "?cats=umvc3", 200},
+		{"te", "?cats=search|umvc3,-NSFW&period=year", 200},
+		{"te", "?url_contains=www", 200},
+		{"te", "?tmap=" + TEST_LOGIN_NAME, 200},
+		{"te", "?tmap=" + TEST_LOGIN_NAME + "&cats=umvc3&section=submitted", 200},
+		{"te", "?tmap=not_a_real_user_1234", 404},
+		{"te", "?cats=-umvc3", 400},
+		{"te", "?period=fortnight", 400},
+		{"te", "?tmap=" + TEST_LOGIN_NAME + "&section=liked", 400},
+	}
+
+	r := chi.NewRouter()
+	r.Get("/cats/*", GetSpellfixMatchesForSnippet)
+
+	for _, tr := range test_requests {
+		req := httptest.NewRequest("GET", "/cats/"+tr.Snippet+tr.Params, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tr.ExpectedStatusCode {
+			t.Fatalf(
+				"expected status code %d, got %d (params %s) \n%s",
+				tr.ExpectedStatusCode,
+				w.Code,
+				tr.Params,
+				w.Body.String(),
+			)
+		} else if w.Code > 200 {
+			continue
+		}
+
+		var results []model.CatCount
+		if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+			t.Fatal(err)
+		} else if len(results) > query.SPELLFIX_MATCHES_LIMIT {
+			t.Fatalf("got %d results, want <= %d", len(results), query.SPELLFIX_MATCHES_LIMIT)
+		}
+
+		for i, res := range results {
+			if res.Count <= 0 {
+				t.Fatalf("got count %d for %s, want co-occurring cats only (params %s)", res.Count, res.Category, tr.Params)
+			} else if i > 0 && res.Count > results[i-1].Count {
+				t.Fatalf("results not sorted by count (params %s): %v", tr.Params, results)
+			} else if strings.EqualFold(res.Category, "umvc3") && strings.Contains(tr.Params, "umvc3") {
+				t.Fatalf("got filter cat %s in suggestions (params %s)", res.Category, tr.Params)
+			}
+		}
+	}
+}
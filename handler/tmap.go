@@ -194,3 +194,38 @@ func GetTreasureMap(w http.ResponseWriter, r *http.Request) {
 
 	render.JSON(w, r, tmap)
 }
+
+func ExportTreasureMap(w http.ResponseWriter, r *http.Request) {
+	var login_name string = chi.URLParam(r, "login_name")
+	if login_name == "" {
+		render.Render(w, r, e.ErrInvalidRequest(e.ErrNoLoginName))
+		return
+	}
+
+	user_exists, err := util.UserExists(login_name)
+	if err != nil {
+		render.Render(w, r, e.ErrInvalidRequest(err))
+		return
+	} else if !user_exists {
+		render.Render(w, r, e.Err404(e.ErrNoUserWithLoginName))
+		return
+	}
+
+	format, err := util.GetTmapExportFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		render.Render(w, r, e.ErrInvalidRequest(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", util.TMAP_EXPORT_CONTENT_TYPES[format])
+	w.Header().Set(
+		"Content-Disposition",
+		`attachment; filename="`+util.GetTmapExportFileName(login_name, format)+`"`,
+	)
+	w.WriteHeader(http.StatusOK)
+
+	// streamed: too late for an error response
+	if err = util.ExportTmap(w, login_name, format); err != nil {
+		log.Printf("Could not export %s's treasure map: %s", login_name, err)
+	}
+}
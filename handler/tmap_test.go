@@ -7,8 +7,11 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/go-chi/chi/v5"
+
 	m "github.com/julianlk522/fitm/middleware"
 )
 
@@ -142,3 +145,46 @@ func TestDeleteProfilePic(t *testing.T) {
 func TestGetTreasureMap(t *testing.T) {
 	// TODO
 }
+
+func TestExportTreasureMap(t *testing.T) {
+	var test_requests = []struct {
+		Path               string
+		ExpectedStatusCode int
+		ContentType        string
+	}{
+		{"/map/" + TEST_LOGIN_NAME + "/export", 200, "application/json"},
+		{"/map/" + TEST_LOGIN_NAME + "/export?format=html", 200, "text/html"},
+		{"/map/" + TEST_LOGIN_NAME + "/export?format=csv", 200, "text/csv"},
+		{"/map/" + TEST_LOGIN_NAME + "/export?format=xml", 400, ""},
+		{"/map/not_a_real_user_1234/export", 404, ""},
+	}
+
+	r := chi.NewRouter()
+	r.Get("/map/{login_name}/export", ExportTreasureMap)
+
+	for _, tr := range test_requests {
+		req := httptest.NewRequest(http.MethodGet, tr.Path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != tr.ExpectedStatusCode {
+			t.Fatalf(
+				"expected status code %d, got %d (%s)\n%s",
+				tr.ExpectedStatusCode,
+				w.Code,
+				tr.Path,
+				w.Body.String(),
+			)
+		} else if w.Code > 200 {
+			continue
+		}
+
+		if !strings.HasPrefix(w.Header().Get("Content-Type"), tr.ContentType) {
+			t.Fatalf("got Content-Type %s, want %s", w.Header().Get("Content-Type"), tr.ContentType)
+		} else if !strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment") {
+			t.Fatalf("got Content-Disposition %s, want attachment", w.Header().Get("Content-Disposition"))
+		} else if w.Body.Len() == 0 {
+			t.Fatalf("empty export (%s)", tr.Path)
+		}
+	}
+}
@@ -38,7 +38,8 @@ func SignUp(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if _, err = db.Client.Exec(
-		`INSERT INTO users VALUES (?,?,?,?,?,?,?)`,
+		`INSERT INTO Users (id, login_name, password, about, pfp, created, email) 
+		VALUES (?,?,?,?,?,?,?)`,
 		signup_data.ID,
 		signup_data.Auth.LoginName,
 		pw_hash,
@@ -0,0 +1,207 @@
+package handler
+
+import (
+	"database/sql"
+	"slices"
+	"strings"
+
+	"github.com/julianlk522/fitm/db"
+	e "github.com/julianlk522/fitm/error"
+	"github.com/julianlk522/fitm/model"
+
+	mutil "github.com/julianlk522/fitm/model/util"
+)
+
+// Refreshes the in-memory aliases used by request binding and cat
+// filters; call after any change to "Cat Aliases"
+func LoadCatAliases(q db.Querier) error {
+	aliases, err := ScanCatAliases(q)
+	if err != nil {
+		return err
+	}
+
+	alias_map := make(map[string]string, len(*aliases))
+	for _, a := range *aliases {
+		alias_map[a.Alias] = a.Cat
+	}
+	mutil.SetCatAliases(alias_map)
+
+	return nil
+}
+
+func ScanCatAliases(q db.Querier) (*[]model.CatAlias, error) {
+	rows, err := q.Query(
+		`SELECT alias, cat, created_by, created
+		FROM "Cat Aliases"
+		ORDER BY alias ASC;`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	aliases := []model.CatAlias{}
+	for rows.Next() {
+		var a model.CatAlias
+		if err = rows.Scan(&a.Alias, &a.Cat, &a.CreatedBy, &a.Created); err != nil {
+			return nil, err
+		}
+		aliases = append(aliases, a)
+	}
+
+	return &aliases, rows.Err()
+}
+
+// Aliases resolve in one step: an alias can't point at another alias or
+// be the target of one
+func ValidateNewCatAlias(alias string, cat string) error {
+	var alias_exists, is_chain bool
+	err := db.Client.QueryRow(
+		`SELECT
+			EXISTS (SELECT 1 FROM "Cat Aliases" WHERE alias = ?),
+			EXISTS (
+				SELECT 1 FROM "Cat Aliases"
+				WHERE alias = ?
+				OR cat = ? COLLATE NOCASE
+			);`,
+		alias,
+		cat,
+		alias,
+	).Scan(&alias_exists, &is_chain)
+	if err != nil {
+		return err
+	} else if alias_exists {
+		return e.ErrCatAliasExists
+	} else if is_chain {
+		return e.ErrCatAliasChain
+	}
+
+	return nil
+}
+
+func CatAliasExists(alias string) (bool, error) {
+	var exists bool
+	err := db.Client.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM "Cat Aliases" WHERE alias = ?);`,
+		alias,
+	).Scan(&exists)
+
+	return exists, err
+}
+
+// Inserts the alias and rewrites the tags using it in one transaction.
+// The in-memory aliases include the new one only if it is committed.
+func AddCatAliasAndRewriteTags(alias model.CatAlias) (tags_rewritten int, links_recalculated int, err error) {
+	tx, err := db.Client.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		`INSERT INTO "Cat Aliases" (alias, cat, created_by, created)
+		VALUES (?, ?, ?, ?);`,
+		alias.Alias,
+		alias.Cat,
+		alias.CreatedBy,
+		alias.Created,
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// the rewrite resolves cats with the in-memory aliases
+	if err = LoadCatAliases(tx); err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			LoadCatAliases(db.Client)
+		}
+	}()
+
+	tags_rewritten, links_recalculated, err = RewriteTagsWithCatAlias(tx, alias.Alias)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+
+	return tags_rewritten, links_recalculated, nil
+}
+
+// Replaces the alias in every tag that has it (archiving the previous
+// cats as a revision but keeping last_updated so tag rankings are
+// unaffected), then recalculates global cats for the affected links,
+// which also moves spellfix rank from the alias to the cat.
+// Must run after LoadCatAliases so the new alias is applied.
+func RewriteTagsWithCatAlias(tx *sql.Tx, alias string) (tags_rewritten int, links_recalculated int, err error) {
+	rows, err := tx.Query(
+		`SELECT id, link_id, cats
+		FROM Tags
+		WHERE ',' || cats || ',' LIKE ?;`,
+		"%,"+alias+",%",
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	type tag_to_rewrite struct {
+		ID     string
+		LinkID string
+		Cats   string
+	}
+	var tags []tag_to_rewrite
+	for rows.Next() {
+		var t tag_to_rewrite
+		if err = rows.Scan(&t.ID, &t.LinkID, &t.Cats); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+
+		// LIKE is only case-insensitive for ASCII and treats _ and %
+		// as wildcards, so confirm
+		if slices.ContainsFunc(strings.Split(t.Cats, ","), func(cat string) bool {
+			return strings.EqualFold(strings.TrimSpace(cat), alias)
+		}) {
+			tags = append(tags, t)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	var link_ids []string
+	for _, t := range tags {
+		if err = ArchiveTagRevision(tx, t.ID); err != nil {
+			return tags_rewritten, 0, err
+		}
+
+		_, err = tx.Exec(
+			"UPDATE Tags SET cats = ? WHERE id = ?;",
+			AlphabetizeCats(mutil.ResolveCatAliases(t.Cats)),
+			t.ID,
+		)
+		if err != nil {
+			return tags_rewritten, 0, err
+		}
+		tags_rewritten++
+
+		if !slices.Contains(link_ids, t.LinkID) {
+			link_ids = append(link_ids, t.LinkID)
+		}
+	}
+
+	for _, link_id := range link_ids {
+		if err = CalculateAndSetGlobalCatsInTx(tx, link_id); err != nil {
+			return tags_rewritten, links_recalculated, err
+		}
+		links_recalculated++
+	}
+
+	return tags_rewritten, links_recalculated, nil
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/julianlk522/fitm/db"
+	"github.com/julianlk522/fitm/model"
+
+	mutil "github.com/julianlk522/fitm/model/util"
+)
+
+// Refreshes the in-memory parents used to expand cat filters; call after
+// any change to "Cat Parents"
+func LoadCatParents() error {
+	parents, err := ScanCatParents()
+	if err != nil {
+		return err
+	}
+
+	parent_map := make(map[string]string, len(*parents))
+	for _, p := range *parents {
+		parent_map[p.Cat] = p.Parent
+	}
+	mutil.SetCatParents(parent_map)
+
+	return nil
+}
+
+func ScanCatParents() (*[]model.CatParent, error) {
+	rows, err := db.Client.Query(
+		`SELECT cat, parent, created_by, created
+		FROM "Cat Parents"
+		ORDER BY parent ASC, cat ASC;`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	parents := []model.CatParent{}
+	for rows.Next() {
+		var p model.CatParent
+		if err = rows.Scan(&p.Cat, &p.Parent, &p.CreatedBy, &p.Created); err != nil {
+			return nil, err
+		}
+		parents = append(parents, p)
+	}
+
+	return &parents, rows.Err()
+}
+
+func CatHasParent(cat string) (bool, error) {
+	var exists bool
+	err := db.Client.QueryRow(
+		`SELECT EXISTS (SELECT 1 FROM "Cat Parents" WHERE cat = ?);`,
+		cat,
+	).Scan(&exists)
+
+	return exists, err
+}
+
+// Arranges counted cats under their parents. Ancestors of counted cats
+// are included even if they weren't counted themselves (Count 0).
+func BuildCatTree(counts []model.CatCount) []model.CatTreeNode {
+	nodes := map[string]*model.CatCount{}
+	for _, c := range counts {
+		lc_cat := strings.ToLower(c.Category)
+		if _, ok := nodes[lc_cat]; !ok {
+			nodes[lc_cat] = &model.CatCount{Category: c.Category, Count: c.Count}
+		}
+	}
+
+	counted_cats := make([]string, 0, len(nodes))
+	for lc_cat := range nodes {
+		counted_cats = append(counted_cats, lc_cat)
+	}
+	slices.Sort(counted_cats)
+
+	children := map[string][]string{}
+	var roots []string
+	for _, lc_cat := range counted_cats {
+		cat := lc_cat
+		for {
+			parent := mutil.GetCatParent(cat)
+			if parent == "" {
+				if !slices.Contains(roots, cat) {
+					roots = append(roots, cat)
+				}
+				break
+			}
+
+			lc_parent := strings.ToLower(parent)
+			if !slices.Contains(children[lc_parent], cat) {
+				children[lc_parent] = append(children[lc_parent], cat)
+			}
+			if _, ok := nodes[lc_parent]; ok {
+				break
+			}
+			nodes[lc_parent] = &model.CatCount{Category: parent}
+			cat = lc_parent
+		}
+	}
+
+	var build func(lc_cats []string, seen map[string]bool) []model.CatTreeNode
+	build = func(lc_cats []string, seen map[string]bool) []model.CatTreeNode {
+		tree_nodes := []model.CatTreeNode{}
+		for _, lc_cat := range lc_cats {
+			if seen[lc_cat] {
+				continue
+			}
+			seen[lc_cat] = true
+
+			tree_nodes = append(tree_nodes, model.CatTreeNode{
+				Category: nodes[lc_cat].Category,
+				Count:    nodes[lc_cat].Count,
+				Children: build(children[lc_cat], seen),
+			})
+		}
+
+		slices.SortFunc(tree_nodes, func(i, j model.CatTreeNode) int {
+			return model.SortCats(
+				model.CatCount{Category: i.Category, Count: i.Count},
+				model.CatCount{Category: j.Category, Count: j.Count},
+			)
+		})
+
+		return tree_nodes
+	}
+
+	return build(roots, map[string]bool{})
+}
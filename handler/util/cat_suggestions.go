@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/julianlk522/fitm/db"
+	e "github.com/julianlk522/fitm/error"
+	"github.com/julianlk522/fitm/model"
+	"github.com/julianlk522/fitm/query"
+)
+
+// Params narrowing the links that cat suggestions are weighted against
+var CAT_SUGGESTION_CONTEXT_PARAMS = []string{"cats", "period", "url_contains", "tmap"}
+
+func HasCatSuggestionContext(params url.Values) bool {
+	return slices.ContainsFunc(CAT_SUGGESTION_CONTEXT_PARAMS, func(p string) bool {
+		return params.Get(p) != ""
+	})
+}
+
+// Replaces each match's spellfix rank with the number of links in the
+// filter context (cats, period, url_contains, tmap owner) that also have
+// it, drops matches with none and keeps the top SPELLFIX_MATCHES_LIMIT.
+// Ties keep spellfix order.
+func WeightSpellfixMatchesByContext(matches []model.CatCount, params url.Values) ([]model.CatCount, error) {
+	if len(matches) == 0 {
+		return matches, nil
+	}
+
+	cats := make([]string, len(matches))
+	for i, m := range matches {
+		cats[i] = m.Category
+	}
+
+	var counts map[string]int32
+	var err error
+	if tmap_owner := params.Get("tmap"); tmap_owner != "" {
+		counts, err = GetTmapContextCatCounts(cats, tmap_owner, params)
+	} else {
+		counts, err = GetGlobalContextCatCounts(cats, params)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	weighted := []model.CatCount{}
+	for _, m := range matches {
+		if count := counts[strings.ToLower(m.Category)]; count > 0 {
+			weighted = append(weighted, model.CatCount{Category: m.Category, Count: count})
+		}
+	}
+
+	slices.SortStableFunc(weighted, func(i, j model.CatCount) int {
+		return int(j.Count - i.Count)
+	})
+	if len(weighted) > query.SPELLFIX_MATCHES_LIMIT {
+		weighted = weighted[:query.SPELLFIX_MATCHES_LIMIT]
+	}
+
+	return weighted, nil
+}
+
+// Lowercased cat -> number of links matching params with it in their
+// global cats
+func GetGlobalContextCatCounts(cats []string, params url.Values) (map[string]int32, error) {
+	counts_sql := query.
+		NewTopGlobalCatCounts().
+		FromRequestParams(params).
+		OnlyCats(cats)
+	if counts_sql.Error != nil {
+		return nil, counts_sql.Error
+	}
+
+	rows, err := db.Client.Query(counts_sql.Text, counts_sql.Args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int32{}
+	for rows.Next() {
+		var cat string
+		var count int32
+		if err = rows.Scan(&cat, &count); err != nil {
+			return nil, err
+		}
+		counts[strings.ToLower(cat)] = count
+	}
+
+	return counts, rows.Err()
+}
+
+// Lowercased cat -> number of the owner's tmap links matching params
+// with it in their (user or global) cats
+func GetTmapContextCatCounts(cats []string, tmap_owner string, params url.Values) (map[string]int32, error) {
+	user_exists, err := UserExists(tmap_owner)
+	if err != nil {
+		return nil, err
+	} else if !user_exists {
+		return nil, e.ErrNoUserWithLoginName
+	}
+
+	opts, err := GetTmapOptsFromRequestParams(params)
+	if err != nil {
+		return nil, err
+	}
+	opts.OwnerLoginName = tmap_owner
+
+	var sections_sql []*query.Query
+	if opts.Section == "" || opts.Section == "submitted" {
+		sections_sql = append(sections_sql, query.NewTmapSubmitted(tmap_owner).FromOptions(opts).Query)
+	}
+	if opts.Section == "" || opts.Section == "copied" {
+		sections_sql = append(sections_sql, query.NewTmapCopied(tmap_owner).FromOptions(opts).Query)
+	}
+	if opts.Section == "" || opts.Section == "tagged" {
+		sections_sql = append(sections_sql, query.NewTmapTagged(tmap_owner).FromOptions(opts).Query)
+	}
+
+	lc_cats := make([]string, len(cats))
+	for i, cat := range cats {
+		lc_cats[i] = strings.ToLower(cat)
+	}
+
+	counts := map[string]int32{}
+	for _, section_sql := range sections_sql {
+		if section_sql.Error != nil {
+			return nil, section_sql.Error
+		}
+
+		links, err := ScanTmapLinks[model.TmapLink](section_sql)
+		if err != nil {
+			return nil, err
+		}
+		if opts.SearchMatchArg != "" {
+			if err = ApplyTmapSearchResults(links, opts); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, link := range *links {
+			var link_cats []string
+			for _, cat := range strings.Split(strings.ToLower(link.Cats), ",") {
+				if slices.Contains(lc_cats, cat) && !slices.Contains(link_cats, cat) {
+					link_cats = append(link_cats, cat)
+					counts[cat]++
+				}
+			}
+		}
+	}
+
+	return counts, nil
+}
@@ -0,0 +1,361 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"html"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julianlk522/fitm/db"
+	e "github.com/julianlk522/fitm/error"
+	"github.com/julianlk522/fitm/model"
+	mutil "github.com/julianlk522/fitm/model/util"
+	"github.com/julianlk522/fitm/query"
+)
+
+// Exports contain every section in this order, newest links first
+var TMAP_EXPORT_SECTIONS = []string{"submitted", "copied", "tagged"}
+
+// Content-Type and file extension per format= params
+var TMAP_EXPORT_CONTENT_TYPES = map[string]string{
+	"html": "text/html; charset=utf-8",
+	"json": "application/json; charset=utf-8",
+	"csv":  "text/csv; charset=utf-8",
+}
+
+// Defaults to json
+func GetTmapExportFormat(format_params string) (string, error) {
+	format := strings.ToLower(strings.TrimSpace(format_params))
+	if format == "" {
+		return "json", nil
+	} else if _, ok := TMAP_EXPORT_CONTENT_TYPES[format]; !ok {
+		return "", e.ErrInvalidExportFormatParams
+	}
+
+	return format, nil
+}
+
+func GetTmapExportFileName(login_name string, format string) string {
+	return login_name + "_fitm_" + time.Now().Format("2006-01-02") + "." + format
+}
+
+// Writes every link in the user's tmap (NSFW included, no cats or page
+// limits) to w as it is scanned, so large maps are never held in memory
+func ExportTmap(w io.Writer, login_name string, format string) error {
+	var ew TmapExportWriter
+	switch format {
+	case "html":
+		ew = &NetscapeTmapExportWriter{w: w}
+	case "json":
+		ew = &JSONTmapExportWriter{w: w}
+	case "csv":
+		ew = &CSVTmapExportWriter{w: csv.NewWriter(w)}
+	default:
+		return e.ErrInvalidExportFormatParams
+	}
+
+	if err := ew.Begin(login_name); err != nil {
+		return err
+	}
+
+	for _, section := range TMAP_EXPORT_SECTIONS {
+		section_sql, err := GetTmapExportSectionQuery(login_name, section)
+		if err != nil {
+			return err
+		}
+
+		if err = ew.BeginSection(section); err != nil {
+			return err
+		}
+		if err = ScanTmapExportLinks(section_sql, ew.WriteLink); err != nil {
+			return err
+		}
+		if err = ew.EndSection(); err != nil {
+			return err
+		}
+	}
+
+	return ew.End()
+}
+
+func GetTmapExportSectionQuery(login_name string, section string) (*query.Query, error) {
+	opts := &model.TmapOptions{
+		OwnerLoginName: login_name,
+		SortByNewest:   true,
+		IncludeNSFW:    true,
+	}
+
+	switch section {
+	case "submitted":
+		submitted_sql := query.
+			NewTmapSubmitted(login_name).
+			FromOptions(opts).
+			ForExport()
+		return submitted_sql.Query, submitted_sql.Error
+	case "copied":
+		copied_sql := query.
+			NewTmapCopied(login_name).
+			FromOptions(opts).
+			ForExport()
+		return copied_sql.Query, copied_sql.Error
+	case "tagged":
+		tagged_sql := query.
+			NewTmapTagged(login_name).
+			FromOptions(opts).
+			ForExport()
+		return tagged_sql.Query, tagged_sql.Error
+	default:
+		return nil, e.ErrInvalidSectionParams
+	}
+}
+
+// Calls write for each link as it is scanned
+func ScanTmapExportLinks(sql *query.Query, write func(model.TmapExportLink) error) error {
+	rows, err := db.Client.Query(sql.Text, sql.Args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		l := model.TmapExportLink{}
+		if err := rows.Scan(
+			&l.ID,
+			&l.URL,
+			&l.SubmittedBy,
+			&l.SubmitDate,
+			&l.Cats,
+			&l.CatsFromUser,
+			&l.Summary,
+			&l.SummaryCount,
+			&l.LikeCount,
+			&l.EarliestLikers,
+			&l.CopyCount,
+			&l.EarliestCopiers,
+			&l.ClickCount,
+			&l.TagCount,
+			&l.PreviewImgFilename,
+			&l.Health,
+
+			// export only
+			&l.GlobalCats,
+			&l.GlobalSummary,
+		); err != nil {
+			return err
+		}
+
+		if err = write(l); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// Called by ExportTmap: Begin, then BeginSection, WriteLink (any number
+// of times) and EndSection for each of TMAP_EXPORT_SECTIONS, then End
+type TmapExportWriter interface {
+	Begin(login_name string) error
+	BeginSection(section string) error
+	WriteLink(l model.TmapExportLink) error
+	EndSection() error
+	End() error
+}
+
+// Browser-importable bookmarks file: one folder per section, cats as
+// TAGS and summaries as titles (see ParseNetscapeBookmarks)
+type NetscapeTmapExportWriter struct {
+	w io.Writer
+}
+
+func (nw *NetscapeTmapExportWriter) Begin(login_name string) error {
+	_, err := io.WriteString(nw.w, `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>`+html.EscapeString(login_name)+`'s treasure map</H1>
+<DL><p>
+`)
+	return err
+}
+
+func (nw *NetscapeTmapExportWriter) BeginSection(section string) error {
+	_, err := io.WriteString(
+		nw.w,
+		"\t<DT><H3>"+GetTmapExportSectionName(section)+"</H3>\n\t<DL><p>\n",
+	)
+	return err
+}
+
+func (nw *NetscapeTmapExportWriter) WriteLink(l model.TmapExportLink) error {
+	title := l.Summary
+	if title == "" {
+		title = l.URL
+	}
+
+	var add_date string
+	if t, err := time.Parse(time.DateTime, l.SubmitDate); err == nil {
+		add_date = ` ADD_DATE="` + strconv.FormatInt(t.Unix(), 10) + `"`
+	}
+
+	_, err := io.WriteString(
+		nw.w,
+		"\t\t<DT><A HREF=\""+html.EscapeString(l.URL)+"\""+
+			add_date+
+			" TAGS=\""+html.EscapeString(l.Cats)+"\">"+
+			html.EscapeString(title)+
+			"</A>\n",
+	)
+	return err
+}
+
+func (nw *NetscapeTmapExportWriter) EndSection() error {
+	_, err := io.WriteString(nw.w, "\t</DL><p>\n")
+	return err
+}
+
+func (nw *NetscapeTmapExportWriter) End() error {
+	_, err := io.WriteString(nw.w, "</DL><p>\n")
+	return err
+}
+
+// model.TmapExport, written one link at a time
+type JSONTmapExportWriter struct {
+	w io.Writer
+	// links written to the current section so far
+	section_links int
+}
+
+func (jw *JSONTmapExportWriter) Begin(login_name string) error {
+	login_name_json, err := json.Marshal(login_name)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(
+		jw.w,
+		`{"LoginName":`+string(login_name_json)+
+			`,"ExportDate":"`+mutil.NEW_LONG_TIMESTAMP()+`"`,
+	)
+	return err
+}
+
+func (jw *JSONTmapExportWriter) BeginSection(section string) error {
+	jw.section_links = 0
+	_, err := io.WriteString(jw.w, `,"`+GetTmapExportSectionName(section)+`":[`)
+	return err
+}
+
+func (jw *JSONTmapExportWriter) WriteLink(l model.TmapExportLink) error {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+
+	if jw.section_links > 0 {
+		data = append([]byte{','}, data...)
+	}
+	jw.section_links++
+
+	_, err = jw.w.Write(data)
+	return err
+}
+
+func (jw *JSONTmapExportWriter) EndSection() error {
+	_, err := io.WriteString(jw.w, "]")
+	return err
+}
+
+func (jw *JSONTmapExportWriter) End() error {
+	_, err := io.WriteString(jw.w, "}\n")
+	return err
+}
+
+// url, title, tags and created columns are named as in Pocket and
+// Raindrop exports (see ParseBookmarksCSV): title is the summary and
+// tags are the cats
+var TMAP_EXPORT_CSV_HEADER = []string{
+	"section",
+	"url",
+	"title",
+	"tags",
+	"cats_from_user",
+	"global_cats",
+	"global_summary",
+	"submitted_by",
+	"created",
+	"link_id",
+}
+
+type CSVTmapExportWriter struct {
+	w       *csv.Writer
+	section string
+}
+
+func (cw *CSVTmapExportWriter) Begin(login_name string) error {
+	return cw.w.Write(TMAP_EXPORT_CSV_HEADER)
+}
+
+func (cw *CSVTmapExportWriter) BeginSection(section string) error {
+	cw.section = section
+	return nil
+}
+
+func (cw *CSVTmapExportWriter) WriteLink(l model.TmapExportLink) error {
+	return cw.w.Write([]string{
+		cw.section,
+		GetCSVSafeCell(l.URL),
+		GetCSVSafeCell(l.Summary),
+		GetCSVSafeCell(l.Cats),
+		strconv.FormatBool(l.CatsFromUser),
+		GetCSVSafeCell(l.GlobalCats),
+		GetCSVSafeCell(l.GlobalSummary),
+		GetCSVSafeCell(l.SubmittedBy),
+		l.SubmitDate,
+		l.ID,
+	})
+}
+
+// Spreadsheet apps run cells starting with these as formulas
+const CSV_FORMULA_PREFIXES = "=+-@\t\r"
+
+// Prefixes user-submitted text that would be read as a formula with '
+// so it's shown as text instead
+func GetCSVSafeCell(cell string) string {
+	if cell != "" && strings.ContainsRune(CSV_FORMULA_PREFIXES, rune(cell[0])) {
+		return "'" + cell
+	}
+
+	return cell
+}
+
+// Drops the ' added by GetCSVSafeCell, so exports can be imported again
+func GetCSVCellWithoutGuard(cell string) string {
+	if len(cell) > 1 && cell[0] == '\'' && strings.ContainsRune(CSV_FORMULA_PREFIXES, rune(cell[1])) {
+		return cell[1:]
+	}
+
+	return cell
+}
+
+// flush each section so rows are streamed instead of held until the end
+func (cw *CSVTmapExportWriter) EndSection() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+func (cw *CSVTmapExportWriter) End() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// "submitted" -> "Submitted"
+func GetTmapExportSectionName(section string) string {
+	if section == "" {
+		return ""
+	}
+
+	return strings.ToUpper(section[:1]) + section[1:]
+}
@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/julianlk522/fitm/model"
+)
+
+func TestGetTmapExportFormat(t *testing.T) {
+	var test_cases = []struct {
+		FormatParams string
+		Format       string
+		Valid        bool
+	}{
+		{"", "json", true},
+		{"html", "html", true},
+		{"CSV", "csv", true},
+		{" json ", "json", true},
+		{"xml", "", false},
+	}
+
+	for _, tc := range test_cases {
+		format, err := GetTmapExportFormat(tc.FormatParams)
+		if tc.Valid && err != nil {
+			t.Fatalf("%q: failed with error: %s", tc.FormatParams, err)
+		} else if !tc.Valid && err == nil {
+			t.Fatalf("%q: expected error", tc.FormatParams)
+		} else if format != tc.Format {
+			t.Fatalf("%q: got format %q, want %q", tc.FormatParams, format, tc.Format)
+		}
+	}
+}
+
+func TestExportTmap(t *testing.T) {
+	var json_export bytes.Buffer
+	if err := ExportTmap(&json_export, TEST_LOGIN_NAME, "json"); err != nil {
+		t.Fatal(err)
+	}
+
+	var tmap model.TmapExport
+	if err := json.Unmarshal(json_export.Bytes(), &tmap); err != nil {
+		t.Fatalf("invalid JSON export: %s\n%s", err, json_export.String())
+	} else if tmap.LoginName != TEST_LOGIN_NAME || tmap.ExportDate == "" {
+		t.Fatalf("got login name %q, export date %q", tmap.LoginName, tmap.ExportDate)
+	}
+
+	// every link in each section, NSFW included
+	sections := map[string][]model.TmapExportLink{
+		"submitted": tmap.Submitted,
+		"copied":    tmap.Copied,
+		"tagged":    tmap.Tagged,
+	}
+	var urls []string
+	for _, section := range TMAP_EXPORT_SECTIONS {
+		section_sql, err := GetTmapExportSectionQuery(TEST_LOGIN_NAME, section)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var count int
+		if err := TestClient.QueryRow(
+			"SELECT count(*) FROM ("+strings.TrimSuffix(section_sql.Text, ";")+");",
+			section_sql.Args...,
+		).Scan(&count); err != nil {
+			t.Fatal(err)
+		} else if len(sections[section]) != count {
+			t.Fatalf("%s: got %d links, want %d", section, len(sections[section]), count)
+		}
+
+		for _, l := range sections[section] {
+			if !l.CatsFromUser && l.Cats != l.GlobalCats {
+				t.Fatalf("%s link %s: got cats %s, want global cats %s", section, l.ID, l.Cats, l.GlobalCats)
+			}
+			urls = append(urls, l.URL)
+		}
+	}
+	if len(urls) == 0 {
+		t.Fatal("no links exported")
+	}
+
+	// HTML and CSV exports can be imported again
+	var html_export bytes.Buffer
+	if err := ExportTmap(&html_export, TEST_LOGIN_NAME, "html"); err != nil {
+		t.Fatal(err)
+	}
+	bookmarks, err := ParseNetscapeBookmarks(&html_export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	CheckExportedBookmarkURLs(t, "html", bookmarks, urls)
+
+	var csv_export bytes.Buffer
+	if err := ExportTmap(&csv_export, TEST_LOGIN_NAME, "csv"); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(bytes.NewReader(csv_export.Bytes())).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	} else if strings.Join(records[0], ",") != strings.Join(TMAP_EXPORT_CSV_HEADER, ",") {
+		t.Fatalf("got CSV header %q", records[0])
+	}
+	bookmarks, err = ParseBookmarksCSV(&csv_export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	CheckExportedBookmarkURLs(t, "csv", bookmarks, urls)
+
+	if err := ExportTmap(&bytes.Buffer{}, TEST_LOGIN_NAME, "xml"); err == nil {
+		t.Fatal("expected error for invalid format")
+	}
+}
+
+func TestCSVTmapExportWriter(t *testing.T) {
+	var csv_export bytes.Buffer
+	cw := &CSVTmapExportWriter{w: csv.NewWriter(&csv_export)}
+	if err := cw.BeginSection("submitted"); err != nil {
+		t.Fatal(err)
+	}
+
+	link := model.TmapExportLink{
+		GlobalCats:    "-go",
+		GlobalSummary: "@SUM(1,1)",
+	}
+	link.ID = "1234"
+	link.URL = "https://example.com/?q=-1"
+	link.Summary = `=HYPERLINK("https://evil.example","click")`
+	link.Cats = "+go,test"
+	link.SubmittedBy = "\t" + TEST_LOGIN_NAME
+	link.SubmitDate = "2024-05-06 07:08:09"
+	if err := cw.WriteLink(link); err != nil {
+		t.Fatal(err)
+	} else if err = cw.End(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(&csv_export).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"submitted",
+		"https://example.com/?q=-1",
+		`'=HYPERLINK("https://evil.example","click")`,
+		"'+go,test",
+		"false",
+		"'-go",
+		"'@SUM(1,1)",
+		"'\t" + TEST_LOGIN_NAME,
+		"2024-05-06 07:08:09",
+		"1234",
+	}
+	if len(records) != 1 || strings.Join(records[0], "|") != strings.Join(want, "|") {
+		t.Fatalf("got records %q, want %q", records, want)
+	}
+
+	// guards are dropped on import
+	var round_trip bytes.Buffer
+	cw = &CSVTmapExportWriter{w: csv.NewWriter(&round_trip)}
+	if err = cw.Begin(TEST_LOGIN_NAME); err != nil {
+		t.Fatal(err)
+	} else if err = cw.BeginSection("submitted"); err != nil {
+		t.Fatal(err)
+	} else if err = cw.WriteLink(link); err != nil {
+		t.Fatal(err)
+	} else if err = cw.End(); err != nil {
+		t.Fatal(err)
+	}
+
+	bookmarks, err := ParseBookmarksCSV(&round_trip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	CheckImportedBookmarks(t, bookmarks, []model.ImportedBookmark{
+		{URL: link.URL, Title: link.Summary, Cats: []string{"+go", "test"}},
+	})
+}
+
+func CheckExportedBookmarkURLs(t *testing.T, format string, bookmarks []model.ImportedBookmark, urls []string) {
+	t.Helper()
+
+	if len(bookmarks) != len(urls) {
+		t.Fatalf("%s: got %d bookmarks, want %d", format, len(bookmarks), len(urls))
+	}
+	for i, b := range bookmarks {
+		if b.URL != urls[i] {
+			t.Fatalf("%s: got bookmark %d URL %s, want %s", format, i, b.URL, urls[i])
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package handler
+
+import (
+	"encoding/xml"
+	"html"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	e "github.com/julianlk522/fitm/error"
+	"github.com/julianlk522/fitm/model"
+	"github.com/julianlk522/fitm/query"
+)
+
+const FEED_ENTRIES_LIMIT = query.LINKS_PAGE_LIMIT
+
+// Filters supported by feeds; results are always newest first and not
+// paginated
+var FEED_PARAMS = []string{
+	"cats",
+	"period",
+	"url_contains",
+	"q",
+	"nsfw",
+	"NSFW",
+	"section",
+	"health",
+}
+
+func GetFeedParams(params url.Values) url.Values {
+	feed_params := url.Values{}
+	for _, key := range FEED_PARAMS {
+		if values, ok := params[key]; ok {
+			feed_params[key] = values
+		}
+	}
+	feed_params.Set("sort_by", "newest")
+
+	return feed_params
+}
+
+func ScanLinksFeedLinks(links_sql *query.TopLinks) ([]model.Link, error) {
+	links_page, err := ScanRawLinksPageData[model.Link](links_sql)
+	if err != nil {
+		return nil, err
+	} else if links_page.Links == nil {
+		return []model.Link{}, nil
+	}
+
+	links := *links_page.Links
+	return links[:min(len(links), FEED_ENTRIES_LIMIT)], nil
+}
+
+// One query for opts.Section, or one per section if not set. Errors
+// come from invalid opts.
+func BuildTmapFeedQueries(opts *model.TmapOptions) ([]*query.Query, error) {
+	if opts.OwnerLoginName == "" {
+		return nil, e.ErrNoTmapOwnerLoginName
+	}
+
+	sections := []string{"submitted", "copied", "tagged"}
+	if opts.Section != "" {
+		sections = []string{opts.Section}
+	}
+
+	section_sqls := make([]*query.Query, 0, len(sections))
+	for _, section := range sections {
+		var section_sql *query.Query
+
+		switch section {
+		case "submitted":
+			submitted_sql := query.
+				NewTmapSubmitted(opts.OwnerLoginName).
+				FromOptions(opts)
+			if submitted_sql.Error != nil {
+				return nil, submitted_sql.Error
+			}
+			section_sql = submitted_sql.Query
+		case "copied":
+			copied_sql := query.
+				NewTmapCopied(opts.OwnerLoginName).
+				FromOptions(opts)
+			if copied_sql.Error != nil {
+				return nil, copied_sql.Error
+			}
+			section_sql = copied_sql.Query
+		case "tagged":
+			tagged_sql := query.
+				NewTmapTagged(opts.OwnerLoginName).
+				FromOptions(opts)
+			if tagged_sql.Error != nil {
+				return nil, tagged_sql.Error
+			}
+			section_sql = tagged_sql.Query
+		default:
+			return nil, e.ErrInvalidSectionParams
+		}
+
+		section_sqls = append(section_sqls, section_sql)
+	}
+
+	return section_sqls, nil
+}
+
+// Newest links across the BuildTmapFeedQueries sections, each once
+func ScanTmapFeedLinks(section_sqls []*query.Query) ([]model.Link, error) {
+	links := []model.Link{}
+	seen := map[string]bool{}
+	for _, section_sql := range section_sqls {
+		section_links, err := ScanTmapLinks[model.TmapLink](section_sql)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range *section_links {
+			if !seen[l.ID] {
+				seen[l.ID] = true
+				links = append(links, l.Link)
+			}
+		}
+	}
+
+	// same order as TMAP_ORDER_BY_NEWEST across sections
+	slices.SortStableFunc(links, func(a, b model.Link) int {
+		return CompareTmapLinkToCursor(a, &model.LinksCursor{
+			SortBy:       "newest",
+			SubmitDate:   b.SubmitDate,
+			LikeCount:    b.LikeCount,
+			CopyCount:    b.CopyCount,
+			ClickCount:   b.ClickCount,
+			TagCount:     b.TagCount,
+			SummaryCount: b.SummaryCount,
+			ID:           b.ID,
+		})
+	})
+
+	return links[:min(len(links), FEED_ENTRIES_LIMIT)], nil
+}
+
+// Scheme and host the request was made to, for absolute URLs to this API
+func GetRequestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	return scheme + "://" + r.Host
+}
+
+// links must be newest first
+func NewAtomFeed(title string, r *http.Request, links []model.Link) *model.AtomFeed {
+	base_url := GetRequestBaseURL(r)
+	self_url := base_url + r.URL.RequestURI()
+
+	feed := &model.AtomFeed{
+		ID:      self_url,
+		Title:   title,
+		Updated: time.Now().Format(time.RFC3339),
+		Links: []model.AtomLink{
+			{Href: self_url, Rel: "self", Type: "application/atom+xml"},
+		},
+		Entries: make([]model.AtomEntry, len(links)),
+	}
+
+	for i, l := range links {
+		feed.Entries[i] = NewAtomEntry(l, base_url)
+	}
+	if len(feed.Entries) > 0 {
+		feed.Updated = feed.Entries[0].Updated
+	}
+
+	return feed
+}
+
+// Content repeats the global summary and cats and shows the preview
+// image, since most feed readers ignore categories and enclosures
+func NewAtomEntry(l model.Link, base_url string) model.AtomEntry {
+	submit_date := GetAtomTimestamp(l.SubmitDate)
+	entry := model.AtomEntry{
+		ID:        "urn:uuid:" + l.ID,
+		Title:     l.URL,
+		Updated:   submit_date,
+		Published: submit_date,
+		Author:    model.AtomAuthor{Name: l.SubmittedBy},
+		Links: []model.AtomLink{
+			{Href: l.URL, Rel: "alternate"},
+		},
+		Summary: l.Summary,
+	}
+
+	var content strings.Builder
+	if l.Summary != "" {
+		content.WriteString("<p>" + html.EscapeString(l.Summary) + "</p>")
+	}
+
+	if l.Cats != "" {
+		cats := strings.Split(l.Cats, ",")
+		for _, cat := range cats {
+			entry.Categories = append(entry.Categories, model.AtomCategory{Term: cat})
+		}
+		content.WriteString("<p>Cats: " + html.EscapeString(strings.Join(cats, ", ")) + "</p>")
+	}
+
+	if l.PreviewImgFilename != "" {
+		img_url := base_url + "/pic/preview/" + url.PathEscape(l.PreviewImgFilename)
+		entry.Links = append(entry.Links, model.AtomLink{
+			Href: img_url,
+			Rel:  "enclosure",
+			Type: mime.TypeByExtension(filepath.Ext(l.PreviewImgFilename)),
+		})
+		content.WriteString(`<img src="` + html.EscapeString(img_url) + `" alt="">`)
+	}
+
+	if content.Len() > 0 {
+		entry.Content = &model.AtomContent{
+			Type: "html",
+			Body: content.String(),
+		}
+	}
+
+	return entry
+}
+
+// Submit dates are stored as UTC "YYYY-MM-DD HH:MM:SS" without an offset
+// (see NEW_LONG_TIMESTAMP); anything else is passed through as-is
+func GetAtomTimestamp(submit_date string) string {
+	t, err := time.ParseInLocation(time.DateTime, submit_date, time.UTC)
+	if err != nil {
+		return submit_date
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+func MarshalAtomFeed(feed *model.AtomFeed) ([]byte, error) {
+	data, err := xml.MarshalIndent(feed, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), data...), nil
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julianlk522/fitm/model"
+)
+
+func TestScanTmapFeedLinks(t *testing.T) {
+	var test_data = []struct {
+		Section string
+		Period  string
+		Valid   bool
+	}{
+		{"", "", true},
+		{"submitted", "", true},
+		{"copied", "year", true},
+		{"tagged", "all", true},
+		{"notasection", "", false},
+		{"", "fortnight", false},
+	}
+
+	for _, td := range test_data {
+		section_sqls, err := BuildTmapFeedQueries(&model.TmapOptions{
+			OwnerLoginName: TEST_LOGIN_NAME,
+			Section:        td.Section,
+			Period:         td.Period,
+			SortByNewest:   true,
+		})
+		if td.Valid && err != nil {
+			t.Fatalf("section %q, period %q: failed with error: %s", td.Section, td.Period, err)
+		} else if !td.Valid {
+			if err == nil {
+				t.Fatalf("section %q, period %q: expected error", td.Section, td.Period)
+			}
+			continue
+		}
+
+		links, err := ScanTmapFeedLinks(section_sqls)
+		if err != nil {
+			t.Fatalf("section %q, period %q: failed with error: %s", td.Section, td.Period, err)
+		}
+
+		if len(links) > FEED_ENTRIES_LIMIT {
+			t.Fatalf("got %d links, want <= %d", len(links), FEED_ENTRIES_LIMIT)
+		}
+
+		seen := map[string]bool{}
+		for i, l := range links {
+			if seen[l.ID] {
+				t.Fatalf("link %s repeated", l.ID)
+			}
+			seen[l.ID] = true
+
+			if i > 0 && l.SubmitDate > links[i-1].SubmitDate {
+				t.Fatalf("link %s (%s) after older link %s", l.ID, l.SubmitDate, links[i-1].ID)
+			}
+		}
+	}
+
+	if _, err := BuildTmapFeedQueries(&model.TmapOptions{}); err == nil {
+		t.Fatal("expected error without owner login name")
+	}
+}
+
+func TestNewAtomEntry(t *testing.T) {
+	entry := NewAtomEntry(model.Link{
+		ID:                 "1234",
+		URL:                "https://example.com",
+		SubmittedBy:        TEST_LOGIN_NAME,
+		SubmitDate:         "2024-05-06 07:08:09",
+		Cats:               "go,test",
+		Summary:            "<b>bold</b> & bright",
+		PreviewImgFilename: "1234.png",
+	}, "https://api.fitm.online")
+
+	if entry.Published != "2024-05-06T07:08:09Z" {
+		t.Fatalf("got published %s, want RFC 3339", entry.Published)
+	} else if len(entry.Categories) != 2 || entry.Categories[1].Term != "test" {
+		t.Fatalf("got categories %+v, want go, test", entry.Categories)
+	} else if entry.Author.Name != TEST_LOGIN_NAME {
+		t.Fatalf("got author %s, want %s", entry.Author.Name, TEST_LOGIN_NAME)
+	}
+
+	if entry.Content == nil || strings.Contains(entry.Content.Body, "<b>") {
+		t.Fatalf("got content %+v, want escaped summary", entry.Content)
+	} else if !strings.Contains(entry.Content.Body, `<img src="https://api.fitm.online/pic/preview/1234.png"`) {
+		t.Fatalf("preview image missing from content %s", entry.Content.Body)
+	}
+
+	var enclosure *model.AtomLink
+	for i, l := range entry.Links {
+		if l.Rel == "enclosure" {
+			enclosure = &entry.Links[i]
+		}
+	}
+	if enclosure == nil || enclosure.Type != "image/png" {
+		t.Fatalf("got links %+v, want image/png enclosure", entry.Links)
+	}
+
+	// no summary, cats or image: no content
+	if entry = NewAtomEntry(model.Link{ID: "1", URL: "https://example.com"}, ""); entry.Content != nil {
+		t.Fatalf("got content %+v, want none", entry.Content)
+	}
+}
+
+func TestGetAtomTimestamp(t *testing.T) {
+	var test_cases = []struct {
+		SubmitDate string
+		Timestamp  string
+	}{
+		{"2024-05-06 07:08:09", "2024-05-06T07:08:09Z"},
+		{"2024-05-06", "2024-05-06"},
+		{"", ""},
+	}
+
+	for _, tc := range test_cases {
+		if got := GetAtomTimestamp(tc.SubmitDate); got != tc.Timestamp {
+			t.Fatalf("%q: got %q, want %q", tc.SubmitDate, got, tc.Timestamp)
+		}
+	}
+}
+
+func TestNewAtomFeed(t *testing.T) {
+	r := httptest.NewRequest("GET", "/feeds/links.atom?cats=go", nil)
+	feed := NewAtomFeed("test", r, []model.Link{
+		{ID: "2", URL: "https://example.com/2", SubmitDate: "2024-05-06 07:08:09"},
+		{ID: "1", URL: "https://example.com/1", SubmitDate: "2024-01-01 00:00:00"},
+	})
+
+	if feed.ID != "http://example.com/feeds/links.atom?cats=go" {
+		t.Fatalf("got feed ID %s, want request URL", feed.ID)
+	} else if feed.Updated != "2024-05-06T07:08:09Z" {
+		t.Fatalf("got updated %s, want newest entry's", feed.Updated)
+	} else if len(feed.Entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(feed.Entries))
+	}
+
+	if _, err := MarshalAtomFeed(feed); err != nil {
+		t.Fatal(err)
+	}
+}
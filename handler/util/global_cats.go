@@ -0,0 +1,209 @@
+package handler
+
+import (
+	"math"
+	"strings"
+
+	"github.com/julianlk522/fitm/config"
+	"github.com/julianlk522/fitm/db"
+	e "github.com/julianlk522/fitm/error"
+	"github.com/julianlk522/fitm/model"
+	"github.com/julianlk522/fitm/query"
+
+	mutil "github.com/julianlk522/fitm/model/util"
+)
+
+// How a link's tags are combined into its global cats. Scores are summed
+// per cat, limited to the top NUM_CATS_LIMIT and then cut by Keeps.
+type GlobalCatsStrategy interface {
+	Name() string
+	Score(votes []model.TagVote) map[string]float32
+	Keeps(score float32, max_score float32) bool
+}
+
+const TIME_DECAY_HALF_LIFE_DAYS = 90
+
+// First is the default
+var GLOBAL_CATS_STRATEGIES = []GlobalCatsStrategy{
+	// tags that have stood longer (relative to the link's age) count more
+	WeightedVotes{
+		name: "lifespan_overlap",
+		weight: func(v model.TagVote) float32 {
+			return v.LifeSpanOverlap
+		},
+	},
+	// submitters whose links have been liked more count more
+	WeightedVotes{
+		name: "reputation",
+		weight: func(v model.TagVote) float32 {
+			return float32(1 + math.Log2(1+float64(v.SubmitterLikeCount)))
+		},
+	},
+	// recently updated tags count more
+	WeightedVotes{
+		name: "time_decay",
+		weight: func(v model.TagVote) float32 {
+			return float32(math.Pow(0.5, float64(v.AgeDays)/TIME_DECAY_HALF_LIFE_DAYS))
+		},
+	},
+	MajorityVote{},
+}
+
+func GetGlobalCatsStrategy(name string) (GlobalCatsStrategy, error) {
+	for _, strategy := range GLOBAL_CATS_STRATEGIES {
+		if strategy.Name() == name {
+			return strategy, nil
+		}
+	}
+
+	return nil, e.ErrInvalidGlobalCatsStrategy
+}
+
+// Falls back to the default if config.Current.GlobalCatsStrategy is unknown
+// (main refuses to start in that case anyway)
+func GetActiveGlobalCatsStrategy() GlobalCatsStrategy {
+	strategy, err := GetGlobalCatsStrategy(config.Current.GlobalCatsStrategy)
+	if err != nil {
+		return GLOBAL_CATS_STRATEGIES[0]
+	}
+
+	return strategy
+}
+
+// Sum of a per-tag weight; cats within MIN_PERCENT_OF_MAX_CAT_SCORE of
+// the top cat are kept
+type WeightedVotes struct {
+	name   string
+	weight func(v model.TagVote) float32
+}
+
+func (wv WeightedVotes) Name() string {
+	return wv.name
+}
+
+func (wv WeightedVotes) Score(votes []model.TagVote) map[string]float32 {
+	scores := make(map[string]float32)
+	for _, v := range votes {
+		weight := wv.weight(v)
+		for _, cat := range strings.Split(v.Cats, ",") {
+			scores[cat] += weight
+		}
+	}
+
+	return scores
+}
+
+func (wv WeightedVotes) Keeps(score float32, max_score float32) bool {
+	return score >= max_score*(MIN_PERCENT_OF_MAX_CAT_SCORE/100)
+}
+
+// Share of tags with each cat; cats on more than half of tags are kept,
+// or the most common ones if none are
+type MajorityVote struct{}
+
+func (mv MajorityVote) Name() string {
+	return "majority"
+}
+
+func (mv MajorityVote) Score(votes []model.TagVote) map[string]float32 {
+	scores := make(map[string]float32)
+	for _, v := range votes {
+		for _, cat := range strings.Split(v.Cats, ",") {
+			scores[cat] += 1 / float32(len(votes))
+		}
+	}
+
+	return scores
+}
+
+func (mv MajorityVote) Keeps(score float32, max_score float32) bool {
+	return score > 0.5 || score == max_score
+}
+
+func CalculateGlobalCats(strategy GlobalCatsStrategy, votes []model.TagVote) (scores map[string]float32, global_cats string) {
+	scores = strategy.Score(votes)
+
+	var max_score float32
+	for _, score := range scores {
+		if score > max_score {
+			max_score = score
+		}
+	}
+
+	limited_scores := scores
+	if len(limited_scores) > mutil.NUM_CATS_LIMIT {
+		limited_scores = LimitToTopCatRankings(limited_scores)
+	}
+
+	var kept_cats []string
+	for _, cat := range AlphabetizeCatRankings(limited_scores) {
+		if strategy.Keeps(limited_scores[cat], max_score) {
+			kept_cats = append(kept_cats, cat)
+		}
+	}
+
+	return scores, strings.Join(kept_cats, ",")
+}
+
+func ScanTagVotes(q db.Querier, tag_votes_sql *query.TagVotes) (*[]model.TagVote, error) {
+	rows, err := q.Query(tag_votes_sql.Text, tag_votes_sql.Args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	votes := []model.TagVote{}
+	for rows.Next() {
+		var v model.TagVote
+		err = rows.Scan(
+			&v.LifeSpanOverlap,
+			&v.Cats,
+			&v.SubmittedBy,
+			&v.AgeDays,
+			&v.SubmitterLikeCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		// tags submitted before an alias was added may still use it,
+		// maybe alongside its cat: each cat must count once per tag
+		// (ResolveCatAliases drops case-insensitive duplicates)
+		v.Cats = mutil.ResolveCatAliases(v.Cats)
+		votes = append(votes, v)
+	}
+
+	return &votes, rows.Err()
+}
+
+func BuildGlobalCatsDryRun(link_id string) (*model.GlobalCatsDryRun, error) {
+	var global_cats string
+	err := db.Client.QueryRow(
+		"SELECT global_cats FROM Links WHERE id = ?;",
+		link_id,
+	).Scan(&global_cats)
+	if err != nil {
+		return nil, err
+	}
+
+	votes, err := ScanTagVotes(db.Client, query.NewTagVotes(link_id))
+	if err != nil {
+		return nil, err
+	}
+
+	dry_run := &model.GlobalCatsDryRun{
+		LinkID:         link_id,
+		GlobalCats:     global_cats,
+		ActiveStrategy: GetActiveGlobalCatsStrategy().Name(),
+	}
+	for _, strategy := range GLOBAL_CATS_STRATEGIES {
+		scores, strategy_global_cats := CalculateGlobalCats(strategy, *votes)
+		dry_run.Results = append(dry_run.Results, model.GlobalCatsStrategyResult{
+			Strategy:   strategy.Name(),
+			GlobalCats: strategy_global_cats,
+			Scores:     scores,
+		})
+	}
+
+	return dry_run, nil
+}
@@ -0,0 +1,426 @@
+package handler
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"golang.org/x/net/html"
+
+	"github.com/julianlk522/fitm/db"
+	e "github.com/julianlk522/fitm/error"
+	"github.com/julianlk522/fitm/model"
+	mutil "github.com/julianlk522/fitm/model/util"
+)
+
+const (
+	MAX_IMPORTED_BOOKMARKS   = 500
+	MAX_BOOKMARKS_FILE_BYTES = 10 << 20
+)
+
+// Folders every browser export has: not useful as cats
+var BOOKMARKS_ROOT_FOLDERS = []string{
+	"bookmarks",
+	"bookmarks bar",
+	"bookmarks toolbar",
+	"bookmarks menu",
+	"other bookmarks",
+	"mobile bookmarks",
+	"menu",
+	"toolbar",
+	"unfiled",
+	"mobile",
+	"unsorted",
+}
+
+// format_params ("html", "csv" or "json") wins, then the file
+// extension, then the first non-space char
+func GetBookmarksFormat(format_params string, file_name string, data []byte) (string, error) {
+	switch format_params {
+	case "html", "csv", "json":
+		return format_params, nil
+	case "":
+	default:
+		return "", e.ErrInvalidBookmarksFormat
+	}
+
+	switch strings.ToLower(filepath.Ext(file_name)) {
+	case ".html", ".htm":
+		return "html", nil
+	case ".csv":
+		return "csv", nil
+	case ".json":
+		return "json", nil
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return "", e.ErrNoBookmarks
+	}
+
+	switch trimmed[0] {
+	case '<':
+		return "html", nil
+	case '{', '[':
+		return "json", nil
+	default:
+		return "csv", nil
+	}
+}
+
+func ParseBookmarks(format string, data []byte) ([]model.ImportedBookmark, error) {
+	switch format {
+	case "html":
+		return ParseNetscapeBookmarks(bytes.NewReader(data))
+	case "csv":
+		return ParseBookmarksCSV(bytes.NewReader(data))
+	case "json":
+		return ParseBookmarksJSON(data)
+	default:
+		return nil, e.ErrInvalidBookmarksFormat
+	}
+}
+
+// Netscape bookmark file (exported by all major browsers): folders are
+// <H3> headings each followed by a <DL> of their contents, bookmarks
+// are <A HREF="..." TAGS="a,b"> (TAGS only from Firefox)
+func ParseNetscapeBookmarks(r io.Reader) ([]model.ImportedBookmark, error) {
+	z := html.NewTokenizer(r)
+	bookmarks := []model.ImportedBookmark{}
+
+	var folders []string
+	var next_folder string
+	var in_folder_name bool
+	var bookmark *model.ImportedBookmark
+
+	flush := func() {
+		if bookmark != nil {
+			bookmark.Title = strings.TrimSpace(bookmark.Title)
+			bookmarks = append(bookmarks, *bookmark)
+			bookmark = nil
+		}
+	}
+
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			flush()
+			if z.Err() == io.EOF {
+				return bookmarks, nil
+			}
+			return nil, z.Err()
+
+		case html.StartTagToken:
+			t := z.Token()
+			switch t.Data {
+			case "h3":
+				in_folder_name = true
+				next_folder = ""
+			case "dl":
+				folders = append(folders, strings.TrimSpace(next_folder))
+				next_folder = ""
+			case "a":
+				flush()
+				var href, tags string
+				for _, attr := range t.Attr {
+					switch attr.Key {
+					case "href":
+						href = attr.Val
+					case "tags":
+						tags = attr.Val
+					}
+				}
+				bookmark = &model.ImportedBookmark{
+					URL:  href,
+					Cats: GetBookmarkCats(folders, strings.Split(tags, ",")),
+				}
+			}
+
+		case html.EndTagToken:
+			switch z.Token().Data {
+			case "h3":
+				in_folder_name = false
+			case "dl":
+				if len(folders) > 0 {
+					folders = folders[:len(folders)-1]
+				}
+			case "a":
+				flush()
+			}
+
+		case html.TextToken:
+			text := z.Token().Data
+			if in_folder_name {
+				next_folder += text
+			} else if bookmark != nil {
+				bookmark.Title += text
+			}
+		}
+	}
+}
+
+// Pocket (title, url, tags separated by "|") and Raindrop (title, url,
+// folder, tags separated by ",") exports, or any CSV with a url column
+func ParseBookmarksCSV(r io.Reader) ([]model.ImportedBookmark, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, e.ErrNoBookmarks
+	} else if err != nil {
+		return nil, err
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.TrimPrefix(name, "\ufeff")
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["url"]; !ok {
+		return nil, e.ErrNoBookmarksURLColumn
+	}
+
+	bookmarks := []model.ImportedBookmark{}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return bookmarks, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		get := func(column string) string {
+			i, ok := columns[column]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(GetCSVCellWithoutGuard(record[i]))
+		}
+
+		tags := strings.FieldsFunc(get("tags"), func(r rune) bool {
+			return r == ',' || r == '|'
+		})
+		bookmarks = append(bookmarks, model.ImportedBookmark{
+			URL:   get("url"),
+			Title: get("title"),
+			Cats:  GetBookmarkCats(strings.Split(get("folder"), "/"), tags),
+		})
+	}
+}
+
+// Firefox backups (title, uri, tags, children) and Chromium's Bookmarks
+// file (roots, name, url, children), or a list of such nodes
+type bookmarksJSONNode struct {
+	Title    string                       `json:"title"`
+	Name     string                       `json:"name"`
+	URI      string                       `json:"uri"`
+	URL      string                       `json:"url"`
+	Tags     string                       `json:"tags"`
+	Children []bookmarksJSONNode          `json:"children"`
+	Roots    map[string]bookmarksJSONNode `json:"roots"`
+}
+
+func ParseBookmarksJSON(data []byte) ([]model.ImportedBookmark, error) {
+	var nodes []bookmarksJSONNode
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &nodes); err != nil {
+			return nil, err
+		}
+	} else {
+		var root bookmarksJSONNode
+		if err := json.Unmarshal(trimmed, &root); err != nil {
+			return nil, err
+		}
+		nodes = []bookmarksJSONNode{root}
+	}
+
+	bookmarks := []model.ImportedBookmark{}
+	for _, node := range nodes {
+		AppendBookmarksJSONNode(&bookmarks, node, nil)
+	}
+
+	return bookmarks, nil
+}
+
+func AppendBookmarksJSONNode(bookmarks *[]model.ImportedBookmark, node bookmarksJSONNode, folders []string) {
+	title := cmp.Or(node.Title, node.Name)
+
+	if url := cmp.Or(node.URI, node.URL); url != "" {
+		// Firefox "smart bookmarks" (saved history queries)
+		if strings.HasPrefix(url, "place:") {
+			return
+		}
+
+		*bookmarks = append(*bookmarks, model.ImportedBookmark{
+			URL:   url,
+			Title: strings.TrimSpace(title),
+			Cats:  GetBookmarkCats(folders, strings.Split(node.Tags, ",")),
+		})
+		return
+	}
+
+	// Chromium roots are bookmark_bar, other, synced: each a named folder
+	root_keys := make([]string, 0, len(node.Roots))
+	for key := range node.Roots {
+		root_keys = append(root_keys, key)
+	}
+	slices.Sort(root_keys)
+	for _, key := range root_keys {
+		AppendBookmarksJSONNode(bookmarks, node.Roots[key], folders)
+	}
+
+	if len(node.Children) > 0 {
+		child_folders := append(slices.Clip(folders), title)
+		for _, child := range node.Children {
+			AppendBookmarksJSONNode(bookmarks, child, child_folders)
+		}
+	}
+}
+
+// Folder names then tags, without browser root folders, case-insensitive
+// duplicates or commas (cats separator)
+func GetBookmarkCats(folders []string, tags []string) []string {
+	cats := []string{}
+
+	for _, cat := range slices.Concat(folders, tags) {
+		cat = strings.TrimSpace(strings.ReplaceAll(cat, ",", " "))
+		if cat == "" || slices.Contains(BOOKMARKS_ROOT_FOLDERS, strings.ToLower(cat)) {
+			continue
+		} else if slices.ContainsFunc(cats, func(c string) bool {
+			return strings.EqualFold(c, cat)
+		}) {
+			continue
+		}
+
+		cats = append(cats, cat)
+	}
+
+	return cats
+}
+
+// extra_cats are added to every bookmark's cats. Bookmarks that would
+// be new links past max_new_links fail (existing links are still copied).
+func ImportBookmarks(bookmarks []model.ImportedBookmark, extra_cats []string, user_id string, login_name string, max_new_links int) *model.LinkImport {
+	link_import := &model.LinkImport{
+		Results: []model.LinkImportResult{},
+	}
+	seen_urls := map[string]bool{}
+
+	for _, bookmark := range bookmarks {
+		result := ImportBookmark(
+			bookmark,
+			extra_cats,
+			user_id,
+			login_name,
+			seen_urls,
+			link_import.Added < max_new_links,
+		)
+		link_import.Add(result)
+	}
+
+	return link_import
+}
+
+// New links are added like AddLink's, with the bookmark's title as auto
+// summary until their ingest job finds one (see FetchLinkMetadata).
+// Existing links are copied.
+func ImportBookmark(bookmark model.ImportedBookmark, extra_cats []string, user_id string, login_name string, seen_urls map[string]bool, can_add bool) model.LinkImportResult {
+	url, url_err := GetSubmittedLinkURL(bookmark.URL)
+	result := model.LinkImportResult{URL: url}
+
+	skip := func(reason error) model.LinkImportResult {
+		result.Status = model.LINK_IMPORT_SKIPPED
+		result.Reason = reason.Error()
+		return result
+	}
+	fail := func(err error) model.LinkImportResult {
+		result.Status = model.LINK_IMPORT_FAILED
+		result.Reason = err.Error()
+		return result
+	}
+
+	if url_err != nil {
+		result.URL = strings.TrimSpace(bookmark.URL)
+		return fail(url_err)
+	} else if seen_urls[url] {
+		return skip(e.ErrDuplicateBookmark)
+	}
+	seen_urls[url] = true
+
+	if is_duplicate, link_id := LinkAlreadyAdded(url); is_duplicate {
+		result.LinkID = link_id
+
+		if UserSubmittedLink(login_name, link_id) {
+			return skip(e.ErrLinkAlreadySubmitted)
+		} else if UserHasCopiedLink(user_id, link_id) {
+			return skip(e.ErrLinkAlreadyCopied)
+		}
+
+		if _, err := db.Client.Exec(
+			`INSERT INTO "Link Copies" (id, link_id, user_id, timestamp) VALUES(?,?,?,?);`,
+			uuid.New().String(),
+			link_id,
+			user_id,
+			mutil.NEW_LONG_TIMESTAMP(),
+		); err != nil {
+			return fail(err)
+		}
+
+		result.Status = model.LINK_IMPORT_COPIED
+		return result
+	}
+
+	if !can_add {
+		return fail(e.ErrMaxDailyImportedLinksReached(MAX_DAILY_IMPORTED_LINKS))
+	}
+
+	cats := GetBookmarkCats(bookmark.Cats, extra_cats)
+	if len(cats) == 0 {
+		return fail(e.ErrNoTagCats)
+	}
+
+	request := &model.NewLinkRequest{
+		URL:  url,
+		Cats: strings.Join(cats, ","),
+	}
+	if err := request.Bind(nil); err != nil {
+		return fail(err)
+	}
+
+	if _, err := AddLinkAndQueueIngestJob(
+		request,
+		login_name,
+		GetImportedLinkSummary(bookmark.Title, url),
+		db.AUTO_SUMMARY_USER_ID,
+		url,
+	); err != nil {
+		return fail(err)
+	}
+
+	result.Status = model.LINK_IMPORT_ADDED
+	result.LinkID = request.LinkID
+	return result
+}
+
+// Bookmark title, unless it's just the URL, fit for an auto summary
+func GetImportedLinkSummary(title string, url string) string {
+	title = strings.TrimSpace(title)
+	if title == "" || title == url {
+		return ""
+	}
+
+	summary := strings.ReplaceAll(title, "\"", "'")
+	if len(summary) > mutil.SUMMARY_CHAR_LIMIT {
+		summary = strings.ToValidUTF8(summary[:mutil.SUMMARY_CHAR_LIMIT], "")
+	}
+
+	return summary
+}
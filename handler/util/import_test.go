@@ -0,0 +1,329 @@
+package handler
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/julianlk522/fitm/model"
+)
+
+func TestGetBookmarksFormat(t *testing.T) {
+	var test_cases = []struct {
+		FormatParams string
+		FileName     string
+		Data         string
+		Format       string
+		Valid        bool
+	}{
+		{"csv", "bookmarks.html", "<!DOCTYPE", "csv", true},
+		{"", "bookmarks.HTML", "", "html", true},
+		{"", "ril_export.csv", "", "csv", true},
+		{"", "bookmarks-2024-01-01.json", "", "json", true},
+		{"", "Bookmarks", "\n  {\"roots\": {}}", "json", true},
+		{"", "Bookmarks", "<!DOCTYPE NETSCAPE-Bookmark-file-1>", "html", true},
+		{"", "export", "title,url", "csv", true},
+		{"", "export", "  ", "", false},
+		{"xml", "bookmarks.html", "", "", false},
+	}
+
+	for _, tc := range test_cases {
+		format, err := GetBookmarksFormat(tc.FormatParams, tc.FileName, []byte(tc.Data))
+		if tc.Valid && err != nil {
+			t.Fatalf("%+v: failed with error: %s", tc, err)
+		} else if !tc.Valid && err == nil {
+			t.Fatalf("%+v: expected error", tc)
+		} else if format != tc.Format {
+			t.Fatalf("%+v: got format %q", tc, format)
+		}
+	}
+}
+
+func CheckImportedBookmarks(t *testing.T, got []model.ImportedBookmark, want []model.ImportedBookmark) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d bookmarks, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i].URL != want[i].URL ||
+			got[i].Title != want[i].Title ||
+			!slices.Equal(got[i].Cats, want[i].Cats) {
+			t.Fatalf("got bookmark %+v, want %+v", got[i], want[i])
+		}
+	}
+}
+
+func TestParseNetscapeBookmarks(t *testing.T) {
+	bookmarks, err := ParseNetscapeBookmarks(strings.NewReader(`<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+	<DT><H3 PERSONAL_TOOLBAR_FOLDER="true">Bookmarks bar</H3>
+	<DL><p>
+		<DT><A HREF="https://go.dev/" ADD_DATE="1700000000">The Go Programming Language</A>
+		<DT><H3>Programming</H3>
+		<DL><p>
+			<DT><H3>Go</H3>
+			<DL><p>
+				<DT><A HREF="https://gobyexample.com" TAGS="tutorial,Go">Go by Example</A>
+			</DL><p>
+			<DT><A HREF="https://www.rust-lang.org">Rust &amp; Cargo</A>
+		</DL><p>
+	</DL><p>
+	<DT><A HREF="https://example.com">Example, Inc.</A>
+</DL><p>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	CheckImportedBookmarks(t, bookmarks, []model.ImportedBookmark{
+		{URL: "https://go.dev/", Title: "The Go Programming Language", Cats: []string{}},
+		{URL: "https://gobyexample.com", Title: "Go by Example", Cats: []string{"Programming", "Go", "tutorial"}},
+		{URL: "https://www.rust-lang.org", Title: "Rust & Cargo", Cats: []string{"Programming"}},
+		{URL: "https://example.com", Title: "Example, Inc.", Cats: []string{}},
+	})
+}
+
+func TestParseBookmarksCSV(t *testing.T) {
+	// Pocket
+	bookmarks, err := ParseBookmarksCSV(strings.NewReader(`title,url,time_added,tags,status
+Go by Example,https://gobyexample.com,1700000000,go|tutorial,unread
+"Rust, the book",https://doc.rust-lang.org/book,1700000001,,archive
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	CheckImportedBookmarks(t, bookmarks, []model.ImportedBookmark{
+		{URL: "https://gobyexample.com", Title: "Go by Example", Cats: []string{"go", "tutorial"}},
+		{URL: "https://doc.rust-lang.org/book", Title: "Rust, the book", Cats: []string{}},
+	})
+
+	// Raindrop
+	bookmarks, err = ParseBookmarksCSV(strings.NewReader("\ufeffid,title,note,excerpt,url,folder,tags,created\n" +
+		`1,Go,,,https://go.dev,Programming/Go,"go, lang",2024-01-01` + "\n" +
+		`2,Unsorted,,,https://example.com,Unsorted,,2024-01-01` + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	CheckImportedBookmarks(t, bookmarks, []model.ImportedBookmark{
+		{URL: "https://go.dev", Title: "Go", Cats: []string{"Programming", "Go", "lang"}},
+		{URL: "https://example.com", Title: "Unsorted", Cats: []string{}},
+	})
+
+	if _, err = ParseBookmarksCSV(strings.NewReader("title,link\nGo,https://go.dev\n")); err == nil {
+		t.Fatal("expected error for CSV without url column")
+	}
+}
+
+func TestParseBookmarksJSON(t *testing.T) {
+	// Chromium
+	bookmarks, err := ParseBookmarksJSON([]byte(`{
+		"checksum": "abc",
+		"roots": {
+			"bookmark_bar": {
+				"name": "Bookmarks bar",
+				"type": "folder",
+				"children": [
+					{"name": "Go", "type": "url", "url": "https://go.dev"},
+					{"name": "News", "type": "folder", "children": [
+						{"name": "HN", "type": "url", "url": "https://news.ycombinator.com"}
+					]}
+				]
+			},
+			"other": {"name": "Other bookmarks", "type": "folder", "children": []}
+		},
+		"version": 1
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	CheckImportedBookmarks(t, bookmarks, []model.ImportedBookmark{
+		{URL: "https://go.dev", Title: "Go", Cats: []string{}},
+		{URL: "https://news.ycombinator.com", Title: "HN", Cats: []string{"News"}},
+	})
+
+	// Firefox
+	bookmarks, err = ParseBookmarksJSON([]byte(`{
+		"title": "",
+		"root": "placesRoot",
+		"children": [
+			{"title": "menu", "root": "bookmarksMenuFolder", "children": [
+				{"title": "Recent Tags", "uri": "place:type=6&sort=14&maxResults=10"},
+				{"title": "Dev", "children": [
+					{"title": "MDN", "uri": "https://developer.mozilla.org", "tags": "web,docs"}
+				]}
+			]}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	CheckImportedBookmarks(t, bookmarks, []model.ImportedBookmark{
+		{URL: "https://developer.mozilla.org", Title: "MDN", Cats: []string{"Dev", "web", "docs"}},
+	})
+
+	if _, err = ParseBookmarksJSON([]byte(`{"roots": `)); err == nil {
+		t.Fatal("expected error for invalid JSON")
+	}
+}
+
+func TestGetBookmarkCats(t *testing.T) {
+	got := GetBookmarkCats(
+		[]string{"", "Bookmarks Toolbar", "Dev, Tools", "go"},
+		[]string{" Go ", "docs", ""},
+	)
+	want := []string{"Dev  Tools", "go", "docs"}
+
+	if !slices.Equal(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestImportBookmarks(t *testing.T) {
+	const importer_id, importer_login_name = TEST_REQ_USER_ID, "test_req_login_name"
+
+	link_import := ImportBookmarks(
+		[]model.ImportedBookmark{
+			// new
+			{URL: "https://example.com/import-test/", Title: "Import test", Cats: []string{"testing"}},
+			// same URL again
+			{URL: "https://example.com/import-test", Cats: []string{"testing"}},
+			// submitted by someone else (bob)
+			{URL: "https://google.com", Cats: []string{"search"}},
+			// submitted by jlk
+			{URL: "https://go.dev", Cats: []string{"go"}},
+			// not http(s)
+			{URL: "ftp://example.com/file", Cats: []string{"files"}},
+			// normalized like AddLink's URLs
+			{URL: " example.com/import-test-no-scheme/ ", Cats: []string{"testing"}},
+		},
+		[]string{"imported"},
+		importer_id,
+		importer_login_name,
+		MAX_DAILY_IMPORTED_LINKS,
+	)
+	t.Cleanup(func() {
+		for _, result := range link_import.Results {
+			switch result.Status {
+			case model.LINK_IMPORT_ADDED:
+				for _, table := range []string{"Summaries", "Tags"} {
+					TestClient.Exec("DELETE FROM "+table+" WHERE link_id = ?;", result.LinkID)
+				}
+				TestClient.Exec("DELETE FROM Links WHERE id = ?;", result.LinkID)
+				TestClient.Exec(`DELETE FROM "Ingest Jobs" WHERE link_id = ?;`, result.LinkID)
+			case model.LINK_IMPORT_COPIED:
+				TestClient.Exec(
+					`DELETE FROM "Link Copies" WHERE link_id = ? AND user_id = ?;`,
+					result.LinkID,
+					importer_id,
+				)
+			}
+		}
+	})
+
+	want_statuses := []string{
+		model.LINK_IMPORT_ADDED,
+		model.LINK_IMPORT_SKIPPED,
+		model.LINK_IMPORT_COPIED,
+		model.LINK_IMPORT_COPIED,
+		model.LINK_IMPORT_FAILED,
+		model.LINK_IMPORT_ADDED,
+	}
+	for i, result := range link_import.Results {
+		if result.Status != want_statuses[i] {
+			t.Fatalf("result %d: got %+v, want status %s", i, result, want_statuses[i])
+		}
+	}
+	if link_import.Added != 2 || link_import.Skipped != 1 || link_import.Copied != 2 || link_import.Failed != 1 {
+		t.Fatalf("got counts %+v", link_import)
+	}
+
+	// added link: trailing slash dropped, extra cats added, title as
+	// auto summary, ingest job queued
+	added := link_import.Results[0]
+	var url, global_cats, global_summary string
+	if err := TestClient.QueryRow(
+		"SELECT url, global_cats, global_summary FROM Links WHERE id = ?;",
+		added.LinkID,
+	).Scan(&url, &global_cats, &global_summary); err != nil {
+		t.Fatal(err)
+	} else if url != "https://example.com/import-test" ||
+		global_cats != "imported,testing" ||
+		global_summary != "Import test" {
+		t.Fatalf("got url %s, cats %s, summary %s", url, global_cats, global_summary)
+	}
+
+	if job, err := GetLatestIngestJob(added.LinkID); err != nil {
+		t.Fatalf("no ingest job queued for added link: %s", err)
+	} else if job.Status != model.INGEST_JOB_PENDING || job.URL != url {
+		t.Fatalf("got ingest job %+v, want pending job for %s", job, url)
+	}
+
+	if no_scheme := link_import.Results[5]; no_scheme.URL != "https://example.com/import-test-no-scheme" {
+		t.Fatalf("got URL %s, want https:// added and trailing slash dropped", no_scheme.URL)
+	}
+
+	if !UserHasCopiedLink(importer_id, link_import.Results[2].LinkID) {
+		t.Fatal("existing link not copied")
+	}
+
+	// importing again: nothing to do
+	again := ImportBookmarks(
+		[]model.ImportedBookmark{{URL: "https://go.dev", Cats: []string{"go"}}},
+		nil,
+		importer_id,
+		importer_login_name,
+		MAX_DAILY_IMPORTED_LINKS,
+	)
+	if again.Skipped != 1 {
+		t.Fatalf("got %+v, want already copied link skipped", again.Results)
+	}
+
+	// own link
+	own := ImportBookmarks(
+		[]model.ImportedBookmark{{URL: "https://go.dev", Cats: []string{"go"}}},
+		nil,
+		TEST_USER_ID,
+		TEST_LOGIN_NAME,
+		MAX_DAILY_IMPORTED_LINKS,
+	)
+	if own.Skipped != 1 {
+		t.Fatalf("got %+v, want own link skipped", own.Results)
+	}
+
+	// new link without cats
+	no_cats := ImportBookmarks(
+		[]model.ImportedBookmark{{URL: "https://example.com/no-cats"}},
+		nil,
+		importer_id,
+		importer_login_name,
+		MAX_DAILY_IMPORTED_LINKS,
+	)
+	if no_cats.Failed != 1 {
+		t.Fatalf("got %+v, want link without cats failed", no_cats.Results)
+	}
+
+	// daily quota reached: no new links, existing ones still copied
+	quota := ImportBookmarks(
+		[]model.ImportedBookmark{
+			{URL: "https://example.com/over-quota", Cats: []string{"testing"}},
+			{URL: "https://gobyexample.com/channels", Cats: []string{"go"}},
+		},
+		nil,
+		importer_id,
+		importer_login_name,
+		0,
+	)
+	t.Cleanup(func() {
+		TestClient.Exec(
+			`DELETE FROM "Link Copies" WHERE link_id = ? AND user_id = ?;`,
+			quota.Results[1].LinkID,
+			importer_id,
+		)
+	})
+	if quota.Failed != 1 || quota.Copied != 1 || quota.Results[0].Status != model.LINK_IMPORT_FAILED {
+		t.Fatalf("got %+v, want new link failed and existing link copied", quota.Results)
+	}
+}
@@ -0,0 +1,484 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/julianlk522/fitm/db"
+	e "github.com/julianlk522/fitm/error"
+	m "github.com/julianlk522/fitm/middleware"
+	"github.com/julianlk522/fitm/model"
+	mutil "github.com/julianlk522/fitm/model/util"
+)
+
+const (
+	MAX_INGEST_ATTEMPTS = 3
+	// after the nth failed attempt, the next one waits n times this
+	INGEST_RETRY_DELAY = time.Minute
+	// to pick up retries and jobs left over from before a restart
+	INGEST_POLL_INTERVAL = 30 * time.Second
+)
+
+// Buffered: NotifyIngestWorker never blocks, and any number of
+// notifications before the worker checks again count as one
+var ingest_worker_wake = make(chan struct{}, 1)
+
+// Submitted URLs are stored as https:// (unless they have another
+// scheme) without a trailing slash until their ingest job resolves
+// them, and must at least look like a URL with a domain
+func GetSubmittedLinkURL(submitted_url string) (string, error) {
+	link_url := strings.TrimSuffix(strings.TrimSpace(submitted_url), "/")
+	if !strings.Contains(link_url, "://") {
+		link_url = "https://" + link_url
+	}
+
+	parsed, err := url.Parse(link_url)
+	if err != nil ||
+		(parsed.Scheme != "http" && parsed.Scheme != "https") ||
+		!strings.Contains(strings.Trim(parsed.Hostname(), "."), ".") {
+		return "", e.ErrInvalidURL
+	}
+
+	return link_url, nil
+}
+
+// Inserts the link (request.URL should come from GetSubmittedLinkURL),
+// its tag and summary if any, and queues an ingest job to fetch the
+// rest from submitted_url.
+// summary_by: user ID of the summary's author
+func AddLinkAndQueueIngestJob(request *model.NewLinkRequest, login_name string, summary string, summary_by string, submitted_url string) (*model.IngestJob, error) {
+	tx, err := db.Client.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	cats := AlphabetizeCats(request.Cats)
+
+	// link first: summaries, tags and ingest jobs reference it
+	if _, err = tx.Exec(
+		`INSERT INTO Links (id, url, submitted_by, submit_date, global_cats, global_summary, img_file)
+		VALUES(?,?,?,?,?,?,?);`,
+		request.LinkID,
+		request.URL,
+		login_name,
+		request.SubmitDate,
+		cats,
+		summary,
+		"",
+	); err != nil {
+		return nil, err
+	}
+
+	if summary != "" {
+		if _, err = tx.Exec(
+			"INSERT INTO Summaries (id, text, link_id, submitted_by, last_updated) VALUES(?,?,?,?,?);",
+			uuid.New().String(),
+			summary,
+			request.LinkID,
+			summary_by,
+			request.SubmitDate,
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err = tx.Exec(
+		"INSERT INTO Tags (id, link_id, cats, submitted_by, last_updated) VALUES(?,?,?,?,?);",
+		uuid.New().String(),
+		request.LinkID,
+		cats,
+		login_name,
+		request.SubmitDate,
+	); err != nil {
+		return nil, err
+	}
+
+	if err = IncrementSpellfixRanksForCats(
+		tx,
+		strings.Split(request.Cats, ","),
+	); err != nil {
+		return nil, err
+	}
+
+	job, err := QueueIngestJob(tx, request.LinkID, submitted_url)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	NotifyIngestWorker()
+	return job, nil
+}
+
+func QueueIngestJob(tx *sql.Tx, link_id string, submitted_url string) (*model.IngestJob, error) {
+	now := mutil.NEW_LONG_TIMESTAMP()
+	job := &model.IngestJob{
+		ID:      uuid.New().String(),
+		LinkID:  link_id,
+		URL:     submitted_url,
+		Status:  model.INGEST_JOB_PENDING,
+		Created: now,
+		Updated: now,
+	}
+
+	if _, err := tx.Exec(
+		`INSERT INTO "Ingest Jobs" (id, link_id, url, status, attempts, created, updated, next_attempt)
+		VALUES(?,?,?,?,?,?,?,?);`,
+		job.ID,
+		job.LinkID,
+		job.URL,
+		job.Status,
+		job.Attempts,
+		job.Created,
+		job.Updated,
+		now,
+	); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+func NotifyIngestWorker() {
+	select {
+	case ingest_worker_wake <- struct{}{}:
+	default:
+	}
+}
+
+// Runs pending ingest jobs one at a time until ctx is done. Jobs are
+// persisted, so any left when the server stops are run on the next start.
+func RunIngestWorker(ctx context.Context) {
+	if err := ResetRunningIngestJobs(); err != nil {
+		log.Printf("Could not reset interrupted ingest jobs: %s", err)
+	}
+
+	ticker := time.NewTicker(INGEST_POLL_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		RunPendingIngestJobs(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ingest_worker_wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Jobs still running were interrupted (the worker runs one at a time)
+func ResetRunningIngestJobs() error {
+	_, err := db.Client.Exec(
+		`UPDATE "Ingest Jobs" SET status = ? WHERE status = ?;`,
+		model.INGEST_JOB_PENDING,
+		model.INGEST_JOB_RUNNING,
+	)
+	return err
+}
+
+// Until none are due
+func RunPendingIngestJobs(ctx context.Context) {
+	for ctx.Err() == nil {
+		job, err := ClaimNextIngestJob()
+		if err != nil {
+			log.Printf("Could not claim ingest job: %s", err)
+			return
+		} else if job == nil {
+			return
+		}
+
+		duplicate_of, fetch_err := FetchLinkMetadata(job.LinkID, job.URL)
+		if fetch_err != nil {
+			log.Printf("Could not fetch metadata for %s (attempt %d): %s", job.URL, job.Attempts, fetch_err)
+		}
+		job.DuplicateOf = duplicate_of
+
+		if err = FinishIngestJob(job, fetch_err); err != nil {
+			log.Printf("Could not update ingest job %s: %s", job.ID, err)
+		}
+	}
+}
+
+// Selected and returned by ScanIngestJob
+const INGEST_JOB_COLUMNS = "id, link_id, url, status, attempts, error, duplicate_of, link_deleted, created, updated"
+
+func ScanIngestJob(row *sql.Row) (*model.IngestJob, error) {
+	job := &model.IngestJob{}
+	var job_err, duplicate_of sql.NullString
+
+	if err := row.Scan(
+		&job.ID,
+		&job.LinkID,
+		&job.URL,
+		&job.Status,
+		&job.Attempts,
+		&job_err,
+		&duplicate_of,
+		&job.LinkDeleted,
+		&job.Created,
+		&job.Updated,
+	); err != nil {
+		return nil, err
+	}
+	job.Error = job_err.String
+	job.DuplicateOf = duplicate_of.String
+
+	return job, nil
+}
+
+// Oldest due pending job, marked running; nil if there are none
+func ClaimNextIngestJob() (*model.IngestJob, error) {
+	now := mutil.NEW_LONG_TIMESTAMP()
+
+	job, err := ScanIngestJob(db.Client.QueryRow(
+		`UPDATE "Ingest Jobs"
+		SET status = ?, attempts = attempts + 1, updated = ?
+		WHERE id = (
+			SELECT id
+			FROM "Ingest Jobs"
+			WHERE status = ?
+			AND next_attempt <= ?
+			ORDER BY next_attempt, created
+			LIMIT 1
+		)
+		RETURNING `+INGEST_JOB_COLUMNS+`;`,
+		model.INGEST_JOB_RUNNING,
+		now,
+		model.INGEST_JOB_PENDING,
+		now,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return job, err
+}
+
+// Failed jobs are retried later until MAX_INGEST_ATTEMPTS. The link is
+// deleted if its URL turned out to be another link's (job.DuplicateOf)
+// or never resolved (out of attempts).
+func FinishIngestJob(job *model.IngestJob, fetch_err error) error {
+	now := time.Now()
+	job.Updated = now.Format(time.DateTime)
+	next_attempt := job.Updated
+
+	switch {
+	case job.DuplicateOf != "":
+		job.Status = model.INGEST_JOB_DUPLICATE
+		job.Error = ""
+		job.LinkDeleted = true
+	case fetch_err == nil:
+		job.Status = model.INGEST_JOB_DONE
+		job.Error = ""
+	case job.Attempts >= MAX_INGEST_ATTEMPTS:
+		job.Status = model.INGEST_JOB_FAILED
+		job.Error = fetch_err.Error()
+		job.LinkDeleted = true
+	default:
+		job.Status = model.INGEST_JOB_PENDING
+		job.Error = fetch_err.Error()
+		next_attempt = now.
+			Add(time.Duration(job.Attempts) * INGEST_RETRY_DELAY).
+			Format(time.DateTime)
+	}
+
+	tx, err := db.Client.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(
+		`UPDATE "Ingest Jobs"
+		SET 
+			status = ?, 
+			error = NULLIF(?, ''), 
+			duplicate_of = NULLIF(?, ''), 
+			link_deleted = ?, 
+			updated = ?, 
+			next_attempt = ?
+		WHERE id = ?;`,
+		job.Status,
+		job.Error,
+		job.DuplicateOf,
+		job.LinkDeleted,
+		job.Updated,
+		next_attempt,
+		job.ID,
+	); err != nil {
+		return err
+	}
+
+	if job.LinkDeleted {
+		if err = DeleteIngestedLink(tx, job.LinkID); err != nil {
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	if job.LinkDeleted {
+		m.InvalidateResponseCache()
+	}
+	return nil
+}
+
+// Before it has a preview image (FetchLinkMetadata saves none for
+// duplicates or failures)
+func DeleteIngestedLink(tx *sql.Tx, link_id string) error {
+	var global_cats string
+	err := tx.QueryRow(
+		"SELECT global_cats FROM Links WHERE id = ?;",
+		link_id,
+	).Scan(&global_cats)
+	if err == sql.ErrNoRows {
+		// deleted since
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec("DELETE FROM Links WHERE id = ?;", link_id); err != nil {
+		return err
+	}
+
+	return DecrementSpellfixRanksForCats(tx, strings.Split(global_cats, ","))
+}
+
+// Most recent job for the link, even if the link was deleted since
+func GetLatestIngestJob(link_id string) (*model.IngestJob, error) {
+	return ScanIngestJob(db.Client.QueryRow(
+		`SELECT `+INGEST_JOB_COLUMNS+`
+		FROM "Ingest Jobs"
+		WHERE link_id = ?
+		ORDER BY created DESC, rowid DESC
+		LIMIT 1;`,
+		link_id,
+	))
+}
+
+// Resolves submitted_url: the link takes the resolved URL, unless
+// another link already has it (returned, and nothing is saved). Adds an
+// auto summary (replacing an imported bookmark's title), updates the
+// global summary and saves a preview image.
+func FetchLinkMetadata(link_id string, submitted_url string) (duplicate_of string, err error) {
+	var link_url string
+	if err = db.Client.QueryRow(
+		"SELECT url FROM Links WHERE id = ?;",
+		link_id,
+	).Scan(&link_url); err == sql.ErrNoRows {
+		// deleted since
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	resp, err := GetResolvedURLResponse(submitted_url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	final_url := GetFinalURL(submitted_url, resp)
+	if final_url != link_url {
+		if is_duplicate, other_link_id := LinkAlreadyAdded(final_url); is_duplicate && other_link_id != link_id {
+			return other_link_id, nil
+		}
+	}
+
+	x_md := GetLinkExtraMetadata(final_url, resp)
+	if x_md == nil {
+		x_md = &model.LinkExtraMetadata{}
+	}
+
+	var img_file string
+	if x_md.PreviewImgURL != "" {
+		img_file, err = SavePreviewImgAndGetFileName(x_md.PreviewImgURL, link_id)
+		if err != nil {
+			log.Printf("Could not save preview image for %s: %s", submitted_url, err)
+		}
+	}
+
+	tx, err := db.Client.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if final_url != link_url {
+		if _, err = tx.Exec(
+			"UPDATE Links SET url = ? WHERE id = ?;",
+			final_url,
+			link_id,
+		); err != nil {
+			return "", err
+		}
+	}
+
+	if x_md.AutoSummary != "" {
+		// only an import's bookmark title can be there already
+		var auto_summary_id string
+		err = tx.QueryRow(
+			"SELECT id FROM Summaries WHERE link_id = ? AND submitted_by = ?;",
+			link_id,
+			db.AUTO_SUMMARY_USER_ID,
+		).Scan(&auto_summary_id)
+
+		switch err {
+		case sql.ErrNoRows:
+			_, err = tx.Exec(
+				"INSERT INTO Summaries (id, text, link_id, submitted_by, last_updated) VALUES(?,?,?,?,?);",
+				uuid.New().String(),
+				x_md.AutoSummary,
+				link_id,
+				db.AUTO_SUMMARY_USER_ID,
+				mutil.NEW_LONG_TIMESTAMP(),
+			)
+		case nil:
+			_, err = tx.Exec(
+				"UPDATE Summaries SET text = ?, last_updated = ? WHERE id = ?;",
+				x_md.AutoSummary,
+				mutil.NEW_LONG_TIMESTAMP(),
+				auto_summary_id,
+			)
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if err = CalculateAndSetGlobalSummary(tx, link_id); err != nil {
+			return "", err
+		}
+	}
+
+	if img_file != "" {
+		if _, err = tx.Exec(
+			"UPDATE Links SET img_file = ? WHERE id = ?;",
+			img_file,
+			link_id,
+		); err != nil {
+			return "", err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", err
+	}
+
+	m.InvalidateResponseCache()
+	return "", nil
+}
@@ -0,0 +1,281 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julianlk522/fitm/db"
+	"github.com/julianlk522/fitm/model"
+)
+
+func TestGetSubmittedLinkURL(t *testing.T) {
+	var test_cases = []struct {
+		URL     string
+		LinkURL string
+		Valid   bool
+	}{
+		{"https://go.dev/", "https://go.dev", true},
+		{" gmail.com ", "https://gmail.com", true},
+		{"http://example.com/path?q=1", "http://example.com/path?q=1", true},
+		{"notreal", "", false},
+		{"ftp://example.com/file", "", false},
+		{"https://", "", false},
+		{"javascript:void(0)", "", false},
+	}
+
+	for _, tc := range test_cases {
+		link_url, err := GetSubmittedLinkURL(tc.URL)
+		if tc.Valid && err != nil {
+			t.Fatalf("%q: failed with error: %s", tc.URL, err)
+		} else if !tc.Valid && err == nil {
+			t.Fatalf("%q: expected error, got %s", tc.URL, link_url)
+		} else if link_url != tc.LinkURL {
+			t.Fatalf("%q: got %q, want %q", tc.URL, link_url, tc.LinkURL)
+		}
+	}
+}
+
+func TestIngestJobs(t *testing.T) {
+	request := &model.NewLinkRequest{
+		URL:  "https://example.com/ingest-test",
+		Cats: "testing",
+	}
+	if err := request.Bind(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	job, err := AddLinkAndQueueIngestJob(
+		request,
+		TEST_LOGIN_NAME,
+		"Ingest test",
+		TEST_USER_ID,
+		"example.com/ingest-test/",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for _, table := range []string{"Summaries", "Tags"} {
+			TestClient.Exec("DELETE FROM "+table+" WHERE link_id = ?;", request.LinkID)
+		}
+		TestClient.Exec("DELETE FROM Links WHERE id = ?;", request.LinkID)
+		TestClient.Exec(`DELETE FROM "Ingest Jobs" WHERE link_id = ?;`, request.LinkID)
+	})
+
+	if latest, err := GetLatestIngestJob(request.LinkID); err != nil {
+		t.Fatal(err)
+	} else if latest.ID != job.ID ||
+		latest.Status != model.INGEST_JOB_PENDING ||
+		latest.URL != "example.com/ingest-test/" {
+		t.Fatalf("got %+v, want pending job for submitted URL", latest)
+	}
+
+	claimed, err := ClaimNextIngestJob()
+	if err != nil {
+		t.Fatal(err)
+	} else if claimed == nil || claimed.ID != job.ID {
+		t.Fatalf("got claimed job %+v, want %s", claimed, job.ID)
+	} else if claimed.Status != model.INGEST_JOB_RUNNING || claimed.Attempts != 1 {
+		t.Fatalf("got %+v, want running, attempt 1", claimed)
+	}
+
+	// failed: retried later, not right away
+	if err = FinishIngestJob(claimed, errors.New("unreachable")); err != nil {
+		t.Fatal(err)
+	}
+	if latest, err := GetLatestIngestJob(request.LinkID); err != nil {
+		t.Fatal(err)
+	} else if latest.Status != model.INGEST_JOB_PENDING || latest.Error != "unreachable" {
+		t.Fatalf("got %+v, want pending with error", latest)
+	}
+	if next, err := ClaimNextIngestJob(); err != nil {
+		t.Fatal(err)
+	} else if next != nil && next.ID == job.ID {
+		t.Fatal("failed job claimed again before its retry delay")
+	}
+
+	// out of attempts
+	claimed.Attempts = MAX_INGEST_ATTEMPTS
+	if err = FinishIngestJob(claimed, errors.New("still unreachable")); err != nil {
+		t.Fatal(err)
+	}
+	if latest, err := GetLatestIngestJob(request.LinkID); err != nil {
+		t.Fatal(err)
+	} else if latest.Status != model.INGEST_JOB_FAILED {
+		t.Fatalf("got %+v, want failed", latest)
+	}
+
+	// running when the server stopped
+	if _, err = TestClient.Exec(
+		`UPDATE "Ingest Jobs" SET status = ? WHERE id = ?;`,
+		model.INGEST_JOB_RUNNING,
+		job.ID,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err = ResetRunningIngestJobs(); err != nil {
+		t.Fatal(err)
+	}
+	if latest, err := GetLatestIngestJob(request.LinkID); err != nil {
+		t.Fatal(err)
+	} else if latest.Status != model.INGEST_JOB_PENDING {
+		t.Fatalf("got %+v, want pending after reset", latest)
+	}
+
+	if err = FinishIngestJob(claimed, nil); err != nil {
+		t.Fatal(err)
+	}
+	if latest, err := GetLatestIngestJob(request.LinkID); err != nil {
+		t.Fatal(err)
+	} else if latest.Status != model.INGEST_JOB_DONE || latest.Error != "" {
+		t.Fatalf("got %+v, want done without error", latest)
+	}
+}
+
+// An imported bookmark's title is only a placeholder auto summary
+func TestFetchLinkMetadataReplacesImportedTitle(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head>
+			<title>Page title</title>
+			<meta property="og:description" content="Fetched description">
+		</head></html>`))
+	}))
+	defer srv.Close()
+
+	result := ImportBookmark(
+		model.ImportedBookmark{
+			URL:   srv.URL + "/imported",
+			Title: "Bookmark title",
+			Cats:  []string{"testing"},
+		},
+		nil,
+		TEST_REQ_USER_ID,
+		"test_req_login_name",
+		map[string]bool{},
+		true,
+	)
+	if result.Status != model.LINK_IMPORT_ADDED {
+		t.Fatalf("got %+v, want added", result)
+	}
+	t.Cleanup(func() {
+		TestClient.Exec("DELETE FROM Links WHERE id = ?;", result.LinkID)
+		TestClient.Exec(`DELETE FROM "Ingest Jobs" WHERE link_id = ?;`, result.LinkID)
+	})
+
+	if _, err := FetchLinkMetadata(result.LinkID, srv.URL+"/imported"); err != nil {
+		t.Fatal(err)
+	}
+
+	var auto_summaries int
+	var auto_summary, global_summary string
+	if err := TestClient.QueryRow(
+		`SELECT 
+			count(*), 
+			s.text, 
+			l.global_summary
+		FROM Summaries s
+		JOIN Links l ON l.id = s.link_id
+		WHERE s.link_id = ? AND s.submitted_by = ?;`,
+		result.LinkID,
+		db.AUTO_SUMMARY_USER_ID,
+	).Scan(&auto_summaries, &auto_summary, &global_summary); err != nil {
+		t.Fatal(err)
+	} else if auto_summaries != 1 ||
+		auto_summary != "Fetched description" ||
+		global_summary != "Fetched description" {
+		t.Fatalf(
+			"got %d auto summaries %q, global summary %q, want fetched description",
+			auto_summaries,
+			auto_summary,
+			global_summary,
+		)
+	}
+}
+
+// Links whose URL was never resolved are deleted if it doesn't resolve,
+// and any link whose URL resolves to another link's is
+func TestIngestJobOutcomes(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/original", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/original", http.StatusMovedPermanently)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	add := func(link_url string) *model.IngestJob {
+		t.Helper()
+
+		request := &model.NewLinkRequest{URL: link_url, Cats: "testing"}
+		if err := request.Bind(nil); err != nil {
+			t.Fatal(err)
+		}
+		job, err := AddLinkAndQueueIngestJob(
+			request,
+			TEST_LOGIN_NAME,
+			"",
+			TEST_USER_ID,
+			link_url,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			TestClient.Exec("DELETE FROM Links WHERE id = ?;", job.LinkID)
+			TestClient.Exec(`DELETE FROM "Ingest Jobs" WHERE id = ?;`, job.ID)
+		})
+
+		return job
+	}
+
+	// out of attempts
+	unresolved := add("https://example.com/ingest-outcome-unresolved")
+	unresolved.Attempts = MAX_INGEST_ATTEMPTS
+	if err := FinishIngestJob(unresolved, errors.New("unreachable")); err != nil {
+		t.Fatal(err)
+	}
+	if latest, err := GetLatestIngestJob(unresolved.LinkID); err != nil {
+		t.Fatal(err)
+	} else if latest.Status != model.INGEST_JOB_FAILED || !latest.LinkDeleted {
+		t.Fatalf("got %+v, want failed with link deleted", latest)
+	} else if exists, _ := LinkExists(unresolved.LinkID); exists {
+		t.Fatal("link with unresolved URL not deleted")
+	}
+
+	// resolves to another link's URL
+	original := add(srv.URL + "/original")
+	moved := add(srv.URL + "/moved")
+
+	duplicate_of, err := FetchLinkMetadata(moved.LinkID, moved.URL)
+	if err != nil {
+		t.Fatal(err)
+	} else if duplicate_of != original.LinkID {
+		t.Fatalf("got duplicate of %q, want %s", duplicate_of, original.LinkID)
+	}
+
+	moved.DuplicateOf = duplicate_of
+	if err = FinishIngestJob(moved, nil); err != nil {
+		t.Fatal(err)
+	}
+	if latest, err := GetLatestIngestJob(moved.LinkID); err != nil {
+		t.Fatal(err)
+	} else if latest.Status != model.INGEST_JOB_DUPLICATE ||
+		latest.DuplicateOf != original.LinkID ||
+		!latest.LinkDeleted {
+		t.Fatalf("got %+v, want duplicate of %s with link deleted", latest, original.LinkID)
+	} else if exists, _ := LinkExists(moved.LinkID); exists {
+		t.Fatal("duplicate link not deleted")
+	} else if exists, _ := LinkExists(original.LinkID); !exists {
+		t.Fatal("original link deleted")
+	}
+
+	// resolves to its own URL
+	if duplicate_of, err = FetchLinkMetadata(original.LinkID, original.URL); err != nil {
+		t.Fatal(err)
+	} else if duplicate_of != "" {
+		t.Fatalf("got duplicate of %s for own URL", duplicate_of)
+	}
+}
@@ -24,8 +24,12 @@ import (
 var Preview_img_dir string
 
 const (
-	MAX_DAILY_LINKS          = 50
+	MAX_DAILY_LINKS = 50
+	// imports can add more (links added either way count toward both)
+	MAX_DAILY_IMPORTED_LINKS = 500
 	MAX_PREVIEW_IMG_WIDTH_PX = 200
+	// for fetching submitted pages and checking links
+	FITM_BOT_USER_AGENT = "FITM-Bot (https://fitm.online/about/how#retrieving-metadata)"
 )
 
 func init() {
@@ -37,6 +41,15 @@ func init() {
 }
 
 func UserHasSubmittedMaxDailyLinks(login_name string) (bool, error) {
+	count, err := GetUserDailyLinksCount(login_name)
+	if err != nil {
+		return false, err
+	}
+
+	return count >= MAX_DAILY_LINKS, nil
+}
+
+func GetUserDailyLinksCount(login_name string) (int, error) {
 	var count int
 	err := db.Client.QueryRow(`SELECT count(*)
 		FROM Links
@@ -44,11 +57,8 @@ func UserHasSubmittedMaxDailyLinks(login_name string) (bool, error) {
 		AND submit_date >= date('now', '-1 days');`,
 		login_name,
 	).Scan(&count)
-	if err != nil {
-		return false, err
-	}
 
-	return count >= MAX_DAILY_LINKS, nil
+	return count, err
 }
 
 func PrepareLinksPage[T model.HasCats](links_sql *query.TopLinks, options *model.LinksPageOptions) (*model.LinksPage[T], error) {
@@ -57,6 +67,7 @@ func PrepareLinksPage[T model.HasCats](links_sql *query.TopLinks, options *model
 		return nil, err
 	}
 	
+	links_page.NextCursor = GetNextLinksCursor(links_page.Links, options.SortBy)
 	PaginateLinks(links_page.Links)
 
 	cats_params, nsfw_params := options.Cats, options.NSFW
@@ -95,13 +106,16 @@ func ScanRawLinksPageData[T model.Link | model.LinkSignedIn](links_sql *query.To
 	var links any
 	var pages int
 
+	// search snippet is the last field if present
+	is_search := links_sql.IsSearch()
+
 	switch any(new(T)).(type) {
 	case *model.Link:
 		var signed_out_links = []model.Link{}
 
 		for rows.Next() {
 			l := model.Link{}
-			err := rows.Scan(
+			fields := []any{
 				&l.ID,
 				&l.URL,
 				&l.SubmittedBy,
@@ -116,11 +130,18 @@ func ScanRawLinksPageData[T model.Link | model.LinkSignedIn](links_sql *query.To
 				&l.ClickCount,
 				&l.TagCount,
 				&l.PreviewImgFilename,
+				&l.Health,
 				&pages,
-			)
+			}
+			if is_search {
+				fields = append(fields, &l.SearchSnippet)
+			}
+
+			err := rows.Scan(fields...)
 			if err != nil {
 				return nil, err
 			}
+			l.SearchSnippet = query.GetSearchSnippetHTML(l.SearchSnippet)
 			signed_out_links = append(signed_out_links, l)
 		}
 
@@ -131,7 +152,7 @@ func ScanRawLinksPageData[T model.Link | model.LinkSignedIn](links_sql *query.To
 
 		for rows.Next() {
 			l := model.LinkSignedIn{}
-			if err := rows.Scan(
+			fields := []any{
 				&l.ID,
 				&l.URL,
 				&l.SubmittedBy,
@@ -146,12 +167,19 @@ func ScanRawLinksPageData[T model.Link | model.LinkSignedIn](links_sql *query.To
 				&l.ClickCount,
 				&l.TagCount,
 				&l.PreviewImgFilename,
+				&l.Health,
 				&pages,
 				&l.IsLiked,
 				&l.IsCopied,
-			); err != nil {
+			}
+			if is_search {
+				fields = append(fields, &l.SearchSnippet)
+			}
+
+			if err := rows.Scan(fields...); err != nil {
 				return nil, err
 			}
+			l.SearchSnippet = query.GetSearchSnippetHTML(l.SearchSnippet)
 
 			signed_in_links = append(signed_in_links, l)
 		}
@@ -192,6 +220,7 @@ func ScanSingleLink[T model.Link | model.LinkSignedIn](single_link_sql *query.Si
 				&l.ClickCount,
 				&l.TagCount,
 				&l.PreviewImgFilename,
+				&l.Health,
 				&l.IsLiked,
 				&l.IsCopied,
 			); err != nil {
@@ -218,6 +247,7 @@ func ScanSingleLink[T model.Link | model.LinkSignedIn](single_link_sql *query.Si
 				&l.ClickCount,
 				&l.TagCount,
 				&l.PreviewImgFilename,
+				&l.Health,
 			); err != nil {
 			return nil, err
 		}
@@ -228,6 +258,28 @@ func ScanSingleLink[T model.Link | model.LinkSignedIn](single_link_sql *query.Si
 	return link.(*T), nil
 }
 
+// Empty unless there is a link beyond this page (see TopLinks.Page)
+func GetNextLinksCursor[T model.LinkSignedIn | model.Link](links *[]T, sort_by string) string {
+	if links == nil || len(*links) <= query.LINKS_PAGE_LIMIT {
+		return ""
+	}
+
+	sort_by, err := model.GetCursorSortBy(sort_by)
+	if err != nil {
+		return ""
+	}
+
+	var last_link model.Link
+	switch l := any((*links)[query.LINKS_PAGE_LIMIT-1]).(type) {
+	case model.Link:
+		last_link = l
+	case model.LinkSignedIn:
+		last_link = l.Link
+	}
+
+	return model.NewLinksCursor(sort_by, last_link).Encode()
+}
+
 func PaginateLinks[T model.LinkSignedIn | model.Link](links *[]T) {
 	if links == nil || len(*links) == 0 {
 		return
@@ -273,6 +325,40 @@ func CountNSFWLinks[T model.HasCats](links_sql *query.TopLinks, nsfw_params bool
 	return int(hidden_links.Int32), nil
 }
 
+// URL after any redirects (e.g., to www.) unless the response is a 302,
+// 401, 403, 429 or Google "sorry" page, in which case it's the
+// requested URL
+func GetFinalURL(request_url string, resp *http.Response) string {
+	url_after_redirects := resp.Request.URL.String()
+
+	is_302_redirect := resp.StatusCode == http.StatusFound
+	is_unauthorized := resp.StatusCode == http.StatusUnauthorized
+	is_forbidden := resp.StatusCode == http.StatusForbidden
+	is_too_many_requests := resp.StatusCode == http.StatusTooManyRequests
+	is_google_sorry_page := strings.Contains(url_after_redirects, "google.com/sorry")
+
+	if is_302_redirect || is_unauthorized || is_forbidden || is_too_many_requests || is_google_sorry_page {
+		return strings.TrimSuffix(request_url, "/")
+	}
+
+	return strings.TrimSuffix(url_after_redirects, "/")
+}
+
+// Auto summary and preview image: from the YouTube API for YT videos,
+// otherwise (or if that fails) from resp's HTML
+func GetLinkExtraMetadata(final_url string, resp *http.Response) *model.LinkExtraMetadata {
+	if IsYTVideo(final_url) {
+		if yt_md, err := GetYTVideoMetadata(final_url); err == nil {
+			return &model.LinkExtraMetadata{
+				AutoSummary:   yt_md.Items[0].Snippet.Title,
+				PreviewImgURL: yt_md.Items[0].Snippet.Thumbnails.Default.URL,
+			}
+		}
+	}
+
+	return GetLinkExtraMetadataFromResponse(resp)
+}
+
 // Add link (non-YT)
 func GetLinkExtraMetadataFromResponse(resp *http.Response) *model.LinkExtraMetadata {
 	if resp == nil {
@@ -296,7 +382,7 @@ func GetResolvedURLResponse(url string) (*http.Response, error) {
 		}
 
 		req.Header.Set("Accept", "*/*")
-		req.Header.Set("User-Agent", "FITM-Bot (https://fitm.online/about/how#retrieving-metadata)")
+		req.Header.Set("User-Agent", FITM_BOT_USER_AGENT)
 
 		resp, err := http.DefaultClient.Do(req)
 		if err != nil {
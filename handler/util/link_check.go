@@ -0,0 +1,483 @@
+package handler
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/julianlk522/fitm/db"
+	m "github.com/julianlk522/fitm/middleware"
+	"github.com/julianlk522/fitm/model"
+)
+
+const (
+	LINK_CHECK_TIMEOUT = 15 * time.Second
+	// due links RunLinkChecker takes at a time
+	LINK_CHECK_BATCH_SIZE = 50
+	// how often RunLinkChecker looks for due links
+	LINK_CHECK_POLL_INTERVAL = time.Minute
+	// dead links are rechecked at most this many intervals apart
+	MAX_LINK_CHECK_BACKOFF_INTERVALS = 8
+	// "Link Checks" rows kept per link
+	LINK_CHECKS_HISTORY_LIMIT = 20
+	// for hosts that answer 429 or 503 (doubled each time until a good
+	// response)
+	MIN_HOST_BACKOFF = time.Minute
+	MAX_HOST_BACKOFF = 6 * time.Hour
+)
+
+var link_check_client = &http.Client{Timeout: LINK_CHECK_TIMEOUT}
+
+// Re-fetches links that are due (unchecked first, then every interval:
+// see GetNextLinkCheckDelay) until ctx is done. Requests to the same
+// host are at least host_delay apart.
+func RunLinkChecker(ctx context.Context, interval time.Duration, host_delay time.Duration) {
+	hosts := NewLinkCheckHosts(host_delay)
+	ticker := time.NewTicker(LINK_CHECK_POLL_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		if err := CheckDueLinks(ctx, hosts, interval); err != nil {
+			log.Printf("Could not check links: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Checks one batch of due links, waiting for hosts that were requested
+// too recently. Links whose hosts are backing off are postponed.
+func CheckDueLinks(ctx context.Context, hosts *LinkCheckHosts, interval time.Duration) error {
+	links, err := GetDueLinks(LINK_CHECK_BATCH_SIZE)
+	if err != nil {
+		return err
+	}
+	hosts.Prune(time.Now())
+
+	for len(links) > 0 && ctx.Err() == nil {
+		now := time.Now()
+
+		next := -1
+		var earliest time.Time
+		for i, l := range links {
+			ready_at := hosts.ReadyAt(GetLinkHost(l.URL))
+			if !ready_at.After(now) {
+				next = i
+				break
+			} else if earliest.IsZero() || ready_at.Before(earliest) {
+				earliest = ready_at
+			}
+		}
+
+		if next == -1 {
+			if earliest.Sub(now) > LINK_CHECK_POLL_INTERVAL {
+				for _, l := range links {
+					if err = PostponeLinkCheck(l.ID, hosts.ReadyAt(GetLinkHost(l.URL))); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(earliest.Sub(now)):
+			}
+			continue
+		}
+
+		l := links[next]
+		links = slices.Delete(links, next, next+1)
+		host := GetLinkHost(l.URL)
+
+		check := CheckLink(ctx, l.ID, l.URL)
+		// interrupted: not a verdict on the link either
+		if ctx.Err() != nil {
+			return nil
+		}
+		hosts.Record(host, time.Now(), IsHostThrottling(check))
+
+		// not a verdict on the link
+		if check.StatusCode == http.StatusTooManyRequests {
+			if err = PostponeLinkCheck(l.ID, hosts.ReadyAt(host)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err = SaveLinkCheck(check, interval); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Never checked first, then longest overdue. Only ID and URL are set.
+func GetDueLinks(limit int) ([]model.Link, error) {
+	rows, err := db.Client.Query(
+		`SELECT l.id, l.url
+		FROM Links l
+		LEFT JOIN "Link Health" lh ON l.id = lh.link_id
+		WHERE lh.next_check IS NULL OR lh.next_check <= ?
+		ORDER BY lh.next_check IS NOT NULL, lh.next_check, l.submit_date
+		LIMIT ?;`,
+		time.Now().Format(time.DateTime),
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []model.Link{}
+	for rows.Next() {
+		var l model.Link
+		if err = rows.Scan(&l.ID, &l.URL); err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+
+	return links, rows.Err()
+}
+
+// Follows redirects like GetResolvedURLResponse but doesn't retry with
+// other protocols: the link's URL is what users get. The request is
+// canceled when ctx is done.
+func CheckLink(ctx context.Context, link_id string, link_url string) model.LinkCheck {
+	check := model.LinkCheck{
+		LinkID:  link_id,
+		Checked: time.Now().Format(time.DateTime),
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", link_url, nil)
+	if err != nil {
+		check.Status = model.LINK_HEALTH_UNREACHABLE
+		check.Error = err.Error()
+		return check
+	}
+	req.Header.Set("Accept", "*/*")
+	req.Header.Set("User-Agent", FITM_BOT_USER_AGENT)
+
+	resp, err := link_check_client.Do(req)
+	if err != nil {
+		check.Status = GetLinkCheckErrorStatus(err)
+		check.Error = err.Error()
+		return check
+	}
+	defer resp.Body.Close()
+
+	check.StatusCode = resp.StatusCode
+	check.Status = GetLinkCheckResponseStatus(link_url, resp)
+	if check.Status == model.LINK_HEALTH_REDIRECTED {
+		check.FinalURL = resp.Request.URL.String()
+	}
+
+	return check
+}
+
+func GetLinkCheckErrorStatus(err error) string {
+	var cert_verification_err *tls.CertificateVerificationError
+	var unknown_authority_err x509.UnknownAuthorityError
+	var hostname_err x509.HostnameError
+	var cert_invalid_err x509.CertificateInvalidError
+	var record_header_err tls.RecordHeaderError
+	var net_err net.Error
+
+	switch {
+	case errors.As(err, &cert_verification_err),
+		errors.As(err, &unknown_authority_err),
+		errors.As(err, &hostname_err),
+		errors.As(err, &cert_invalid_err),
+		errors.As(err, &record_header_err),
+		strings.Contains(err.Error(), "tls: "):
+		return model.LINK_HEALTH_TLS_ERROR
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &net_err) && net_err.Timeout():
+		return model.LINK_HEALTH_TIMEOUT
+	default:
+		return model.LINK_HEALTH_UNREACHABLE
+	}
+}
+
+// 401 and 403 count as ok: the page is there, just not for us
+func GetLinkCheckResponseStatus(link_url string, resp *http.Response) string {
+	switch code := resp.StatusCode; {
+	case code == http.StatusNotFound || code == http.StatusGone:
+		return model.LINK_HEALTH_NOT_FOUND
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return model.LINK_HEALTH_OK
+	case code >= 400:
+		return model.LINK_HEALTH_HTTP_ERROR
+	case resp.Request != nil && !IsSameLinkURL(link_url, resp.Request.URL.String()):
+		return model.LINK_HEALTH_REDIRECTED
+	default:
+		return model.LINK_HEALTH_OK
+	}
+}
+
+// Ignoring scheme, "www.", host case and trailing slashes (e.g. an
+// http -> https redirect is not a new URL)
+func IsSameLinkURL(a string, b string) bool {
+	a_url, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	b_url, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+
+	return GetLinkHost(a) == GetLinkHost(b) &&
+		strings.TrimSuffix(a_url.Path, "/") == strings.TrimSuffix(b_url.Path, "/") &&
+		a_url.RawQuery == b_url.RawQuery
+}
+
+// Lowercase, without "www." or port; empty if link_url can't be parsed
+func GetLinkHost(link_url string) string {
+	parsed, err := url.Parse(link_url)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+}
+
+// Rate limited or overloaded
+func IsHostThrottling(check model.LinkCheck) bool {
+	return check.StatusCode == http.StatusTooManyRequests ||
+		check.StatusCode == http.StatusServiceUnavailable
+}
+
+// Alive links are checked every interval. A link's first failure is
+// rechecked within a day in case it was temporary, then the delay
+// doubles each time up to MAX_LINK_CHECK_BACKOFF_INTERVALS intervals.
+func GetNextLinkCheckDelay(interval time.Duration, consecutive_failures int) time.Duration {
+	switch {
+	case consecutive_failures <= 0:
+		return interval
+	case consecutive_failures == 1:
+		return min(interval, 24*time.Hour)
+	}
+
+	delay := interval
+	for i := 2; i < consecutive_failures && delay < MAX_LINK_CHECK_BACKOFF_INTERVALS*interval; i++ {
+		delay *= 2
+	}
+
+	return min(delay, MAX_LINK_CHECK_BACKOFF_INTERVALS*interval)
+}
+
+// Adds check to the link's history (dropping the oldest beyond
+// LINK_CHECKS_HISTORY_LIMIT) and updates its "Link Health"
+func SaveLinkCheck(check model.LinkCheck, interval time.Duration) error {
+	tx, err := db.Client.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(
+		`INSERT INTO "Link Checks" (id, link_id, checked, status, status_code, final_url, error)
+		VALUES(?,?,?,?,NULLIF(?, 0),NULLIF(?, ''),NULLIF(?, ''));`,
+		uuid.New().String(),
+		check.LinkID,
+		check.Checked,
+		check.Status,
+		check.StatusCode,
+		check.FinalURL,
+		check.Error,
+	); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(
+		`DELETE FROM "Link Checks"
+		WHERE link_id = ?
+		AND id NOT IN (
+			SELECT id
+			FROM "Link Checks"
+			WHERE link_id = ?
+			ORDER BY checked DESC, rowid DESC
+			LIMIT ?
+		);`,
+		check.LinkID,
+		check.LinkID,
+		LINK_CHECKS_HISTORY_LIMIT,
+	); err != nil {
+		return err
+	}
+
+	var previous_health string
+	var consecutive_failures int
+	if err = tx.QueryRow(
+		`SELECT health, consecutive_failures FROM "Link Health" WHERE link_id = ?;`,
+		check.LinkID,
+	).Scan(&previous_health, &consecutive_failures); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if slices.Contains(model.LINK_HEALTH_ALIVE, check.Status) {
+		consecutive_failures = 0
+	} else {
+		consecutive_failures++
+	}
+
+	checked, err := time.Parse(time.DateTime, check.Checked)
+	if err != nil {
+		return err
+	}
+	next_check := checked.
+		Add(GetNextLinkCheckDelay(interval, consecutive_failures)).
+		Format(time.DateTime)
+
+	if _, err = tx.Exec(
+		`INSERT INTO "Link Health" (link_id, health, last_checked, next_check, consecutive_failures)
+		VALUES(?,?,?,?,?)
+		ON CONFLICT(link_id) DO UPDATE SET
+			health = excluded.health,
+			last_checked = excluded.last_checked,
+			next_check = excluded.next_check,
+			consecutive_failures = excluded.consecutive_failures;`,
+		check.LinkID,
+		check.Status,
+		check.Checked,
+		next_check,
+		consecutive_failures,
+	); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+
+	// cached links responses show health
+	if check.Status != previous_health {
+		m.InvalidateResponseCache()
+	}
+
+	return nil
+}
+
+// Leaves the link's health as is
+func PostponeLinkCheck(link_id string, until time.Time) error {
+	_, err := db.Client.Exec(
+		`INSERT INTO "Link Health" (link_id, health, next_check)
+		VALUES(?,?,?)
+		ON CONFLICT(link_id) DO UPDATE SET
+			next_check = excluded.next_check;`,
+		link_id,
+		model.LINK_HEALTH_UNCHECKED,
+		until.Format(time.DateTime),
+	)
+	return err
+}
+
+// With the link's check history, newest first
+func GetLinkHealth(link_id string) (*model.LinkHealth, error) {
+	health := &model.LinkHealth{
+		LinkID: link_id,
+		Health: model.LINK_HEALTH_UNCHECKED,
+		Checks: []model.LinkCheck{},
+	}
+
+	var last_checked, next_check sql.NullString
+	if err := db.Client.QueryRow(
+		`SELECT health, last_checked, next_check FROM "Link Health" WHERE link_id = ?;`,
+		link_id,
+	).Scan(&health.Health, &last_checked, &next_check); err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	health.LastChecked = last_checked.String
+	health.NextCheck = next_check.String
+
+	rows, err := db.Client.Query(
+		`SELECT checked, status, COALESCE(status_code, 0), COALESCE(final_url, ''), COALESCE(error, '')
+		FROM "Link Checks"
+		WHERE link_id = ?
+		ORDER BY checked DESC, rowid DESC;`,
+		link_id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		check := model.LinkCheck{LinkID: link_id}
+		if err = rows.Scan(
+			&check.Checked,
+			&check.Status,
+			&check.StatusCode,
+			&check.FinalURL,
+			&check.Error,
+		); err != nil {
+			return nil, err
+		}
+		health.Checks = append(health.Checks, check)
+	}
+
+	return health, rows.Err()
+}
+
+// Per-host politeness for the link checker (which runs on one
+// goroutine: not safe for concurrent use)
+type LinkCheckHosts struct {
+	delay time.Duration
+	// earliest time each host may be requested again
+	ready_at map[string]time.Time
+	// current backoff of throttling hosts
+	backoff map[string]time.Duration
+}
+
+func NewLinkCheckHosts(delay time.Duration) *LinkCheckHosts {
+	return &LinkCheckHosts{
+		delay:    delay,
+		ready_at: map[string]time.Time{},
+		backoff:  map[string]time.Duration{},
+	}
+}
+
+// Zero if the host hasn't been requested recently
+func (h *LinkCheckHosts) ReadyAt(host string) time.Time {
+	return h.ready_at[host]
+}
+
+func (h *LinkCheckHosts) Record(host string, requested time.Time, throttling bool) {
+	if !throttling {
+		delete(h.backoff, host)
+		h.ready_at[host] = requested.Add(h.delay)
+		return
+	}
+
+	backoff := min(max(h.backoff[host]*2, MIN_HOST_BACKOFF), MAX_HOST_BACKOFF)
+	h.backoff[host] = backoff
+	h.ready_at[host] = requested.Add(max(backoff, h.delay))
+}
+
+// Forgets hosts that are ready and not backing off
+func (h *LinkCheckHosts) Prune(now time.Time) {
+	for host, ready_at := range h.ready_at {
+		if _, backing_off := h.backoff[host]; !backing_off && !ready_at.After(now) {
+			delete(h.ready_at, host)
+		}
+	}
+}
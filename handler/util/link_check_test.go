@@ -0,0 +1,301 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julianlk522/fitm/model"
+)
+
+func TestCheckLink(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		if r.UserAgent() != FITM_BOT_USER_AGENT {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	})
+	mux.HandleFunc("/ok/", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/trailing-slash", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ok/", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ok", http.StatusMovedPermanently)
+	})
+	for path, code := range map[string]int{
+		"/gone":       http.StatusGone,
+		"/private":    http.StatusForbidden,
+		"/broken":     http.StatusInternalServerError,
+		"/rate-limit": http.StatusTooManyRequests,
+	} {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(code)
+		})
+	}
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tls_srv := httptest.NewTLSServer(mux)
+	defer tls_srv.Close()
+
+	closed_srv := httptest.NewServer(mux)
+	closed_srv.Close()
+
+	var test_cases = []struct {
+		URL        string
+		Status     string
+		StatusCode int
+		FinalURL   string
+	}{
+		{srv.URL + "/ok", model.LINK_HEALTH_OK, 200, ""},
+		{srv.URL + "/trailing-slash", model.LINK_HEALTH_REDIRECTED, 200, srv.URL + "/ok/"},
+		{srv.URL + "/moved", model.LINK_HEALTH_REDIRECTED, 200, srv.URL + "/ok"},
+		{srv.URL + "/not-there", model.LINK_HEALTH_NOT_FOUND, 404, ""},
+		{srv.URL + "/gone", model.LINK_HEALTH_NOT_FOUND, 410, ""},
+		{srv.URL + "/private", model.LINK_HEALTH_OK, 403, ""},
+		{srv.URL + "/broken", model.LINK_HEALTH_HTTP_ERROR, 500, ""},
+		{srv.URL + "/rate-limit", model.LINK_HEALTH_HTTP_ERROR, 429, ""},
+		// self-signed certificate
+		{tls_srv.URL + "/ok", model.LINK_HEALTH_TLS_ERROR, 0, ""},
+		{closed_srv.URL + "/ok", model.LINK_HEALTH_UNREACHABLE, 0, ""},
+	}
+
+	for _, tc := range test_cases {
+		check := CheckLink(context.Background(), TEST_LINK_ID, tc.URL)
+		if check.Status != tc.Status ||
+			check.StatusCode != tc.StatusCode ||
+			check.FinalURL != tc.FinalURL {
+			t.Fatalf(
+				"%s: got %s (%d, final URL %q, error %q), want %s (%d, final URL %q)",
+				tc.URL,
+				check.Status,
+				check.StatusCode,
+				check.FinalURL,
+				check.Error,
+				tc.Status,
+				tc.StatusCode,
+				tc.FinalURL,
+			)
+		} else if check.StatusCode == 0 && check.Error == "" {
+			t.Fatalf("%s: got no error for failed check", tc.URL)
+		}
+	}
+}
+
+func TestIsSameLinkURL(t *testing.T) {
+	var test_cases = []struct {
+		A    string
+		B    string
+		Same bool
+	}{
+		{"https://go.dev", "https://go.dev/", true},
+		{"http://go.dev/doc", "https://www.go.dev/doc/", true},
+		{"https://Go.dev/doc", "https://go.dev/doc", true},
+		{"https://go.dev/doc", "https://go.dev/blog", false},
+		{"https://go.dev/?q=1", "https://go.dev/?q=2", false},
+		{"https://go.dev", "https://golang.org", false},
+	}
+
+	for _, tc := range test_cases {
+		if same := IsSameLinkURL(tc.A, tc.B); same != tc.Same {
+			t.Fatalf("%s, %s: got %t, want %t", tc.A, tc.B, same, tc.Same)
+		}
+	}
+}
+
+func TestGetNextLinkCheckDelay(t *testing.T) {
+	week := 7 * 24 * time.Hour
+
+	var test_cases = []struct {
+		Interval            time.Duration
+		ConsecutiveFailures int
+		Delay               time.Duration
+	}{
+		{week, 0, week},
+		// recheck soon in case it was temporary
+		{week, 1, 24 * time.Hour},
+		{time.Hour, 1, time.Hour},
+		{week, 2, week},
+		{week, 3, 2 * week},
+		{week, 4, 4 * week},
+		{week, 5, 8 * week},
+		{week, 50, MAX_LINK_CHECK_BACKOFF_INTERVALS * week},
+	}
+
+	for _, tc := range test_cases {
+		if delay := GetNextLinkCheckDelay(tc.Interval, tc.ConsecutiveFailures); delay != tc.Delay {
+			t.Fatalf(
+				"interval %s, %d failures: got %s, want %s",
+				tc.Interval,
+				tc.ConsecutiveFailures,
+				delay,
+				tc.Delay,
+			)
+		}
+	}
+}
+
+func TestLinkCheckHosts(t *testing.T) {
+	delay := 10 * time.Second
+	hosts := NewLinkCheckHosts(delay)
+	now := time.Now()
+
+	if !hosts.ReadyAt("go.dev").IsZero() {
+		t.Fatal("new host not ready")
+	}
+
+	hosts.Record("go.dev", now, false)
+	if ready_at := hosts.ReadyAt("go.dev"); !ready_at.Equal(now.Add(delay)) {
+		t.Fatalf("got ready at %s, want %s", ready_at, now.Add(delay))
+	}
+
+	// backoff doubles while the host keeps throttling
+	hosts.Record("go.dev", now, true)
+	if ready_at := hosts.ReadyAt("go.dev"); !ready_at.Equal(now.Add(MIN_HOST_BACKOFF)) {
+		t.Fatalf("got ready at %s, want %s", ready_at, now.Add(MIN_HOST_BACKOFF))
+	}
+	hosts.Record("go.dev", now, true)
+	if ready_at := hosts.ReadyAt("go.dev"); !ready_at.Equal(now.Add(2 * MIN_HOST_BACKOFF)) {
+		t.Fatalf("got ready at %s, want %s", ready_at, now.Add(2*MIN_HOST_BACKOFF))
+	}
+	for range 20 {
+		hosts.Record("go.dev", now, true)
+	}
+	if ready_at := hosts.ReadyAt("go.dev"); !ready_at.Equal(now.Add(MAX_HOST_BACKOFF)) {
+		t.Fatalf("got ready at %s, want %s", ready_at, now.Add(MAX_HOST_BACKOFF))
+	}
+
+	// still backing off: kept
+	hosts.Prune(now.Add(MAX_HOST_BACKOFF))
+	if hosts.ReadyAt("go.dev").IsZero() {
+		t.Fatal("throttling host pruned")
+	}
+
+	hosts.Record("go.dev", now, false)
+	if ready_at := hosts.ReadyAt("go.dev"); !ready_at.Equal(now.Add(delay)) {
+		t.Fatalf("got ready at %s after good response, want %s", ready_at, now.Add(delay))
+	}
+	hosts.Prune(now.Add(delay))
+	if !hosts.ReadyAt("go.dev").IsZero() {
+		t.Fatal("ready host not pruned")
+	}
+}
+
+func TestSaveLinkCheck(t *testing.T) {
+	t.Cleanup(func() {
+		TestClient.Exec(`DELETE FROM "Link Checks" WHERE link_id = ?;`, TEST_LINK_ID)
+		TestClient.Exec(`DELETE FROM "Link Health" WHERE link_id = ?;`, TEST_LINK_ID)
+	})
+	interval := 7 * 24 * time.Hour
+
+	if health, err := GetLinkHealth(TEST_LINK_ID); err != nil {
+		t.Fatal(err)
+	} else if health.Health != model.LINK_HEALTH_UNCHECKED || len(health.Checks) != 0 {
+		t.Fatalf("got %+v, want unchecked", health)
+	}
+
+	due, err := GetDueLinks(1)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(due) != 1 {
+		t.Fatalf("got %d due links, want 1", len(due))
+	}
+
+	checked := time.Now().Add(-time.Hour).Truncate(time.Second)
+	var test_checks = []struct {
+		Status              string
+		ConsecutiveFailures int
+	}{
+		{model.LINK_HEALTH_OK, 0},
+		{model.LINK_HEALTH_NOT_FOUND, 1},
+		{model.LINK_HEALTH_TIMEOUT, 2},
+		{model.LINK_HEALTH_REDIRECTED, 0},
+	}
+
+	for i, tc := range test_checks {
+		checked = checked.Add(time.Second)
+		if err := SaveLinkCheck(model.LinkCheck{
+			LinkID:  TEST_LINK_ID,
+			Checked: checked.Format(time.DateTime),
+			Status:  tc.Status,
+		}, interval); err != nil {
+			t.Fatal(err)
+		}
+
+		health, err := GetLinkHealth(TEST_LINK_ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want_next_check := checked.
+			Add(GetNextLinkCheckDelay(interval, tc.ConsecutiveFailures)).
+			Format(time.DateTime)
+		if health.Health != tc.Status ||
+			health.LastChecked != checked.Format(time.DateTime) ||
+			health.NextCheck != want_next_check {
+			t.Fatalf("check %d: got %+v, want %s next checked at %s", i, health, tc.Status, want_next_check)
+		} else if len(health.Checks) != i+1 || health.Checks[0].Status != tc.Status {
+			t.Fatalf("check %d: got history %+v, want newest %s first", i, health.Checks, tc.Status)
+		}
+	}
+
+	// not due until next check
+	due, err = GetDueLinks(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range due {
+		if l.ID == TEST_LINK_ID {
+			t.Fatal("checked link still due")
+		}
+	}
+
+	// history is capped
+	for range LINK_CHECKS_HISTORY_LIMIT {
+		checked = checked.Add(time.Second)
+		if err := SaveLinkCheck(model.LinkCheck{
+			LinkID:  TEST_LINK_ID,
+			Checked: checked.Format(time.DateTime),
+			Status:  model.LINK_HEALTH_OK,
+		}, interval); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if health, err := GetLinkHealth(TEST_LINK_ID); err != nil {
+		t.Fatal(err)
+	} else if len(health.Checks) != LINK_CHECKS_HISTORY_LIMIT {
+		t.Fatalf("got %d checks, want %d", len(health.Checks), LINK_CHECKS_HISTORY_LIMIT)
+	}
+
+	// postponed: health unchanged
+	until := time.Now().Add(time.Hour)
+	if err := PostponeLinkCheck(TEST_LINK_ID, until); err != nil {
+		t.Fatal(err)
+	}
+	if health, err := GetLinkHealth(TEST_LINK_ID); err != nil {
+		t.Fatal(err)
+	} else if health.Health != model.LINK_HEALTH_OK || health.NextCheck != until.Format(time.DateTime) {
+		t.Fatalf("got %+v, want ok next checked at %s", health, until.Format(time.DateTime))
+	}
+}
+
+func TestCheckLinkCanceled(t *testing.T) {
+	hang := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hang
+	}))
+	defer srv.Close()
+	defer close(hang)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	check := CheckLink(ctx, TEST_LINK_ID, srv.URL)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("check took %s after ctx was done", elapsed)
+	} else if check.StatusCode != 0 || check.Error == "" {
+		t.Fatalf("got %+v, want failed check", check)
+	}
+}
@@ -0,0 +1,242 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/julianlk522/fitm/config"
+	"github.com/julianlk522/fitm/db"
+	e "github.com/julianlk522/fitm/error"
+	m "github.com/julianlk522/fitm/middleware"
+	"github.com/julianlk522/fitm/model"
+	"github.com/julianlk522/fitm/query"
+
+	mutil "github.com/julianlk522/fitm/model/util"
+)
+
+// Global cats depend on julianday('now') (see query.TAG_VOTES) so stored
+// values go stale unless recomputed; at most one recompute runs at a time
+var recompute = struct {
+	sync.Mutex
+	status model.RecomputeStatus
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}{}
+
+func GetRecomputeStatus() model.RecomputeStatus {
+	recompute.Lock()
+	defer recompute.Unlock()
+
+	return recompute.status
+}
+
+// Runs in the background until done or StopRecompute is called
+func StartRecompute() error {
+	recompute.Lock()
+	defer recompute.Unlock()
+
+	if recompute.status.Running {
+		return e.ErrRecomputeRunning
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	recompute.cancel = cancel
+	recompute.status = model.RecomputeStatus{
+		Running:   true,
+		StartedAt: mutil.NEW_LONG_TIMESTAMP(),
+	}
+
+	recompute.wg.Add(1)
+	go func() {
+		defer recompute.wg.Done()
+		defer cancel()
+
+		if err := RecomputeAllLinks(ctx, config.Current.RecomputeBatchSize); err != nil {
+			log.Printf("Recompute stopped: %s", err)
+		}
+	}()
+
+	return nil
+}
+
+// Cancels any running recompute after its current batch and waits
+func StopRecompute() {
+	recompute.Lock()
+	if recompute.cancel != nil {
+		recompute.cancel()
+	}
+	recompute.Unlock()
+
+	recompute.wg.Wait()
+}
+
+// Starts a recompute every interval until ctx is done
+func RunRecomputeScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := StartRecompute(); err != nil {
+				log.Printf("Scheduled recompute skipped: %s", err)
+			}
+		}
+	}
+}
+
+// Failed batches are rolled back and skipped; only ctx ending stops
+// the run early
+func RecomputeAllLinks(ctx context.Context, batch_size int) error {
+	link_ids, err := GetAllLinkIDs()
+	if err != nil {
+		SetRecomputeFinished(err)
+		return err
+	}
+
+	UpdateRecomputeStatus(func(s *model.RecomputeStatus) {
+		s.LinksTotal = len(link_ids)
+	})
+	log.Printf("Recompute started: %d links", len(link_ids))
+
+	for start := 0; start < len(link_ids); start += batch_size {
+		if err = ctx.Err(); err != nil {
+			SetRecomputeFinished(err)
+			return err
+		}
+
+		end := min(start+batch_size, len(link_ids))
+		cats_changed, summaries_changed, err := RecomputeLinks(link_ids[start:end])
+
+		status := UpdateRecomputeStatus(func(s *model.RecomputeStatus) {
+			s.LinksDone = end
+			s.GlobalCatsChanged += cats_changed
+			s.GlobalSummariesChanged += summaries_changed
+			if err != nil {
+				s.FailedBatches++
+				s.LastError = err.Error()
+			}
+		})
+		if err != nil {
+			log.Printf("Recompute batch %d-%d failed: %s", start, end, err)
+		} else if cats_changed > 0 || summaries_changed > 0 {
+			m.InvalidateResponseCache()
+		}
+		log.Printf(
+			"Recompute: %d/%d links (%d global cats, %d global summaries changed)",
+			status.LinksDone,
+			status.LinksTotal,
+			status.GlobalCatsChanged,
+			status.GlobalSummariesChanged,
+		)
+	}
+
+	// catch drift left by batches that failed or by missed increments
+	reconciliation, err := ReconcileSpellfix(false)
+	if err != nil {
+		SetRecomputeFinished(err)
+		return err
+	}
+	LogSpellfixReconciliation(reconciliation)
+
+	SetRecomputeFinished(nil)
+	log.Print("Recompute finished")
+
+	return nil
+}
+
+// Tag votes and summaries are read through the write transaction so
+// edits made meanwhile can't be overwritten with stale global cats or
+// summaries
+func RecomputeLinks(link_ids []string) (cats_changed int, summaries_changed int, err error) {
+	strategy := GetActiveGlobalCatsStrategy()
+
+	tx, err := db.Client.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	for _, link_id := range link_ids {
+		votes, err := ScanTagVotes(tx, query.NewTagVotes(link_id))
+		if err != nil {
+			return 0, 0, err
+		}
+		_, new_global_cats := CalculateGlobalCats(strategy, *votes)
+
+		changed, err := SetGlobalCatsInTx(tx, link_id, new_global_cats)
+		if err != nil {
+			return 0, 0, err
+		} else if changed {
+			cats_changed++
+		}
+
+		new_global_summary, err := GetTopSummaryText(tx, link_id)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		res, err := tx.Exec(
+			`UPDATE Links 
+			SET global_summary = ? 
+			WHERE id = ? 
+			AND COALESCE(global_summary, '') != ?;`,
+			new_global_summary,
+			link_id,
+			new_global_summary,
+		)
+		if err != nil {
+			return 0, 0, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			summaries_changed++
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+
+	return cats_changed, summaries_changed, nil
+}
+
+func GetAllLinkIDs() ([]string, error) {
+	rows, err := db.Client.Query("SELECT id FROM Links ORDER BY id;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var link_ids []string
+	for rows.Next() {
+		var link_id string
+		if err = rows.Scan(&link_id); err != nil {
+			return nil, err
+		}
+		link_ids = append(link_ids, link_id)
+	}
+
+	return link_ids, rows.Err()
+}
+
+func UpdateRecomputeStatus(update func(s *model.RecomputeStatus)) model.RecomputeStatus {
+	recompute.Lock()
+	defer recompute.Unlock()
+
+	update(&recompute.status)
+	return recompute.status
+}
+
+func SetRecomputeFinished(err error) {
+	UpdateRecomputeStatus(func(s *model.RecomputeStatus) {
+		s.Running = false
+		s.FinishedAt = mutil.NEW_LONG_TIMESTAMP()
+		if err != nil {
+			s.LastError = err.Error()
+		}
+	})
+}
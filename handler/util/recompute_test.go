@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/julianlk522/fitm/query"
+)
+
+func TestRecomputeAllLinks(t *testing.T) {
+	_, err := TestClient.Exec(
+		"UPDATE Links SET global_summary = 'stale' WHERE id = ?;",
+		TEST_LINK_ID,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// canceled before the first batch
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = RecomputeAllLinks(ctx, 2); err == nil {
+		t.Fatal("expected error for canceled context")
+	} else if status := GetRecomputeStatus(); status.LinksDone != 0 || status.Running {
+		t.Fatalf("got status %+v, want no links done and not running", status)
+	}
+
+	if err = RecomputeAllLinks(context.Background(), 2); err != nil {
+		t.Fatalf("failed with error: %s", err)
+	}
+
+	status := GetRecomputeStatus()
+	if status.LinksDone != status.LinksTotal || status.LinksTotal == 0 {
+		t.Fatalf("got %d/%d links done", status.LinksDone, status.LinksTotal)
+	} else if status.FailedBatches > 0 {
+		t.Fatalf("got %d failed batches: %s", status.FailedBatches, status.LastError)
+	} else if status.GlobalSummariesChanged == 0 {
+		t.Fatal("expected stale global summary to change")
+	}
+
+	var global_summary, global_cats string
+	err = TestClient.QueryRow(
+		"SELECT global_summary, global_cats FROM Links WHERE id = ?;",
+		TEST_LINK_ID,
+	).Scan(&global_summary, &global_cats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	top_summary_text, err := GetTopSummaryText(TestClient, TEST_LINK_ID)
+	if err != nil {
+		t.Fatal(err)
+	} else if global_summary != top_summary_text {
+		t.Fatalf("got global summary %q, want %q", global_summary, top_summary_text)
+	}
+
+	votes, err := ScanTagVotes(TestClient, query.NewTagVotes(TEST_LINK_ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, want := CalculateGlobalCats(GetActiveGlobalCatsStrategy(), *votes); global_cats != want {
+		t.Fatalf("got global cats %s, want %s", global_cats, want)
+	}
+
+	// nothing left to change
+	cats_changed, summaries_changed, err := RecomputeLinks([]string{TEST_LINK_ID})
+	if err != nil {
+		t.Fatal(err)
+	} else if cats_changed != 0 || summaries_changed != 0 {
+		t.Fatalf("got %d global cats, %d global summaries changed on second run", cats_changed, summaries_changed)
+	}
+}
+
+func TestStartRecompute(t *testing.T) {
+	if err := StartRecompute(); err != nil {
+		t.Fatalf("failed with error: %s", err)
+	}
+	StopRecompute()
+
+	if GetRecomputeStatus().Running {
+		t.Fatal("expected recompute stopped")
+	}
+}
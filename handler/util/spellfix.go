@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/julianlk522/fitm/db"
+	"github.com/julianlk522/fitm/model"
+)
+
+// global_cats_spellfix is kept in sync incrementally (see
+// IncrementSpellfixRanksForCats) so any missed update leaves it wrong
+// for good. This compares it against Links.global_cats and, unless
+// dry_run, rewrites every drifted word.
+func ReconcileSpellfix(dry_run bool) (*model.SpellfixReconciliation, error) {
+	if dry_run {
+		return GetSpellfixDrift(db.Client)
+	}
+
+	// drift is computed in the same tx so links changed meanwhile can't
+	// be rewritten with stale ranks
+	tx, err := db.Client.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	reconciliation, err := GetSpellfixDrift(tx)
+	if err != nil {
+		return nil, err
+	} else if len(reconciliation.Drift) == 0 {
+		return reconciliation, nil
+	}
+
+	for _, drift := range reconciliation.Drift {
+		_, err = tx.Exec("DELETE FROM global_cats_spellfix WHERE word = ?;", drift.Word)
+		if err != nil {
+			return nil, err
+		}
+
+		if drift.ExpectedRank > 0 {
+			_, err = tx.Exec(
+				"INSERT INTO global_cats_spellfix (word, rank) VALUES (?, ?);",
+				drift.Word,
+				drift.ExpectedRank,
+			)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	reconciliation.Fixed = true
+
+	return reconciliation, nil
+}
+
+func GetSpellfixDrift(q db.Querier) (*model.SpellfixReconciliation, error) {
+	expected_ranks, err := GetExpectedSpellfixRanks(q)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.Query("SELECT word, rank FROM global_cats_spellfix;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actual := map[string]*model.SpellfixDrift{}
+	for rows.Next() {
+		var word string
+		var rank int
+		if err = rows.Scan(&word, &rank); err != nil {
+			return nil, err
+		}
+
+		if _, ok := actual[word]; !ok {
+			actual[word] = &model.SpellfixDrift{Word: word}
+		}
+		actual[word].Rows++
+		actual[word].Rank += rank
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	words := make([]string, 0, len(expected_ranks)+len(actual))
+	for word := range expected_ranks {
+		words = append(words, word)
+	}
+	for word := range actual {
+		if _, ok := expected_ranks[word]; !ok {
+			words = append(words, word)
+		}
+	}
+	slices.Sort(words)
+
+	reconciliation := &model.SpellfixReconciliation{
+		WordsChecked: len(words),
+		Drift:        []model.SpellfixDrift{},
+	}
+	for _, word := range words {
+		drift := model.SpellfixDrift{Word: word, ExpectedRank: expected_ranks[word]}
+		if a, ok := actual[word]; ok {
+			drift.Rows = a.Rows
+			drift.Rank = a.Rank
+		}
+
+		is_ok := drift.Rows == 1 && drift.Rank == drift.ExpectedRank
+		if drift.ExpectedRank == 0 {
+			is_ok = drift.Rows == 0
+		}
+		if !is_ok {
+			reconciliation.Drift = append(reconciliation.Drift, drift)
+		}
+	}
+
+	return reconciliation, nil
+}
+
+// Word -> number of links with it in their global cats
+func GetExpectedSpellfixRanks(q db.Querier) (map[string]int, error) {
+	rows, err := q.Query("SELECT global_cats FROM Links;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ranks := map[string]int{}
+	for rows.Next() {
+		var global_cats string
+		if err = rows.Scan(&global_cats); err != nil {
+			return nil, err
+		}
+
+		for _, cat := range strings.Split(global_cats, ",") {
+			if !IsEmptyCat(cat) {
+				ranks[cat]++
+			}
+		}
+	}
+
+	return ranks, rows.Err()
+}
+
+func LogSpellfixReconciliation(reconciliation *model.SpellfixReconciliation) {
+	if len(reconciliation.Drift) == 0 {
+		log.Printf("Spellfix: %d words checked, no drift", reconciliation.WordsChecked)
+		return
+	}
+
+	action := "found"
+	if reconciliation.Fixed {
+		action = "fixed"
+	}
+	log.Printf(
+		"Spellfix: %d words checked, drift %s for %d",
+		reconciliation.WordsChecked,
+		action,
+		len(reconciliation.Drift),
+	)
+	for _, drift := range reconciliation.Drift {
+		log.Printf(
+			"Spellfix: %q has %d row(s) with rank %d, expected rank %d",
+			drift.Word,
+			drift.Rows,
+			drift.Rank,
+			drift.ExpectedRank,
+		)
+	}
+}
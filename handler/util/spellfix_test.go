@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"testing"
+)
+
+func TestReconcileSpellfix(t *testing.T) {
+	// fix any drift in test data first
+	if _, err := ReconcileSpellfix(false); err != nil {
+		t.Fatalf("failed with error: %s", err)
+	}
+
+	// missed increment, duplicate row, and a word on no links
+	_, err := TestClient.Exec(`UPDATE global_cats_spellfix SET rank = rank + 1 WHERE word = 'go';`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, word := range []string{"test_dupe", "test_dupe", "test_orphan"} {
+		_, err = TestClient.Exec(
+			`INSERT INTO global_cats_spellfix (word, rank) VALUES (?, 1);`,
+			word,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = TestClient.Exec(`UPDATE Links SET global_cats = global_cats || ',test_dupe' WHERE id = ?;`, TEST_LINK_ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer TestClient.Exec(`UPDATE Links SET global_cats = REPLACE(global_cats, ',test_dupe', '') WHERE id = ?;`, TEST_LINK_ID)
+
+	reconciliation, err := ReconcileSpellfix(true)
+	if err != nil {
+		t.Fatalf("failed with error: %s", err)
+	} else if reconciliation.Fixed {
+		t.Fatal("dry run should not fix drift")
+	}
+
+	var drifted_words []string
+	for _, drift := range reconciliation.Drift {
+		drifted_words = append(drifted_words, drift.Word)
+	}
+	if len(drifted_words) != 3 ||
+		drifted_words[0] != "go" ||
+		drifted_words[1] != "test_dupe" ||
+		drifted_words[2] != "test_orphan" {
+		t.Fatalf("got drift for %v, want [go test_dupe test_orphan]", drifted_words)
+	}
+
+	if reconciliation, err = ReconcileSpellfix(false); err != nil {
+		t.Fatalf("failed with error: %s", err)
+	} else if !reconciliation.Fixed {
+		t.Fatal("expected drift fixed")
+	}
+
+	if reconciliation, err = ReconcileSpellfix(true); err != nil {
+		t.Fatalf("failed with error: %s", err)
+	} else if len(reconciliation.Drift) > 0 {
+		t.Fatalf("got drift %+v after fix", reconciliation.Drift)
+	}
+
+	var rank int
+	if err = TestClient.QueryRow(
+		`SELECT rank FROM global_cats_spellfix WHERE word = 'test_dupe';`,
+	).Scan(&rank); err != nil {
+		t.Fatal(err)
+	} else if rank != 1 {
+		t.Fatalf("got rank %d for test_dupe, want 1", rank)
+	}
+
+	// put back for later tests
+	_, err = TestClient.Exec(`DELETE FROM global_cats_spellfix WHERE word = 'test_dupe';`)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -2,17 +2,22 @@ package handler
 
 import (
 	"database/sql"
-	"log"
+	"strings"
 
 	"net/http"
 
+	"github.com/google/uuid"
+
 	"github.com/julianlk522/fitm/db"
 	e "github.com/julianlk522/fitm/error"
 	m "github.com/julianlk522/fitm/middleware"
 	"github.com/julianlk522/fitm/model"
+	mutil "github.com/julianlk522/fitm/model/util"
 	"github.com/julianlk522/fitm/query"
 )
 
+const MAX_PERCENT_SUMMARY_CHANGED_TO_KEEP_LIKES float32 = 30
+
 func BuildSummaryPageForLink(link_id string, r *http.Request) (any, error) {
 	get_link_sql := query.NewSingleLink(link_id)
 	get_summaries_sql := query.NewSummariesForLink(link_id)
@@ -134,6 +139,235 @@ func GetIDOfUserSummaryForLink(user_id string, link_id string) (string, error) {
 	return summary_id.String, nil
 }
 
+// Edit summary
+// Likes are kept for minor edits (typos, punctuation, etc.) but reset
+// if the summary has been rewritten enough that it may no longer be
+// what the likers agreed with
+func SummaryChangedEnoughToResetLikes(old_text string, new_text string) bool {
+	old_runes, new_runes := []rune(old_text), []rune(new_text)
+
+	longest := max(len(old_runes), len(new_runes))
+	if longest == 0 {
+		return false
+	}
+
+	percent_changed := float32(GetEditDistance(old_runes, new_runes)) / float32(longest) * 100
+
+	return percent_changed > MAX_PERCENT_SUMMARY_CHANGED_TO_KEEP_LIKES
+}
+
+// Levenshtein distance
+func GetEditDistance(a []rune, b []rune) int {
+	prev_row := make([]int, len(b)+1)
+	for j := range prev_row {
+		prev_row[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		row := make([]int, len(b)+1)
+		row[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			substitution_cost := 1
+			if a[i-1] == b[j-1] {
+				substitution_cost = 0
+			}
+
+			row[j] = min(
+				prev_row[j]+1,
+				row[j-1]+1,
+				prev_row[j-1]+substitution_cost,
+			)
+		}
+		prev_row = row
+	}
+
+	return prev_row[len(b)]
+}
+
+func ResetSummaryLikes(tx *sql.Tx, summary_id string) error {
+	_, err := tx.Exec(
+		`DELETE FROM "Summary Likes" WHERE summary_id = ?`,
+		summary_id,
+	)
+
+	return err
+}
+
+// Archives the current text as a revision before replacing it.
+// Returns whether the summary's likes were reset
+func ReplaceSummaryText(tx *sql.Tx, summary_id string, new_text string, last_updated string) (bool, error) {
+	var old_text string
+	err := tx.QueryRow("SELECT text FROM Summaries WHERE id = ?", summary_id).Scan(&old_text)
+	if err != nil {
+		return false, err
+	}
+
+	if err = ArchiveSummaryRevision(tx, summary_id); err != nil {
+		return false, err
+	}
+
+	_, err = tx.Exec(
+		`UPDATE Summaries SET text = ?, last_updated = ? WHERE id = ?`,
+		new_text,
+		last_updated,
+		summary_id,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	if SummaryChangedEnoughToResetLikes(old_text, new_text) {
+		if err = ResetSummaryLikes(tx, summary_id); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// Summary history
+func ArchiveSummaryRevision(tx *sql.Tx, summary_id string) error {
+	_, err := tx.Exec(
+		`INSERT INTO "Summary Revisions" (id, summary_id, text, submitted_by, last_updated, replaced)
+		SELECT ?, id, text, submitted_by, last_updated, ?
+		FROM Summaries
+		WHERE id = ?`,
+		uuid.New().String(),
+		mutil.NEW_LONG_TIMESTAMP(),
+		summary_id,
+	)
+
+	return err
+}
+
+func GetSummaryRevisionText(summary_id string, rev_id string) (string, error) {
+	var text sql.NullString
+	err := db.Client.QueryRow(
+		`SELECT text FROM "Summary Revisions" WHERE id = ? AND summary_id = ?`,
+		rev_id,
+		summary_id,
+	).Scan(&text)
+	if err != nil {
+		return "", err
+	}
+
+	return text.String, nil
+}
+
+func BuildSummaryHistory(summary_id string) (*model.SummaryHistory, error) {
+	history := &model.SummaryHistory{}
+	err := db.Client.QueryRow(`SELECT 
+			s.id, 
+			s.link_id, 
+			s.text, 
+			COALESCE(u.login_name, ''), 
+			s.last_updated
+		FROM Summaries s
+		LEFT JOIN Users u ON u.id = s.submitted_by
+		WHERE s.id = ?`,
+		summary_id,
+	).Scan(
+		&history.SummaryID,
+		&history.LinkID,
+		&history.Text,
+		&history.SubmittedBy,
+		&history.LastUpdated,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, e.ErrNoSummaryWithID
+		}
+		return nil, err
+	}
+
+	revisions_sql := query.NewSummaryRevisions(summary_id)
+	rows, err := db.Client.Query(revisions_sql.Text, revisions_sql.Args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history.Revisions = []model.SummaryRevision{}
+
+	// revisions are newest first, so each one is diffed against
+	// the one before it (or the current text for the newest)
+	replaced_by := history.Text
+	for rows.Next() {
+		rev := model.SummaryRevision{}
+		if err := rows.Scan(
+			&rev.ID,
+			&rev.Text,
+			&rev.SubmittedBy,
+			&rev.LastUpdated,
+			&rev.Replaced,
+		); err != nil {
+			return nil, err
+		}
+
+		rev.Diff = GetWordDiff(rev.Text, replaced_by)
+		replaced_by = rev.Text
+
+		history.Revisions = append(history.Revisions, rev)
+	}
+
+	return history, nil
+}
+
+// Word-level diff using longest common subsequence
+func GetWordDiff(old_text string, new_text string) []model.DiffChunk {
+	old_words, new_words := strings.Fields(old_text), strings.Fields(new_text)
+
+	// lcs[i][j] = length of LCS of old_words[i:] and new_words[j:]
+	lcs := make([][]int, len(old_words)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(new_words)+1)
+	}
+	for i := len(old_words) - 1; i >= 0; i-- {
+		for j := len(new_words) - 1; j >= 0; j-- {
+			if old_words[i] == new_words[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	diff := []model.DiffChunk{}
+	add_word := func(op string, word string) {
+		if len(diff) > 0 && diff[len(diff)-1].Op == op {
+			diff[len(diff)-1].Text += " " + word
+		} else {
+			diff = append(diff, model.DiffChunk{Op: op, Text: word})
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(old_words) && j < len(new_words) {
+		switch {
+		case old_words[i] == new_words[j]:
+			add_word("=", old_words[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			add_word("-", old_words[i])
+			i++
+		default:
+			add_word("+", new_words[j])
+			j++
+		}
+	}
+	for ; i < len(old_words); i++ {
+		add_word("-", old_words[i])
+	}
+	for ; j < len(new_words); j++ {
+		add_word("+", new_words[j])
+	}
+
+	return diff
+}
+
 // Delete summary
 func GetLinkIDFromSummaryID(summary_id string) (string, error) {
 	var lid sql.NullString
@@ -187,24 +421,46 @@ func UserHasLikedSummary(user_id string, summary_id string) (bool, error) {
 	return summary_like_id.Valid, nil
 }
 
-func CalculateAndSetGlobalSummary(link_id string) error {
+// Within tx, so the summary change that calls for it and the new global
+// summary are saved together
+func CalculateAndSetGlobalSummary(tx *sql.Tx, link_id string) error {
 	// If there are no summaries then should just be empty string
 	var summaries_count_for_link sql.NullInt32
-	err := db.Client.QueryRow("SELECT COUNT(id) FROM Summaries WHERE link_id = ?", link_id).Scan(&summaries_count_for_link)
+	err := tx.QueryRow("SELECT COUNT(id) FROM Summaries WHERE link_id = ?", link_id).Scan(&summaries_count_for_link)
 	if err != nil {
 		return err
 	}
 
 	if summaries_count_for_link.Int32 == 0 {
-		_, err = db.Client.Exec(`UPDATE Links SET global_summary = '' WHERE id = ?`, link_id)
+		_, err = tx.Exec(`UPDATE Links SET global_summary = '' WHERE id = ?`, link_id)
 		return err
 	}
 
-	// Otherwise global summary is set to summary with most upvotes
-	// UNLESS 1st is auto summary and is tied with 2nd place
-	// in that case use 2nd place summary
+	top_summary_text, err := GetTopSummaryText(tx, link_id)
+	if err != nil {
+		return err
+	}
+
+	// Set global summary if not already set to top result
+	var gs string
+	err = tx.QueryRow(`SELECT global_summary 
+		FROM Links 
+		WHERE id = ?`,
+		link_id).Scan(&gs)
+	if err != nil {
+		return err
+	} else if gs == "" || gs != top_summary_text {
+		return SetLinkGlobalSummary(tx, link_id, top_summary_text)
+	}
+
+	return nil
+}
+
+// Summary with most upvotes UNLESS 1st is auto summary and is tied with
+// 2nd place, in which case 2nd place. Empty if the link has no summaries.
+func GetTopSummaryText(rq db.RowQuerier, link_id string) (string, error) {
 	var top_summary_text string
-	err = db.Client.QueryRow(`WITH RankedSummaries AS (
+	err := rq.QueryRow(`WITH RankedSummaries AS (
 		SELECT 
 			s.text,
 			s.submitted_by,
@@ -229,29 +485,14 @@ func CalculateAndSetGlobalSummary(link_id string) error {
 		db.AUTO_SUMMARY_USER_ID,
 		link_id,
 	).Scan(&top_summary_text)
-
-	if err != nil {
-		return err
+	if err == sql.ErrNoRows {
+		return "", nil
 	}
 
-	// Set global summary if not already set to top result
-	var gs string
-	err = db.Client.QueryRow(`SELECT global_summary 
-		FROM Links 
-		WHERE id = ?`,
-		link_id).Scan(&gs)
-	if err != nil {
-		return err
-	} else if gs == "" || gs != top_summary_text {
-		SetLinkGlobalSummary(link_id, top_summary_text)
-	}
-
-	return nil
+	return top_summary_text, err
 }
 
-func SetLinkGlobalSummary(link_id string, text string) {
-	_, err := db.Client.Exec(`UPDATE Links SET global_summary = ? WHERE id = ?`, text, link_id)
-	if err != nil {
-		log.Fatal(err)
-	}
+func SetLinkGlobalSummary(tx *sql.Tx, link_id string, text string) error {
+	_, err := tx.Exec(`UPDATE Links SET global_summary = ? WHERE id = ?`, text, link_id)
+	return err
 }
@@ -3,6 +3,7 @@ package handler
 import (
 	"context"
 	"net/http"
+	"slices"
 	"testing"
 
 	m "github.com/julianlk522/fitm/middleware"
@@ -129,6 +130,158 @@ func TestGetSummaryIDForLink(t *testing.T) {
 	}
 }
 
+// Edit summary
+func TestSummaryChangedEnoughToResetLikes(t *testing.T) {
+	var test_edits = []struct {
+		OldText    string
+		NewText    string
+		ResetLikes bool
+	}{
+		{"The very first website!", "The very first website!", false},
+		{"The very first website!", "The very first website.", false},
+		{"The very frist website!", "The very first website!", false},
+		{"The very first website!", "A page about the history of the web", true},
+		{"short", "completely different", true},
+		{"", "", false},
+	}
+
+	for _, te := range test_edits {
+		got := SummaryChangedEnoughToResetLikes(te.OldText, te.NewText)
+		if got != te.ResetLikes {
+			t.Fatalf(
+				"expected %t, got %t for edit %q -> %q",
+				te.ResetLikes,
+				got,
+				te.OldText,
+				te.NewText,
+			)
+		}
+	}
+}
+
+func TestGetEditDistance(t *testing.T) {
+	var test_strings = []struct {
+		A        string
+		B        string
+		Distance int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"kitten", "sitting", 3},
+		{"flaw", "lawn", 2},
+		{"café", "cafe", 1},
+	}
+
+	for _, ts := range test_strings {
+		got := GetEditDistance([]rune(ts.A), []rune(ts.B))
+		if got != ts.Distance {
+			t.Fatalf("got %d, want %d for %q -> %q", got, ts.Distance, ts.A, ts.B)
+		}
+	}
+}
+
+// Summary history
+func TestBuildSummaryHistory(t *testing.T) {
+	const test_summary_id = "65"
+
+	var old_text string
+	err := TestClient.QueryRow(
+		"SELECT text FROM Summaries WHERE id = ?",
+		test_summary_id,
+	).Scan(&old_text)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := TestClient.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	new_text := old_text + " (revised)"
+	if _, err = ReplaceSummaryText(tx, test_summary_id, new_text, "2024-10-01 00:00:00"); err != nil {
+		t.Fatal(err)
+	} else if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := BuildSummaryHistory(test_summary_id)
+	if err != nil {
+		t.Fatal(err)
+	} else if history.Text != new_text {
+		t.Fatalf("got current text %s, want %s", history.Text, new_text)
+	} else if len(history.Revisions) == 0 {
+		t.Fatal("expected at least 1 revision")
+	}
+
+	// newest revision should be the replaced text, with the
+	// addition as its only change
+	newest := history.Revisions[0]
+	if newest.Text != old_text {
+		t.Fatalf("got newest revision text %s, want %s", newest.Text, old_text)
+	}
+
+	var added []string
+	for _, chunk := range newest.Diff {
+		if chunk.Op == "-" {
+			t.Fatalf("unexpected removal in diff: %+v", newest.Diff)
+		} else if chunk.Op == "+" {
+			added = append(added, chunk.Text)
+		}
+	}
+	if len(added) != 1 || added[0] != "(revised)" {
+		t.Fatalf("got added chunks %v, want [(revised)]", added)
+	}
+
+	if _, err = BuildSummaryHistory("-1"); err == nil {
+		t.Fatal("expected error for nonexistent summary")
+	}
+}
+
+func TestGetWordDiff(t *testing.T) {
+	var test_diffs = []struct {
+		OldText string
+		NewText string
+		Want    []model.DiffChunk
+	}{
+		{
+			"the very first website",
+			"the very first website",
+			[]model.DiffChunk{{Op: "=", Text: "the very first website"}},
+		},
+		{
+			"the very first website",
+			"the first ever website",
+			[]model.DiffChunk{
+				{Op: "=", Text: "the"},
+				{Op: "-", Text: "very"},
+				{Op: "=", Text: "first"},
+				{Op: "+", Text: "ever"},
+				{Op: "=", Text: "website"},
+			},
+		},
+		{
+			"",
+			"brand new",
+			[]model.DiffChunk{{Op: "+", Text: "brand new"}},
+		},
+		{
+			"all gone",
+			"",
+			[]model.DiffChunk{{Op: "-", Text: "all gone"}},
+		},
+	}
+
+	for _, td := range test_diffs {
+		got := GetWordDiff(td.OldText, td.NewText)
+		if !slices.Equal(got, td.Want) {
+			t.Fatalf("got %+v, want %+v for %q -> %q", got, td.Want, td.OldText, td.NewText)
+		}
+	}
+}
+
 // Delete summary
 func TestGetLinkIDFromSummaryID(t *testing.T) {
 	var test_summary_id, test_link_id = "84", "99"
@@ -223,9 +376,17 @@ func TestCalculateAndSetGlobalSummary(t *testing.T) {
 	}
 
 	for _, l := range test_link_ids {
-		err := CalculateAndSetGlobalSummary(l.ID)
+		tx, err := TestClient.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = CalculateAndSetGlobalSummary(tx, l.ID)
 		if err != nil {
+			tx.Rollback()
 			t.Fatalf("failed with error: %s", err)
+		} else if err = tx.Commit(); err != nil {
+			t.Fatal(err)
 		}
 
 		// confirm global summary matches expected
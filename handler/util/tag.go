@@ -2,9 +2,11 @@ package handler
 
 import (
 	"database/sql"
+	"fmt"
 	"slices"
 	"strings"
 
+	"github.com/google/uuid"
 	"github.com/julianlk522/fitm/db"
 	"github.com/julianlk522/fitm/model"
 	"github.com/julianlk522/fitm/query"
@@ -112,6 +114,91 @@ func ScanGlobalCatCounts(global_cats_sql *query.GlobalCatCounts) (*[]model.CatCo
 	return &counts, nil
 }
 
+// GetCatGraph
+func ScanCatGraph(cat_graph_sql *query.CatGraph) (*model.CatGraph, error) {
+	if cat_graph_sql.Error != nil {
+		return nil, cat_graph_sql.Error
+	}
+
+	rows, err := db.Client.Query(cat_graph_sql.Text, cat_graph_sql.Args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	graph := &model.CatGraph{
+		Nodes: []model.CatCount{},
+		Edges: []model.CatGraphEdge{},
+	}
+	node_counts := map[string]int32{}
+
+	for rows.Next() {
+		var edge model.CatGraphEdge
+		var source_count, target_count int32
+		err = rows.Scan(
+			&edge.Source,
+			&source_count,
+			&edge.Target,
+			&target_count,
+			&edge.Weight,
+		)
+		if err != nil {
+			return nil, err
+		}
+		graph.Edges = append(graph.Edges, edge)
+		node_counts[edge.Source] = source_count
+		node_counts[edge.Target] = target_count
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for cat, count := range node_counts {
+		graph.Nodes = append(graph.Nodes, model.CatCount{
+			Category: cat,
+			Count:    count,
+		})
+	}
+	slices.SortFunc(graph.Nodes, model.SortCats)
+
+	return graph, nil
+}
+
+// GraphViz DOT (undirected) with link counts as node labels and
+// co-occurrence counts as edge weights
+func CatGraphToDOT(graph *model.CatGraph) string {
+	var b strings.Builder
+	b.WriteString("graph cats {\n")
+
+	for _, node := range graph.Nodes {
+		fmt.Fprintf(
+			&b,
+			"\t%s [label=%s];\n",
+			GetDOTID(node.Category),
+			GetDOTID(fmt.Sprintf("%s (%d)", node.Category, node.Count)),
+		)
+	}
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(
+			&b,
+			"\t%s -- %s [weight=%d, label=%d];\n",
+			GetDOTID(edge.Source),
+			GetDOTID(edge.Target),
+			edge.Weight,
+			edge.Weight,
+		)
+	}
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Quoted DOT ID: only double quotes need escaping, but a trailing
+// backslash would escape the closing quote
+func GetDOTID(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
 func CatsAreSingularOrPluralVariationsOfEachOther(a string, b string) bool {
 	if a == b {
 		return false
@@ -210,60 +297,32 @@ func IsOnlyTag(tag_id string) (bool, error) {
 	return true, nil
 }
 
+// Uses the strategy set in config.Current.GlobalCatsStrategy
 func CalculateAndSetGlobalCats(link_id string) error {
-	tag_rankings_sql := query.NewTagRankings(link_id)
-	if tag_rankings_sql.Error != nil {
-		return tag_rankings_sql.Error
-	}
-
-	tags_for_link, err := ScanTagRankings(tag_rankings_sql)
+	tx, err := db.Client.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	cat_rankings := make(map[string]float32)
-	var max_cat_score float32
-
-	for _, tag := range *tags_for_link {
-		// multiple cats
-		if strings.Contains(tag.Cats, ",") {
-			cats := strings.Split(tag.Cats, ",")
-			for _, cat := range cats {
-				cat_rankings[cat] += tag.LifeSpanOverlap
-				if cat_rankings[cat] > max_cat_score {
-					max_cat_score = cat_rankings[cat]
-				}
-			}
-		// single cat
-		} else {
-			cat_rankings[tag.Cats] += tag.LifeSpanOverlap
-			if cat_rankings[tag.Cats] > max_cat_score {
-				max_cat_score = cat_rankings[tag.Cats]
-			}
-		}
-	}
-
-	if len(cat_rankings) > mutil.NUM_CATS_LIMIT {
-		cat_rankings = LimitToTopCatRankings(cat_rankings)
-	}
-
-	var new_global_cats string
-	for _, cat := range AlphabetizeCatRankings(cat_rankings) {
-		if cat_rankings[cat] >= max_cat_score * (MIN_PERCENT_OF_MAX_CAT_SCORE / 100) {
-			new_global_cats += cat + ","
-		}
+	if err = CalculateAndSetGlobalCatsInTx(tx, link_id); err != nil {
+		return err
 	}
 
-	// remove trailing comma
-	if len(new_global_cats) > 0 {
-		new_global_cats = new_global_cats[:len(new_global_cats)-1]
-	}
+	return tx.Commit()
+}
 
-	if err = SetGlobalCats(link_id, new_global_cats); err != nil {
+// Tags are read through tx so changes made earlier in it are counted
+func CalculateAndSetGlobalCatsInTx(tx *sql.Tx, link_id string) error {
+	votes, err := ScanTagVotes(tx, query.NewTagVotes(link_id))
+	if err != nil {
 		return err
 	}
 
-	return nil
+	_, new_global_cats := CalculateGlobalCats(GetActiveGlobalCatsStrategy(), *votes)
+	_, err = SetGlobalCatsInTx(tx, link_id, new_global_cats)
+
+	return err
 }
 
 func LimitToTopCatRankings(cat_rankings map[string]float32) map[string]float32 {
@@ -316,16 +375,31 @@ func AlphabetizeCatRankings(scores map[string]float32) []string {
 }
 
 func SetGlobalCats(link_id string, new_global_cats string) error {
-	cats_diff, err := GetGlobalCatsDiff(link_id, new_global_cats)
+	tx, err := db.Client.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	tx, err := db.Client.Begin()
-	if err != nil {
+	if _, err = SetGlobalCatsInTx(tx, link_id, new_global_cats); err != nil {
 		return err
 	}
-	defer tx.Rollback()
+
+	return tx.Commit()
+}
+
+// Also moves spellfix ranks and records the transition. Reports whether
+// global cats changed.
+func SetGlobalCatsInTx(tx *sql.Tx, link_id string, new_global_cats string) (bool, error) {
+	var old_global_cats string
+	err := tx.QueryRow(
+		"SELECT global_cats FROM Links WHERE id = ?;",
+		link_id,
+	).Scan(&old_global_cats)
+	if err != nil {
+		return false, err
+	}
+	cats_diff := DiffGlobalCats(old_global_cats, new_global_cats)
 
 	_, err = tx.Exec(`
 		UPDATE Links 
@@ -334,33 +408,129 @@ func SetGlobalCats(link_id string, new_global_cats string) error {
 		new_global_cats,
 		link_id)
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	if err = IncrementSpellfixRanksForCats(tx, cats_diff.Added); err != nil {
-		return err
+		return false, err
 	}
 	if err = DecrementSpellfixRanksForCats(tx, cats_diff.Removed); err != nil {
-		return err
+		return false, err
 	}
 
-	if err = tx.Commit(); err != nil {
-		return err
+	if err = RecordGlobalCatsTransition(tx, link_id, new_global_cats, cats_diff); err != nil {
+		return false, err
 	}
 
-	return nil
+	return len(cats_diff.Added) > 0 || len(cats_diff.Removed) > 0, nil
 }
 
-func GetGlobalCatsDiff(link_id string, new_cats_str string) (*model.GlobalCatsDiff, error) {
-	var old_cats_str string
-	err := db.Client.QueryRow(
-		"SELECT global_cats FROM Links WHERE id = ?;",
+// No-op if the diff is empty (e.g., recalculated on GetTagPage with
+// no change)
+func RecordGlobalCatsTransition(tx *sql.Tx, link_id string, new_global_cats string, cats_diff *model.GlobalCatsDiff) error {
+	added := slices.DeleteFunc(slices.Clone(cats_diff.Added), IsEmptyCat)
+	removed := slices.DeleteFunc(slices.Clone(cats_diff.Removed), IsEmptyCat)
+	if len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	_, err := tx.Exec(
+		`INSERT INTO "Global Cats History" (id, link_id, global_cats, added, removed, timestamp)
+		VALUES (?, ?, ?, ?, ?, ?);`,
+		uuid.New().String(),
 		link_id,
-	).Scan(&old_cats_str)
+		new_global_cats,
+		strings.Join(added, ","),
+		strings.Join(removed, ","),
+		mutil.NEW_LONG_TIMESTAMP(),
+	)
+
+	return err
+}
+
+func IsEmptyCat(cat string) bool {
+	return cat == ""
+}
+
+func ArchiveTagRevision(ex db.Execer, tag_id string) error {
+	_, err := ex.Exec(
+		`INSERT INTO "Tag Revisions" (id, tag_id, link_id, cats, submitted_by, last_updated, replaced)
+		SELECT ?, id, link_id, cats, submitted_by, last_updated, ?
+		FROM Tags
+		WHERE id = ?`,
+		uuid.New().String(),
+		mutil.NEW_LONG_TIMESTAMP(),
+		tag_id,
+	)
+
+	return err
+}
+
+func BuildTagHistory(link_id string) (*model.TagHistory, error) {
+	history := &model.TagHistory{
+		LinkID:       link_id,
+		TagRevisions: []model.TagRevision{},
+		GlobalCats:   []model.GlobalCatsTransition{},
+	}
+
+	revisions_sql := query.NewTagRevisions(link_id)
+	rows, err := db.Client.Query(revisions_sql.Text, revisions_sql.Args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rev := model.TagRevision{}
+		if err := rows.Scan(
+			&rev.ID,
+			&rev.TagID,
+			&rev.Cats,
+			&rev.SubmittedBy,
+			&rev.LastUpdated,
+			&rev.Replaced,
+		); err != nil {
+			return nil, err
+		}
+		history.TagRevisions = append(history.TagRevisions, rev)
+	}
+
+	global_cats_sql := query.NewGlobalCatsHistory(link_id)
+	gc_rows, err := db.Client.Query(global_cats_sql.Text, global_cats_sql.Args...)
 	if err != nil {
 		return nil, err
 	}
+	defer gc_rows.Close()
+
+	for gc_rows.Next() {
+		var added, removed string
+		transition := model.GlobalCatsTransition{}
+		if err := gc_rows.Scan(
+			&transition.GlobalCats,
+			&added,
+			&removed,
+			&transition.Timestamp,
+		); err != nil {
+			return nil, err
+		}
 
+		transition.Added = SplitCats(added)
+		transition.Removed = SplitCats(removed)
+		history.GlobalCats = append(history.GlobalCats, transition)
+	}
+
+	return history, nil
+}
+
+// Like strings.Split but returns an empty slice for ""
+func SplitCats(cats string) []string {
+	if cats == "" {
+		return []string{}
+	}
+	return strings.Split(cats, ",")
+}
+
+func DiffGlobalCats(old_cats_str string, new_cats_str string) *model.GlobalCatsDiff {
 	var new_cats = strings.Split(new_cats_str, ",")
 	var old_cats = strings.Split(old_cats_str, ",")
 
@@ -380,5 +550,5 @@ func GetGlobalCatsDiff(link_id string, new_cats_str string) (*model.GlobalCatsDi
 	return &model.GlobalCatsDiff{
 		Added:   added_cats,
 		Removed: removed_cats,
-	}, nil
+	}
 }
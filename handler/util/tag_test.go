@@ -2,9 +2,13 @@ package handler
 
 import (
 	"database/sql"
+	"net/url"
+	"slices"
 	"strings"
 	"testing"
 
+	e "github.com/julianlk522/fitm/error"
+	"github.com/julianlk522/fitm/model"
 	modelutil "github.com/julianlk522/fitm/model/util"
 	"github.com/julianlk522/fitm/query"
 )
@@ -560,3 +564,423 @@ func TestSetGlobalCats(t *testing.T) {
 		}
 	}
 }
+
+func TestArchiveTagRevision(t *testing.T) {
+	var test_tag_id = "32"
+
+	var cats string
+	err := TestClient.QueryRow(
+		"SELECT cats FROM Tags WHERE id = ?;",
+		test_tag_id,
+	).Scan(&cats)
+	if err != nil {
+		t.Fatalf("failed with error: %s", err)
+	}
+
+	if err = ArchiveTagRevision(TestClient, test_tag_id); err != nil {
+		t.Fatalf("failed with error: %s", err)
+	}
+
+	var archived_cats string
+	err = TestClient.QueryRow(`
+		SELECT cats 
+		FROM "Tag Revisions" 
+		WHERE tag_id = ?
+		ORDER BY replaced DESC
+		LIMIT 1;`,
+		test_tag_id,
+	).Scan(&archived_cats)
+	if err != nil {
+		t.Fatalf("failed with error: %s", err)
+	} else if archived_cats != cats {
+		t.Fatalf("got archived cats %s, want %s", archived_cats, cats)
+	}
+
+	history, err := BuildTagHistory("1")
+	if err != nil {
+		t.Fatalf("failed with error: %s", err)
+	}
+
+	var found bool
+	for _, rev := range history.TagRevisions {
+		if rev.TagID == test_tag_id && rev.Cats == cats {
+			found = true
+			break
+		}
+	}
+	if !found {
+		t.Fatalf("archived revision for tag %s not in history", test_tag_id)
+	}
+}
+
+func TestRecordGlobalCatsTransition(t *testing.T) {
+	var test_link_id = "1"
+
+	var old_gcs string
+	err := TestClient.QueryRow(
+		"SELECT global_cats FROM Links WHERE id = ?;",
+		test_link_id,
+	).Scan(&old_gcs)
+	if err != nil {
+		t.Fatalf("failed with error: %s", err)
+	}
+
+	old_history, err := BuildTagHistory(test_link_id)
+	if err != nil {
+		t.Fatalf("failed with error: %s", err)
+	}
+
+	// unchanged global cats should not be recorded
+	if err = SetGlobalCats(test_link_id, old_gcs); err != nil {
+		t.Fatalf("failed with error: %s", err)
+	}
+
+	history, err := BuildTagHistory(test_link_id)
+	if err != nil {
+		t.Fatalf("failed with error: %s", err)
+	} else if len(history.GlobalCats) != len(old_history.GlobalCats) {
+		t.Fatalf("recorded transition for unchanged global cats")
+	}
+
+	var new_gcs = old_gcs + ",transitiontest"
+	if err = SetGlobalCats(test_link_id, new_gcs); err != nil {
+		t.Fatalf("failed with error: %s", err)
+	}
+
+	history, err = BuildTagHistory(test_link_id)
+	if err != nil {
+		t.Fatalf("failed with error: %s", err)
+	} else if len(history.GlobalCats) != len(old_history.GlobalCats)+1 {
+		t.Fatalf(
+			"got %d transitions, want %d",
+			len(history.GlobalCats),
+			len(old_history.GlobalCats)+1,
+		)
+	}
+
+	latest := history.GlobalCats[0]
+	if latest.GlobalCats != new_gcs {
+		t.Fatalf("got global cats %s, want %s", latest.GlobalCats, new_gcs)
+	} else if len(latest.Added) != 1 || latest.Added[0] != "transitiontest" {
+		t.Fatalf("got added cats %v, want [transitiontest]", latest.Added)
+	} else if len(latest.Removed) != 0 {
+		t.Fatalf("got removed cats %v, want none", latest.Removed)
+	}
+}
+
+func TestSplitCats(t *testing.T) {
+	var test_cats = []struct {
+		Cats string
+		Want int
+	}{
+		{"", 0},
+		{"go", 1},
+		{"go,concurrency,channels", 3},
+	}
+
+	for _, tc := range test_cats {
+		if got := SplitCats(tc.Cats); len(got) != tc.Want {
+			t.Fatalf("got %d cats for %q, want %d", len(got), tc.Cats, tc.Want)
+		}
+	}
+}
+
+func TestCatGraphToDOT(t *testing.T) {
+	graph := &model.CatGraph{
+		Nodes: []model.CatCount{
+			{Category: "go", Count: 3},
+			{Category: `say "hi"`, Count: 1},
+		},
+		Edges: []model.CatGraphEdge{
+			{Source: "go", Target: `say "hi"`, Weight: 1},
+		},
+	}
+
+	want := `graph cats {
+	"go" [label="go (3)"];
+	"say \"hi\"" [label="say \"hi\" (1)"];
+	"go" -- "say \"hi\"" [weight=1, label=1];
+}
+`
+	if got := CatGraphToDOT(graph); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRewriteTagsWithCatAlias(t *testing.T) {
+	const (
+		test_link_id = "cat_alias_test_link"
+		test_alias   = "cat_alias_test_alias"
+		test_cat     = "cat_alias_test_cat"
+	)
+
+	_, err := TestClient.Exec(
+		`INSERT INTO Links (id, url, submitted_by, submit_date, global_cats)
+		VALUES (?, ?, ?, ?, ?);`,
+		test_link_id,
+		"https://cat-alias-test.com",
+		TEST_LOGIN_NAME,
+		"2024-01-01 00:00:00",
+		"other,"+test_alias,
+	)
+	if err != nil {
+		t.Fatal(err)
+	} else if err = IncrementSpellfixRanksForCats(nil, []string{"other", test_alias}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = TestClient.Exec(
+		`INSERT INTO Tags (id, link_id, cats, submitted_by, last_updated)
+		VALUES (?, ?, ?, ?, ?);`,
+		"cat_alias_test_tag",
+		test_link_id,
+		"other,"+strings.ToUpper(test_alias),
+		TEST_LOGIN_NAME,
+		"2024-01-01 00:00:00",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ValidateNewCatAlias(test_alias, test_cat); err != nil {
+		t.Fatalf("failed with error: %s", err)
+	}
+	defer func() {
+		TestClient.Exec(`DELETE FROM "Cat Aliases" WHERE alias = ?;`, test_alias)
+		LoadCatAliases(TestClient)
+	}()
+
+	tags_rewritten, links_recalculated, err := AddCatAliasAndRewriteTags(model.CatAlias{
+		Alias:     test_alias,
+		Cat:       test_cat,
+		CreatedBy: TEST_LOGIN_NAME,
+		Created:   modelutil.NEW_LONG_TIMESTAMP(),
+	})
+	if err != nil {
+		t.Fatalf("failed with error: %s", err)
+	} else if tags_rewritten != 1 || links_recalculated != 1 {
+		t.Fatalf(
+			"got %d tags rewritten and %d links recalculated, want 1 and 1",
+			tags_rewritten,
+			links_recalculated,
+		)
+	} else if modelutil.ResolveCatAlias(test_alias) != test_cat {
+		t.Fatal("alias not applied after creation")
+	}
+
+	// duplicates and chains
+	if err = ValidateNewCatAlias(strings.ToUpper(test_alias), "anything"); err != e.ErrCatAliasExists {
+		t.Fatalf("got %v, want %s", err, e.ErrCatAliasExists)
+	} else if err = ValidateNewCatAlias(test_cat, "anything"); err != e.ErrCatAliasChain {
+		t.Fatalf("got %v, want %s", err, e.ErrCatAliasChain)
+	} else if err = ValidateNewCatAlias("anything", test_alias); err != e.ErrCatAliasChain {
+		t.Fatalf("got %v, want %s", err, e.ErrCatAliasChain)
+	}
+
+	var cats, global_cats string
+	err = TestClient.QueryRow(
+		`SELECT t.cats, l.global_cats
+		FROM Tags t
+		INNER JOIN Links l ON l.id = t.link_id
+		WHERE t.id = 'cat_alias_test_tag';`,
+	).Scan(&cats, &global_cats)
+	if err != nil {
+		t.Fatal(err)
+	} else if cats != test_cat+",other" {
+		t.Fatalf("got tag cats %s, want %s", cats, test_cat+",other")
+	} else if !slices.Contains(strings.Split(global_cats, ","), test_cat) {
+		t.Fatalf("got global cats %s, want to contain %s", global_cats, test_cat)
+	}
+
+	// spellfix rank moved from alias to cat
+	var alias_in_spellfix bool
+	err = TestClient.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM global_cats_spellfix WHERE word = ?);",
+		test_alias,
+	).Scan(&alias_in_spellfix)
+	if err != nil {
+		t.Fatal(err)
+	} else if alias_in_spellfix {
+		t.Fatalf("alias %s still ranked in spellfix", test_alias)
+	}
+}
+
+func TestBuildCatTree(t *testing.T) {
+	modelutil.SetCatParents(map[string]string{
+		"goroutines": "go",
+		"go":         "programming",
+		"select":     "channels",
+	})
+	defer modelutil.SetCatParents(map[string]string{})
+
+	tree := BuildCatTree([]model.CatCount{
+		{Category: "goroutines", Count: 2},
+		{Category: "Go", Count: 5},
+		{Category: "select", Count: 1},
+		{Category: "python", Count: 3},
+	})
+
+	// programming and channels weren't counted but are ancestors
+	var roots []string
+	for _, node := range tree {
+		roots = append(roots, node.Category)
+	}
+	if !slices.Equal(roots, []string{"python", "channels", "programming"}) {
+		t.Fatalf("got roots %v", roots)
+	}
+
+	programming := tree[2]
+	if programming.Count != 0 ||
+		len(programming.Children) != 1 ||
+		programming.Children[0].Category != "Go" ||
+		programming.Children[0].Count != 5 {
+		t.Fatalf("got programming subtree %+v", programming)
+	} else if go_children := programming.Children[0].Children; len(go_children) != 1 || go_children[0].Category != "goroutines" {
+		t.Fatalf("got go children %+v", go_children)
+	}
+}
+
+func TestCalculateGlobalCats(t *testing.T) {
+	votes := []model.TagVote{
+		{
+			TagRanking:         model.TagRanking{Cats: "go,concurrency", LifeSpanOverlap: 90},
+			AgeDays:            300,
+			SubmitterLikeCount: 0,
+		},
+		{
+			TagRanking:         model.TagRanking{Cats: "go,channels", LifeSpanOverlap: 10},
+			AgeDays:            1,
+			SubmitterLikeCount: 100,
+		},
+		{
+			TagRanking:         model.TagRanking{Cats: "tutorial", LifeSpanOverlap: 5},
+			AgeDays:            1,
+			SubmitterLikeCount: 0,
+		},
+	}
+
+	var test_strategies = []struct {
+		Strategy   string
+		GlobalCats string
+	}{
+		{"lifespan_overlap", "go,concurrency"},
+		{"reputation", "go,channels"},
+		{"time_decay", "go,channels,tutorial"},
+		{"majority", "go"},
+	}
+
+	for _, ts := range test_strategies {
+		strategy, err := GetGlobalCatsStrategy(ts.Strategy)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, got := CalculateGlobalCats(strategy, votes)
+		if got != ts.GlobalCats {
+			t.Fatalf("got %s, want %s for strategy %s", got, ts.GlobalCats, ts.Strategy)
+		}
+	}
+
+	if _, err := GetGlobalCatsStrategy("coin_flip"); err == nil {
+		t.Fatal("expected error for unknown strategy")
+	}
+
+	// majority falls back to most common cats
+	strategy, _ := GetGlobalCatsStrategy("majority")
+	if _, got := CalculateGlobalCats(strategy, votes[1:]); got != "channels,go,tutorial" {
+		t.Fatalf("got %s, want channels,go,tutorial", got)
+	}
+}
+
+// A tag with both an alias and its cat votes for the cat once
+func TestScanTagVotesResolvesCatAliases(t *testing.T) {
+	modelutil.SetCatAliases(map[string]string{"golang": "go"})
+	defer modelutil.SetCatAliases(map[string]string{})
+
+	for _, stmt := range []string{
+		`INSERT INTO Links (id, url, submitted_by, submit_date, global_cats, global_summary, img_file)
+		VALUES ('scan-tag-votes-alias', 'https://example.com/scan-tag-votes-alias', 'jlk', '2024-01-01 00:00:00', 'go', '', '');`,
+		`INSERT INTO Tags (id, link_id, cats, submitted_by, last_updated)
+		VALUES ('scan-tag-votes-alias', 'scan-tag-votes-alias', 'concurrency,golang,Go', 'jlk', '2024-01-01 00:00:00');`,
+	} {
+		if _, err := TestClient.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		TestClient.Exec("DELETE FROM Tags WHERE link_id = 'scan-tag-votes-alias';")
+		TestClient.Exec("DELETE FROM Links WHERE id = 'scan-tag-votes-alias';")
+	})
+
+	votes, err := ScanTagVotes(TestClient, query.NewTagVotes("scan-tag-votes-alias"))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(*votes) != 1 {
+		t.Fatalf("got %d votes, want 1", len(*votes))
+	} else if cats := (*votes)[0].Cats; cats != "concurrency,go" {
+		t.Fatalf("got cats %s, want concurrency,go", cats)
+	}
+
+	strategy, _ := GetGlobalCatsStrategy("majority")
+	if scores, _ := CalculateGlobalCats(strategy, *votes); scores["go"] != scores["concurrency"] {
+		t.Fatalf("got scores %v, want go counted once", scores)
+	}
+}
+
+func TestBuildGlobalCatsDryRun(t *testing.T) {
+	dry_run, err := BuildGlobalCatsDryRun("1")
+	if err != nil {
+		t.Fatalf("failed with error: %s", err)
+	} else if len(dry_run.Results) != len(GLOBAL_CATS_STRATEGIES) {
+		t.Fatalf("got %d results, want %d", len(dry_run.Results), len(GLOBAL_CATS_STRATEGIES))
+	}
+
+	// dry run must not change global cats
+	var global_cats string
+	if err = TestClient.QueryRow(
+		"SELECT global_cats FROM Links WHERE id = '1';",
+	).Scan(&global_cats); err != nil {
+		t.Fatal(err)
+	} else if global_cats != dry_run.GlobalCats {
+		t.Fatalf("got global cats %s, want unchanged %s", global_cats, dry_run.GlobalCats)
+	}
+
+	if _, err = BuildGlobalCatsDryRun("-1"); err == nil {
+		t.Fatal("expected error for nonexistent link")
+	}
+}
+
+func TestWeightSpellfixMatchesByContext(t *testing.T) {
+	matches := []model.CatCount{
+		{Category: "test_no_links_cat", Count: 100},
+		{Category: "flowers", Count: 1},
+		{Category: "umvc3", Count: 1},
+	}
+
+	var test_params = []url.Values{
+		{"cats": {"umvc3"}},
+		{"period": {"year"}},
+		{"tmap": {TEST_LOGIN_NAME}},
+	}
+
+	for _, params := range test_params {
+		weighted, err := WeightSpellfixMatchesByContext(matches, params)
+		if err != nil {
+			t.Fatalf("failed with error: %s for params %v", err, params)
+		}
+
+		for _, w := range weighted {
+			if w.Category == "test_no_links_cat" {
+				t.Fatalf("got cat on no links for params %v", params)
+			} else if w.Count <= 0 {
+				t.Fatalf("got count %d for %s, params %v", w.Count, w.Category, params)
+			}
+		}
+	}
+
+	if _, err := WeightSpellfixMatchesByContext(
+		matches,
+		url.Values{"tmap": {"not_a_real_user_1234"}},
+	); err != e.ErrNoUserWithLoginName {
+		t.Fatalf("got error %v, want %s", err, e.ErrNoUserWithLoginName)
+	}
+}
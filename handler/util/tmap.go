@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"cmp"
 	"database/sql"
 	"math"
 	"net/url"
@@ -40,13 +41,18 @@ func GetTmapOptsFromRequestParams(params url.Values) (*model.TmapOptions, error)
 
 	cats_params = params.Get("cats")
 	if cats_params != "" {
-		// For GetCatCountsFromTmapLinks()
-		opts.RawCatsParams = cats_params
+		cats_filter, err := query.ParseCatsFilter(cats_params)
+		if err != nil {
+			return nil, err
+		}
 
-		cats := query.GetCatsOptionalPluralOrSingularForms(
-			strings.Split(cats_params, ","),
-		)
-		opts.Cats = cats
+		// For GetCatCountsFromTmapLinks(): only the included cats, without
+		// filter syntax
+		opts.RawCatsParams = strings.Join(cats_filter.Cats(), ",")
+
+		// Single pre-compiled MATCH arg (ANDed with any others by the
+		// FromCats methods)
+		opts.Cats = []string{cats_filter.MatchArg()}
 	}
 
 	period_params = params.Get("period")
@@ -70,9 +76,23 @@ func GetTmapOptsFromRequestParams(params url.Values) (*model.TmapOptions, error)
 		return nil, e.ErrInvalidNSFWParams
 	}
 
+	search_params := params.Get("q")
+	if search_params != "" {
+		match_arg, err := query.GetSearchMatchArg(search_params)
+		if err != nil {
+			return nil, err
+		}
+		opts.SearchMatchArg = match_arg
+	}
+
 	sort_params = params.Get("sort_by")
 	if sort_params == "newest" {
 		opts.SortByNewest = true
+	} else if sort_params == "relevance" || (sort_params == "" && search_params != "") {
+		if search_params == "" {
+			return nil, e.ErrRelevanceWithoutSearch
+		}
+		opts.SortByRelevance = true
 	} else if sort_params != "rating" && sort_params != "" {
 		return nil, e.ErrInvalidSortByParams
 	}
@@ -96,6 +116,28 @@ func GetTmapOptsFromRequestParams(params url.Values) (*model.TmapOptions, error)
 		opts.Page = page
 	}
 
+	cursor_params := params.Get("cursor")
+	if cursor_params != "" {
+		if opts.Section == "" {
+			return nil, e.ErrCursorWithoutSection
+		} else if opts.Page > 1 {
+			return nil, e.ErrCursorWithPage
+		}
+
+		var sort_by string
+		if opts.SortByNewest {
+			sort_by = "newest"
+		} else if opts.SortByRelevance {
+			sort_by = "relevance"
+		}
+
+		cursor, err := model.DecodeLinksCursor(cursor_params, sort_by)
+		if err != nil {
+			return nil, err
+		}
+		opts.Cursor = cursor
+	}
+
 	return opts, nil
 }
 
@@ -130,6 +172,7 @@ func BuildTmapFromOpts[T model.TmapLink | model.TmapLinkSignedIn](opts *model.Tm
 		CatsFilter: opts.Cats,
 		Period: opts.Period,
 		URLContains: opts.URLContains,
+		SearchMatchArg: opts.SearchMatchArg,
 	}
 	nsfw_links_count_sql := query.
 		NewTmapNSFWLinksCount(tmap_owner).
@@ -183,6 +226,12 @@ func BuildTmapFromOpts[T model.TmapLink | model.TmapLinkSignedIn](opts *model.Tm
 			return nil, err
 		}
 
+		if opts.SearchMatchArg != "" {
+			if err = ApplyTmapSearchResults(links, opts); err != nil {
+				return nil, err
+			}
+		}
+
 		if links == nil || len(*links) == 0 {
 			return model.TmapSectionPage[T]{
 				Links:          &[]T{},
@@ -199,6 +248,12 @@ func BuildTmapFromOpts[T model.TmapLink | model.TmapLinkSignedIn](opts *model.Tm
 		// and looping over <= 60 links is trivial
 		cat_counts = GetCatCountsFromTmapLinks(links, cat_counts_opts)
 
+		if opts.Cursor != nil {
+			*links = slices.DeleteFunc(*links, func(l T) bool {
+				return CompareTmapLinkToCursor(GetTmapLinkBase(l), opts.Cursor) <= 0
+			})
+		}
+
 		// Pagination
 		// TODO: move to separate util function
 		page := 1
@@ -217,11 +272,24 @@ func BuildTmapFromOpts[T model.TmapLink | model.TmapLinkSignedIn](opts *model.Tm
 			*links = (*links)[query.LINKS_PAGE_LIMIT*(page-1) : query.LINKS_PAGE_LIMIT*page]
 		}
 
+		var next_cursor string
+		if page < pages && !opts.SortByRelevance {
+			sort_by := "rating"
+			if opts.SortByNewest {
+				sort_by = "newest"
+			}
+			next_cursor = model.NewLinksCursor(
+				sort_by,
+				GetTmapLinkBase((*links)[len(*links)-1]),
+			).Encode()
+		}
+
 		return model.TmapSectionPage[T]{
 			Links:          links,
 			Cats:           cat_counts,
 			Pages:      pages,
 			NSFWLinksCount: nsfw_links_count,
+			NextCursor:     next_cursor,
 		}, nil
 
 		// All sections
@@ -262,6 +330,14 @@ func BuildTmapFromOpts[T model.TmapLink | model.TmapLinkSignedIn](opts *model.Tm
 			return nil, err
 		}
 
+		if opts.SearchMatchArg != "" {
+			for _, section := range []*[]T{submitted, copied, tagged} {
+				if err = ApplyTmapSearchResults(section, opts); err != nil {
+					return nil, err
+				}
+			}
+		}
+
 		links_from_all_sections := slices.Concat(*submitted, *copied, *tagged)
 		if len(links_from_all_sections) == 0 {
 			return model.FilteredTmap[T]{
@@ -366,7 +442,8 @@ func ScanTmapLinks[T model.TmapLink | model.TmapLinkSignedIn](sql *query.Query)
 				&l.EarliestCopiers,
 				&l.ClickCount,
 				&l.TagCount,
-				&l.PreviewImgFilename)
+				&l.PreviewImgFilename,
+				&l.Health)
 			if err != nil {
 				return nil, err
 			}
@@ -395,6 +472,7 @@ func ScanTmapLinks[T model.TmapLink | model.TmapLinkSignedIn](sql *query.Query)
 				&l.ClickCount,
 				&l.TagCount,
 				&l.PreviewImgFilename,
+				&l.Health,
 
 				// signed-in only properties
 				&l.IsLiked,
@@ -411,6 +489,106 @@ func ScanTmapLinks[T model.TmapLink | model.TmapLinkSignedIn](sql *query.Query)
 	return links.(*[]T), nil
 }
 
+// Sets search snippets and, if sorting by relevance, reorders links
+// by search rank (ties keep their existing order)
+func ApplyTmapSearchResults[T model.TmapLink | model.TmapLinkSignedIn](links *[]T, opts *model.TmapOptions) error {
+	if links == nil || len(*links) == 0 {
+		return nil
+	}
+
+	var link_ids []string
+	switch l := any(links).(type) {
+	case *[]model.TmapLink:
+		for _, link := range *l {
+			link_ids = append(link_ids, link.ID)
+		}
+	case *[]model.TmapLinkSignedIn:
+		for _, link := range *l {
+			link_ids = append(link_ids, link.ID)
+		}
+	}
+
+	results_sql := query.NewLinkSearchResults(opts.SearchMatchArg, link_ids)
+	if results_sql.Error != nil {
+		return results_sql.Error
+	}
+
+	rows, err := db.Client.Query(results_sql.Text, results_sql.Args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	ranks := make(map[string]float64, len(link_ids))
+	snippets := make(map[string]string, len(link_ids))
+	for rows.Next() {
+		var link_id, snippet string
+		var rank float64
+		if err = rows.Scan(&link_id, &rank, &snippet); err != nil {
+			return err
+		}
+		ranks[link_id] = rank
+		snippets[link_id] = query.GetSearchSnippetHTML(snippet)
+	}
+
+	switch l := any(links).(type) {
+	case *[]model.TmapLink:
+		for i := range *l {
+			(*l)[i].SearchSnippet = snippets[(*l)[i].ID]
+		}
+		if opts.SortByRelevance {
+			slices.SortStableFunc(*l, func(a, b model.TmapLink) int {
+				return cmp.Compare(ranks[a.ID], ranks[b.ID])
+			})
+		}
+	case *[]model.TmapLinkSignedIn:
+		for i := range *l {
+			(*l)[i].SearchSnippet = snippets[(*l)[i].ID]
+		}
+		if opts.SortByRelevance {
+			slices.SortStableFunc(*l, func(a, b model.TmapLinkSignedIn) int {
+				return cmp.Compare(ranks[a.ID], ranks[b.ID])
+			})
+		}
+	}
+
+	return nil
+}
+
+// Same order as TMAP_DEFAULT_ORDER_BY / TMAP_ORDER_BY_NEWEST, where all
+// keys are DESC: > 0 means the link comes after the cursor
+func CompareTmapLinkToCursor(l model.Link, c *model.LinksCursor) int {
+	counts := cmp.Or(
+		cmp.Compare(c.LikeCount, l.LikeCount),
+		cmp.Compare(c.CopyCount, l.CopyCount),
+		cmp.Compare(c.ClickCount, l.ClickCount),
+		cmp.Compare(c.TagCount, l.TagCount),
+		cmp.Compare(c.SummaryCount, l.SummaryCount),
+	)
+
+	if c.SortBy == "newest" {
+		return cmp.Or(
+			cmp.Compare(c.SubmitDate, l.SubmitDate),
+			counts,
+			cmp.Compare(c.ID, l.ID),
+		)
+	}
+
+	// default order breaks ties by ID before submit date
+	return cmp.Or(counts, cmp.Compare(c.ID, l.ID))
+}
+
+func GetTmapLinkBase[T model.TmapLink | model.TmapLinkSignedIn](link T) model.Link {
+	switch l := any(link).(type) {
+	case model.TmapLink:
+		return l.Link
+	case model.TmapLinkSignedIn:
+		return l.Link
+	}
+
+	return model.Link{}
+}
+
 func GetCatCountsFromTmapLinks[T model.TmapLink | model.TmapLinkSignedIn](links *[]T, opts *model.TmapCatCountsOptions) *[]model.CatCount {
 	var omitted_cats []string
 	// Use raw_cats_params here to determine omitted_cats because CatsFilter
@@ -1,9 +1,11 @@
 package handler
 
 import (
+	"net/url"
 	"slices"
 	"strings"
 	"testing"
+	"unicode"
 
 	"github.com/julianlk522/fitm/model"
 	"github.com/julianlk522/fitm/query"
@@ -111,6 +113,116 @@ func TestBuildTmapFromOpts(t *testing.T) {
 	}
 }
 
+func TestBuildTmapFromOptsWithSearch(t *testing.T) {
+	var test_params = []struct {
+		Params url.Values
+		Valid  bool
+	}{
+		{url.Values{"q": {"google"}}, true},
+		{url.Values{"q": {"google"}, "sort_by": {"newest"}}, true},
+		{url.Values{"q": {"google"}, "section": {"submitted"}}, true},
+		{url.Values{"q": {"google"}, "cats": {"search"}, "nsfw": {"true"}}, true},
+		{url.Values{"sort_by": {"relevance"}}, false},
+		{url.Values{"q": {"?!"}}, false},
+	}
+
+	for _, tp := range test_params {
+		opts, err := GetTmapOptsFromRequestParams(tp.Params)
+		if (err == nil) != tp.Valid {
+			t.Fatalf("expected %t, got error %s (params: %v)", tp.Valid, err, tp.Params)
+		} else if !tp.Valid {
+			continue
+		}
+
+		if opts.SortByRelevance == (tp.Params.Get("sort_by") == "newest") {
+			t.Fatalf("got SortByRelevance %t for params %v", opts.SortByRelevance, tp.Params)
+		}
+
+		opts.OwnerLoginName = TEST_LOGIN_NAME
+		opts.AsSignedInUser = TEST_USER_ID
+		if _, err = BuildTmapFromOpts[model.TmapLinkSignedIn](opts); err != nil {
+			t.Fatalf("failed with error: %s (params: %v)", err, tp.Params)
+		}
+	}
+}
+
+func TestBuildTmapFromOptsWithCatsFilter(t *testing.T) {
+	var test_params = []struct {
+		Params url.Values
+		Valid  bool
+	}{
+		{url.Values{"cats": {"search|umvc3"}}, true},
+		{url.Values{"cats": {"search,-engine"}, "section": {"copied"}}, true},
+		{url.Values{"cats": {`"search engine",-NSFW`}, "nsfw": {"true"}}, true},
+		{url.Values{"cats": {"-search"}}, false},
+		{url.Values{"cats": {`"search`}}, false},
+	}
+
+	for _, tp := range test_params {
+		opts, err := GetTmapOptsFromRequestParams(tp.Params)
+		if (err == nil) != tp.Valid {
+			t.Fatalf("expected %t, got error %s (params: %v)", tp.Valid, err, tp.Params)
+		} else if !tp.Valid {
+			continue
+		}
+
+		// filter syntax must not reach cat counts
+		if strings.ContainsAny(opts.RawCatsParams, `|-"`) {
+			t.Fatalf("got RawCatsParams %s for params %v", opts.RawCatsParams, tp.Params)
+		}
+
+		opts.OwnerLoginName = TEST_LOGIN_NAME
+		if _, err = BuildTmapFromOpts[model.TmapLink](opts); err != nil {
+			t.Fatalf("failed with error: %s (params: %v)", err, tp.Params)
+		}
+	}
+}
+
+func TestApplyTmapSearchResults(t *testing.T) {
+	opts := &model.TmapOptions{
+		OwnerLoginName:  TEST_LOGIN_NAME,
+		SortByRelevance: true,
+	}
+
+	submitted_sql := query.NewTmapSubmitted(TEST_LOGIN_NAME).FromOptions(opts)
+	links, err := ScanTmapLinks[model.TmapLink](submitted_sql.Query)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(*links) == 0 {
+		t.Skip("no submitted links for test user")
+	}
+
+	// search for a word from the first link's URL so there is at least
+	// one match
+	words := strings.FieldsFunc((*links)[0].URL, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	opts.SearchMatchArg, err = query.GetSearchMatchArg(words[len(words)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ApplyTmapSearchResults(links, opts); err != nil {
+		t.Fatalf("failed with error: %s", err)
+	}
+
+	var has_snippet bool
+	for _, l := range *links {
+		if l.SearchSnippet != "" {
+			has_snippet = true
+			break
+		}
+	}
+	if !has_snippet {
+		t.Fatalf("no search snippets set for match %s", opts.SearchMatchArg)
+	}
+
+	// matches sorted first
+	if (*links)[0].SearchSnippet == "" {
+		t.Fatal("expected best match first")
+	}
+}
+
 func TestScanTmapProfile(t *testing.T) {
 	profile_sql := query.NewTmapProfile(TEST_LOGIN_NAME)
 	// NewTmapProfile() tested in query/tmap_test.go
@@ -321,3 +433,83 @@ func TestMergeCatCountsCapitalizationVariants(t *testing.T) {
 		t.Fatalf("expected FITM to have moved up to index 3 because Music and music were combined, got %s", counts[3].Category)
 	}
 }
+
+func TestGetTmapOptsFromRequestParamsWithCursor(t *testing.T) {
+	rating_cursor := model.NewLinksCursor("rating", model.Link{ID: "1"}).Encode()
+	newest_cursor := model.NewLinksCursor("newest", model.Link{ID: "1"}).Encode()
+
+	var test_params = []struct {
+		Params url.Values
+		Valid  bool
+	}{
+		{url.Values{"section": {"submitted"}, "cursor": {rating_cursor}}, true},
+		{url.Values{"section": {"tagged"}, "sort_by": {"newest"}, "cursor": {newest_cursor}}, true},
+		{url.Values{"section": {"copied"}, "page": {"1"}, "cursor": {rating_cursor}}, true},
+		// cursor only applies to a single section
+		{url.Values{"cursor": {rating_cursor}}, false},
+		{url.Values{"section": {"submitted"}, "page": {"2"}, "cursor": {rating_cursor}}, false},
+		// cursor from another sort
+		{url.Values{"section": {"submitted"}, "sort_by": {"newest"}, "cursor": {rating_cursor}}, false},
+		{url.Values{"section": {"submitted"}, "cursor": {"invalid"}}, false},
+	}
+
+	for _, tp := range test_params {
+		opts, err := GetTmapOptsFromRequestParams(tp.Params)
+		if tp.Valid && err != nil {
+			t.Fatalf("unexpected error %s for params %v", err, tp.Params)
+		} else if !tp.Valid && err == nil {
+			t.Fatalf("expected error for params %v", tp.Params)
+		} else if tp.Valid && opts.Cursor == nil {
+			t.Fatalf("expected cursor to be set for params %v", tp.Params)
+		}
+	}
+}
+
+func TestBuildTmapFromOptsWithCursor(t *testing.T) {
+	for _, sort_by_newest := range []bool{false, true} {
+		opts := &model.TmapOptions{
+			OwnerLoginName: TEST_LOGIN_NAME,
+			SortByNewest:   sort_by_newest,
+			IncludeNSFW:    true,
+			Section:        "submitted",
+		}
+		tmap, err := BuildTmapFromOpts[model.TmapLink](opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all_links := *tmap.(model.TmapSectionPage[model.TmapLink]).Links
+
+		sort_by := "rating"
+		if sort_by_newest {
+			sort_by = "newest"
+		}
+
+		// resuming after each link continues with the next one
+		for i, link := range all_links {
+			opts.Cursor = model.NewLinksCursor(sort_by, link.Link)
+			tmap, err := BuildTmapFromOpts[model.TmapLink](opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			links_after := *tmap.(model.TmapSectionPage[model.TmapLink]).Links
+
+			if len(links_after) != min(len(all_links)-i-1, query.LINKS_PAGE_LIMIT) {
+				t.Fatalf(
+					"got %d links after %s (sort_by %s), want %d",
+					len(links_after),
+					link.ID,
+					sort_by,
+					len(all_links)-i-1,
+				)
+			} else if len(links_after) > 0 && links_after[0].ID != all_links[i+1].ID {
+				t.Fatalf(
+					"got link %s after %s (sort_by %s), want %s",
+					links_after[0].ID,
+					link.ID,
+					sort_by,
+					all_links[i+1].ID,
+				)
+			}
+		}
+	}
+}
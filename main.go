@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
 	"net/http"
 	"os"
-
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -12,15 +16,13 @@ import (
 	"github.com/go-chi/httprate"
 	"github.com/go-chi/jwtauth/v5"
 
+	"github.com/julianlk522/fitm/config"
+	"github.com/julianlk522/fitm/db"
 	h "github.com/julianlk522/fitm/handler"
+	util "github.com/julianlk522/fitm/handler/util"
 	m "github.com/julianlk522/fitm/middleware"
 )
 
-const (
-	API_URL = "api.fitm.online:1999"
-	// TEST_API_URL = "localhost:1999"
-)
-
 var token_auth *jwtauth.JWTAuth
 
 func init() {
@@ -28,17 +30,36 @@ func init() {
 }
 
 func main() {
-	r := chi.NewRouter()
-	defer func() {
-		if err := http.ListenAndServeTLS(
-		API_URL,
-			"/etc/letsencrypt/live/api.fitm.online/fullchain.pem",
-			"/etc/letsencrypt/live/api.fitm.online/privkey.pem",
-			r,
-		); err != nil {
+	cfg := config.Current
+
+	// COMMANDS
+	// (run instead of serving)
+	reconcile_spellfix := flag.Bool(
+		"reconcile-spellfix",
+		false,
+		"rebuild global_cats_spellfix from Links.global_cats, then exit",
+	)
+	dry_run := flag.Bool(
+		"dry-run",
+		false,
+		"with -reconcile-spellfix: only report drift",
+	)
+	flag.Parse()
+
+	if *reconcile_spellfix {
+		reconciliation, err := util.ReconcileSpellfix(*dry_run)
+		if err != nil {
 			log.Fatal(err)
 		}
-	}()
+		util.LogSpellfixReconciliation(reconciliation)
+
+		if err = db.Close(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	r := chi.NewRouter()
 
 	// ROUTER-WIDE MIDDLEWARE
 	// LOGGER
@@ -51,23 +72,32 @@ func main() {
 	r.Use(m.SplitRequestLogger(m.FileLogFormatter))
 
 	// RATE LIMIT
+	// (limits <= 0 are disabled)
 	// overall
-	r.Use(httprate.LimitAll(
-		4000,
-		time.Minute,
-	))
+	if cfg.RateLimits.AllPerMinute > 0 {
+		r.Use(httprate.LimitAll(
+			cfg.RateLimits.AllPerMinute,
+			time.Minute,
+		))
+	}
 	// by IP
-	r.Use(httprate.LimitByIP(
-		2400,
-		time.Minute,
-	))
-	r.Use(httprate.LimitByIP(
-		100,
-		time.Second,
-	))
+	if cfg.RateLimits.IPPerMinute > 0 {
+		r.Use(httprate.LimitByIP(
+			cfg.RateLimits.IPPerMinute,
+			time.Minute,
+		))
+	}
+	if cfg.RateLimits.IPPerSecond > 0 {
+		r.Use(httprate.LimitByIP(
+			cfg.RateLimits.IPPerSecond,
+			time.Second,
+		))
+	}
 
 	// CORS
+	// (no configured origins: allow all)
 	r.Use(cors.Handler(cors.Options{
+		AllowedOrigins: cfg.CORSOrigins,
 		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowedHeaders: []string{
 			"Authorization",
@@ -84,10 +114,30 @@ func main() {
 	r.Post("/reset-password", h.ResetPassword)
 
 	r.Get("/pic/preview/{file_name}", h.GetPreviewImg)
-	r.Get("/cats", h.GetTopGlobalCats)
+	r.Get("/cats/graph", h.GetCatGraph)
+	r.Get("/cats/aliases", h.GetCatAliases)
+	r.Get("/cats/parents", h.GetCatParents)
 	r.Get("/cats/*", h.GetSpellfixMatchesForSnippet)
-	r.Get("/contributors", h.GetTopContributors)
-	r.Get("/totals", h.GetTotals)
+	r.Get("/links/{link_id}/ingest-status", h.GetLinkIngestStatus)
+	r.Get("/links/{link_id}/health", h.GetLinkHealth)
+
+	// RESPONSE CACHE
+	// (anonymous reads; cleared by writes: see InvalidatesResponseCache)
+	cache_responses := m.CacheAnonymousResponses(
+		time.Duration(cfg.ResponseCacheSeconds) * time.Second,
+	)
+	r.With(cache_responses).Get("/cats", h.GetTopGlobalCats)
+	r.With(cache_responses).Get("/contributors", h.GetTopContributors)
+	r.With(cache_responses).Get("/totals", h.GetTotals)
+
+	// FEEDS
+	// (Atom; newest first)
+	r.With(cache_responses).Get("/feeds/links.atom", h.GetLinksFeed)
+	r.With(cache_responses).Get("/feeds/map/{login_name}.atom", h.GetTmapFeed)
+
+	// EXPORTS
+	// (whole tmap, streamed: not cached)
+	r.Get("/map/{login_name}/export", h.ExportTreasureMap)
 
 	// CD webhook: application update and refresh
 	r.Post("/ghwh", h.HandleGitHubWebhook)
@@ -102,15 +152,19 @@ func main() {
 
 		r.Get("/map/{login_name}", h.GetTreasureMap)
 		r.Get("/summaries/{link_id}", h.GetSummaryPage)
+		r.Get("/summaries/{summary_id}/history", h.GetSummaryHistory)
 		r.Get("/tags/{link_id}", h.GetTagPage)
+		r.Get("/tags/{link_id}/history", h.GetTagHistory)
+		r.Get("/tags/{link_id}/global-cats/dry-run", h.GetGlobalCatsDryRun)
 
 		r.
-			With(m.Pagination).
+			With(m.Pagination, cache_responses).
 			Get("/links", h.GetLinks)
 
-		r.
-			With(httprate.Limit(
-				2,
+		click_limit := func(next http.Handler) http.Handler { return next }
+		if cfg.RateLimits.ClicksPerSecond > 0 {
+			click_limit = httprate.Limit(
+				cfg.RateLimits.ClicksPerSecond,
 				time.Second,
 				httprate.WithKeyFuncs(func(r *http.Request) (string, error) {
 					user_id := r.Context().Value(m.JWTClaimsKey).(map[string]any)["user_id"].(string)
@@ -120,7 +174,12 @@ func main() {
 
 					return httprate.KeyByIP(r)
 				}),
-			)).
+			)
+		}
+		// doesn't invalidate the response cache: cached click counts are
+		// allowed to be stale for its TTL
+		r.
+			With(click_limit).
 			Post("/click", h.ClickLink)
 	})
 
@@ -130,6 +189,7 @@ func main() {
 		r.Use(jwtauth.Verifier(token_auth))
 		r.Use(jwtauth.Authenticator(token_auth))
 		r.Use(m.JWTContext)
+		r.Use(m.InvalidatesResponseCache)
 
 		// Users
 		r.Put("/about", h.EditAbout)
@@ -139,6 +199,7 @@ func main() {
 
 		// Links
 		r.Post("/links", h.AddLink)
+		r.Post("/links/import", h.ImportLinks)
 		r.Delete("/links", h.DeleteLink)
 		r.Post("/links/{link_id}/like", h.LikeLink)
 		r.Delete("/links/{link_id}/like", h.UnlikeLink)
@@ -150,10 +211,148 @@ func main() {
 		r.Put("/tags", h.EditTag)
 		r.Delete("/tags", h.DeleteTag)
 
+		// Cat Aliases (admins only)
+		r.With(m.AdminOnly).Post("/cats/aliases", h.AddCatAlias)
+		r.With(m.AdminOnly).Delete("/cats/aliases", h.DeleteCatAlias)
+
+		// Cat Parents (admins only)
+		r.With(m.AdminOnly).Put("/cats/parents", h.SetCatParent)
+		r.With(m.AdminOnly).Delete("/cats/parents", h.DeleteCatParent)
+
+		// Recompute global cats, global summaries and spellfix ranks
+		// (admins only)
+		r.With(m.AdminOnly).Get("/recompute", h.GetRecomputeStatus)
+		r.With(m.AdminOnly).Post("/recompute", h.TriggerRecompute)
+
+		// Spellfix consistency (admins only)
+		r.With(m.AdminOnly).Get("/spellfix/drift", h.GetSpellfixDrift)
+		r.With(m.AdminOnly).Post("/spellfix/reconcile", h.ReconcileSpellfix)
+
 		// Summaries
 		r.Post("/summaries", h.AddSummary)
+		r.Put("/summaries", h.EditSummary)
 		r.Delete("/summaries", h.DeleteSummary)
+		r.Post("/summaries/{summary_id}/restore/{rev_id}", h.RestoreSummaryRevision)
 		r.Post("/summaries/{summary_id}/like", h.LikeSummary)
 		r.Delete("/summaries/{summary_id}/like", h.UnlikeSummary)
 	})
+
+	// GLOBAL CATS STRATEGY
+	if _, err := util.GetGlobalCatsStrategy(cfg.GlobalCatsStrategy); err != nil {
+		log.Fatalf("%s: %q", err, cfg.GlobalCatsStrategy)
+	}
+
+	// CAT ALIASES AND PARENTS
+	// (kept in memory so request binding and cat filters can apply them)
+	if err := util.LoadCatAliases(db.Client); err != nil {
+		log.Fatal(err)
+	}
+	if err := util.LoadCatParents(); err != nil {
+		log.Fatal(err)
+	}
+
+	// SPELLFIX
+	if cfg.ReconcileSpellfixOnStartup {
+		reconciliation, err := util.ReconcileSpellfix(false)
+		if err != nil {
+			log.Fatal(err)
+		}
+		util.LogSpellfixReconciliation(reconciliation)
+	}
+
+	// SERVE
+	srv := &http.Server{
+		Addr:    cfg.ListenAddr,
+		Handler: r,
+	}
+
+	ctx, stop := signal.NotifyContext(
+		context.Background(),
+		syscall.SIGINT,
+		syscall.SIGTERM,
+	)
+	defer stop()
+
+	// background workers return once ctx is done and are waited for
+	// before the DB is closed
+	var workers sync.WaitGroup
+	run_worker := func(run func()) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run()
+		}()
+	}
+
+	// RECOMPUTE SCHEDULER
+	if cfg.RecomputeIntervalMinutes > 0 {
+		run_worker(func() {
+			util.RunRecomputeScheduler(
+				ctx,
+				time.Duration(cfg.RecomputeIntervalMinutes)*time.Minute,
+			)
+		})
+	}
+
+	// NEW LINKS METADATA
+	// (see util.AddLinkAndQueueIngestJob)
+	run_worker(func() { util.RunIngestWorker(ctx) })
+
+	// LINK CHECKER
+	// (re-fetches links to update their health)
+	if cfg.LinkCheckIntervalHours > 0 {
+		run_worker(func() {
+			util.RunLinkChecker(
+				ctx,
+				time.Duration(cfg.LinkCheckIntervalHours)*time.Hour,
+				time.Duration(cfg.LinkCheckHostDelaySeconds)*time.Second,
+			)
+		})
+	}
+
+	go func() {
+		var err error
+		if cfg.TLS {
+			log.Printf("Listening on %s (TLS)", cfg.ListenAddr)
+			err = srv.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			log.Printf("Listening on %s", cfg.ListenAddr)
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	// restore default signal behavior: a second signal kills immediately
+	stop()
+
+	// SHUTDOWN
+	// stop accepting connections and wait for in-flight requests
+	// (e.g., AddLink transactions, preview img writes) to finish
+	log.Print("Shutting down, draining in-flight requests")
+	shutdown_ctx, cancel := context.WithTimeout(
+		context.Background(),
+		time.Duration(cfg.ShutdownTimeoutSeconds)*time.Second,
+	)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdown_ctx); err != nil {
+		log.Printf("Could not drain all requests: %s", err)
+	}
+
+	// an ingest job finishes the fetch it is on; link checks are canceled.
+	// Waiting for the scheduler first means no recompute starts after
+	// StopRecompute.
+	workers.Wait()
+
+	// a running recompute stops after its current batch
+	util.StopRecompute()
+
+	if err := db.Close(); err != nil {
+		log.Printf("Could not close DB: %s", err)
+	}
+
+	log.Print("Shutdown complete")
 }
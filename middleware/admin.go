@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/go-chi/render"
+
+	"github.com/julianlk522/fitm/config"
+	e "github.com/julianlk522/fitm/error"
+)
+
+// Must follow JWTContext
+func AdminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := r.Context().Value(JWTClaimsKey).(map[string]any)
+		login_name, _ := claims["login_name"].(string)
+
+		if login_name == "" || !slices.Contains(config.Current.AdminLoginNames, login_name) {
+			render.Render(w, r, e.ErrUnauthorized(e.ErrNotAdmin))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
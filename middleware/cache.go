@@ -0,0 +1,209 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// When full, expired entries are dropped first and then all of them
+const RESPONSE_CACHE_MAX_ENTRIES = 1000
+
+type cachedResponse struct {
+	Status      int
+	ContentType string
+	Body        []byte
+	ETag        string
+	Expires     time.Time
+}
+
+// Anonymous GET responses keyed by path + normalized params.
+// generation is bumped on every invalidation so responses computed
+// before a write are not stored after it.
+var response_cache = struct {
+	sync.Mutex
+	entries    map[string]cachedResponse
+	generation uint64
+}{
+	entries: map[string]cachedResponse{},
+}
+
+func InvalidateResponseCache() {
+	response_cache.Lock()
+	defer response_cache.Unlock()
+
+	clear(response_cache.entries)
+	response_cache.generation++
+}
+
+// Caches successful GET responses for requests without a signed-in user
+// (for routes using JWTContext it must follow it) for up to ttl, and
+// answers matching If-None-Match with 304. ttl <= 0 disables caching
+// but ETags are still sent.
+func CacheAnonymousResponses(ttl time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet || IsSignedIn(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := GetResponseCacheKey(r)
+
+			response_cache.Lock()
+			cached, ok := response_cache.entries[key]
+			generation := response_cache.generation
+			response_cache.Unlock()
+
+			if ok && time.Now().Before(cached.Expires) {
+				WriteCachedResponse(w, r, cached)
+				return
+			}
+
+			rec := &bufferedResponseWriter{
+				ResponseWriter: w,
+				status:         http.StatusOK,
+			}
+			next.ServeHTTP(rec, r)
+
+			if rec.status != http.StatusOK {
+				w.WriteHeader(rec.status)
+				w.Write(rec.body.Bytes())
+				return
+			}
+
+			cached = cachedResponse{
+				Status:      rec.status,
+				ContentType: w.Header().Get("Content-Type"),
+				Body:        rec.body.Bytes(),
+				ETag:        GetETag(rec.body.Bytes()),
+				Expires:     time.Now().Add(ttl),
+			}
+			if ttl > 0 {
+				StoreCachedResponse(key, cached, generation)
+			}
+
+			WriteCachedResponse(w, r, cached)
+		})
+	}
+}
+
+// Clears cached responses after successful non-GET requests
+func InvalidatesResponseCache(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		if ww.Status() < 400 {
+			InvalidateResponseCache()
+		}
+	})
+}
+
+func IsSignedIn(r *http.Request) bool {
+	claims, _ := r.Context().Value(JWTClaimsKey).(map[string]any)
+	user_id, _ := claims["user_id"].(string)
+
+	return user_id != ""
+}
+
+// Path + params sorted by key, with empty params dropped
+// (e.g. "?cats=&sort_by=newest" is the same as "?sort_by=newest")
+func GetResponseCacheKey(r *http.Request) string {
+	params := r.URL.Query()
+	for key, values := range params {
+		if strings.Join(values, "") == "" {
+			params.Del(key)
+		}
+	}
+
+	return r.URL.Path + "?" + params.Encode()
+}
+
+func GetETag(body []byte) string {
+	return fmt.Sprintf(`"%x"`, sha256.Sum256(body))
+}
+
+// Not stored if the cache was invalidated after generation was read
+func StoreCachedResponse(key string, cached cachedResponse, generation uint64) {
+	response_cache.Lock()
+	defer response_cache.Unlock()
+
+	if response_cache.generation != generation {
+		return
+	}
+
+	if len(response_cache.entries) >= RESPONSE_CACHE_MAX_ENTRIES {
+		now := time.Now()
+		for k, entry := range response_cache.entries {
+			if !now.Before(entry.Expires) {
+				delete(response_cache.entries, k)
+			}
+		}
+
+		if len(response_cache.entries) >= RESPONSE_CACHE_MAX_ENTRIES {
+			clear(response_cache.entries)
+		}
+	}
+
+	response_cache.entries[key] = cached
+}
+
+// Clients must revalidate (no-cache) so they see writes right away
+func WriteCachedResponse(w http.ResponseWriter, r *http.Request, cached cachedResponse) {
+	w.Header().Set("ETag", cached.ETag)
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if ETagMatches(r.Header.Get("If-None-Match"), cached.ETag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if cached.ContentType != "" {
+		w.Header().Set("Content-Type", cached.ContentType)
+	}
+	w.WriteHeader(cached.Status)
+	w.Write(cached.Body)
+}
+
+// if_none_match may list several (possibly weak) ETags or be "*"
+func ETagMatches(if_none_match string, etag string) bool {
+	if if_none_match == "" {
+		return false
+	}
+
+	for _, candidate := range strings.Split(if_none_match, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Holds the body so its ETag can be set before anything is sent
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (brw *bufferedResponseWriter) WriteHeader(status int) {
+	brw.status = status
+}
+
+func (brw *bufferedResponseWriter) Write(b []byte) (int, error) {
+	return brw.body.Write(b)
+}
@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCacheAnonymousResponses(t *testing.T) {
+	InvalidateResponseCache()
+
+	var calls int
+	cached := CacheAnonymousResponses(time.Minute)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"cats":"` + r.URL.Query().Get("cats") + `"}`))
+		}),
+	)
+	get := func(target string, if_none_match string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if if_none_match != "" {
+			r.Header.Set("If-None-Match", if_none_match)
+		}
+		w := httptest.NewRecorder()
+		cached.ServeHTTP(w, r)
+		return w
+	}
+
+	first := get("/links?cats=go&sort_by=newest", "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("got status %d, ETag %q, want 200 with ETag", first.Code, etag)
+	}
+
+	// same params in another order, plus an empty one
+	second := get("/links?sort_by=newest&page=&cats=go", "")
+	if calls != 1 {
+		t.Fatalf("got %d handler calls, want 1 (cached)", calls)
+	} else if second.Body.String() != first.Body.String() ||
+		second.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("got cached response %q, want %q", second.Body.String(), first.Body.String())
+	}
+
+	if w := get("/links?cats=go&sort_by=newest", etag); w.Code != http.StatusNotModified {
+		t.Fatalf("got status %d with matching If-None-Match, want 304", w.Code)
+	} else if w.Body.Len() != 0 {
+		t.Fatal("got body with 304")
+	}
+	if w := get("/links?cats=go&sort_by=newest", `"stale"`); w.Code != http.StatusOK {
+		t.Fatalf("got status %d with stale If-None-Match, want 200", w.Code)
+	}
+
+	get("/links?cats=rust", "")
+	if calls != 2 {
+		t.Fatalf("got %d handler calls, want 2 (different params)", calls)
+	}
+
+	// signed-in users skip the cache
+	r := httptest.NewRequest(http.MethodGet, "/links?cats=go&sort_by=newest", nil)
+	r = r.WithContext(context.WithValue(
+		r.Context(),
+		JWTClaimsKey,
+		map[string]any{"user_id": "1234"},
+	))
+	cached.ServeHTTP(httptest.NewRecorder(), r)
+	if calls != 3 {
+		t.Fatalf("got %d handler calls, want 3 (signed in)", calls)
+	}
+
+	// failed writes keep the cache, successful ones clear it
+	for _, status := range []int{http.StatusBadRequest, http.StatusOK} {
+		write := InvalidatesResponseCache(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(status)
+			}),
+		)
+		write.ServeHTTP(
+			httptest.NewRecorder(),
+			httptest.NewRequest(http.MethodPost, "/links", nil),
+		)
+	}
+	get("/links?cats=go&sort_by=newest", "")
+	if calls != 4 {
+		t.Fatalf("got %d handler calls, want 4 (invalidated)", calls)
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	var test_cases = []struct {
+		IfNoneMatch string
+		Matches     bool
+	}{
+		{"", false},
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"xyz", "abc"`, true},
+		{"*", true},
+		{`"xyz"`, false},
+		{`abc`, false},
+	}
+
+	for _, tc := range test_cases {
+		if got := ETagMatches(tc.IfNoneMatch, `"abc"`); got != tc.Matches {
+			t.Fatalf("got %t for %q, want %t", got, tc.IfNoneMatch, tc.Matches)
+		}
+	}
+}
@@ -91,7 +91,8 @@ func (sle *SplitLogEntry) Write(status, bytes int, header http.Header, elapsed t
 		)
 
 		// save errors
-	} else if status > 299 {
+		// (304s are cache hits, see CacheAnonymousResponses)
+	} else if status > 299 && status != http.StatusNotModified {
 		status_text := "Unknown Error"
 		if crw, ok := extra.(*ResponseWriterWithStatusText); ok {
 			status_text = crw.StatusText
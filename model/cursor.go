@@ -0,0 +1,74 @@
+package model
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	e "github.com/julianlk522/fitm/error"
+)
+
+// Opaque position in a sorted list of links: the sort key values of the
+// last link seen. Unlike page numbers, pages after a cursor don't shift
+// when links ahead of it gain likes or new links are submitted.
+type LinksCursor struct {
+	SortBy       string `json:"s"`
+	LikeCount    int64  `json:"l"`
+	CopyCount    int    `json:"c"`
+	ClickCount   int64  `json:"k"`
+	TagCount     int    `json:"t"`
+	SummaryCount int    `json:"m"`
+	SubmitDate   string `json:"d"`
+	ID           string `json:"i"`
+}
+
+// "" means "rating"; relevance (search rank) can't be resumed from
+func GetCursorSortBy(sort_by string) (string, error) {
+	switch sort_by {
+	case "", "rating":
+		return "rating", nil
+	case "newest":
+		return "newest", nil
+	case "relevance":
+		return "", e.ErrCursorWithRelevance
+	default:
+		return "", e.ErrInvalidSortByParams
+	}
+}
+
+func NewLinksCursor(sort_by string, link Link) *LinksCursor {
+	return &LinksCursor{
+		SortBy:       sort_by,
+		LikeCount:    link.LikeCount,
+		CopyCount:    link.CopyCount,
+		ClickCount:   link.ClickCount,
+		TagCount:     link.TagCount,
+		SummaryCount: link.SummaryCount,
+		SubmitDate:   link.SubmitDate,
+		ID:           link.ID,
+	}
+}
+
+func (lc *LinksCursor) Encode() string {
+	b, _ := json.Marshal(lc)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Cursors only resume the sort they were made for
+func DecodeLinksCursor(token string, sort_by string) (*LinksCursor, error) {
+	sort_by, err := GetCursorSortBy(sort_by)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, e.ErrInvalidCursor
+	}
+
+	var lc LinksCursor
+	if err = json.Unmarshal(b, &lc); err != nil || lc.ID == "" || lc.SortBy != sort_by {
+		return nil, e.ErrInvalidCursor
+	}
+
+	return &lc, nil
+}
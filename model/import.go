@@ -0,0 +1,52 @@
+package model
+
+// Bookmark parsed from an imported file (see handler/util
+// ParseBookmarks). Cats come from its folders and tags.
+type ImportedBookmark struct {
+	URL   string
+	Title string
+	Cats  []string
+}
+
+// LinkImportResult.Status
+const (
+	// new link submitted by the importing user
+	LINK_IMPORT_ADDED = "added"
+	// link already submitted by someone else: copied to the user's tmap
+	LINK_IMPORT_COPIED = "copied"
+	// nothing to do: user already submitted or copied the link, or the
+	// URL came up earlier in the same file
+	LINK_IMPORT_SKIPPED = "skipped"
+	LINK_IMPORT_FAILED  = "failed"
+)
+
+type LinkImportResult struct {
+	URL    string
+	Status string
+	LinkID string `json:",omitempty"`
+	// why the bookmark was skipped or failed
+	Reason string `json:",omitempty"`
+}
+
+type LinkImport struct {
+	Results []LinkImportResult
+	Added   int
+	Copied  int
+	Skipped int
+	Failed  int
+}
+
+func (li *LinkImport) Add(result LinkImportResult) {
+	li.Results = append(li.Results, result)
+
+	switch result.Status {
+	case LINK_IMPORT_ADDED:
+		li.Added++
+	case LINK_IMPORT_COPIED:
+		li.Copied++
+	case LINK_IMPORT_SKIPPED:
+		li.Skipped++
+	case LINK_IMPORT_FAILED:
+		li.Failed++
+	}
+}
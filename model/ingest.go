@@ -0,0 +1,37 @@
+package model
+
+// IngestJob.Status
+const (
+	INGEST_JOB_PENDING = "pending"
+	INGEST_JOB_RUNNING = "running"
+	INGEST_JOB_DONE    = "done"
+	// out of attempts: the URL never resolved, so the link is deleted
+	INGEST_JOB_FAILED = "failed"
+	// URL resolved to another link's (DuplicateOf): the link is deleted
+	INGEST_JOB_DUPLICATE = "duplicate"
+)
+
+// Background fetch of a new link's metadata (see handler/util
+// RunIngestWorker)
+type IngestJob struct {
+	ID     string
+	LinkID string
+	// as submitted
+	URL      string
+	Status   string
+	Attempts int
+	// last failed attempt's
+	Error       string `json:",omitempty"`
+	DuplicateOf string `json:",omitempty"`
+	// by the job's outcome
+	LinkDeleted bool
+	Created     string
+	Updated     string
+}
+
+// AddLink response: the auto summary and preview image are added later
+// by IngestJob
+type NewLinkIngest struct {
+	*NewLink
+	IngestJob *IngestJob
+}
@@ -32,6 +32,11 @@ type Link struct {
 	ClickCount         int64
 	TagCount           int
 	PreviewImgFilename string
+	// latest link check's status (see LINK_HEALTH_*)
+	Health string
+	// only set for search (q=) results: HTML-escaped with matched
+	// terms in <mark> tags
+	SearchSnippet string `json:",omitempty"`
 }
 
 func (l Link) GetCats() string {
@@ -51,6 +56,8 @@ func (lsi LinkSignedIn) GetCats() string {
 type LinksPageOptions struct {
 	Cats string
 	NSFW bool
+	// for NextCursor
+	SortBy string
 }
 
 type LinksPage[T Link | LinkSignedIn] struct {
@@ -58,6 +65,9 @@ type LinksPage[T Link | LinkSignedIn] struct {
 	NSFWLinks int
 	MergedCats []string
 	Pages   int
+	// pass as cursor= params for the following links; empty on the
+	// last page or when sorted by relevance
+	NextCursor string
 }
 
 type Contributor struct {
@@ -107,6 +117,7 @@ func (nlr *NewLinkRequest) Bind(r *http.Request) error {
 		nlr.Summary = strings.ReplaceAll(nlr.Summary, "\"", "'")
 	}
 
+	nlr.Cats = util.ResolveCatAliases(nlr.Cats)
 	nlr.LinkID = uuid.New().String()
 	nlr.SubmitDate = util.NEW_LONG_TIMESTAMP()
 
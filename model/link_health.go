@@ -0,0 +1,53 @@
+package model
+
+// LinkCheck.Status and Link.Health
+const (
+	LINK_HEALTH_OK = "ok"
+	// works, but ends up at a different URL
+	LINK_HEALTH_REDIRECTED = "redirected"
+	// 404 or 410
+	LINK_HEALTH_NOT_FOUND = "not_found"
+	// any other 4xx or 5xx (401 and 403 count as ok: the page exists)
+	LINK_HEALTH_HTTP_ERROR = "http_error"
+	LINK_HEALTH_TLS_ERROR  = "tls_error"
+	LINK_HEALTH_TIMEOUT    = "timeout"
+	// DNS lookup failed, connection refused, etc.
+	LINK_HEALTH_UNREACHABLE = "unreachable"
+	// Link.Health only: not checked yet
+	LINK_HEALTH_UNCHECKED = "unchecked"
+)
+
+// health= params groups
+var (
+	LINK_HEALTH_ALIVE = []string{
+		LINK_HEALTH_OK,
+		LINK_HEALTH_REDIRECTED,
+	}
+	LINK_HEALTH_DEAD = []string{
+		LINK_HEALTH_NOT_FOUND,
+		LINK_HEALTH_HTTP_ERROR,
+		LINK_HEALTH_TLS_ERROR,
+		LINK_HEALTH_TIMEOUT,
+		LINK_HEALTH_UNREACHABLE,
+	}
+)
+
+type LinkCheck struct {
+	LinkID  string `json:"-"`
+	Checked string
+	Status  string
+	// 0 if there was no response
+	StatusCode int    `json:",omitempty"`
+	FinalURL   string `json:",omitempty"`
+	Error      string `json:",omitempty"`
+}
+
+type LinkHealth struct {
+	LinkID string
+	Health string
+	// empty if unchecked
+	LastChecked string `json:",omitempty"`
+	NextCheck   string `json:",omitempty"`
+	// newest first
+	Checks []LinkCheck
+}
@@ -0,0 +1,15 @@
+package model
+
+// Progress of the latest recompute of global cats, global summaries and
+// spellfix ranks for all links
+type RecomputeStatus struct {
+	Running                bool
+	StartedAt              string
+	FinishedAt             string
+	LinksTotal             int
+	LinksDone              int
+	GlobalCatsChanged      int
+	GlobalSummariesChanged int
+	FailedBatches          int
+	LastError              string
+}
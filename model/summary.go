@@ -29,6 +29,31 @@ type SummaryPage[S SummarySignedIn | Summary, L LinkSignedIn | Link] struct {
 	Summaries []S
 }
 
+type SummaryRevision struct {
+	ID          string
+	Text        string
+	SubmittedBy string
+	LastUpdated string
+	Replaced    string
+	// changes from this revision to the version that replaced it
+	Diff []DiffChunk
+}
+
+type SummaryHistory struct {
+	SummaryID   string
+	LinkID      string
+	Text        string
+	SubmittedBy string
+	LastUpdated string
+	Revisions   []SummaryRevision
+}
+
+// Op is "=" (unchanged), "+" (added) or "-" (removed)
+type DiffChunk struct {
+	Op   string
+	Text string
+}
+
 type NewSummaryRequest struct {
 	ID          string
 	LinkID      string `json:"link_id"`
@@ -70,8 +95,10 @@ func (dsr *DeleteSummaryRequest) Bind(r *http.Request) error {
 }
 
 type EditSummaryRequest struct {
-	SummaryID string `json:"summary_id"`
-	Text      string `json:"text"`
+	SummaryID   string `json:"summary_id"`
+	Text        string `json:"text"`
+	LastUpdated string
+	LikesReset  bool
 }
 
 func (esr *EditSummaryRequest) Bind(r *http.Request) error {
@@ -88,5 +115,8 @@ func (esr *EditSummaryRequest) Bind(r *http.Request) error {
 		esr.Text = strings.ReplaceAll(esr.Text, "\"", "'")
 	}
 
+	esr.LastUpdated = util.NEW_LONG_TIMESTAMP()
+	esr.LikesReset = false
+
 	return nil
 }
@@ -23,6 +23,19 @@ type CatCount struct {
 	Count    int32
 }
 
+type CatGraph struct {
+	Nodes []CatCount
+	Edges []CatGraphEdge
+}
+
+// Source and Target are in alphabetical order; Weight is the number of
+// links carrying both cats
+type CatGraphEdge struct {
+	Source string
+	Target string
+	Weight int32
+}
+
 func SortCats(i, j CatCount) int {
 	if i.Count > j.Count {
 		return -1
@@ -43,6 +56,31 @@ type TagRankingPublic struct {
 	LastUpdated string
 }
 
+// A tag as one vote toward its link's global cats
+type TagVote struct {
+	TagRanking
+	SubmittedBy string
+	// days since last_updated
+	AgeDays float32
+	// likes received on all links the tag's submitter has submitted
+	SubmitterLikeCount int
+}
+
+// What each global cats scoring strategy would produce for a link,
+// without saving
+type GlobalCatsDryRun struct {
+	LinkID         string
+	GlobalCats     string
+	ActiveStrategy string
+	Results        []GlobalCatsStrategyResult
+}
+
+type GlobalCatsStrategyResult struct {
+	Strategy   string
+	GlobalCats string
+	Scores     map[string]float32
+}
+
 type CatRanking struct {
 	Cat   string
 	Score float32
@@ -53,6 +91,27 @@ type GlobalCatsDiff struct {
 	Removed []string
 }
 
+type TagRevision struct {
+	ID          string
+	TagID       string
+	Cats        string
+	SubmittedBy string
+	LastUpdated string
+	Replaced    string
+}
+
+type GlobalCatsTransition struct {
+	GlobalCats string
+	GlobalCatsDiff
+	Timestamp string
+}
+
+type TagHistory struct {
+	LinkID       string
+	TagRevisions []TagRevision
+	GlobalCats   []GlobalCatsTransition
+}
+
 type TagPage[T Link | LinkSignedIn] struct {
 	Link        *T
 	UserTag     *Tag
@@ -89,6 +148,7 @@ func (ntr *NewTagRequest) Bind(r *http.Request) error {
 	ntr.ID = uuid.New().String()
 	ntr.NewTag.Cats = util.CapitalizeNSFWCatIfNotAlready(ntr.NewTag.Cats)
 	ntr.Cats = util.TrimExcessAndTrailingSpaces(ntr.NewTag.Cats)
+	ntr.Cats = util.ResolveCatAliases(ntr.Cats)
 	ntr.LastUpdated = util.NEW_LONG_TIMESTAMP()
 
 	return nil
@@ -118,6 +178,7 @@ func (etr *EditTagRequest) Bind(r *http.Request) error {
 
 	etr.Cats = util.CapitalizeNSFWCatIfNotAlready(etr.Cats)
 	etr.Cats = util.TrimExcessAndTrailingSpaces(etr.Cats)
+	etr.Cats = util.ResolveCatAliases(etr.Cats)
 	etr.LastUpdated = util.NEW_LONG_TIMESTAMP()
 
 	return nil
@@ -134,3 +195,132 @@ func (dtr *DeleteTagRequest) Bind(r *http.Request) error {
 
 	return nil
 }
+
+type CatAlias struct {
+	Alias     string
+	Cat       string
+	CreatedBy string
+	Created   string
+}
+
+type NewCatAliasRequest struct {
+	Alias   string `json:"alias"`
+	Cat     string `json:"cat"`
+	Created string
+}
+
+func (ncar *NewCatAliasRequest) Bind(r *http.Request) error {
+	ncar.Alias = util.TrimExcessAndTrailingSpaces(ncar.Alias)
+	ncar.Cat = util.TrimExcessAndTrailingSpaces(ncar.Cat)
+
+	switch {
+	case ncar.Alias == "":
+		return e.ErrNoCatAlias
+	case ncar.Cat == "":
+		return e.ErrNoCats
+	case strings.Contains(ncar.Alias, ",") || strings.Contains(ncar.Cat, ","):
+		return e.NumCatsExceedsLimit(1)
+	case util.HasTooLongCats(ncar.Alias) || util.HasTooLongCats(ncar.Cat):
+		return e.CatCharsExceedLimit(util.CAT_CHAR_LIMIT)
+	case strings.EqualFold(ncar.Alias, ncar.Cat):
+		return e.ErrCatAliasIsCat
+	case strings.EqualFold(ncar.Alias, "NSFW") || strings.EqualFold(ncar.Cat, "NSFW"):
+		return e.ErrCantAliasNSFW
+	}
+
+	ncar.Created = util.NEW_LONG_TIMESTAMP()
+
+	return nil
+}
+
+type DeleteCatAliasRequest struct {
+	Alias string `json:"alias"`
+}
+
+func (dcar *DeleteCatAliasRequest) Bind(r *http.Request) error {
+	if dcar.Alias == "" {
+		return e.ErrNoCatAlias
+	}
+
+	return nil
+}
+
+// Tags whose cats contained the alias and the links whose global cats
+// were recalculated as a result
+type CatAliasRewrite struct {
+	CatAlias
+	TagsRewritten     int
+	LinksRecalculated int
+}
+
+type CatParent struct {
+	Cat       string
+	Parent    string
+	CreatedBy string
+	Created   string
+}
+
+type SetCatParentRequest struct {
+	Cat     string `json:"cat"`
+	Parent  string `json:"parent"`
+	Created string
+}
+
+func (scpr *SetCatParentRequest) Bind(r *http.Request) error {
+	scpr.Cat = util.ResolveCatAlias(util.TrimExcessAndTrailingSpaces(scpr.Cat))
+	scpr.Parent = util.ResolveCatAlias(util.TrimExcessAndTrailingSpaces(scpr.Parent))
+
+	switch {
+	case scpr.Cat == "":
+		return e.ErrNoCats
+	case scpr.Parent == "":
+		return e.ErrNoCatParent
+	case strings.Contains(scpr.Cat, ",") || strings.Contains(scpr.Parent, ","):
+		return e.NumCatsExceedsLimit(1)
+	case util.HasTooLongCats(scpr.Cat) || util.HasTooLongCats(scpr.Parent):
+		return e.CatCharsExceedLimit(util.CAT_CHAR_LIMIT)
+	case strings.EqualFold(scpr.Cat, scpr.Parent):
+		return e.ErrCatParentIsCat
+	case util.CatParentWouldCycle(scpr.Cat, scpr.Parent):
+		return e.ErrCatParentCycle
+	}
+
+	scpr.Created = util.NEW_LONG_TIMESTAMP()
+
+	return nil
+}
+
+type DeleteCatParentRequest struct {
+	Cat string `json:"cat"`
+}
+
+func (dcpr *DeleteCatParentRequest) Bind(r *http.Request) error {
+	if dcpr.Cat == "" {
+		return e.ErrNoCats
+	}
+
+	return nil
+}
+
+// Count is the cat's own count (not including descendants)
+type CatTreeNode struct {
+	Category string
+	Count    int32
+	Children []CatTreeNode
+}
+
+// Words in global_cats_spellfix whose rows don't match Links.global_cats.
+// Rank is summed over Rows (0 if missing); ExpectedRank is the number of
+// links with the word in their global cats (0 if none).
+type SpellfixDrift struct {
+	Word         string
+	Rows         int
+	Rank         int
+	ExpectedRank int
+}
+
+type SpellfixReconciliation struct {
+	WordsChecked int
+	Drift        []SpellfixDrift
+	Fixed        bool
+}
@@ -75,6 +75,8 @@ type TmapSectionPage[T TmapLink | TmapLinkSignedIn] struct {
 	Cats           *[]CatCount
 	NSFWLinksCount int
 	Pages       int
+	// see LinksPage.NextCursor
+	NextCursor string
 }
 
 type TmapOptions struct {
@@ -90,8 +92,14 @@ type TmapOptions struct {
 	SortByNewest   bool
 	IncludeNSFW    bool
 	URLContains    string
+	// compiled from q= params by query.GetSearchMatchArg
+	SearchMatchArg string
+	// results ordered by search rank (default when searching)
+	SortByRelevance bool
 	Section        string
 	Page           int
+	// from cursor= params (single section only); replaces Page
+	Cursor *LinksCursor
 }
 
 type TmapNSFWLinksCountOptions struct {
@@ -99,8 +107,27 @@ type TmapNSFWLinksCountOptions struct {
 	CatsFilter []string
 	Period string
 	URLContains string
+	SearchMatchArg string
 }
 
 type TmapCatCountsOptions struct {
 	RawCatsParams string
 }
+
+// Export
+type TmapExportLink struct {
+	TmapLink
+	// Cats and Summary are the user's own if CatsFromUser / they wrote
+	// one, otherwise the same as these
+	GlobalCats    string
+	GlobalSummary string
+}
+
+// JSON export shape (written link by link: see handler/util ExportTmap)
+type TmapExport struct {
+	LoginName  string
+	ExportDate string
+	Submitted  []TmapExportLink
+	Copied     []TmapExportLink
+	Tagged     []TmapExportLink
+}
@@ -0,0 +1,59 @@
+package model
+
+import (
+	"strings"
+	"sync"
+)
+
+// In-memory copy of "Cat Aliases" (lowercased alias -> cat), so that
+// request binding and query building can apply aliases without the DB.
+// Loaded at startup and whenever an alias is added or removed.
+var (
+	cat_aliases    = map[string]string{}
+	cat_aliases_mu sync.RWMutex
+)
+
+func SetCatAliases(aliases map[string]string) {
+	lc_aliases := make(map[string]string, len(aliases))
+	for alias, cat := range aliases {
+		lc_aliases[strings.ToLower(alias)] = cat
+	}
+
+	cat_aliases_mu.Lock()
+	cat_aliases = lc_aliases
+	cat_aliases_mu.Unlock()
+}
+
+// Returns cat unchanged if it is not an alias
+func ResolveCatAlias(cat string) string {
+	cat_aliases_mu.RLock()
+	defer cat_aliases_mu.RUnlock()
+
+	if aliased, ok := cat_aliases[strings.ToLower(strings.TrimSpace(cat))]; ok {
+		return aliased
+	}
+
+	return cat
+}
+
+// Resolves each comma-separated cat and drops any duplicates that result
+// (e.g., "golang,go" -> "go")
+func ResolveCatAliases(cats string) string {
+	var resolved []string
+	for _, cat := range strings.Split(cats, ",") {
+		cat = ResolveCatAlias(cat)
+
+		is_duplicate := false
+		for _, r := range resolved {
+			if strings.EqualFold(r, cat) {
+				is_duplicate = true
+				break
+			}
+		}
+		if !is_duplicate {
+			resolved = append(resolved, cat)
+		}
+	}
+
+	return strings.Join(resolved, ",")
+}
@@ -0,0 +1,31 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestResolveCatAliases(t *testing.T) {
+	SetCatAliases(map[string]string{
+		"golang": "go",
+		"JS":     "javascript",
+	})
+	defer SetCatAliases(map[string]string{})
+
+	var test_cats = []struct {
+		Cats string
+		Want string
+	}{
+		{"golang", "go"},
+		{"Golang", "go"},
+		{"js,react", "javascript,react"},
+		{"go,golang,concurrency", "go,concurrency"},
+		{"golang,GO", "go"},
+		{"python", "python"},
+	}
+
+	for _, tc := range test_cats {
+		if got := ResolveCatAliases(tc.Cats); got != tc.Want {
+			t.Fatalf("got %s, want %s", got, tc.Want)
+		}
+	}
+}
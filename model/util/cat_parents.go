@@ -0,0 +1,80 @@
+package model
+
+import (
+	"strings"
+	"sync"
+)
+
+// In-memory copy of "Cat Parents" (lowercased cat -> parent), like
+// cat_aliases. Each cat has at most one parent so the cats form a forest.
+var (
+	cat_parents    = map[string]string{}
+	cat_children   = map[string][]string{}
+	cat_parents_mu sync.RWMutex
+)
+
+func SetCatParents(parents map[string]string) {
+	lc_parents := make(map[string]string, len(parents))
+	children := map[string][]string{}
+	for cat, parent := range parents {
+		lc_parents[strings.ToLower(cat)] = parent
+		lc_parent := strings.ToLower(parent)
+		children[lc_parent] = append(children[lc_parent], cat)
+	}
+
+	cat_parents_mu.Lock()
+	cat_parents = lc_parents
+	cat_children = children
+	cat_parents_mu.Unlock()
+}
+
+// Returns "" if cat has no parent
+func GetCatParent(cat string) string {
+	cat_parents_mu.RLock()
+	defer cat_parents_mu.RUnlock()
+
+	return cat_parents[strings.ToLower(strings.TrimSpace(cat))]
+}
+
+// Children, grandchildren, etc. (not including cat itself)
+func GetCatDescendants(cat string) []string {
+	cat_parents_mu.RLock()
+	defer cat_parents_mu.RUnlock()
+
+	var descendants []string
+	seen := map[string]bool{strings.ToLower(strings.TrimSpace(cat)): true}
+	queue := []string{strings.ToLower(strings.TrimSpace(cat))}
+
+	for len(queue) > 0 {
+		for _, child := range cat_children[queue[0]] {
+			lc_child := strings.ToLower(child)
+			if seen[lc_child] {
+				continue
+			}
+			seen[lc_child] = true
+			descendants = append(descendants, child)
+			queue = append(queue, lc_child)
+		}
+		queue = queue[1:]
+	}
+
+	return descendants
+}
+
+// Whether making parent the parent of cat would create a cycle, i.e., cat
+// is parent or one of its ancestors
+func CatParentWouldCycle(cat string, parent string) bool {
+	cat_parents_mu.RLock()
+	defer cat_parents_mu.RUnlock()
+
+	lc_cat := strings.ToLower(strings.TrimSpace(cat))
+	ancestor := strings.ToLower(strings.TrimSpace(parent))
+	for steps := 0; ancestor != "" && steps <= len(cat_parents); steps++ {
+		if ancestor == lc_cat {
+			return true
+		}
+		ancestor = strings.ToLower(cat_parents[ancestor])
+	}
+
+	return false
+}
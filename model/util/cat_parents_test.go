@@ -0,0 +1,56 @@
+package model
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestGetCatDescendants(t *testing.T) {
+	SetCatParents(map[string]string{
+		"goroutines": "go",
+		"channels":   "Go",
+		"select":     "channels",
+		"python":     "programming",
+	})
+	defer SetCatParents(map[string]string{})
+
+	var test_cats = []struct {
+		Cat  string
+		Want []string
+	}{
+		{"go", []string{"channels", "goroutines", "select"}},
+		{"GO", []string{"channels", "goroutines", "select"}},
+		{"channels", []string{"select"}},
+		{"select", nil},
+		{"rust", nil},
+	}
+
+	for _, tc := range test_cats {
+		got := GetCatDescendants(tc.Cat)
+		slices.Sort(got)
+		if !slices.Equal(got, tc.Want) {
+			t.Fatalf("got %v, want %v for cat %s", got, tc.Want, tc.Cat)
+		}
+	}
+
+	if got := GetCatParent("Select"); got != "channels" {
+		t.Fatalf("got parent %s, want channels", got)
+	}
+
+	var test_cycles = []struct {
+		Cat    string
+		Parent string
+		Cycle  bool
+	}{
+		{"go", "select", true},
+		{"go", "go", true},
+		{"channels", "goroutines", false},
+		{"go", "programming", false},
+	}
+
+	for _, tc := range test_cycles {
+		if got := CatParentWouldCycle(tc.Cat, tc.Parent); got != tc.Cycle {
+			t.Fatalf("got %t, want %t for %s under %s", got, tc.Cycle, tc.Cat, tc.Parent)
+		}
+	}
+}
@@ -14,6 +14,7 @@ const URL_CHAR_LIMIT = 200
 const SUMMARY_CHAR_LIMIT = 400
 
 // Links + Summaries
+// (links' are stored in "Link Stats": also set in migration 0008)
 const EARLIEST_LIKERS_AND_COPIERS_LIMIT = 10
 
 // Tag
@@ -0,0 +1,303 @@
+package query
+
+import (
+	"database/sql"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SQL fragment with a key so later methods can replace or drop it
+type clause struct {
+	Key  string
+	Text string
+}
+
+type clauses []clause
+
+// Replaces the clause with the same key in place, otherwise appends
+func (c *clauses) set(key string, text string) {
+	for i := range *c {
+		if (*c)[i].Key == key {
+			(*c)[i].Text = text
+			return
+		}
+	}
+	*c = append(*c, clause{key, text})
+}
+
+// Like set, but a new clause goes in front of the one with key before
+// (if present)
+func (c *clauses) setBefore(before string, key string, text string) {
+	if c.has(key) {
+		c.set(key, text)
+		return
+	}
+
+	i := slices.IndexFunc(*c, func(cl clause) bool {
+		return cl.Key == before
+	})
+	if i == -1 {
+		*c = append(*c, clause{key, text})
+		return
+	}
+	*c = slices.Insert(*c, i, clause{key, text})
+}
+
+func (c *clauses) remove(key string) {
+	*c = slices.DeleteFunc(*c, func(cl clause) bool {
+		return cl.Key == key
+	})
+}
+
+func (c clauses) has(key string) bool {
+	return slices.ContainsFunc(c, func(cl clause) bool {
+		return cl.Key == key
+	})
+}
+
+func (c clauses) texts() []string {
+	texts := make([]string, len(c))
+	for i, cl := range c {
+		texts[i] = cl.Text
+	}
+
+	return texts
+}
+
+// Structured SELECT statement. CTEs, fields, joins and WHERE conditions
+// are kept apart (and keyed) until Build, so filters can be added,
+// replaced or dropped in any order. Args are named: clauses reference
+// them as ":name" and only the ones referenced are bound.
+type SelectBuilder struct {
+	recursive bool
+	ctes      clauses
+	fields    clauses
+	from      string
+	joins     clauses
+	wheres    clauses
+	group_by  string
+	having    string
+	order_by  string
+	limit     bool
+	offset    bool
+	args      map[string]any
+}
+
+func NewSelectBuilder() *SelectBuilder {
+	return &SelectBuilder{
+		args: map[string]any{},
+	}
+}
+
+func (b *SelectBuilder) Recursive() *SelectBuilder {
+	b.recursive = true
+	return b
+}
+
+// name may include a column list, e.g. "Split(id, str)": it is keyed by
+// the part before it
+func (b *SelectBuilder) With(name string, body string) *SelectBuilder {
+	key, _, _ := strings.Cut(name, "(")
+	b.ctes.set(key, name+" AS ("+body+"\n)")
+	return b
+}
+
+func (b *SelectBuilder) Without(cte_name string) *SelectBuilder {
+	b.ctes.remove(cte_name)
+	return b
+}
+
+func (b *SelectBuilder) HasCTE(name string) bool {
+	return b.ctes.has(name)
+}
+
+func (b *SelectBuilder) Field(alias string, expr string) *SelectBuilder {
+	b.fields.set(alias, expr+" AS "+alias)
+	return b
+}
+
+// For fields that must stay in front of another one (e.g. so it can be
+// scanned last)
+func (b *SelectBuilder) FieldBefore(before string, alias string, expr string) *SelectBuilder {
+	b.fields.setBefore(before, alias, expr+" AS "+alias)
+	return b
+}
+
+func (b *SelectBuilder) WithoutField(alias string) *SelectBuilder {
+	b.fields.remove(alias)
+	return b
+}
+
+func (b *SelectBuilder) WithoutFields() *SelectBuilder {
+	b.fields = nil
+	return b
+}
+
+func (b *SelectBuilder) From(table string) *SelectBuilder {
+	b.from = table
+	return b
+}
+
+// key is normally the joined table's alias
+func (b *SelectBuilder) Join(key string, join string) *SelectBuilder {
+	b.joins.set(key, join)
+	return b
+}
+
+func (b *SelectBuilder) WithoutJoin(key string) *SelectBuilder {
+	b.joins.remove(key)
+	return b
+}
+
+// Conditions are ANDed together
+func (b *SelectBuilder) Where(key string, condition string) *SelectBuilder {
+	b.wheres.set(key, condition)
+	return b
+}
+
+func (b *SelectBuilder) WithoutWhere(key string) *SelectBuilder {
+	b.wheres.remove(key)
+	return b
+}
+
+func (b *SelectBuilder) HasWhere(key string) bool {
+	return b.wheres.has(key)
+}
+
+func (b *SelectBuilder) GroupBy(expr string) *SelectBuilder {
+	b.group_by = expr
+	return b
+}
+
+// Requires GroupBy
+func (b *SelectBuilder) Having(condition string) *SelectBuilder {
+	b.having = condition
+	return b
+}
+
+// "" removes ORDER BY
+func (b *SelectBuilder) OrderBy(expr string) *SelectBuilder {
+	b.order_by = expr
+	return b
+}
+
+func (b *SelectBuilder) Limit(limit int) *SelectBuilder {
+	b.limit = true
+	return b.Arg("limit", limit)
+}
+
+// Requires Limit
+func (b *SelectBuilder) Offset(offset int) *SelectBuilder {
+	b.offset = true
+	return b.Arg("offset", offset)
+}
+
+func (b *SelectBuilder) WithoutLimit() *SelectBuilder {
+	b.limit, b.offset = false, false
+	return b
+}
+
+func (b *SelectBuilder) Arg(name string, value any) *SelectBuilder {
+	b.args[name] = value
+	return b
+}
+
+// Binds each value as name_0, name_1, ... and returns the placeholders
+// for an IN (...) list
+func (b *SelectBuilder) ArgList(name string, values []any) string {
+	placeholders := make([]string, len(values))
+	for i, v := range values {
+		arg_name := name + "_" + strconv.Itoa(i)
+		b.Arg(arg_name, v)
+		placeholders[i] = ":" + arg_name
+	}
+
+	return strings.Join(placeholders, ", ")
+}
+
+var named_arg_regex = regexp.MustCompile(`:([A-Za-z_][A-Za-z0-9_]*)`)
+
+func (b *SelectBuilder) Build() (string, []any) {
+	var text strings.Builder
+
+	if len(b.ctes) > 0 {
+		text.WriteString("WITH ")
+		if b.recursive {
+			text.WriteString("RECURSIVE ")
+		}
+		text.WriteString(strings.Join(b.ctes.texts(), ",\n"))
+		text.WriteString("\n")
+	}
+
+	text.WriteString("SELECT\n\t")
+	text.WriteString(strings.Join(b.fields.texts(), ",\n\t"))
+	text.WriteString("\nFROM " + b.from)
+
+	for _, join := range b.joins {
+		text.WriteString("\n" + join.Text)
+	}
+
+	if len(b.wheres) > 0 {
+		text.WriteString("\nWHERE ")
+		text.WriteString(strings.Join(b.wheres.texts(), "\nAND "))
+	}
+
+	if b.group_by != "" {
+		text.WriteString("\nGROUP BY " + b.group_by)
+		if b.having != "" {
+			text.WriteString("\nHAVING " + b.having)
+		}
+	}
+
+	if b.order_by != "" {
+		text.WriteString("\nORDER BY " + b.order_by)
+	}
+
+	if b.limit {
+		text.WriteString("\nLIMIT :limit")
+		if b.offset {
+			text.WriteString(" OFFSET :offset")
+		}
+	}
+
+	text.WriteString(";")
+
+	// Only bind args still referenced: database/sql rejects extras
+	referenced := map[string]bool{}
+	for _, match := range named_arg_regex.FindAllStringSubmatch(text.String(), -1) {
+		referenced[match[1]] = true
+	}
+
+	var names []string
+	for name := range b.args {
+		if referenced[name] {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	args := make([]any, len(names))
+	for i, name := range names {
+		args[i] = sql.Named(name, b.args[name])
+	}
+
+	return text.String(), args
+}
+
+// Renders b into q
+func (b *SelectBuilder) BuildInto(q *Query) {
+	q.Text, q.Args = b.Build()
+}
+
+// Value bound for a named arg (or nil), e.g. to inspect built queries
+func GetNamedArg(args []any, name string) any {
+	for _, arg := range args {
+		if named, ok := arg.(sql.NamedArg); ok && named.Name == name {
+			return named.Value
+		}
+	}
+
+	return nil
+}
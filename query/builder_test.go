@@ -0,0 +1,302 @@
+package query
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/julianlk522/fitm/model"
+)
+
+func CheckNamedArgs(t *testing.T, args []any, want map[string]any) {
+	t.Helper()
+
+	if len(args) != len(want) {
+		t.Fatalf("got %d args %v, want %d", len(args), args, len(want))
+	}
+
+	for name, want_value := range want {
+		if got := GetNamedArg(args, name); got != want_value {
+			t.Fatalf("arg %s: got %v, want %v", name, got, want_value)
+		}
+	}
+}
+
+func TestSelectBuilder(t *testing.T) {
+	b := NewSelectBuilder().
+		With("A", "\n\tSELECT 1 AS x WHERE 1 = :a").
+		Field("x", "a.x").
+		Field("y", "2").
+		From("A a").
+		Where("first", "a.x = :x").
+		Where("second", "a.x IN ("+NewSelectBuilder().ArgList("unused", []any{1})+")").
+		Limit(10).
+		Arg("a", 1).
+		Arg("x", 1).
+		Arg("stale", 1)
+
+	// replacing keeps position
+	b.Field("x", "a.x + 0").FieldBefore("y", "z", "3")
+	b.Where("second", "a.x IN ("+b.ArgList("in", []any{1, 2})+")")
+
+	text, args := b.Build()
+	want_text := `WITH A AS (
+	SELECT 1 AS x WHERE 1 = :a
+)
+SELECT
+	a.x + 0 AS x,
+	3 AS z,
+	2 AS y
+FROM A a
+WHERE a.x = :x
+AND a.x IN (:in_0, :in_1)
+LIMIT :limit;`
+	if text != want_text {
+		t.Fatalf("got text\n%s\nwant\n%s", text, want_text)
+	}
+
+	// only referenced args are bound
+	CheckNamedArgs(t, args, map[string]any{
+		"a":     1,
+		"x":     1,
+		"in_0":  1,
+		"in_1":  2,
+		"limit": 10,
+	})
+
+	var x, z, y int
+	if err := TestClient.QueryRow(text, args...).Scan(&x, &z, &y); err != nil {
+		t.Fatal(err)
+	} else if x != 1 || z != 3 || y != 2 {
+		t.Fatalf("got %d, %d, %d, want 1, 3, 2", x, z, y)
+	}
+
+	b.WithoutWhere("first").WithoutField("z").WithoutLimit().Offset(5)
+	text, args = b.Build()
+	if strings.Contains(text, ":x") || strings.Contains(text, "3 AS z") || strings.Contains(text, "LIMIT") {
+		t.Fatalf("got text\n%s\nwant removed clauses gone", text)
+	} else if GetNamedArg(args, "x") != nil || GetNamedArg(args, "offset") != nil {
+		t.Fatalf("got args %v, want removed clauses' args unbound", args)
+	}
+
+	// HAVING follows GROUP BY
+	text, _ = NewSelectBuilder().
+		Field("x", "x").
+		From("A").
+		GroupBy("x").
+		Having("count(*) > :min").
+		Arg("min", 1).
+		Build()
+	if !strings.HasSuffix(text, "GROUP BY x\nHAVING count(*) > :min;") {
+		t.Fatalf("got text\n%s\nwant GROUP BY ... HAVING", text)
+	}
+}
+
+func ScanAnyRows(t *testing.T, q *Query) [][]any {
+	t.Helper()
+
+	rows, err := TestClient.Query(q.Text, q.Args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var results [][]any
+	for rows.Next() {
+		row := make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range row {
+			ptrs[i] = &row[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, row)
+	}
+
+	return results
+}
+
+// Applies each permutation of filters to a fresh query and checks that
+// they all bind the same args and return the same rows. (Text may
+// differ in clause order.)
+func CheckFiltersCombineInAnyOrder[T any](t *testing.T, new_query func() T, build func(T) *Query, filters []func(T)) {
+	t.Helper()
+
+	var want_args []any
+	var want_rows [][]any
+	first := true
+
+	var permute func(order []int, remaining []int)
+	permute = func(order []int, remaining []int) {
+		if len(remaining) == 0 {
+			q := new_query()
+			for _, i := range order {
+				filters[i](q)
+			}
+
+			built := build(q)
+			if built.Error != nil {
+				t.Fatalf("order %v: %s", order, built.Error)
+			}
+
+			rows := ScanAnyRows(t, built)
+			if first {
+				want_args, want_rows = built.Args, rows
+				first = false
+			} else if !reflect.DeepEqual(built.Args, want_args) {
+				t.Fatalf("order %v: got args %v, want %v", order, built.Args, want_args)
+			} else if !reflect.DeepEqual(rows, want_rows) {
+				t.Fatalf("order %v: got rows %v, want %v", order, rows, want_rows)
+			}
+			return
+		}
+
+		for i, f := range remaining {
+			next := append(append([]int{}, remaining[:i]...), remaining[i+1:]...)
+			permute(append(order, f), next)
+		}
+	}
+
+	remaining := make([]int, len(filters))
+	for i := range filters {
+		remaining[i] = i
+	}
+	permute(nil, remaining)
+}
+
+func TestTopLinksFiltersCombineInAnyOrder(t *testing.T) {
+	cursor := model.NewLinksCursor("newest", model.Link{ID: "1", SubmitDate: "2024-01-01"}).Encode()
+
+	CheckFiltersCombineInAnyOrder(
+		t,
+		NewTopLinks,
+		func(tl *TopLinks) *Query { return &tl.Query },
+		[]func(*TopLinks){
+			func(tl *TopLinks) { tl.FromCats(test_cats) },
+			func(tl *TopLinks) { tl.MatchingSearch("go") },
+			func(tl *TopLinks) { tl.WithURLContaining("www") },
+			func(tl *TopLinks) { tl.SortBy("newest") },
+			func(tl *TopLinks) { tl.AsSignedInUser(TEST_USER_ID) },
+			func(tl *TopLinks) { tl.AfterCursor(cursor, "newest") },
+		},
+	)
+}
+
+func TestTmapFiltersCombineInAnyOrder(t *testing.T) {
+	opts := &model.TmapOptions{}
+	CheckFiltersCombineInAnyOrder(
+		t,
+		func() *TmapSubmitted { return NewTmapSubmitted(TEST_LOGIN_NAME) },
+		func(ts *TmapSubmitted) *Query { return ts.Query },
+		[]func(*TmapSubmitted){
+			func(ts *TmapSubmitted) { ts.FromCats(test_cats) },
+			func(ts *TmapSubmitted) { ts.AsSignedInUser(TEST_USER_ID) },
+			func(ts *TmapSubmitted) { ts.NSFW() },
+			func(ts *TmapSubmitted) { ts.DuringPeriod("year") },
+			func(ts *TmapSubmitted) { ts.WithURLContaining("www") },
+			func(ts *TmapSubmitted) { ts.MatchingSearch("go") },
+		},
+	)
+
+	CheckFiltersCombineInAnyOrder(
+		t,
+		func() *TmapCopied { return NewTmapCopied(TEST_LOGIN_NAME).FromOptions(opts) },
+		func(tc *TmapCopied) *Query { return tc.Query },
+		[]func(*TmapCopied){
+			func(tc *TmapCopied) { tc.FromCats(test_cats) },
+			func(tc *TmapCopied) { tc.SortByNewest() },
+			func(tc *TmapCopied) { tc.DuringPeriod("year") },
+			func(tc *TmapCopied) { tc.WithURLContaining("www") },
+		},
+	)
+
+	CheckFiltersCombineInAnyOrder(
+		t,
+		func() *TmapTagged { return NewTmapTagged(TEST_LOGIN_NAME) },
+		func(tt *TmapTagged) *Query { return tt.Query },
+		[]func(*TmapTagged){
+			func(tt *TmapTagged) { tt.FromCats(test_cats) },
+			func(tt *TmapTagged) { tt.AsSignedInUser(TEST_USER_ID) },
+			func(tt *TmapTagged) { tt.NSFW() },
+			func(tt *TmapTagged) { tt.WithURLContaining("www") },
+		},
+	)
+}
+
+func TestContributorsFiltersCombineInAnyOrder(t *testing.T) {
+	CheckFiltersCombineInAnyOrder(
+		t,
+		NewTopContributors,
+		func(c *Contributors) *Query { return c.Query },
+		[]func(*Contributors){
+			func(c *Contributors) { c.FromCats(test_cats) },
+			func(c *Contributors) { c.WithURLContaining("www") },
+			func(c *Contributors) { c.DuringPeriod("year") },
+		},
+	)
+}
+
+func TestGlobalCatCountsFiltersCombineInAnyOrder(t *testing.T) {
+	CheckFiltersCombineInAnyOrder(
+		t,
+		NewTopGlobalCatCounts,
+		func(gcc *GlobalCatCounts) *Query { return gcc.Query },
+		[]func(*GlobalCatCounts){
+			func(gcc *GlobalCatCounts) { gcc.SubcatsOfCats("umvc3") },
+			func(gcc *GlobalCatCounts) { gcc.WithURLContaining("www") },
+			func(gcc *GlobalCatCounts) { gcc.DuringPeriod("year") },
+			func(gcc *GlobalCatCounts) { gcc.More() },
+			func(gcc *GlobalCatCounts) { gcc.OnlyCats([]string{"flowers", "Go"}) },
+		},
+	)
+}
+
+func TestTmapNSFWLinksCountFiltersCombineInAnyOrder(t *testing.T) {
+	CheckFiltersCombineInAnyOrder(
+		t,
+		func() *TmapNSFWLinksCount { return NewTmapNSFWLinksCount(TEST_REQ_LOGIN_NAME) },
+		func(tnlc *TmapNSFWLinksCount) *Query { return tnlc.Query },
+		[]func(*TmapNSFWLinksCount){
+			func(tnlc *TmapNSFWLinksCount) { tnlc.FromCats([]string{"search", "engine"}) },
+			func(tnlc *TmapNSFWLinksCount) { tnlc.SubmittedOnly() },
+			func(tnlc *TmapNSFWLinksCount) { tnlc.DuringPeriod("year") },
+			func(tnlc *TmapNSFWLinksCount) { tnlc.WithURLContaining("www") },
+			func(tnlc *TmapNSFWLinksCount) { tnlc.MatchingSearch("go") },
+		},
+	)
+}
+
+func TestCatGraphFiltersCombineInAnyOrder(t *testing.T) {
+	cats_filter, err := ParseCatsFilter("umvc3|flowers,-NSFW")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	CheckFiltersCombineInAnyOrder(
+		t,
+		NewCatGraph,
+		func(cg *CatGraph) *Query { return cg.Query },
+		[]func(*CatGraph){
+			func(cg *CatGraph) { cg.FromCatsFilter(cats_filter) },
+			func(cg *CatGraph) { cg.WithMinWeight(2) },
+		},
+	)
+}
+
+func TestSpellfixMatchesFiltersCombineInAnyOrder(t *testing.T) {
+	CheckFiltersCombineInAnyOrder(
+		t,
+		func() *SpellfixMatches { return NewSpellfixMatchesForSnippet(TEST_SNIPPET) },
+		func(sm *SpellfixMatches) *Query { return sm.Query },
+		[]func(*SpellfixMatches){
+			func(sm *SpellfixMatches) { sm.OmitCats([]string{TEST_SNIPPET}) },
+			func(sm *SpellfixMatches) { sm.ForContext() },
+		},
+	)
+}
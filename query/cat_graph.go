@@ -0,0 +1,113 @@
+package query
+
+import (
+	"net/url"
+	"strconv"
+
+	e "github.com/julianlk522/fitm/error"
+)
+
+const CAT_GRAPH_DEFAULT_MIN_WEIGHT = 1
+const CAT_GRAPH_EDGES_LIMIT = 250
+
+// Pairs of global cats that appear together on links (edges), weighted by
+// the number of links sharing both, plus each cat's own link count (nodes).
+// Cats are lowercased so capitalization variants share a node.
+type CatGraph struct {
+	*Query
+	builder *SelectBuilder
+}
+
+func NewCatGraph() *CatGraph {
+	cg := &CatGraph{
+		Query: &Query{},
+		builder: NewSelectBuilder().
+			Recursive().
+			With("GlobalCatsSplit(id, global_cat, str)", `
+    SELECT id, '', global_cats||','
+    FROM Links`+CAT_GRAPH_SPLIT_STEP).
+			With("LinkCats", `
+	SELECT DISTINCT id, LOWER(global_cat) AS cat
+	FROM GlobalCatsSplit
+	WHERE global_cat != ''`).
+			With("CatCounts", `
+	SELECT cat, count(id) AS count
+	FROM LinkCats
+	GROUP BY cat`).
+			Field("source", "a.cat").
+			Field("source_count", "sc.count").
+			Field("target", "b.cat").
+			Field("target_count", "tc.count").
+			Field("weight", "count(a.id)").
+			From("LinkCats a").
+			Join("b", "INNER JOIN LinkCats b ON a.id = b.id AND a.cat < b.cat").
+			Join("sc", "INNER JOIN CatCounts sc ON sc.cat = a.cat").
+			Join("tc", "INNER JOIN CatCounts tc ON tc.cat = b.cat").
+			GroupBy("a.cat, b.cat").
+			Having("weight >= :min_weight").
+			OrderBy("weight DESC, source ASC, target ASC").
+			Limit(CAT_GRAPH_EDGES_LIMIT).
+			Arg("min_weight", CAT_GRAPH_DEFAULT_MIN_WEIGHT),
+	}
+
+	return cg.build()
+}
+
+func (cg *CatGraph) build() *CatGraph {
+	cg.builder.BuildInto(cg.Query)
+	return cg
+}
+
+// Recursive part of GlobalCatsSplit: one row per link per global cat
+const CAT_GRAPH_SPLIT_STEP = `
+    UNION ALL SELECT
+	id,
+    substr(str, 0, instr(str, ',')),
+    substr(str, instr(str, ',') + 1)
+    FROM GlobalCatsSplit
+    WHERE str != ''`
+
+func (cg *CatGraph) FromRequestParams(params url.Values) *CatGraph {
+	cats_params := params.Get("cats")
+	if cats_params != "" {
+		cats_filter, err := ParseCatsFilter(cats_params)
+		if err != nil {
+			cg.Error = err
+			return cg
+		}
+		cg = cg.FromCatsFilter(cats_filter)
+	}
+
+	min_weight_params := params.Get("min_weight")
+	if min_weight_params != "" {
+		min_weight, err := strconv.Atoi(min_weight_params)
+		if err != nil || min_weight < 1 {
+			cg.Error = e.ErrInvalidMinWeight
+			return cg
+		}
+		cg = cg.WithMinWeight(min_weight)
+	}
+
+	return cg
+}
+
+// Only links matching the filter are counted
+func (cg *CatGraph) FromCatsFilter(cats_filter *CatsFilter) *CatGraph {
+	cg.builder.
+		With("GlobalCatsSplit(id, global_cat, str)", `
+    SELECT id, '', global_cats||','
+    FROM Links
+	WHERE id IN (
+		SELECT link_id
+		FROM global_cats_fts
+		WHERE global_cats MATCH :cats
+	)`+CAT_GRAPH_SPLIT_STEP).
+		Arg("cats", cats_filter.MatchArg())
+
+	return cg.build()
+}
+
+func (cg *CatGraph) WithMinWeight(min_weight int) *CatGraph {
+	cg.builder.Arg("min_weight", min_weight)
+	return cg.build()
+}
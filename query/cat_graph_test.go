@@ -0,0 +1,63 @@
+package query
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestNewCatGraph(t *testing.T) {
+	var test_params = []struct {
+		Params url.Values
+		Valid  bool
+	}{
+		{url.Values{}, true},
+		{url.Values{"min_weight": {"2"}}, true},
+		{url.Values{"cats": {"umvc3|flowers,-NSFW"}, "min_weight": {"1"}}, true},
+		{url.Values{"min_weight": {"0"}}, false},
+		{url.Values{"min_weight": {"-1"}}, false},
+		{url.Values{"cats": {"-umvc3"}}, false},
+	}
+
+	for _, tp := range test_params {
+		graph_sql := NewCatGraph().FromRequestParams(tp.Params)
+		if tp.Valid && graph_sql.Error != nil {
+			t.Fatalf("failed with error: %s for params %v", graph_sql.Error, tp.Params)
+		} else if !tp.Valid {
+			if graph_sql.Error == nil {
+				t.Fatalf("expected error for params %v", tp.Params)
+			}
+			continue
+		}
+
+		rows, err := TestClient.Query(graph_sql.Text, graph_sql.Args...)
+		if err != nil {
+			t.Fatalf("failed with error: %s for params %v", err, tp.Params)
+		}
+
+		min_weight := GetNamedArg(graph_sql.Args, "min_weight").(int)
+		for rows.Next() {
+			var source, target string
+			var source_count, target_count, weight int
+			if err := rows.Scan(&source, &source_count, &target, &target_count, &weight); err != nil {
+				t.Fatal(err)
+			}
+
+			if weight < min_weight {
+				t.Fatalf("got weight %d below min %d for params %v", weight, min_weight, tp.Params)
+			}
+
+			// verify weight
+			var count int
+			if err := TestClient.QueryRow(`SELECT count(link_id)
+				FROM global_cats_fts
+				WHERE global_cats MATCH ?`,
+				GetCatSurroundedInDoubleQuotes(source)+" AND "+GetCatSurroundedInDoubleQuotes(target),
+			).Scan(&count); err != nil {
+				t.Fatal(err)
+			} else if tp.Params.Get("cats") == "" && count < weight {
+				t.Fatalf("got weight %d for %s -- %s, only %d links carry both", weight, source, target, count)
+			}
+		}
+		rows.Close()
+	}
+}
@@ -0,0 +1,189 @@
+package query
+
+import (
+	"strings"
+
+	e "github.com/julianlk522/fitm/error"
+	mutil "github.com/julianlk522/fitm/model/util"
+)
+
+// Parsed cats param. Grammar:
+//
+//	go,concurrency|channels,-beginner
+//
+// Comma-separated clauses are ANDed, "|" separates alternatives within a
+// clause and a leading "-" excludes the clause. A cat wrapped in double
+// quotes is matched literally (no plural/singular variants) and may
+// contain "|" or start with "-". Unquoted cats that are aliases (see
+// "Cat Aliases") are replaced by the cat they point to, and unquoted cats
+// also match their descendants (see "Cat Parents").
+type CatsFilter struct {
+	Include [][]CatsFilterTerm
+	Exclude [][]CatsFilterTerm
+}
+
+type CatsFilterTerm struct {
+	Cat    string
+	Quoted bool
+}
+
+func ParseCatsFilter(cats_params string) (*CatsFilter, error) {
+	clauses, err := SplitOutsideQuotes(cats_params, ',')
+	if err != nil {
+		return nil, err
+	}
+
+	filter := &CatsFilter{}
+	for _, clause := range clauses {
+		clause = strings.TrimSpace(clause)
+		is_exclusion := strings.HasPrefix(clause, "-")
+		if is_exclusion {
+			clause = strings.TrimSpace(clause[1:])
+		}
+
+		alternatives, err := SplitOutsideQuotes(clause, '|')
+		if err != nil {
+			return nil, err
+		}
+
+		var terms []CatsFilterTerm
+		for _, alt := range alternatives {
+			term, err := ParseCatsFilterTerm(alt)
+			if err != nil {
+				return nil, err
+			} else if term.Cat == "" {
+				continue
+			}
+			terms = append(terms, term)
+		}
+
+		if len(terms) == 0 {
+			if is_exclusion {
+				return nil, e.ErrInvalidCatsFilter
+			}
+			continue
+		}
+
+		if is_exclusion {
+			filter.Exclude = append(filter.Exclude, terms)
+		} else {
+			filter.Include = append(filter.Include, terms)
+		}
+	}
+
+	if len(filter.Include) == 0 {
+		return nil, e.ErrNoCats
+	}
+
+	return filter, nil
+}
+
+// Plain cats ANDed together, i.e., the behavior before the filter grammar
+func NewCatsFilterFromCats(cats []string) *CatsFilter {
+	filter := &CatsFilter{}
+	for _, cat := range cats {
+		if cat == "" {
+			continue
+		}
+		filter.Include = append(filter.Include, []CatsFilterTerm{{Cat: mutil.ResolveCatAlias(cat)}})
+	}
+
+	return filter
+}
+
+func SplitOutsideQuotes(s string, sep rune) ([]string, error) {
+	var parts []string
+	var in_quotes bool
+	var start int
+
+	for i, r := range s {
+		switch r {
+		case '"':
+			in_quotes = !in_quotes
+		case sep:
+			if !in_quotes {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if in_quotes {
+		return nil, e.ErrInvalidCatsFilter
+	}
+
+	return append(parts, s[start:]), nil
+}
+
+func ParseCatsFilterTerm(s string) (CatsFilterTerm, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, `"`) {
+		return CatsFilterTerm{Cat: mutil.ResolveCatAlias(s)}, nil
+	}
+
+	// quotes must wrap the whole cat
+	inner := strings.TrimSuffix(strings.TrimPrefix(s, `"`), `"`)
+	if len(s) < 2 ||
+		!strings.HasPrefix(s, `"`) ||
+		!strings.HasSuffix(s, `"`) ||
+		strings.Contains(inner, `"`) {
+		return CatsFilterTerm{}, e.ErrInvalidCatsFilter
+	}
+
+	return CatsFilterTerm{Cat: strings.TrimSpace(inner), Quoted: true}, nil
+}
+
+// Every cat is wrapped in double quotes so FTS5 syntax in user input is
+// never interpreted; only the operators below are.
+func (cf *CatsFilter) MatchArg() string {
+	include := make([]string, len(cf.Include))
+	for i, clause := range cf.Include {
+		include[i] = GetCatsFilterClauseMatchArg(clause)
+	}
+	match_arg := strings.Join(include, " AND ")
+
+	if len(cf.Exclude) == 0 {
+		return match_arg
+	}
+
+	exclude := make([]string, len(cf.Exclude))
+	for i, clause := range cf.Exclude {
+		exclude[i] = GetCatsFilterClauseMatchArg(clause)
+	}
+
+	return "((" + match_arg + ") NOT (" + strings.Join(exclude, " OR ") + "))"
+}
+
+func GetCatsFilterClauseMatchArg(clause []CatsFilterTerm) string {
+	var alternatives []string
+	for _, term := range clause {
+		if term.Quoted {
+			alternatives = append(alternatives, GetCatSurroundedInDoubleQuotes(term.Cat))
+			continue
+		}
+
+		alternatives = append(alternatives, WithOptionalPluralOrSingularForm(term.Cat))
+		// parent cats match their descendants too
+		for _, descendant := range mutil.GetCatDescendants(term.Cat) {
+			alternatives = append(alternatives, WithOptionalPluralOrSingularForm(descendant))
+		}
+	}
+
+	if len(alternatives) == 1 {
+		return alternatives[0]
+	}
+
+	return "(" + strings.Join(alternatives, " OR ") + ")"
+}
+
+// Included cats (incl. alternatives) as passed, e.g., for omitting them
+// from cat counts
+func (cf *CatsFilter) Cats() []string {
+	var cats []string
+	for _, clause := range cf.Include {
+		for _, term := range clause {
+			cats = append(cats, term.Cat)
+		}
+	}
+
+	return cats
+}
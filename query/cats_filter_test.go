@@ -0,0 +1,142 @@
+package query
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+
+	mutil "github.com/julianlk522/fitm/model/util"
+)
+
+func TestParseCatsFilter(t *testing.T) {
+	var test_filters = []struct {
+		CatsParams string
+		MatchArg   string
+		Cats       []string
+		Valid      bool
+	}{
+		{"", "", nil, false},
+		{"-go", "", nil, false},
+		{"go,-", "", nil, false},
+		{`go,"concurrency`, "", nil, false},
+		{`go,con"currency"`, "", nil, false},
+		{"umvc3", `("umvc3" OR "umvc3s")`, []string{"umvc3"}, true},
+		// trailing commas ignored
+		{"umvc3,", `("umvc3" OR "umvc3s")`, []string{"umvc3"}, true},
+		{
+			"go,concurrency|channels",
+			`("go" OR "gos") AND (("concurrency" OR "concurrencys") OR ("channels" OR "channelses" OR "channel"))`,
+			[]string{"go", "concurrency", "channels"},
+			true,
+		},
+		{
+			"go,-beginner",
+			`((("go" OR "gos")) NOT (("beginner" OR "beginners")))`,
+			[]string{"go"},
+			true,
+		},
+		{
+			`"c. viper",-"a|b"|nsfw`,
+			`(("c. viper") NOT (("a|b" OR ("nsfw" OR "nsfws"))))`,
+			[]string{"c. viper"},
+			true,
+		},
+		// FTS5 syntax is not interpreted
+		{"go OR NOT*", `("go or not*" OR "go or not*s")`, []string{"go OR NOT*"}, true},
+	}
+
+	for _, tf := range test_filters {
+		cats_filter, err := ParseCatsFilter(tf.CatsParams)
+		if !tf.Valid {
+			if err == nil {
+				t.Fatalf("expected error for cats params %q", tf.CatsParams)
+			}
+			continue
+		} else if err != nil {
+			t.Fatalf("failed with error: %s for cats params %q", err, tf.CatsParams)
+		}
+
+		if got := cats_filter.MatchArg(); got != tf.MatchArg {
+			t.Fatalf("got match arg %s, want %s for cats params %q", got, tf.MatchArg, tf.CatsParams)
+		} else if got := cats_filter.Cats(); !slices.Equal(got, tf.Cats) {
+			t.Fatalf("got cats %v, want %v for cats params %q", got, tf.Cats, tf.CatsParams)
+		}
+	}
+}
+
+func TestCatsFilterWithCatParents(t *testing.T) {
+	mutil.SetCatParents(map[string]string{"goroutines": "go"})
+	defer mutil.SetCatParents(map[string]string{})
+
+	var test_filters = []struct {
+		CatsParams string
+		MatchArg   string
+	}{
+		{"go", `(("go" OR "gos") OR ("goroutines" OR "goroutineses" OR "goroutine"))`},
+		{"goroutines", `("goroutines" OR "goroutineses" OR "goroutine")`},
+		// quoted cats are literal
+		{`"go"`, `"go"`},
+		{
+			"programming,-go",
+			`((("programming" OR "programmings")) NOT ((("go" OR "gos") OR ("goroutines" OR "goroutineses" OR "goroutine"))))`,
+		},
+	}
+
+	for _, tf := range test_filters {
+		cats_filter, err := ParseCatsFilter(tf.CatsParams)
+		if err != nil {
+			t.Fatalf("failed with error: %s for cats params %q", err, tf.CatsParams)
+		} else if got := cats_filter.MatchArg(); got != tf.MatchArg {
+			t.Fatalf("got match arg %s, want %s for cats params %q", got, tf.MatchArg, tf.CatsParams)
+		}
+	}
+}
+
+func TestCatsFilterFromRequestParams(t *testing.T) {
+	var test_params = []url.Values{
+		{"cats": {"umvc3|flowers"}},
+		{"cats": {"search,-engine"}},
+		{"cats": {`"search engine"|umvc3,-NSFW`}, "period": {"year"}},
+	}
+
+	for _, params := range test_params {
+		links_sql := NewTopLinks().FromRequestParams(params)
+		if links_sql.Error != nil {
+			t.Fatalf("failed with error: %s for params %v", links_sql.Error, params)
+		}
+		rows, err := TestClient.Query(links_sql.Text, links_sql.Args...)
+		if err != nil {
+			t.Fatalf("failed with error: %s for params %v", err, params)
+		}
+		rows.Close()
+
+		contributors_sql := NewTopContributors().FromRequestParams(params)
+		if contributors_sql.Error != nil {
+			t.Fatalf("failed with error: %s for params %v", contributors_sql.Error, params)
+		}
+		rows, err = TestClient.Query(contributors_sql.Text, contributors_sql.Args...)
+		if err != nil {
+			t.Fatalf("failed with error: %s for params %v", err, params)
+		}
+		rows.Close()
+
+		counts_sql := NewTopGlobalCatCounts().FromRequestParams(params)
+		if counts_sql.Error != nil {
+			t.Fatalf("failed with error: %s for params %v", counts_sql.Error, params)
+		}
+		rows, err = TestClient.Query(counts_sql.Text, counts_sql.Args...)
+		if err != nil {
+			t.Fatalf("failed with error: %s for params %v", err, params)
+		}
+		rows.Close()
+	}
+
+	invalid_params := url.Values{"cats": {"-umvc3"}}
+	if NewTopLinks().FromRequestParams(invalid_params).Error == nil {
+		t.Fatal("expected error for links with only excluded cats")
+	} else if NewTopContributors().FromRequestParams(invalid_params).Error == nil {
+		t.Fatal("expected error for contributors with only excluded cats")
+	} else if NewTopGlobalCatCounts().FromRequestParams(invalid_params).Error == nil {
+		t.Fatal("expected error for cat counts with only excluded cats")
+	}
+}
@@ -9,29 +9,38 @@ const CONTRIBUTORS_PAGE_LIMIT = 10
 
 type Contributors struct {
 	*Query
+	builder *SelectBuilder
 }
 
 func NewTopContributors() *Contributors {
-	return (&Contributors{
-		Query: &Query{
-			Text: CONTRIBUTORS_BASE,
-			Args: []any{CONTRIBUTORS_PAGE_LIMIT},
-		},
-	})
+	c := &Contributors{
+		Query: &Query{},
+		builder: NewSelectBuilder().
+			Field("count", "count(l.id)").
+			Field("submitted_by", "l.submitted_by").
+			From("Links l").
+			GroupBy("l.submitted_by").
+			OrderBy("count DESC, l.submitted_by ASC").
+			Limit(CONTRIBUTORS_PAGE_LIMIT),
+	}
+
+	return c.build()
 }
 
-const CONTRIBUTORS_BASE = `SELECT
-count(l.id) as count, l.submitted_by
-FROM Links l
-GROUP BY l.submitted_by
-ORDER BY count DESC, l.submitted_by ASC
-LIMIT ?;`
+func (c *Contributors) build() *Contributors {
+	c.builder.BuildInto(c.Query)
+	return c
+}
 
 func (c *Contributors) FromRequestParams(params url.Values) *Contributors {
 	cats_params := params.Get("cats")
 	if cats_params != "" {
-		cats := strings.Split(cats_params, ",")
-		c = c.FromCats(cats)
+		cats_filter, err := ParseCatsFilter(cats_params)
+		if err != nil {
+			c.Error = err
+			return c
+		}
+		c = c.FromCatsFilter(cats_filter)
 	}
 
 	url_contains_params := params.Get("url_contains")
@@ -48,74 +57,30 @@ func (c *Contributors) FromRequestParams(params url.Values) *Contributors {
 }
 
 func (c *Contributors) FromCats(cats []string) *Contributors {
-	if len(cats) == 0 {
+	if len(cats) == 0 || cats[0] == "" {
 		return c
 	}
 
-	cats = GetCatsOptionalPluralOrSingularForms(cats)
-	match_arg := cats[0]
-	for i := 1; i < len(cats); i++ {
-		match_arg += " AND " + cats[i]
-	}
-	c.Args = append(c.Args, match_arg)
-
-	// Build CTE
-	match_clause := " WHERE global_cats MATCH ?"
-	cats_CTE := `WITH CatsFilter AS (
-	SELECT link_id
-	FROM global_cats_fts` + match_clause + `
-	)
-	`
-
-	// Prepend CTE
-	c.Text = cats_CTE + c.Text
-
-	// Append join
-	c.Text = strings.Replace(
-		c.Text,
-		"FROM Links l",
-		"FROM Links l"+CONTRIBUTORS_CATS_FROM,
-		1,
-	)
-
-	// Move page limit arg from first to last
-	c.Args = append(c.Args[1:], c.Args[0])
-
-	return c
+	return c.FromCatsFilter(NewCatsFilterFromCats(cats))
 }
 
-const CONTRIBUTORS_CATS_FROM = `
-INNER JOIN CatsFilter f ON l.id = f.link_id`
+func (c *Contributors) FromCatsFilter(cats_filter *CatsFilter) *Contributors {
+	WithCatsFilter(c.builder, cats_filter)
+	return c.build()
+}
 
 func (c *Contributors) WithURLContaining(snippet string) *Contributors {
-	c.Text = strings.Replace(
-		c.Text,
-		"GROUP BY l.submitted_by",
-		"WHERE url LIKE ?\nGROUP BY l.submitted_by",
-		1,
-	)
-
-	// insert into args in 2nd-to-last position
-	last_arg := c.Args[len(c.Args)-1]
-	c.Args = c.Args[:len(c.Args)-1]
-	c.Args = append(c.Args, "%"+snippet+"%")
-	c.Args = append(c.Args, last_arg)
+	c.builder.
+		Where("url_contains", "url LIKE :url_contains").
+		Arg("url_contains", "%"+snippet+"%")
 
-	return c
+	return c.build()
 }
 
 func (c *Contributors) DuringPeriod(period string) *Contributors {
 	if (period == "all") {
 		return c
 	}
-	
-	var clause_keyword string
-	// adapt keyword if .WithURLContaining was called first
-	if strings.Contains(c.Text, "WHERE url LIKE") {
-		clause_keyword = "AND"
-	} else {
-		clause_keyword = "WHERE"
-	}
 
 	period_clause, err := GetPeriodClause(period)
 	if err != nil {
@@ -123,18 +88,12 @@ func (c *Contributors) DuringPeriod(period string) *Contributors {
 		return c
 	}
 
-	period_clause = strings.Replace(
+	c.builder.Where("period", strings.Replace(
 		period_clause,
 		"submit_date",
 		"l.submit_date",
 		1,
-	)
+	))
 
-	c.Text = strings.Replace(
-		c.Text,
-		"GROUP BY l.submitted_by",
-		clause_keyword+" "+period_clause+"\n"+"GROUP BY l.submitted_by",
-		1)
-
-	return c
+	return c.build()
 }
@@ -0,0 +1,42 @@
+package query
+
+import (
+	"github.com/julianlk522/fitm/model"
+)
+
+// Sort key expressions in LINKS_ORDER_BY / LINKS_ORDER_BY_NEWEST order.
+// Not the result aliases: in WHERE, like_count etc. would resolve to the
+// (nullable) "Link Stats" columns.
+func GetLinksCursorKeyColumnsAndArgs(lc *model.LinksCursor) (string, []any) {
+	counts_columns := `COALESCE(ls.like_count, 0),
+	COALESCE(ls.copy_count, 0),
+	COALESCE(ls.click_count, 0),
+	COALESCE(ls.tag_count, 0),
+	COALESCE(ls.summary_count, 0)`
+	counts_args := []any{lc.LikeCount, lc.CopyCount, lc.ClickCount, lc.TagCount, lc.SummaryCount}
+
+	if lc.SortBy == "newest" {
+		return "l.submit_date,\n\t" + counts_columns + ",\n\tl.id",
+			append([]any{lc.SubmitDate}, append(counts_args, lc.ID)...)
+	}
+
+	return counts_columns + ",\n\tl.submit_date,\n\tl.id",
+		append(counts_args, lc.SubmitDate, lc.ID)
+}
+
+// Only links sorting after the cursor are returned
+func (tl *TopLinks) AfterCursor(token string, sort_by string) *TopLinks {
+	cursor, err := model.DecodeLinksCursor(token, sort_by)
+	if err != nil {
+		tl.Error = err
+		return tl
+	}
+
+	columns, args := GetLinksCursorKeyColumnsAndArgs(cursor)
+	tl.builder.Where(
+		"cursor",
+		"(\n\t"+columns+"\n) < ("+tl.builder.ArgList("cursor", args)+")",
+	)
+
+	return tl.build()
+}
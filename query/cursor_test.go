@@ -0,0 +1,118 @@
+package query
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/julianlk522/fitm/model"
+)
+
+func ScanTestLinks(t *testing.T, links_sql *TopLinks) []model.Link {
+	if links_sql.Error != nil {
+		t.Fatal(links_sql.Error)
+	}
+
+	rows, err := TestClient.Query(links_sql.Text, links_sql.Args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var links []model.Link
+	for rows.Next() {
+		var l model.Link
+		var pages int
+		if err := rows.Scan(
+			&l.ID,
+			&l.URL,
+			&l.SubmittedBy,
+			&l.SubmitDate,
+			&l.Cats,
+			&l.Summary,
+			&l.SummaryCount,
+			&l.LikeCount,
+			&l.EarliestLikers,
+			&l.CopyCount,
+			&l.EarliestCopiers,
+			&l.ClickCount,
+			&l.TagCount,
+			&l.PreviewImgFilename,
+			&l.Health,
+			&pages,
+		); err != nil {
+			t.Fatal(err)
+		}
+		links = append(links, l)
+	}
+
+	return links
+}
+
+func TestAfterCursor(t *testing.T) {
+	for _, sort_by := range []string{"rating", "newest"} {
+		params := url.Values{"sort_by": {sort_by}, "nsfw": {"true"}}
+		all_links := ScanTestLinks(t, NewTopLinks().FromRequestParams(params))
+
+		// resuming after each link continues with the next one
+		for i, link := range all_links {
+			params.Set("cursor", model.NewLinksCursor(sort_by, link).Encode())
+			links_after := ScanTestLinks(t, NewTopLinks().FromRequestParams(params))
+
+			if len(links_after) != min(len(all_links)-i-1, LINKS_PAGE_LIMIT) {
+				t.Fatalf(
+					"got %d links after %s (sort_by %s), want %d",
+					len(links_after),
+					link.ID,
+					sort_by,
+					len(all_links)-i-1,
+				)
+			} else if len(links_after) > 0 && links_after[0].ID != all_links[i+1].ID {
+				t.Fatalf(
+					"got link %s after %s (sort_by %s), want %s",
+					links_after[0].ID,
+					link.ID,
+					sort_by,
+					all_links[i+1].ID,
+				)
+			}
+		}
+	}
+
+	// no conflict with other methods
+	cursor := model.NewLinksCursor("newest", model.Link{ID: "1", SubmitDate: "2024-01-01"}).Encode()
+	links_sql := NewTopLinks().
+		FromRequestParams(url.Values{
+			"cats":         {"umvc3"},
+			"period":       {"year"},
+			"url_contains": {"www"},
+			"sort_by":      {"newest"},
+			"cursor":       {cursor},
+		}).
+		AsSignedInUser(TEST_USER_ID).
+		Page(1)
+	if links_sql.Error != nil {
+		t.Fatal(links_sql.Error)
+	} else if _, err := TestClient.Query(links_sql.Text, links_sql.Args...); err != nil {
+		t.Fatal(err)
+	}
+
+	// NSFW links count ignores cursor
+	if _, err := TestClient.Query(
+		links_sql.NSFWLinks(false).Text,
+		links_sql.NSFWLinks(false).Args...,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	var invalid_params = []url.Values{
+		{"cursor": {"not a cursor"}},
+		// cursor from another sort
+		{"cursor": {cursor}},
+		{"cursor": {cursor}, "q": {"go"}},
+	}
+	for _, params := range invalid_params {
+		if NewTopLinks().FromRequestParams(params).Error == nil {
+			t.Fatalf("expected error for params %v", params)
+		}
+	}
+}
@@ -3,136 +3,82 @@ package query
 import (
 	"fmt"
 	"net/url"
+	"slices"
 	"strings"
 
 	e "github.com/julianlk522/fitm/error"
-	mutil "github.com/julianlk522/fitm/model/util"
+	"github.com/julianlk522/fitm/model"
 )
 
 const LINKS_PAGE_LIMIT = 20
 
 type TopLinks struct {
 	Query
+	builder *SelectBuilder
 }
 
 func NewTopLinks() *TopLinks {
-	return (&TopLinks{
-		Query: Query{
-			Text: LINKS_BASE_CTES +
-				LINKS_BASE_FIELDS +
-				LINKS_FROM +
-				LINKS_BASE_JOINS +
-				LINKS_NO_NSFW_CATS_WHERE +
-				LINKS_ORDER_BY +
-				LINKS_LIMIT,
-			Args: []any{
-				mutil.EARLIEST_LIKERS_AND_COPIERS_LIMIT,
-				mutil.EARLIEST_LIKERS_AND_COPIERS_LIMIT,
-				LINKS_PAGE_LIMIT,
-			},
-		},
-	})
+	tl := &TopLinks{builder: NewSelectBuilder()}
+	tl.builder.
+		Field("id", "l.id").
+		Field("url", "l.url").
+		Field("sb", "l.submitted_by").
+		Field("sd", "l.submit_date").
+		Field("cats", "COALESCE(l.global_cats, '')").
+		Field("summary", "COALESCE(l.global_summary, '')")
+	WithLinkCounts(tl.builder).
+		Field("img_file", "COALESCE(l.img_file, '')")
+	WithLinkHealth(tl.builder).
+		Field("pages", LINKS_PAGES_FIELD).
+		From("Links l").
+		Where("nsfw", LINKS_NO_NSFW_CATS_WHERE).
+		OrderBy(LINKS_ORDER_BY).
+		Limit(LINKS_PAGE_LIMIT)
+
+	return tl.build()
 }
 
-const LINKS_BASE_CTES = `WITH LikeCount AS (
-    SELECT link_id, COUNT(*) AS like_count 
-    FROM "Link Likes"
-    GROUP BY link_id
-),
-EarliestLikers AS (
-    SELECT 
-        link_id,
-        GROUP_CONCAT(login_name, ', ') AS earliest_likers
-    FROM (
-        SELECT 
-            ll.link_id,
-            u.login_name,
-            ROW_NUMBER() OVER (PARTITION BY ll.link_id ORDER BY ll.timestamp ASC) as row_num
-        FROM "Link Likes" ll
-        JOIN Users u ON ll.user_id = u.id
-		ORDER BY ll.timestamp ASC, u.login_name ASC
-    ) ranked
-    WHERE row_num <= ?
-    GROUP BY link_id
-),
-CopyCount AS (
-	SELECT link_id, COUNT(*) AS copy_count
-	FROM "Link Copies"
-	GROUP BY link_id
-),
-EarliestCopiers AS (
-    SELECT 
-        link_id,
-        GROUP_CONCAT(login_name, ', ') AS earliest_copiers
-    FROM (
-        SELECT 
-            lc.link_id,
-            u.login_name,
-            ROW_NUMBER() OVER (PARTITION BY lc.link_id ORDER BY lc.timestamp ASC) as row_num
-        FROM "Link Copies" lc
-        JOIN Users u ON lc.user_id = u.id
-		ORDER BY lc.timestamp ASC, u.login_name ASC
-    ) ranked
-    WHERE row_num <= ?
-    GROUP BY link_id
-),
-ClickCount AS (
-	SELECT link_id, count(*) AS click_count
-	FROM Clicks
-	GROUP BY link_id
-),
-TagCount AS (
-    SELECT link_id, COUNT(*) AS tag_count
-    FROM Tags
-    GROUP BY link_id
-),
-SummaryCount AS (
-    SELECT link_id, COUNT(*) AS summary_count
-    FROM Summaries
-    GROUP BY link_id
-)`
+func (tl *TopLinks) build() *TopLinks {
+	tl.builder.BuildInto(&tl.Query)
+	return tl
+}
+
+// Adds summary, like, copy, click and tag counts and earliest
+// likers/copiers of each link l (shared by TopLinks and tmap sections).
+// "Link Stats" is kept up to date by triggers (see migration 0008).
+func WithLinkCounts(b *SelectBuilder) *SelectBuilder {
+	return b.
+		Field("summary_count", "COALESCE(ls.summary_count, 0)").
+		Field("like_count", "COALESCE(ls.like_count, 0)").
+		Field("earliest_likers", "COALESCE(ls.earliest_likers, '')").
+		Field("copy_count", "COALESCE(ls.copy_count, 0)").
+		Field("earliest_copiers", "COALESCE(ls.earliest_copiers, '')").
+		Field("click_count", "COALESCE(ls.click_count, 0)").
+		Field("tag_count", "COALESCE(ls.tag_count, 0)").
+		Join("ls", `LEFT JOIN "Link Stats" ls ON l.id = ls.link_id`)
+}
 
-var LINKS_BASE_FIELDS = fmt.Sprintf(` 
-SELECT 
-	l.id, 
-    l.url, 
-    l.submitted_by AS sb, 
-    l.submit_date AS sd, 
-    COALESCE(l.global_cats, '') AS cats, 
-    COALESCE(l.global_summary, '') AS summary, 
-    COALESCE(sc.summary_count, 0) AS summary_count,
-    COALESCE(lc.like_count, 0) AS like_count,
-	COALESCE(el.earliest_likers, '') AS earliest_likers,
-	COALESCE(cpc.copy_count, 0) AS copy_count,
-	COALESCE(ec.earliest_copiers, '') AS earliest_copiers,
-	COALESCE(clc.click_count, 0) AS click_count, 
-    COALESCE(tc.tag_count, 0) AS tag_count,
-    COALESCE(l.img_file, '') AS img_file,
-	(COUNT(*) OVER() + %d - 1) / %d AS pages`, 
-LINKS_PAGE_LIMIT,
-LINKS_PAGE_LIMIT)
-
-const LINKS_FROM = `
-FROM
-	Links l`
-
-const LINKS_BASE_JOINS = `
-LEFT JOIN LikeCount lc ON l.id = lc.link_id
-LEFT JOIN EarliestLikers el ON l.id = el.link_id
-LEFT JOIN CopyCount cpc ON l.id = cpc.link_id
-LEFT JOIN EarliestCopiers ec ON l.id = ec.link_id
-LEFT JOIN ClickCount clc ON l.id = clc.link_id
-LEFT JOIN TagCount tc ON l.id = tc.link_id
-LEFT JOIN SummaryCount sc ON l.id = sc.link_id
-`
-
-const LINKS_NO_NSFW_CATS_WHERE = `
-WHERE l.id NOT IN (
+// Latest link check's status (see model LINK_HEALTH_*) of each link l,
+// scanned right after img_file
+func WithLinkHealth(b *SelectBuilder) *SelectBuilder {
+	return b.
+		Field("health", LINK_HEALTH_FIELD).
+		Join("lh", `LEFT JOIN "Link Health" lh ON l.id = lh.link_id`)
+}
+
+const LINK_HEALTH_FIELD = "COALESCE(lh.health, 'unchecked')"
+
+var LINKS_PAGES_FIELD = fmt.Sprintf(
+	"(COUNT(*) OVER() + %d - 1) / %d",
+	LINKS_PAGE_LIMIT,
+	LINKS_PAGE_LIMIT,
+)
+
+const LINKS_NO_NSFW_CATS_WHERE = `l.id NOT IN (
 	SELECT link_id FROM global_cats_fts WHERE global_cats MATCH 'NSFW'
 )`
 
 const LINKS_ORDER_BY = ` 
-ORDER BY 
     like_count DESC, 
 	copy_count DESC,
 	click_count DESC,
@@ -142,7 +88,6 @@ ORDER BY
     l.id DESC`
 
 const LINKS_ORDER_BY_NEWEST = `
-ORDER BY 
 	submit_date DESC, 
 	like_count DESC, 
 	copy_count DESC,
@@ -151,33 +96,56 @@ ORDER BY
 	summary_count DESC, 
 	l.id DESC`
 
-const LINKS_LIMIT = `
-LIMIT ?;`
+// requires MatchingSearch
+const LINKS_ORDER_BY_RELEVANCE = `
+	sr.search_rank ASC,
+	like_count DESC, 
+	copy_count DESC,
+	click_count DESC,
+	tag_count DESC,
+	summary_count DESC, 
+	submit_date DESC,
+	l.id DESC`
 
 func (tl *TopLinks) FromRequestParams(params url.Values) *TopLinks {
-	// this first because using sort_params value helps with
-	// later text replaces since ORDER BY goes at the end
-	// (hence the methods below with a "sort_params" arg - it is
-	// not the prettiest solution but it works)
 	sort_params := params.Get("sort_by")
+	search_params := params.Get("q")
+
+	// search results are ranked by relevance unless another sort
+	// is requested
+	if search_params != "" && sort_params == "" {
+		sort_params = "relevance"
+	} else if search_params == "" && sort_params == "relevance" {
+		tl.Error = e.ErrRelevanceWithoutSearch
+		return tl
+	}
+
 	if sort_params != "" {
 		tl = tl.SortBy(sort_params)
 	}
 
+	if search_params != "" {
+		tl = tl.MatchingSearch(search_params)
+	}
+
 	cats_params := params.Get("cats")
 	if cats_params != "" {
-		cats := strings.Split(cats_params, ",")
-		tl = tl.FromCats(cats)
+		cats_filter, err := ParseCatsFilter(cats_params)
+		if err != nil {
+			tl.Error = err
+			return tl
+		}
+		tl = tl.FromCatsFilter(cats_filter)
 	}
 
 	url_contains_params := params.Get("url_contains")
 	if url_contains_params != "" {
-		tl = tl.WithURLContaining(url_contains_params, sort_params)
+		tl = tl.WithURLContaining(url_contains_params)
 	}
 
 	period_params := params.Get("period")
 	if period_params != "" {
-		tl = tl.DuringPeriod(period_params, sort_params)
+		tl = tl.DuringPeriod(period_params)
 	}
 
 	var nsfw_params string
@@ -193,209 +161,209 @@ func (tl *TopLinks) FromRequestParams(params url.Values) *TopLinks {
 		tl.Error = e.ErrInvalidNSFWParams
 	}
 
+	health_params := params.Get("health")
+	if health_params != "" {
+		healths, err := ParseHealthParams(health_params)
+		if err != nil {
+			tl.Error = err
+			return tl
+		}
+		tl = tl.WithHealth(healths)
+	}
+
+	cursor_params := params.Get("cursor")
+	if cursor_params != "" {
+		tl = tl.AfterCursor(cursor_params, sort_params)
+	}
+
 	return tl
 }
 
 func (tl *TopLinks) FromCats(cats []string) *TopLinks {
 	if len(cats) == 0 || cats[0] == "" {
-		tl.Error = fmt.Errorf("no cats provided")
+		tl.Error = e.ErrNoCats
 		return tl
 	}
-	// Pop limit arg
-	tl.Args = tl.Args[:len(tl.Args)-1]
-
-	// Build and add match arg
-	cats = GetCatsOptionalPluralOrSingularForms(cats)
-	var match_arg = cats[0]
-	for i := 1; i < len(cats); i++ {
-		match_arg += " AND " + cats[i]
-	}
-	tl.Args = append(tl.Args, match_arg)
-
-	// Build CTE from match_clause
-	match_clause := `
-	WHERE global_cats MATCH ?`
-	cats_CTE := `,
-		CatsFilter AS (
-			SELECT link_id
-			FROM global_cats_fts` + match_clause + `
-		)`
-
-	// Prepend CTE
-	tl.Text = strings.Replace(
-		tl.Text,
-		LINKS_BASE_CTES,
-		LINKS_BASE_CTES+cats_CTE,
-		1)
-
-	// Append join
-	const LINKS_CATS_JOIN = `
-	INNER JOIN CatsFilter f ON l.id = f.link_id`
-	tl.Text = strings.Replace(
-		tl.Text,
-		LINKS_FROM,
-		LINKS_FROM+LINKS_CATS_JOIN,
-		1,
-	)
 
-	// Append limit arg
-	tl.Args = append(tl.Args, LINKS_PAGE_LIMIT)
+	return tl.FromCatsFilter(NewCatsFilterFromCats(cats))
+}
 
-	return tl
+// Supports OR groups, exclusions and quoted cats (see ParseCatsFilter)
+func (tl *TopLinks) FromCatsFilter(cats_filter *CatsFilter) *TopLinks {
+	WithCatsFilter(tl.builder, cats_filter)
+	return tl.build()
 }
 
-// EarliestLikers/Copiers row nums + default NSFW clause makes 3
-const NUM_WHERES_IN_BASE_QUERY = 3
+// Limits links l to those with global cats matching cats_filter
+// (shared by TopLinks and Contributors)
+func WithCatsFilter(b *SelectBuilder, cats_filter *CatsFilter) *SelectBuilder {
+	return b.
+		With("CatsFilter", `
+	SELECT link_id
+	FROM global_cats_fts
+	WHERE global_cats MATCH :cats`).
+		Join("f", "INNER JOIN CatsFilter f ON l.id = f.link_id").
+		Arg("cats", cats_filter.MatchArg())
+}
 
-func (tl *TopLinks) WithURLContaining(snippet string, sort_by string) *TopLinks {
-	var clause_keyword string
-	if strings.Count(tl.Text, "WHERE") > NUM_WHERES_IN_BASE_QUERY {
-		clause_keyword = "AND"
-	} else {
-		clause_keyword = "WHERE"
+// Full-text search over URL, summaries and cats (see link_search_fts)
+func (tl *TopLinks) MatchingSearch(q string) *TopLinks {
+	match_arg, err := GetSearchMatchArg(q)
+	if err != nil {
+		tl.Error = err
+		return tl
 	}
 
-	and_clause := `
-	url LIKE ?`
+	// snippet is scanned last: AsSignedInUser fields go in front of it
+	tl.builder.
+		With("SearchResults", LINKS_SEARCH_CTE).
+		Field("search_snippet", "sr.search_snippet").
+		Join("sr", "INNER JOIN SearchResults sr ON l.id = sr.link_id").
+		Arg("search", match_arg)
 
-	order_by_clause := LINKS_ORDER_BY
-	if sort_by == "newest" {
-		order_by_clause = LINKS_ORDER_BY_NEWEST
-	}
+	return tl.build()
+}
 
-	tl.Text = strings.Replace(
-		tl.Text,
-		order_by_clause,
-		"\n" + clause_keyword + " " + and_clause + order_by_clause,
-		1,
-	)
+func (tl *TopLinks) IsSearch() bool {
+	return tl.builder != nil && tl.builder.HasCTE("SearchResults")
+}
 
-	// insert into args in 2nd-to-last position
-	last_arg := tl.Args[len(tl.Args) - 1]
-	tl.Args = tl.Args[:len(tl.Args) - 1]
-	tl.Args = append(tl.Args, "%" + snippet + "%")
-	tl.Args = append(tl.Args, last_arg)
+var LINKS_SEARCH_CTE = `
+	SELECT 
+		link_id,
+		` + SEARCH_RANK + ` AS search_rank,
+		` + SEARCH_SNIPPET + ` AS search_snippet
+	FROM link_search_fts
+	WHERE link_search_fts MATCH :search`
 
-	return tl
+func (tl *TopLinks) WithURLContaining(snippet string) *TopLinks {
+	tl.builder.
+		Where("url_contains", "url LIKE :url_contains").
+		Arg("url_contains", "%"+snippet+"%")
+
+	return tl.build()
 }
 
-func (tl *TopLinks) DuringPeriod(period string, sort_by string) *TopLinks {
-	if (period == "all") {
+func (tl *TopLinks) DuringPeriod(period string) *TopLinks {
+	if period == "all" {
 		return tl
 	}
-	
+
 	period_clause, err := GetPeriodClause(period)
 	if err != nil {
 		tl.Error = err
 		return tl
 	}
 
-	var clause_keyword string
-	if strings.Count(tl.Text, "WHERE") > NUM_WHERES_IN_BASE_QUERY {
-		clause_keyword = "AND"
-	} else {
-		clause_keyword = "WHERE"
-	}
-
-	order_by_clause := LINKS_ORDER_BY
-	if sort_by == "newest" {
-		order_by_clause = LINKS_ORDER_BY_NEWEST
-	}
+	tl.builder.Where("period", period_clause)
 
-	tl.Text = strings.Replace(
-		tl.Text,
-		order_by_clause,
-		"\n" + clause_keyword + " " + period_clause + order_by_clause,
-		1,
-	)
+	return tl.build()
+}
 
-	return tl
+func GetLinksOrderByClause(sort_by string) string {
+	switch sort_by {
+	case "newest":
+		return LINKS_ORDER_BY_NEWEST
+	case "relevance":
+		return LINKS_ORDER_BY_RELEVANCE
+	default:
+		return LINKS_ORDER_BY
+	}
 }
 
 func (tl *TopLinks) SortBy(order_by string) *TopLinks {
-	if order_by == "newest" {
-		tl.Text = strings.Replace(
-			tl.Text,
-			LINKS_ORDER_BY,
-			LINKS_ORDER_BY_NEWEST,
-			1,
-		)
-	} else if order_by != "rating" {
+	if order_by != "rating" && order_by != "newest" && order_by != "relevance" {
 		tl.Error = fmt.Errorf("invalid order_by value")
+		return tl
 	}
 
-	return tl
+	tl.builder.OrderBy(GetLinksOrderByClause(order_by))
+
+	return tl.build()
 }
 
 func (tl *TopLinks) AsSignedInUser(req_user_id string) *TopLinks {
-	auth_replacer := strings.NewReplacer(
-		// Add auth CTEs
-		LINKS_BASE_CTES, LINKS_BASE_CTES+LINKS_AUTH_CTES,
-		// Add auth fields
-		LINKS_BASE_FIELDS, LINKS_BASE_FIELDS+LINKS_AUTH_FIELDS,
-		// Add auth joins
-		LINKS_BASE_JOINS, LINKS_BASE_JOINS+LINKS_AUTH_JOINS,
-	)
-	tl.Text = auth_replacer.Replace(tl.Text)
-
-	// insert req_user_id * 2 between 2nd and 3rd args (indexes 1 and 2)
-	first_2_args := tl.Args[:2]
-	trailing_args := tl.Args[2:]
-
-	new_args := make([]any, 0, len(tl.Args)+2)
-	new_args = append(new_args, first_2_args...)
-	new_args = append(new_args, req_user_id, req_user_id)
-	new_args = append(new_args, trailing_args...)
-
-	tl.Args = new_args
-
-	return tl
+	WithSignedInUser(tl.builder, req_user_id)
+	return tl.build()
 }
 
-const LINKS_AUTH_CTES = `,
-IsLiked AS (
+// Adds whether req_user_id has liked / copied each link l (shared by
+// TopLinks and tmap sections)
+func WithSignedInUser(b *SelectBuilder, req_user_id string) *SelectBuilder {
+	return b.
+		With("IsLiked", `
 	SELECT link_id, COUNT(*) AS is_liked
 	FROM "Link Likes"
-	WHERE user_id = ?
-	GROUP BY link_id
-),
-IsCopied AS (
+	WHERE user_id = :req_user_id
+	GROUP BY link_id`).
+		With("IsCopied", `
 	SELECT link_id, COUNT(*) AS is_copied
 	FROM "Link Copies"
-	WHERE user_id = ?
-	GROUP BY link_id
-)`
+	WHERE user_id = :req_user_id
+	GROUP BY link_id`).
+		FieldBefore("search_snippet", "is_liked", "COALESCE(il.is_liked, 0)").
+		FieldBefore("search_snippet", "is_copied", "COALESCE(ic.is_copied, 0)").
+		Join("il", "LEFT JOIN IsLiked il ON l.id = il.link_id").
+		Join("ic", "LEFT JOIN IsCopied ic ON l.id = ic.link_id").
+		Arg("req_user_id", req_user_id)
+}
 
-const LINKS_AUTH_FIELDS = `,
-	COALESCE(il.is_liked,0) AS is_liked,
-	COALESCE(ic.is_copied,0) AS is_copied`
+// healths: see ParseHealthParams
+func (tl *TopLinks) WithHealth(healths []string) *TopLinks {
+	if len(healths) == 0 {
+		return tl
+	}
 
-const LINKS_AUTH_JOINS = `
-	LEFT JOIN IsLiked il ON l.id = il.link_id
-	LEFT JOIN IsCopied ic ON l.id = ic.link_id`
+	values := make([]any, len(healths))
+	for i, h := range healths {
+		values[i] = h
+	}
 
-func (tl *TopLinks) NSFW() *TopLinks {
-	has_subsequent_clause := strings.Contains(
-		tl.Text, 
-		LINKS_NO_NSFW_CATS_WHERE + "\nAND",
+	tl.builder.Where(
+		"health",
+		LINK_HEALTH_FIELD+" IN ("+tl.builder.ArgList("health", values)+")",
 	)
-	if has_subsequent_clause {
-		tl.Text = strings.Replace(
-			tl.Text,
-			LINKS_NO_NSFW_CATS_WHERE+"\nAND",
-			"\nWHERE",
-			1,
-		)
-	} else {
-		tl.Text = strings.Replace(
-			tl.Text,
-			LINKS_NO_NSFW_CATS_WHERE,
-			"",
-			1,
-		)
+
+	return tl.build()
+}
+
+// Comma-separated model LINK_HEALTH_* values, "alive" (ok, redirected)
+// and "dead" (any failure); "unchecked" links haven't been checked yet
+func ParseHealthParams(health_params string) ([]string, error) {
+	var healths []string
+	add := func(h string) {
+		if !slices.Contains(healths, h) {
+			healths = append(healths, h)
+		}
 	}
-	
-	return tl
+
+	for _, h := range strings.Split(health_params, ",") {
+		switch h = strings.ToLower(strings.TrimSpace(h)); h {
+		case "alive":
+			for _, alive := range model.LINK_HEALTH_ALIVE {
+				add(alive)
+			}
+		case "dead":
+			for _, dead := range model.LINK_HEALTH_DEAD {
+				add(dead)
+			}
+		case model.LINK_HEALTH_UNCHECKED:
+			add(h)
+		default:
+			if !slices.Contains(model.LINK_HEALTH_ALIVE, h) &&
+				!slices.Contains(model.LINK_HEALTH_DEAD, h) {
+				return nil, e.ErrInvalidHealthParams
+			}
+			add(h)
+		}
+	}
+
+	return healths, nil
+}
+
+func (tl *TopLinks) NSFW() *TopLinks {
+	tl.builder.WithoutWhere("nsfw")
+	return tl.build()
 }
 
 func (tl *TopLinks) Page(page int) *TopLinks {
@@ -403,88 +371,34 @@ func (tl *TopLinks) Page(page int) *TopLinks {
 		return tl
 	}
 
+	// limit + 1 to tell whether there is a next page
 	if page >= 1 {
-		// Pop limit arg and replace with limit + 1
-		tl.Args = append(tl.Args[:len(tl.Args)-1], LINKS_PAGE_LIMIT+1)
+		tl.builder.Limit(LINKS_PAGE_LIMIT + 1)
 	}
 
-	if page == 1 {
-		return tl
+	if page > 1 {
+		tl.builder.Offset((page - 1) * LINKS_PAGE_LIMIT)
 	}
 
-	tl.Text = strings.Replace(
-		tl.Text,
-		"LIMIT ?",
-		"LIMIT ? OFFSET ?",
-		1)
-
-	// Append offset arg
-	tl.Args = append(tl.Args, (page-1)*LINKS_PAGE_LIMIT)
-
-	return tl
+	return tl.build()
 }
 
+// Counts NSFW links among the results: hidden ones if nsfw_params is
+// false, otherwise included ones
 func (tl *TopLinks) NSFWLinks(nsfw_params bool) *TopLinks {
-	count_select := `
-	SELECT count(l.id)`
-
-	// attempt to replace both base and auth-enabled fields
-	// it should be one or the other
-	tl.Text = strings.Replace(
-		tl.Text,
-		LINKS_BASE_FIELDS + LINKS_AUTH_FIELDS,
-		count_select,
-		1,
-	)
-
-	tl.Text = strings.Replace(
-		tl.Text,
-		LINKS_BASE_FIELDS,
-		count_select,
-		1,
-	)
-
-	// invert NSFW clause
-	if nsfw_params {
-		// insert in front of both LINKS_ORDER_BY and LINKS_ORDER_BY_NEWEST
-		// (one should be no-op)
-		tl.Text = strings.Replace(
-			tl.Text,
-			LINKS_ORDER_BY,
-			NSFW_CLAUSE,
-			1,
-		)
-
-		tl.Text = strings.Replace(
-			tl.Text,
-			LINKS_ORDER_BY_NEWEST,
-			NSFW_CLAUSE,
-			1,
-		)
-	} else {
-		tl.Text = strings.Replace(
-			tl.Text,
-			LINKS_NO_NSFW_CATS_WHERE,
-			`
-			WHERE l.id IN (
-				SELECT link_id FROM global_cats_fts WHERE global_cats MATCH 'NSFW'
-			)`,
-			1,
-		)
-	}
-
-	// remove LIMIT and OFFET clause
-	tl.Text = strings.Replace(
-		tl.Text,
-		"\nLIMIT ? OFFSET ?",
-		"",
-		1,
-	)
-
-	return tl
+	tl.builder.
+		WithoutFields().
+		Field("count", "count(l.id)").
+		Where("nsfw", NSFW_CLAUSE).
+		// count isn't limited by cursor
+		WithoutWhere("cursor").
+		OrderBy("").
+		WithoutLimit()
+
+	return tl.build()
 }
 
-const NSFW_CLAUSE = `WHERE l.id IN (
+const NSFW_CLAUSE = `l.id IN (
 	SELECT link_id FROM global_cats_fts WHERE global_cats MATCH 'NSFW'
 )`
 
@@ -499,11 +413,7 @@ func NewSingleLink(link_id string) *SingleLink {
 				SINGLE_LINK_BASE_FIELDS +
 				SINGLE_LINK_FROM +
 				SINGLE_LINK_BASE_JOINS + ";",
-			Args: []any{
-				link_id,
-				mutil.EARLIEST_LIKERS_AND_COPIERS_LIMIT,
-				mutil.EARLIEST_LIKERS_AND_COPIERS_LIMIT,
-			},
+			Args: []any{link_id},
 		},
 	}
 }
@@ -520,73 +430,6 @@ Base AS (
         COALESCE(img_file, "") as img_file
     FROM Links
     WHERE id = ?
-),
-SummaryCount AS (
-    SELECT 
-        link_id, 
-        COUNT(*) AS summary_count
-    FROM Summaries
-    GROUP BY link_id
-),
-LikeCount AS (
-    SELECT 
-        link_id, 
-        COUNT(*) as like_count
-    FROM "Link Likes"
-    GROUP BY link_id
-),
-EarliestLikers AS (
-    SELECT 
-        link_id,
-        GROUP_CONCAT(login_name, ', ') AS earliest_likers
-    FROM (
-        SELECT 
-            ll.link_id,
-            u.login_name,
-            ROW_NUMBER() OVER (PARTITION BY ll.link_id ORDER BY ll.timestamp ASC, u.login_name ASC) as row_num
-        FROM "Link Likes" ll
-        JOIN Users u ON ll.user_id = u.id
-		ORDER BY ll.timestamp ASC, u.login_name ASC
-    ) ranked
-    WHERE row_num <= ?
-    GROUP BY link_id
-),
-CopyCount AS (
-    SELECT 
-        link_id, 
-        COUNT(*) as copy_count
-    FROM "Link Copies"
-    GROUP BY link_id
-),
-EarliestCopiers AS (
-    SELECT 
-        link_id,
-        GROUP_CONCAT(login_name, ', ') AS earliest_copiers
-    FROM (
-        SELECT 
-            lc.link_id,
-            u.login_name,
-            ROW_NUMBER() OVER (PARTITION BY lc.link_id ORDER BY lc.timestamp ASC, u.login_name ASC) as row_num
-        FROM "Link Copies" lc
-        JOIN Users u ON lc.user_id = u.id
-		ORDER BY lc.timestamp ASC, u.login_name ASC
-    ) ranked
-    WHERE row_num <= ?
-    GROUP BY link_id
-),
-ClickCount AS (
-    SELECT 
-        link_id, 
-        COUNT(*) AS click_count
-    FROM Clicks
-    GROUP BY link_id
-),
-TagCount AS (
-    SELECT 
-        link_id, 
-        COUNT(*) as tag_count
-    FROM Tags
-    GROUP BY link_id
 )`
 
 const SINGLE_LINK_BASE_FIELDS = `
@@ -597,26 +440,22 @@ SELECT
     b.sd,
     b.cats,
     b.summary,
-    COALESCE(sc.summary_count, 0) as summary_count,
-    COALESCE(lc.like_count, 0) as like_count,
-    COALESCE(el.earliest_likers, "") as earliest_likers,
-    COALESCE(cc.copy_count, 0) as copy_count,
-	COALESCE(ec.earliest_copiers, "") as earliest_copiers,
-    COALESCE(ckc.click_count, 0) as click_count,
-    COALESCE(tc.tag_count, 0) as tag_count,
-    b.img_file`
+    COALESCE(ls.summary_count, 0) as summary_count,
+    COALESCE(ls.like_count, 0) as like_count,
+    COALESCE(ls.earliest_likers, "") as earliest_likers,
+    COALESCE(ls.copy_count, 0) as copy_count,
+	COALESCE(ls.earliest_copiers, "") as earliest_copiers,
+    COALESCE(ls.click_count, 0) as click_count,
+    COALESCE(ls.tag_count, 0) as tag_count,
+    b.img_file,
+    COALESCE(lh.health, 'unchecked') as health`
 
 const SINGLE_LINK_FROM = `
 FROM Base b`
 
 const SINGLE_LINK_BASE_JOINS = `
-LEFT JOIN SummaryCount sc ON sc.link_id = b.link_id
-LEFT JOIN LikeCount lc ON lc.link_id = b.link_id
-LEFT JOIN EarliestLikers el ON el.link_id = b.link_id
-LEFT JOIN CopyCount cc ON cc.link_id = b.link_id
-LEFT JOIN EarliestCopiers ec ON ec.link_id = b.link_id
-LEFT JOIN ClickCount ckc ON ckc.link_id = b.link_id
-LEFT JOIN TagCount tc ON tc.link_id = b.link_id`
+LEFT JOIN "Link Stats" ls ON ls.link_id = b.link_id
+LEFT JOIN "Link Health" lh ON lh.link_id = b.link_id`
 
 func (sl *SingleLink) AsSignedInUser(user_id string) *SingleLink {
 	sl.Text = strings.Replace(
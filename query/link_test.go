@@ -2,12 +2,12 @@ package query
 
 import (
 	"database/sql"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/julianlk522/fitm/model"
-	mutil "github.com/julianlk522/fitm/model/util"
 )
 
 func TestNewTopLinks(t *testing.T) {
@@ -50,6 +50,7 @@ func TestNewTopLinks(t *testing.T) {
 		{"click_count"},
 		{"tag_count"},
 		{"img_file"},
+		{"health"},
 		{"pages"},
 	}
 
@@ -88,22 +89,21 @@ func TestFromCats(t *testing.T) {
 		defer rows.Close()
 
 		// With period
-		links_sql = links_sql.DuringPeriod("month", "rating")
+		links_sql = links_sql.DuringPeriod("month")
 		if tc.Valid && links_sql.Error != nil {
 			t.Fatal(links_sql.Error)
 		} else if !tc.Valid && links_sql.Error == nil {
 			t.Fatalf("expected error for cats %s", tc.Cats)
 		}
 
-		// If any cats provided, args should be cat_match and limit
-		// in that order
+		// If any cats provided, args should include cat_match and limit
 		if len(tc.Cats) == 0 || len(tc.Cats) == 1 && tc.Cats[0] == "" {
 			continue
 		}
 
-		if 
-		links_sql.Args[len(links_sql.Args)-2] != strings.Join(tc.Cats, " ") && links_sql.Args[len(links_sql.Args)-1] != LINKS_PAGE_LIMIT {
-			t.Fatalf("got %v, want %v (should be cat_match and limit in that order)", links_sql.Args, tc.Cats)
+		if GetNamedArg(links_sql.Args, "cats") != NewCatsFilterFromCats(tc.Cats).MatchArg() ||
+		GetNamedArg(links_sql.Args, "limit") != LINKS_PAGE_LIMIT {
+			t.Fatalf("got %v, want cat_match for %v and limit", links_sql.Args, tc.Cats)
 		}
 
 		rows, err = TestClient.Query(links_sql.Text, links_sql.Args...)
@@ -115,7 +115,7 @@ func TestFromCats(t *testing.T) {
 }
 
 func TestLinksWithURLContaining(t *testing.T) {
-	links_sql := NewTopLinks().WithURLContaining("google", "newest")
+	links_sql := NewTopLinks().WithURLContaining("google")
 
 	rows, err := TestClient.Query(links_sql.Text, links_sql.Args...)
 	if err != nil && err != sql.ErrNoRows {
@@ -143,6 +143,7 @@ func TestLinksWithURLContaining(t *testing.T) {
 			&link.ClickCount,
 			&link.TagCount,
 			&link.PreviewImgFilename,
+			&link.Health,
 			&pages,
 		)
 		if err != nil {
@@ -164,7 +165,7 @@ func TestLinksWithURLContaining(t *testing.T) {
 	// combined with other methods
 	links_sql = NewTopLinks().
 		FromCats([]string{"umvc3"}).
-		WithURLContaining("google", "rating").
+		WithURLContaining("google").
 		AsSignedInUser(TEST_USER_ID).
 		SortBy("newest")
 	rows, err = TestClient.Query(links_sql.Text, links_sql.Args...)
@@ -190,6 +191,7 @@ func TestLinksWithURLContaining(t *testing.T) {
 			&link.ClickCount,
 			&link.TagCount,
 			&link.PreviewImgFilename,
+			&link.Health,
 			&pages,
 		)
 		if err != nil {
@@ -208,6 +210,90 @@ func TestLinksWithURLContaining(t *testing.T) {
 	}
 }
 
+func TestParseHealthParams(t *testing.T) {
+	var test_cases = []struct {
+		HealthParams string
+		Healths      []string
+		Valid        bool
+	}{
+		{"ok", []string{"ok"}, true},
+		{" OK, unchecked ", []string{"ok", "unchecked"}, true},
+		{"alive", model.LINK_HEALTH_ALIVE, true},
+		{"dead,timeout", model.LINK_HEALTH_DEAD, true},
+		{"rotten", nil, false},
+		{"ok,", nil, false},
+	}
+
+	for _, tc := range test_cases {
+		healths, err := ParseHealthParams(tc.HealthParams)
+		if tc.Valid && err != nil {
+			t.Fatalf("%q: failed with error: %s", tc.HealthParams, err)
+		} else if !tc.Valid && err == nil {
+			t.Fatalf("%q: expected error", tc.HealthParams)
+		} else if strings.Join(healths, ",") != strings.Join(tc.Healths, ",") {
+			t.Fatalf("%q: got %v, want %v", tc.HealthParams, healths, tc.Healths)
+		}
+	}
+}
+
+func TestLinksWithHealth(t *testing.T) {
+	test_link_id := "1"
+	if _, err := TestClient.Exec(
+		`INSERT INTO "Link Health" (link_id, health, last_checked, next_check)
+		VALUES(?,?,?,?);`,
+		test_link_id,
+		model.LINK_HEALTH_NOT_FOUND,
+		"2024-01-01 00:00:00",
+		"2024-01-02 00:00:00",
+	); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		TestClient.Exec(`DELETE FROM "Link Health" WHERE link_id = ?;`, test_link_id)
+	})
+
+	var test_cases = []struct {
+		Healths     []string
+		HasTestLink bool
+	}{
+		{[]string{model.LINK_HEALTH_NOT_FOUND}, true},
+		{model.LINK_HEALTH_DEAD, true},
+		{model.LINK_HEALTH_ALIVE, false},
+		{[]string{model.LINK_HEALTH_UNCHECKED}, false},
+	}
+
+	for _, tc := range test_cases {
+		links_sql := NewTopLinks().WithHealth(tc.Healths)
+		if links_sql.Error != nil {
+			t.Fatal(links_sql.Error)
+		}
+
+		rows, err := TestClient.Query(
+			"SELECT id, health FROM ("+strings.TrimSuffix(links_sql.Text, ";")+");",
+			links_sql.Args...,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var has_test_link bool
+		for rows.Next() {
+			var link_id, health string
+			if err := rows.Scan(&link_id, &health); err != nil {
+				t.Fatal(err)
+			} else if !slices.Contains(tc.Healths, health) {
+				t.Fatalf("%v: got link %s with health %s", tc.Healths, link_id, health)
+			}
+			has_test_link = has_test_link || link_id == test_link_id
+		}
+		rows.Close()
+
+		if has_test_link != tc.HasTestLink {
+			t.Fatalf("%v: got test link %t, want %t", tc.Healths, has_test_link, tc.HasTestLink)
+		}
+	}
+}
+
 func TestLinksDuringPeriod(t *testing.T) {
 	var test_periods = []struct {
 		Period string
@@ -223,7 +309,7 @@ func TestLinksDuringPeriod(t *testing.T) {
 
 	for _, tp := range test_periods {
 		// Period only
-		links_sql := NewTopLinks().DuringPeriod(tp.Period, "newest")
+		links_sql := NewTopLinks().DuringPeriod(tp.Period)
 		if tp.Valid && links_sql.Error != nil {
 			t.Fatal(links_sql.Error)
 		} else if !tp.Valid && links_sql.Error == nil {
@@ -297,6 +383,7 @@ func TestLinksSortBy(t *testing.T) {
 				&link.ClickCount,
 				&link.TagCount,
 				&link.PreviewImgFilename,
+				&link.Health,
 				&pages,
 			)
 			if err != nil {
@@ -357,7 +444,7 @@ func TestAsSignedInUser(t *testing.T) {
 
 	if len(cols) == 0 {
 		t.Fatal("no columns")
-	} else if len(cols) != 17 {
+	} else if len(cols) != 18 {
 		t.Fatal("incorrect col count")
 	}
 
@@ -378,6 +465,7 @@ func TestAsSignedInUser(t *testing.T) {
 		{"click_count"},
 		{"tag_count"},
 		{"img_file"},
+		{"health"},
 		{"pages"},
 		{"is_liked"},
 		{"is_copied"},
@@ -389,18 +477,10 @@ func TestAsSignedInUser(t *testing.T) {
 		}
 	}
 
-	var expected_args = []any{
-		mutil.EARLIEST_LIKERS_AND_COPIERS_LIMIT, 
-		mutil.EARLIEST_LIKERS_AND_COPIERS_LIMIT, 
-		TEST_USER_ID, 
-		TEST_USER_ID, 
-		LINKS_PAGE_LIMIT,
-	}
-	for i, arg := range links_sql.Args {
-		if arg != expected_args[i] {
-			t.Fatalf("arg %d: got %v, want %v", i, arg, expected_args[i])
-		}
-	}
+	CheckNamedArgs(t, links_sql.Args, map[string]any{
+		"req_user_id":    TEST_USER_ID,
+		"limit":          LINKS_PAGE_LIMIT,
+	})
 
 	// Verify no conflict with .FromCats()
 	links_sql = NewTopLinks().FromCats(test_cats).AsSignedInUser(TEST_USER_ID)
@@ -409,19 +489,11 @@ func TestAsSignedInUser(t *testing.T) {
 	}
 
 	// "go AND coding" modified to include plural/singular variations
-	expected_args = []any{
-		mutil.EARLIEST_LIKERS_AND_COPIERS_LIMIT, 
-		mutil.EARLIEST_LIKERS_AND_COPIERS_LIMIT, 
-		TEST_USER_ID, 
-		TEST_USER_ID, 
-		WithOptionalPluralOrSingularForm("go") + " AND " + WithOptionalPluralOrSingularForm("coding"), 
-		LINKS_PAGE_LIMIT,
-	}
-	for i, arg := range links_sql.Args {
-		if arg != expected_args[i] {
-			t.Fatalf("arg %d: got %v, want %v", i, arg, expected_args[i])
-		}
-	}
+	CheckNamedArgs(t, links_sql.Args, map[string]any{
+		"req_user_id":    TEST_USER_ID,
+		"cats":           WithOptionalPluralOrSingularForm("go") + " AND " + WithOptionalPluralOrSingularForm("coding"),
+		"limit":          LINKS_PAGE_LIMIT,
+	})
 }
 
 func TestNSFW(t *testing.T) {
@@ -437,7 +509,7 @@ func TestNSFW(t *testing.T) {
 	// Verify no conflict with other filter methods
 	links_sql = NewTopLinks().
 		FromCats([]string{"search", "engine", "NSFW"}).
-		DuringPeriod("year", "rating").
+		DuringPeriod("year").
 		AsSignedInUser(TEST_USER_ID).
 		SortBy("newest").
 		Page(1).
@@ -468,6 +540,7 @@ func TestNSFW(t *testing.T) {
 			&l.ClickCount,
 			&l.TagCount,
 			&l.PreviewImgFilename,
+			&l.Health,
 			&pages,
 			&l.IsLiked,
 			&l.IsCopied,
@@ -481,7 +554,7 @@ func TestNSFW(t *testing.T) {
 	// Verify link not present using same query without .NSFW()
 	links_sql = NewTopLinks().
 		FromCats([]string{"search", "engine", "NSFW"}).
-		DuringPeriod("year", "newest").
+		DuringPeriod("year").
 		AsSignedInUser(TEST_USER_ID).
 		SortBy("newest").
 		Page(1)
@@ -507,6 +580,7 @@ func TestNSFW(t *testing.T) {
 			&l.ClickCount,
 			&l.TagCount,
 			&l.PreviewImgFilename,
+			&l.Health,
 			&pages,
 			&l.IsLiked,
 			&l.IsCopied,
@@ -537,9 +611,9 @@ func TestPage(t *testing.T) {
 			t.Fatal(links_sql.Error)
 		}
 
+		limit_arg := GetNamedArg(links_sql.Args, "limit")
 		if tc.Page > 1 {
-			limit_arg := links_sql.Args[len(links_sql.Args)-2]
-			offset_arg := links_sql.Args[len(links_sql.Args)-1]
+			offset_arg := GetNamedArg(links_sql.Args, "offset")
 
 			if limit_arg != tc.WantLimitArg {
 				t.Fatalf("got %d, want %d", limit_arg, tc.WantLimitArg)
@@ -550,15 +624,15 @@ func TestPage(t *testing.T) {
 			continue
 		}
 
-		if links_sql.Args[len(links_sql.Args)-1] != tc.WantLimitArg {
-			t.Fatalf("got %d, want %d", links_sql.Args[len(links_sql.Args)-1], tc.WantLimitArg)
+		if limit_arg != tc.WantLimitArg {
+			t.Fatalf("got %d, want %d", limit_arg, tc.WantLimitArg)
 		}
 	}
 
 	// Verify no conflict with other methods
 	links_sql = NewTopLinks().
 		FromCats(test_cats).
-		DuringPeriod("year", "rating").
+		DuringPeriod("year").
 		SortBy("newest").
 		AsSignedInUser(TEST_USER_ID).
 		NSFW().
@@ -568,19 +642,10 @@ func TestPage(t *testing.T) {
 	}
 
 	// "go AND coding" modified to include plural/singular variations
-	var expected_args = []any{
-		mutil.EARLIEST_LIKERS_AND_COPIERS_LIMIT, 
-		mutil.EARLIEST_LIKERS_AND_COPIERS_LIMIT,
-		TEST_USER_ID, 
-		TEST_USER_ID, 
-		WithOptionalPluralOrSingularForm("go") + " AND " + WithOptionalPluralOrSingularForm("coding"), 
-		LINKS_PAGE_LIMIT + 1, 
-		LINKS_PAGE_LIMIT,
-	}
-
-	for i, arg := range links_sql.Args {
-		if arg != expected_args[i] {
-			t.Fatalf("got %v, want %v", arg, expected_args[i])
-		}
-	}
+	CheckNamedArgs(t, links_sql.Args, map[string]any{
+		"req_user_id":    TEST_USER_ID,
+		"cats":           WithOptionalPluralOrSingularForm("go") + " AND " + WithOptionalPluralOrSingularForm("coding"),
+		"limit":          LINKS_PAGE_LIMIT + 1,
+		"offset":         LINKS_PAGE_LIMIT,
+	})
 }
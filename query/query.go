@@ -76,5 +76,6 @@ func GetCatsSurroundedInDoubleQuotes(cats []string) []string {
 }
 
 func GetCatSurroundedInDoubleQuotes(cat string) string {
-	return fmt.Sprintf(`"%s"`, cat)
+	// FTS5 escapes a double quote inside a string by doubling it
+	return fmt.Sprintf(`"%s"`, strings.ReplaceAll(cat, `"`, `""`))
 }
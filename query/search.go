@@ -0,0 +1,89 @@
+package query
+
+import (
+	"html"
+	"regexp"
+	"strings"
+
+	e "github.com/julianlk522/fitm/error"
+)
+
+const SEARCH_TERMS_LIMIT = 10
+
+// link_search_fts columns: link_id (unindexed), url, global_summary,
+// summaries, cats
+const SEARCH_RANK = `bm25(link_search_fts, 0.0, 2.0, 3.0, 1.0, 4.0)`
+
+// Matched terms are wrapped in control chars rather than <mark> tags so
+// the raw user-submitted text around them can be escaped first
+// (see GetSearchSnippetHTML)
+const (
+	SEARCH_MATCH_START = "\x02"
+	SEARCH_MATCH_END   = "\x03"
+	SEARCH_SNIPPET     = `snippet(link_search_fts, -1, char(2), char(3), '…', 12)`
+)
+
+var search_snippet_replacer = strings.NewReplacer(
+	SEARCH_MATCH_START, "<mark>",
+	SEARCH_MATCH_END, "</mark>",
+)
+
+// HTML-escapes a SEARCH_SNIPPET and wraps its matched terms in <mark> tags
+func GetSearchSnippetHTML(snippet string) string {
+	return search_snippet_replacer.Replace(html.EscapeString(snippet))
+}
+
+var search_term_regex = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// Compiles free text from the q= param into an FTS5 MATCH arg.
+// Every term is quoted so FTS5 syntax (AND/OR/NOT, column filters, etc.)
+// in user input is never interpreted. Terms are implicitly ANDed and the
+// last one is prefix-matched so partially typed words still match.
+func GetSearchMatchArg(q string) (string, error) {
+	terms := search_term_regex.FindAllString(q, -1)
+	if len(terms) == 0 {
+		return "", e.ErrInvalidSearchParams
+	} else if len(terms) > SEARCH_TERMS_LIMIT {
+		terms = terms[:SEARCH_TERMS_LIMIT]
+	}
+
+	quoted_terms := GetCatsSurroundedInDoubleQuotes(terms)
+	quoted_terms[len(quoted_terms)-1] += "*"
+
+	return strings.Join(quoted_terms, " "), nil
+}
+
+type LinkSearchResults struct {
+	*Query
+}
+
+// Ranks and snippets for the given links, best match first
+func NewLinkSearchResults(match_arg string, link_ids []string) *LinkSearchResults {
+	if len(link_ids) == 0 {
+		return &LinkSearchResults{
+			Query: &Query{
+				Error: e.ErrNoLinkID,
+			},
+		}
+	}
+
+	ids := make([]any, len(link_ids))
+	for i, id := range link_ids {
+		ids[i] = id
+	}
+
+	b := NewSelectBuilder().
+		Field("link_id", "link_id").
+		Field("search_rank", SEARCH_RANK).
+		Field("search_snippet", SEARCH_SNIPPET).
+		From("link_search_fts").
+		Where("search", "link_search_fts MATCH :search").
+		OrderBy("search_rank ASC").
+		Arg("search", match_arg)
+	b.Where("link_ids", "link_id IN ("+b.ArgList("link_id", ids)+")")
+
+	lsr := &LinkSearchResults{Query: &Query{}}
+	b.BuildInto(lsr.Query)
+
+	return lsr
+}
@@ -0,0 +1,138 @@
+package query
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestGetSearchMatchArg(t *testing.T) {
+	var test_queries = []struct {
+		Q     string
+		Want  string
+		Valid bool
+	}{
+		{"", "", false},
+		{"   ", "", false},
+		{`"*():-^`, "", false},
+		{"google", `"google"*`, true},
+		{"go concurrency", `"go" "concurrency"*`, true},
+		// FTS5 syntax is not interpreted
+		{"go OR NOT url:foo", `"go" "OR" "NOT" "url" "foo"*`, true},
+		{`say "hi"`, `"say" "hi"*`, true},
+		{"café über", `"café" "über"*`, true},
+	}
+
+	for _, tq := range test_queries {
+		got, err := GetSearchMatchArg(tq.Q)
+		if tq.Valid && err != nil {
+			t.Fatalf("failed with error: %s for q %q", err, tq.Q)
+		} else if !tq.Valid && err == nil {
+			t.Fatalf("expected error for q %q", tq.Q)
+		} else if got != tq.Want {
+			t.Fatalf("got %s, want %s for q %q", got, tq.Want, tq.Q)
+		}
+	}
+}
+
+func TestLinksMatchingSearch(t *testing.T) {
+	var test_params = []url.Values{
+		{"q": {"google"}},
+		{"q": {"google"}, "sort_by": {"newest"}},
+		{"q": {"google"}, "sort_by": {"relevance"}, "period": {"year"}},
+		{"q": {"google"}, "cats": {"search"}, "url_contains": {"google"}},
+		{"q": {"google"}, "nsfw": {"true"}},
+	}
+
+	for _, params := range test_params {
+		links_sql := NewTopLinks().FromRequestParams(params)
+		if links_sql.Error != nil {
+			t.Fatalf("failed with error: %s for params %v", links_sql.Error, params)
+		} else if !links_sql.IsSearch() {
+			t.Fatalf("expected search query for params %v", params)
+		}
+
+		links_sql = links_sql.AsSignedInUser(TEST_USER_ID).Page(2)
+
+		rows, err := TestClient.Query(links_sql.Text, links_sql.Args...)
+		if err != nil {
+			t.Fatalf("failed with error: %s for params %v", err, params)
+		}
+		cols, err := rows.Columns()
+		rows.Close()
+		if err != nil {
+			t.Fatal(err)
+		} else if cols[len(cols)-1] != "search_snippet" {
+			t.Fatalf("got last column %s, want search_snippet", cols[len(cols)-1])
+		}
+
+		// NSFW count query must still be valid
+		count_sql := links_sql.NSFWLinks(params.Get("nsfw") == "true")
+		var count int
+		if err := TestClient.QueryRow(count_sql.Text, count_sql.Args...).Scan(&count); err != nil {
+			t.Fatalf("NSFW count failed with error: %s for params %v", err, params)
+		}
+	}
+
+	// relevance sort requires a search
+	links_sql := NewTopLinks().FromRequestParams(url.Values{"sort_by": {"relevance"}})
+	if links_sql.Error == nil {
+		t.Fatal("expected error for sort_by=relevance without q")
+	}
+
+	links_sql = NewTopLinks().FromRequestParams(url.Values{"q": {"!!!"}})
+	if links_sql.Error == nil {
+		t.Fatal("expected error for q without searchable terms")
+	}
+}
+
+func TestNewLinkSearchResults(t *testing.T) {
+	if results_sql := NewLinkSearchResults(`"google"*`, []string{}); results_sql.Error == nil {
+		t.Fatal("expected error for no link IDs")
+	}
+
+	results_sql := NewLinkSearchResults(`"google"*`, []string{"1", "2", "3"})
+	if results_sql.Error != nil {
+		t.Fatal(results_sql.Error)
+	} else if len(results_sql.Args) != 4 {
+		t.Fatalf("got %d args, want 4", len(results_sql.Args))
+	}
+
+	rows, err := TestClient.Query(results_sql.Text, results_sql.Args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var link_id, snippet string
+		var rank float64
+		if err = rows.Scan(&link_id, &rank, &snippet); err != nil {
+			t.Fatal(err)
+		} else if !strings.Contains(snippet, SEARCH_MATCH_START) {
+			t.Fatalf("link %s: got snippet %q without match markers", link_id, snippet)
+		}
+	}
+}
+
+func TestGetSearchSnippetHTML(t *testing.T) {
+	var test_cases = []struct {
+		Snippet string
+		HTML    string
+	}{
+		{"plain text", "plain text"},
+		{"a \x02match\x03 here", "a <mark>match</mark> here"},
+		{
+			"<img src=x onerror=alert(1)> \x02go\x03 & \"more\"",
+			"&lt;img src=x onerror=alert(1)&gt; <mark>go</mark> &amp; &#34;more&#34;",
+		},
+		// user-submitted tags are escaped, not treated as matches
+		{"<mark>fake</mark>", "&lt;mark&gt;fake&lt;/mark&gt;"},
+	}
+
+	for _, tc := range test_cases {
+		if got := GetSearchSnippetHTML(tc.Snippet); got != tc.HTML {
+			t.Fatalf("%q: got %q, want %q", tc.Snippet, got, tc.HTML)
+		}
+	}
+}
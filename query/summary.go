@@ -108,3 +108,27 @@ func (s *Summaries) AsSignedInUser(user_id string) *Summaries {
 
 const SUMMARIES_IS_LIKED_FIELD = `
 COALESCE(is_liked,0) as is_liked`
+
+type SummaryRevisions struct {
+	*Query
+}
+
+func NewSummaryRevisions(summary_id string) *SummaryRevisions {
+	return &SummaryRevisions{
+		Query: &Query{
+			Text: SUMMARY_REVISIONS,
+			Args: []any{summary_id},
+		},
+	}
+}
+
+const SUMMARY_REVISIONS = `SELECT
+	sr.id,
+	sr.text,
+	COALESCE(u.login_name, '') as ln,
+	sr.last_updated,
+	sr.replaced
+FROM "Summary Revisions" sr
+LEFT JOIN Users u ON u.id = sr.submitted_by
+WHERE sr.summary_id = ?
+ORDER BY sr.replaced DESC, sr.last_updated DESC;`
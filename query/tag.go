@@ -1,7 +1,6 @@
 package query
 
 import (
-	"fmt"
 	"net/url"
 	"strings"
 
@@ -16,6 +15,8 @@ const (
 
 	SPELLFIX_DISTANCE_LIMIT     = 100
 	SPELLFIX_MATCHES_LIMIT      = 3
+	// candidates fetched before weighting by filter context
+	SPELLFIX_CONTEXT_CANDIDATES_LIMIT = 50
 )
 
 type TagRankings struct {
@@ -60,36 +61,77 @@ const TAG_RANKINGS_PUBLIC_FIELDS = `,
 	Tags.submitted_by, 
 	last_updated`
 
-type GlobalCatCounts struct {
+// Everything the global cats scoring strategies can weigh a tag by
+type TagVotes struct {
 	*Query
 }
 
-func NewTopGlobalCatCounts() *GlobalCatCounts {
-	return (&GlobalCatCounts{
+func NewTagVotes(link_id string) *TagVotes {
+	return (&TagVotes{
 		Query: &Query{
-			Text: GLOBAL_CATS_BASE,
-			Args: []any{GLOBAL_CATS_PAGE_LIMIT},
+			Text: TAG_VOTES,
+			Args: []any{link_id, TAG_RANKINGS_CALC_LIMIT},
 		},
 	})
 }
 
-// id used for .SubcatsOfCats(): don't remove
-const GLOBAL_CATS_BASE = `WITH RECURSIVE GlobalCatsSplit(id, global_cat, str) AS (
-    SELECT id, '', global_cats||','
+var TAG_VOTES = TAG_RANKINGS_BASE_FIELDS + `,
+	Tags.submitted_by,
+	julianday('now') - julianday(last_updated) AS age_days,
+	(
+		SELECT count(ll.id)
+		FROM "Link Likes" ll
+		INNER JOIN Links sl ON sl.id = ll.link_id
+		WHERE sl.submitted_by = Tags.submitted_by
+	) AS submitter_like_count
+FROM Tags
+INNER JOIN Links
+ON Links.id = Tags.link_id
+WHERE link_id = ?
+ORDER BY lifespan_overlap DESC
+LIMIT ?;`
+
+type GlobalCatCounts struct {
+	*Query
+	builder *SelectBuilder
+}
+
+func NewTopGlobalCatCounts() *GlobalCatCounts {
+	gcc := &GlobalCatCounts{
+		Query: &Query{},
+		builder: NewSelectBuilder().
+			Recursive().
+			With("GlobalCatsSplit(id, global_cat, str, url, submit_date)", GLOBAL_CATS_SPLIT_CTE).
+			Field("global_cat", "global_cat").
+			Field("count", "count(DISTINCT id)").
+			From("GlobalCatsSplit").
+			Where("not_empty", "global_cat != ''").
+			GroupBy("LOWER(global_cat)").
+			OrderBy("count DESC, LOWER(global_cat) ASC").
+			Limit(GLOBAL_CATS_PAGE_LIMIT),
+	}
+
+	return gcc.build()
+}
+
+func (gcc *GlobalCatCounts) build() *GlobalCatCounts {
+	gcc.builder.BuildInto(gcc.Query)
+	return gcc
+}
+
+// One row per link per global cat. id is used by SubcatsOfCats, url and
+// submit_date by WithURLContaining and DuringPeriod: don't remove.
+const GLOBAL_CATS_SPLIT_CTE = `
+    SELECT id, '', global_cats||',', url, submit_date
     FROM Links
     UNION ALL SELECT
 	id,
     substr(str, 0, instr(str, ',')),
-    substr(str, instr(str, ',') + 1)
+    substr(str, instr(str, ',') + 1),
+	url,
+	submit_date
     FROM GlobalCatsSplit
-    WHERE str != ''
-)
-SELECT global_cat, count(DISTINCT id) as count
-FROM GlobalCatsSplit
-WHERE global_cat != ''
-GROUP BY LOWER(global_cat)
-ORDER BY count DESC, LOWER(global_cat) ASC
-LIMIT ?`
+    WHERE str != ''`
 
 func (gcc *GlobalCatCounts) FromRequestParams(params url.Values) *GlobalCatCounts {
 	cats_params := params.Get("cats")
@@ -117,84 +159,45 @@ func (gcc *GlobalCatCounts) FromRequestParams(params url.Values) *GlobalCatCount
 	return gcc
 }
 
+// Cats co-occurring on links that match cats_params, minus the filter
+// cats themselves. Plural/singular variants of the filter cats are still
+// counted: GetTopGlobalCats reports them as MergedCats when more=true.
 func (gcc *GlobalCatCounts) SubcatsOfCats(cats_params string) *GlobalCatCounts {
-	// Lowercase to ensure all case variations are returned
-	cats := strings.Split(strings.ToLower(cats_params), ",")
-
-	// Build NOT IN clause
-	not_in_clause := `
-	AND LOWER(global_cat) NOT IN (?`
-
-	gcc.Args = append(gcc.Args, cats[0])
-	for i := 1; i < len(cats); i++ {
-		not_in_clause += ", ?"
-		gcc.Args = append(gcc.Args, cats[i])
-	}
-	not_in_clause += ")"
-
-	// Build MATCH clause
-	match_clause := `
-	AND id IN (
-		SELECT link_id
-		FROM global_cats_fts
-		WHERE global_cats MATCH ?
-		)`
-
-	match_arg := WithOptionalPluralOrSingularForm(cats[0])
-	for i := 1; i < len(cats); i++ {
-		match_arg += " AND " + WithOptionalPluralOrSingularForm(cats[i])
+	cats_filter, err := ParseCatsFilter(cats_params)
+	if err != nil {
+		gcc.Error = err
+		return gcc
 	}
-	// Add optional singular/plural variants
-	// (skip for NOT IN clause otherwise subcats include filters)
-	gcc.Args = append(gcc.Args, match_arg)
 
-	gcc.Text = strings.Replace(
-		gcc.Text,
-		"WHERE global_cat != ''",
-		"WHERE global_cat != ''"+
-			not_in_clause+
-			match_clause,
-		1)
-
-	// Move LIMIT arg to end
-	gcc.Args = append(gcc.Args[1:], GLOBAL_CATS_PAGE_LIMIT)
+	// Lowercase to ensure all case variations are returned
+	// (skip optional singular/plural variants here otherwise subcats
+	// include filters)
+	var cats []any
+	for _, cat := range cats_filter.Cats() {
+		cats = append(cats, strings.ToLower(cat))
+	}
 
-	return gcc
+	gcc.builder.
+		Where(
+			"not_filter_cats",
+			"LOWER(global_cat) NOT IN ("+gcc.builder.ArgList("filter_cat", cats)+")",
+		).
+		Where("cats", `id IN (
+	SELECT link_id
+	FROM global_cats_fts
+	WHERE global_cats MATCH :cats
+)`).
+		Arg("cats", cats_filter.MatchArg())
+
+	return gcc.build()
 }
 
 func (gcc *GlobalCatCounts) WithURLContaining(snippet string) *GlobalCatCounts {
-	gcc.Text = strings.Replace(
-		gcc.Text,
-		"WITH RECURSIVE GlobalCatsSplit(id, global_cat, str)",
-		"WITH RECURSIVE GlobalCatsSplit(id, global_cat, str, url)",
-		1,
-	)
-	gcc.Text = strings.Replace(
-		gcc.Text,
-		"SELECT id, '', global_cats||','",
-		"SELECT id, '', global_cats||',', url",
-		1,
-	)
-	gcc.Text = strings.Replace(
-		gcc.Text,
-		"substr(str, instr(str, ',') + 1)",
-		"substr(str, instr(str, ',') + 1),\nurl",
-		1,
-	)
-	gcc.Text = strings.Replace(
-		gcc.Text,
-		"GROUP BY LOWER(global_cat)",
-		"\nAND url LIKE ?\nGROUP BY LOWER(global_cat)",
-		1,
-	)
-
-	// insert into args in 2nd-to-last position
-	last_arg := gcc.Args[len(gcc.Args)-1]
-	gcc.Args = gcc.Args[:len(gcc.Args)-1]
-	gcc.Args = append(gcc.Args, "%"+snippet+"%")
-	gcc.Args = append(gcc.Args, last_arg)
+	gcc.builder.
+		Where("url_contains", "url LIKE :url_contains").
+		Arg("url_contains", "%"+snippet+"%")
 
-	return gcc
+	return gcc.build()
 }
 
 func (gcc *GlobalCatCounts) DuringPeriod(period string) *GlobalCatCounts {
@@ -208,70 +211,92 @@ func (gcc *GlobalCatCounts) DuringPeriod(period string) *GlobalCatCounts {
 		return gcc
 	}
 
-	gcc.Text = strings.Replace(
-		gcc.Text,
-		"FROM Links",
-		fmt.Sprintf(
-			`FROM Links
-			WHERE %s`,
-			clause,
-		),
-		1)
+	gcc.builder.Where("period", clause)
 
-	return gcc
+	return gcc.build()
 }
 
 func (gcc *GlobalCatCounts) More() *GlobalCatCounts {
-	gcc.Args[len(gcc.Args)-1] = MORE_GLOBAL_CATS_PAGE_LIMIT
-	return gcc
+	// OnlyCats' limit already covers all its cats
+	if gcc.builder.HasWhere("only_cats") {
+		return gcc
+	}
+
+	gcc.builder.Limit(MORE_GLOBAL_CATS_PAGE_LIMIT)
+	return gcc.build()
+}
+
+// Counts only the given cats (case-insensitive), none of them cut by the
+// page limit
+func (gcc *GlobalCatCounts) OnlyCats(cats []string) *GlobalCatCounts {
+	if len(cats) == 0 {
+		gcc.Error = e.ErrNoCats
+		return gcc
+	}
+
+	lc_cats := make([]any, len(cats))
+	for i, cat := range cats {
+		lc_cats[i] = strings.ToLower(cat)
+	}
+
+	gcc.builder.
+		Where(
+			"only_cats",
+			"LOWER(global_cat) IN ("+gcc.builder.ArgList("only_cat", lc_cats)+")",
+		).
+		Limit(len(cats))
+
+	return gcc.build()
 }
 
 type SpellfixMatches struct {
 	*Query
+	builder *SelectBuilder
 }
 
 func NewSpellfixMatchesForSnippet(snippet string) *SpellfixMatches {
 	// oddly, "WHERE word MATCH "%s OR %s*" doesn't work very well here
 	// hence the UNION
-	return (&SpellfixMatches{
-		Query: &Query{
-			Text: `WITH CombinedResults AS (
+	sm := &SpellfixMatches{
+		Query: &Query{},
+		builder: NewSelectBuilder().
+			With("CombinedResults", `
 		SELECT word, rank, distance
 		FROM global_cats_spellfix
-		WHERE word MATCH ?
+		WHERE word MATCH :snippet
 		UNION ALL
 		SELECT word, rank, distance
 		FROM global_cats_spellfix
-		WHERE word MATCH ? || '*'
-			),
-		RankedResults AS (
-			SELECT 
-				word, 
-				rank,
-				distance,
-				ROW_NUMBER() OVER (PARTITION BY word ORDER BY distance) AS row_num
-			FROM CombinedResults
-		),
-		TopResults AS (
-			SELECT word, rank, distance
-			FROM RankedResults
-			WHERE row_num = 1
-			AND distance <= ?
-			ORDER BY distance, rank DESC
-		)
-		SELECT word, SUM(rank) as rank
-		FROM TopResults
-		GROUP BY LOWER(word)
-		ORDER BY distance, rank DESC
-		LIMIT ?;`,
-			Args: []any{
-				snippet,
-				snippet,
-				SPELLFIX_DISTANCE_LIMIT,
-				SPELLFIX_MATCHES_LIMIT,
-			},
-		},
-	})
+		WHERE word MATCH :snippet || '*'`).
+			With("RankedResults", `
+		SELECT 
+			word, 
+			rank,
+			distance,
+			ROW_NUMBER() OVER (PARTITION BY word ORDER BY distance) AS row_num
+		FROM CombinedResults`).
+			With("TopResults", `
+		SELECT word, rank, distance
+		FROM RankedResults
+		WHERE row_num = 1
+		AND distance <= :distance_limit
+		ORDER BY distance, rank DESC`).
+			Field("word", "word").
+			Field("rank", "SUM(rank)").
+			From("TopResults").
+			GroupBy("LOWER(word)").
+			OrderBy("distance, rank DESC").
+			Limit(SPELLFIX_MATCHES_LIMIT).
+			Arg("snippet", snippet).
+			Arg("distance_limit", SPELLFIX_DISTANCE_LIMIT),
+	}
+
+	return sm.build()
+}
+
+func (sm *SpellfixMatches) build() *SpellfixMatches {
+	sm.builder.BuildInto(sm.Query)
+	return sm
 }
 
 func (sm *SpellfixMatches) OmitCats(cats []string) error {
@@ -279,29 +304,69 @@ func (sm *SpellfixMatches) OmitCats(cats []string) error {
 		return e.ErrNoOmittedCats
 	}
 
-	// Pop SPELLFIX_MATCHES_LIMIT arg
-	sm.Args = sm.Args[0 : len(sm.Args)-1]
+	omitted := make([]any, len(cats))
+	for i, cat := range cats {
+		omitted[i] = cat
+	}
+
+	sm.builder.Where(
+		"not_omitted",
+		"LOWER(word) NOT IN ("+sm.builder.ArgList("omitted", omitted)+")",
+	)
+	sm.build()
+
+	return nil
+}
 
-	not_in_clause := `
-	AND LOWER(word) NOT IN (?`
-	sm.Args = append(sm.Args, cats[0])
+// Fetches enough candidates to be narrowed down by filter context
+// (see handler/util WeightSpellfixMatchesByContext)
+func (sm *SpellfixMatches) ForContext() *SpellfixMatches {
+	sm.builder.Limit(SPELLFIX_CONTEXT_CANDIDATES_LIMIT)
+	return sm.build()
+}
 
-	for i := 1; i < len(cats); i++ {
-		not_in_clause += ", ?"
-		sm.Args = append(sm.Args, cats[i])
+type TagRevisions struct {
+	*Query
+}
+
+func NewTagRevisions(link_id string) *TagRevisions {
+	return &TagRevisions{
+		Query: &Query{
+			Text: TAG_REVISIONS,
+			Args: []any{link_id},
+		},
 	}
-	not_in_clause += ")"
+}
 
-	distance_clause := `AND distance <= ?`
-	sm.Text = strings.Replace(
-		sm.Text,
-		distance_clause,
-		distance_clause+not_in_clause,
-		1,
-	)
+const TAG_REVISIONS = `SELECT
+	id,
+	tag_id,
+	cats,
+	submitted_by,
+	last_updated,
+	replaced
+FROM "Tag Revisions"
+WHERE link_id = ?
+ORDER BY replaced DESC, last_updated DESC;`
 
-	// Push SPELLFIX_MATCHES_LIMIT arg back to end
-	sm.Args = append(sm.Args, SPELLFIX_MATCHES_LIMIT)
+type GlobalCatsHistory struct {
+	*Query
+}
 
-	return nil
+func NewGlobalCatsHistory(link_id string) *GlobalCatsHistory {
+	return &GlobalCatsHistory{
+		Query: &Query{
+			Text: GLOBAL_CATS_HISTORY,
+			Args: []any{link_id},
+		},
+	}
 }
+
+const GLOBAL_CATS_HISTORY = `SELECT
+	global_cats,
+	added,
+	removed,
+	timestamp
+FROM "Global Cats History"
+WHERE link_id = ?
+ORDER BY timestamp DESC, rowid DESC;`
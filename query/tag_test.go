@@ -2,6 +2,8 @@ package query
 
 import (
 	"database/sql"
+	"net/url"
+	"slices"
 	"strings"
 	"testing"
 
@@ -146,6 +148,13 @@ func TestNewTopGlobalCatCountsSubcatsOfCats(t *testing.T) {
 
 	// Verify counts
 	for _, c := range counts {
+		// filter cats are never counted as their own subcats
+		if slices.ContainsFunc(test_cats, func(cat string) bool {
+			return strings.EqualFold(cat, c.Category)
+		}) {
+			t.Fatalf("filter cat %s returned as subcat", c.Category)
+		}
+
 		var count int32
 		if err := TestClient.QueryRow(`SELECT count(id) as count 
 		FROM LINKS 
@@ -254,6 +263,50 @@ func TestNewTopGlobalCatCountsDuringPeriod(t *testing.T) {
 	}
 }
 
+func TestNewTopGlobalCatCountsOnlyCats(t *testing.T) {
+	only_cats := []string{"umvc3", "Flowers", "test_no_links_cat"}
+
+	var test_params = []url.Values{
+		{},
+		{"cats": {"umvc3"}},
+		{"url_contains": {"www"}, "period": {"year"}},
+		{"more": {"true"}},
+	}
+
+	for _, params := range test_params {
+		counts_sql := NewTopGlobalCatCounts().
+			FromRequestParams(params).
+			OnlyCats(only_cats)
+		if counts_sql.Error != nil {
+			t.Fatalf("failed with error: %s for params %v", counts_sql.Error, params)
+		} else if limit := GetNamedArg(counts_sql.Args, "limit"); limit != len(only_cats) {
+			t.Fatalf("got limit %v, want %d", limit, len(only_cats))
+		}
+
+		rows, err := TestClient.Query(counts_sql.Text, counts_sql.Args...)
+		if err != nil {
+			t.Fatalf("failed with error: %s for params %v", err, params)
+		}
+
+		for rows.Next() {
+			var cat string
+			var count int
+			if err := rows.Scan(&cat, &count); err != nil {
+				t.Fatal(err)
+			} else if !slices.ContainsFunc(only_cats, func(c string) bool {
+				return strings.EqualFold(c, cat)
+			}) {
+				t.Fatalf("got cat %s not in %v for params %v", cat, only_cats, params)
+			}
+		}
+		rows.Close()
+	}
+
+	if NewTopGlobalCatCounts().OnlyCats([]string{}).Error == nil {
+		t.Fatal("expected error for no cats")
+	}
+}
+
 const TEST_SNIPPET = "test"
 
 func TestNewSpellfixMatchesForSnippet(t *testing.T) {
@@ -311,3 +364,31 @@ func TestOmitCats(t *testing.T) {
 		}
 	}
 }
+
+func TestSpellfixMatchesForContext(t *testing.T) {
+	matches_sql := NewSpellfixMatchesForSnippet(TEST_SNIPPET).ForContext()
+	if err := matches_sql.OmitCats([]string{TEST_SNIPPET}); err != nil {
+		t.Fatal(err)
+	}
+
+	// OmitCats keeps the context limit
+	if limit := GetNamedArg(matches_sql.Args, "limit"); limit != SPELLFIX_CONTEXT_CANDIDATES_LIMIT {
+		t.Fatalf("got limit %v, want %d", limit, SPELLFIX_CONTEXT_CANDIDATES_LIMIT)
+	}
+
+	rows, err := TestClient.Query(matches_sql.Text, matches_sql.Args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var word string
+		var rank int
+		if err := rows.Scan(&word, &rank); err != nil {
+			t.Fatal(err)
+		} else if strings.EqualFold(word, TEST_SNIPPET) {
+			t.Fatalf("got omitted word %s", word)
+		}
+	}
+}
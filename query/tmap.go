@@ -5,7 +5,6 @@ import (
 
 	e "github.com/julianlk522/fitm/error"
 	"github.com/julianlk522/fitm/model"
-	mutil "github.com/julianlk522/fitm/model/util"
 )
 
 type TmapProfile struct {
@@ -32,127 +31,72 @@ WHERE login_name = ?;`
 
 type TmapNSFWLinksCount struct {
 	*Query
+	builder *SelectBuilder
 }
 
 func NewTmapNSFWLinksCount(login_name string) *TmapNSFWLinksCount {
-	return &TmapNSFWLinksCount{
-		&Query{
-			Text: TMAP_NSFW_LINKS_COUNT,
-			Args: []any{
-				login_name, 
-				login_name, 
-				login_name,
-			},
-		},
-	}
-}
-
-const TMAP_NSFW_LINKS_COUNT = `WITH PossibleUserCats AS (
-    SELECT 
-		link_id, 
-		cats AS user_cats,
-		(cats IS NOT NULL) AS cats_from_user
-    FROM user_cats_fts
-    WHERE submitted_by = ?
-	AND cats MATCH 'NSFW'
-),
-GlobalCatsFTS AS (
+	tnlc := &TmapNSFWLinksCount{
+		Query: &Query{},
+		builder: NewSelectBuilder().
+			With("PossibleUserCats", POSSIBLE_USER_CATS_CTE+`
+	AND cats MATCH :nsfw_cats`).
+			With("GlobalCatsFTS", `
 	SELECT
 		link_id,
 		global_cats
 	FROM global_cats_fts
-	WHERE global_cats MATCH 'NSFW'
-),
-UserCopies AS (
-    SELECT lc.link_id
-    FROM "Link Copies" lc
-    INNER JOIN Users u ON u.id = lc.user_id
-    WHERE u.login_name = ?
-)
-SELECT count(*) as NSFW_link_count
-FROM Links l
-LEFT JOIN PossibleUserCats puc ON l.id = puc.link_id
-LEFT JOIN GlobalCatsFTS gc ON l.id = gc.link_id
-WHERE 
-	(
+	WHERE global_cats MATCH :nsfw_cats`).
+			With("UserCopies", USER_COPIES_CTE).
+			Field("NSFW_link_count", "count(*)").
+			From("Links l").
+			Join("puc", "LEFT JOIN PossibleUserCats puc ON l.id = puc.link_id").
+			Join("gc", "LEFT JOIN GlobalCatsFTS gc ON l.id = gc.link_id").
+			Where("nsfw", `(
 	gc.global_cats IS NOT NULL
 	OR
 	puc.user_cats IS NOT NULL
-	)
-AND (
-	l.submitted_by = ?
+)`).
+			Where("section", `(
+	l.submitted_by = :login_name
 	OR l.id IN UserCopies
 	OR l.id IN 
 		(
 		SELECT link_id
 		FROM PossibleUserCats
 		)
-	);`
+)`).
+			Arg("login_name", login_name).
+			Arg("nsfw_cats", "NSFW"),
+	}
 
-func (tnlc *TmapNSFWLinksCount) SubmittedOnly() *TmapNSFWLinksCount {
-	tnlc.Text = strings.Replace(
-		tnlc.Text,
-		`(
-	l.submitted_by = ?
-	OR l.id IN UserCopies
-	OR l.id IN 
-		(
-		SELECT link_id
-		FROM PossibleUserCats
-		)
-	)`,
-		"l.submitted_by = ?",
-		1,
-	)
+	return tnlc.build()
+}
 
+func (tnlc *TmapNSFWLinksCount) build() *TmapNSFWLinksCount {
+	tnlc.builder.BuildInto(tnlc.Query)
 	return tnlc
 }
 
-func (tnlc *TmapNSFWLinksCount) CopiedOnly() *TmapNSFWLinksCount {
-	tnlc.Text = strings.Replace(
-		tnlc.Text,
-		`(
-	l.submitted_by = ?
-	OR l.id IN UserCopies
-	OR l.id IN 
-		(
-		SELECT link_id
-		FROM PossibleUserCats
-		)
-	)`,
-		"l.id IN UserCopies",
-		1,
-	)
-
-	tnlc.Args = tnlc.Args[:len(tnlc.Args)-1]
+func (tnlc *TmapNSFWLinksCount) SubmittedOnly() *TmapNSFWLinksCount {
+	tnlc.builder.Where("section", "l.submitted_by = :login_name")
+	return tnlc.build()
+}
 
-	return tnlc
+func (tnlc *TmapNSFWLinksCount) CopiedOnly() *TmapNSFWLinksCount {
+	tnlc.builder.Where("section", "l.id IN UserCopies")
+	return tnlc.build()
 }
 
 func (tnlc *TmapNSFWLinksCount) TaggedOnly() *TmapNSFWLinksCount {
-	tnlc.Text = strings.Replace(
-		tnlc.Text,
-		`
-	l.submitted_by = ?
-	OR l.id IN UserCopies
-	OR l.id IN 
-		(
-		SELECT link_id
-		FROM PossibleUserCats
-		)
-	`,
-		`
-	l.submitted_by != ?
+	tnlc.builder.Where("section", `(
+	l.submitted_by != :login_name
 	AND l.id IN 
 		(
 		SELECT link_id
 		FROM PossibleUserCats
 		)
-	`,
-		1,
-	)
-
-	return tnlc
+)`)
+	return tnlc.build()
 }
 
 func (tnlc *TmapNSFWLinksCount) FromCats(cats []string) *TmapNSFWLinksCount {
@@ -160,62 +104,27 @@ func (tnlc *TmapNSFWLinksCount) FromCats(cats []string) *TmapNSFWLinksCount {
 		return tnlc
 	}
 
-	tnlc.Text = strings.ReplaceAll(tnlc.Text, "'NSFW'", "?")
-
-	// Build MATCH clause
-	match_arg := "NSFW AND " + cats[0]
-	for i := 1; i < len(cats); i++ {
-		match_arg += " AND " + cats[i]
-	}
-
-	// Insert match_arg arg * 2 after first login_name arg and before last 2 args
-	trailing_args := make([]any, len(tnlc.Args[1:]))
-	copy(trailing_args, tnlc.Args[1:])
-	tnlc.Args = append(tnlc.Args[:1], match_arg, match_arg)
-	tnlc.Args = append(tnlc.Args, trailing_args...)
-
-	return tnlc
+	tnlc.builder.Arg("nsfw_cats", "NSFW AND "+strings.Join(cats, " AND "))
+	return tnlc.build()
 }
 
 func (tnlc *TmapNSFWLinksCount) DuringPeriod(period string) *TmapNSFWLinksCount {
-	if period == "all" {
-		return tnlc
-	}
-
-	period_clause, err := GetPeriodClause(period)
-	if err != nil {
+	if err := WithTmapPeriod(tnlc.builder, period); err != nil {
 		tnlc.Error = err
 		return tnlc
 	}
 
-	period_clause = strings.Replace(
-		period_clause,
-		"submit_date",
-		"l.submit_date",
-		1,
-	)
-
-	tnlc.Text = strings.Replace(
-		tnlc.Text,
-		";",
-		"\nAND "+period_clause + ";",
-		1,
-	)
-
-	return tnlc
+	return tnlc.build()
 }
 
 func (tnlc *TmapNSFWLinksCount) WithURLContaining(snippet string) *TmapNSFWLinksCount {
-	tnlc.Text = strings.Replace(
-		tnlc.Text,
-		";",
-		"\nAND url LIKE ?;",
-		1,
-	)
-
-	tnlc.Args = append(tnlc.Args, "%"+snippet+"%")
+	WithTmapURLContaining(tnlc.builder, snippet)
+	return tnlc.build()
+}
 
-	return tnlc
+func (tnlc *TmapNSFWLinksCount) MatchingSearch(match_arg string) *TmapNSFWLinksCount {
+	WithTmapSearch(tnlc.builder, match_arg)
+	return tnlc.build()
 }
 
 func (tnlc *TmapNSFWLinksCount) FromOptions(opts *model.TmapNSFWLinksCountOptions) *TmapNSFWLinksCount {
@@ -245,146 +154,75 @@ func (tnlc *TmapNSFWLinksCount) FromOptions(opts *model.TmapNSFWLinksCountOption
 		tnlc.WithURLContaining(opts.URLContains)
 	}
 
+	if opts.SearchMatchArg != "" {
+		tnlc.MatchingSearch(opts.SearchMatchArg)
+	}
+
 	return tnlc
 }
 
 type TmapSubmitted struct {
 	*Query
+	builder *SelectBuilder
 }
 
 func NewTmapSubmitted(login_name string) *TmapSubmitted {
-	return &TmapSubmitted{
-		Query: &Query{
-			Text: "WITH " + TMAP_BASE_CTES + "," +
-				POSSIBLE_USER_CATS_CTE + "," +
-				POSSIBLE_USER_SUMMARY_CTE +
-				TMAP_BASE_FIELDS +
-				TMAP_FROM +
-				TMAP_BASE_JOINS +
-				TMAP_NO_NSFW_CATS_WHERE +
-				SUBMITTED_WHERE +
-				TMAP_DEFAULT_ORDER_BY,
-			Args: []any{
-				mutil.EARLIEST_LIKERS_AND_COPIERS_LIMIT, 
-				mutil.EARLIEST_LIKERS_AND_COPIERS_LIMIT, 
-				login_name, 
-				login_name, 
-				login_name,
-			},
-		},
+	ts := &TmapSubmitted{
+		Query:   &Query{},
+		builder: NewTmapSectionBuilder(login_name),
 	}
+	ts.builder.Where("section", "l.submitted_by = :login_name")
+
+	return ts.build()
 }
 
-const SUBMITTED_WHERE = `
-AND l.submitted_by = ?`
+func (ts *TmapSubmitted) build() *TmapSubmitted {
+	ts.builder.BuildInto(ts.Query)
+	return ts
+}
 
 func (ts *TmapSubmitted) FromCats(cats []string) *TmapSubmitted {
-	ts.Query = FromUserOrGlobalCats(ts.Query, cats)
-	return ts
+	FromUserOrGlobalCats(ts.builder, cats)
+	return ts.build()
 }
 
 func (ts *TmapSubmitted) AsSignedInUser(req_user_id string) *TmapSubmitted {
-	fields_replacer := strings.NewReplacer(
-		TMAP_BASE_CTES, TMAP_BASE_CTES+","+TMAP_AUTH_CTES,
-		TMAP_BASE_FIELDS, TMAP_BASE_FIELDS+TMAP_AUTH_FIELDS,
-		TMAP_BASE_JOINS, TMAP_BASE_JOINS+TMAP_AUTH_JOINS,
-	)
-	ts.Text = fields_replacer.Replace(ts.Text)
-
-	new_args := make([]any, 0, len(ts.Args)+2)
-
-	first_2_args := make([]any, 2)
-	copy(first_2_args, ts.Args[:2])
-
-	trailing_args := ts.Args[2:]
-
-	new_args = append(new_args, first_2_args...)
-	new_args = append(new_args, req_user_id, req_user_id)
-	new_args = append(new_args, trailing_args...)
-
-	ts.Args = new_args
-	return ts
+	WithSignedInUser(ts.builder, req_user_id)
+	return ts.build()
 }
 
 func (ts *TmapSubmitted) NSFW() *TmapSubmitted {
-	// Remove NSFW clause
-	ts.Text = strings.Replace(
-		ts.Text,
-		TMAP_NO_NSFW_CATS_WHERE,
-		"",
-		1,
-	)
-
-	// Swap AND to WHERE in WHERE clause
-	ts.Text = strings.Replace(
-		ts.Text,
-		"AND l.submitted_by",
-		"WHERE l.submitted_by",
-		1,
-	)
-	return ts
+	ts.builder.WithoutWhere("nsfw")
+	return ts.build()
 }
 
 func (ts *TmapSubmitted) SortByNewest() *TmapSubmitted {
-	ts.Text = strings.Replace(
-		ts.Text,
-		TMAP_DEFAULT_ORDER_BY,
-		TMAP_ORDER_BY_NEWEST,
-		1,
-	)
-
-	return ts
+	ts.builder.OrderBy(TMAP_ORDER_BY_NEWEST)
+	return ts.build()
 }
 
 func (ts *TmapSubmitted) DuringPeriod(period string) *TmapSubmitted {
-	if period == "all" {
-		return ts
-	}
-	
-	period_clause, err := GetPeriodClause(period)
-	if err != nil {
+	if err := WithTmapPeriod(ts.builder, period); err != nil {
 		ts.Error = err
 		return ts
 	}
 
-	period_clause = strings.Replace(
-		period_clause,
-		"submit_date",
-		"l.submit_date",
-		1,
-	)
-
-	for _, order_by := range []string{
-		TMAP_DEFAULT_ORDER_BY, 
-		TMAP_ORDER_BY_NEWEST,
-	} {
-		ts.Text = strings.Replace(
-			ts.Text,
-			order_by,
-			"\nAND " + period_clause + order_by,
-			1,
-		)
-	}
-
-	return ts
+	return ts.build()
 }
 
 func (ts *TmapSubmitted) WithURLContaining(snippet string) *TmapSubmitted {
-	for _, order_by := range []string{
-		TMAP_DEFAULT_ORDER_BY, 
-		TMAP_ORDER_BY_NEWEST,
-	} {
-		ts.Text = strings.Replace(
-			ts.Text,
-			order_by,
-			"\nAND " + "url LIKE ?" + order_by,
-			1,
-		)
-	} 
+	WithTmapURLContaining(ts.builder, snippet)
+	return ts.build()
+}
 
-	ts.Args = append(ts.Args, "%"+snippet+"%")
+func (ts *TmapSubmitted) MatchingSearch(match_arg string) *TmapSubmitted {
+	WithTmapSearch(ts.builder, match_arg)
+	return ts.build()
+}
 
-	return ts
+func (ts *TmapSubmitted) ForExport() *TmapSubmitted {
+	WithTmapExportFields(ts.builder)
+	return ts.build()
 }
 
 func (ts *TmapSubmitted) FromOptions(opts *model.TmapOptions) *TmapSubmitted {
@@ -412,156 +250,78 @@ func (ts *TmapSubmitted) FromOptions(opts *model.TmapOptions) *TmapSubmitted {
 		ts.WithURLContaining(opts.URLContains)
 	}
 
+	if opts.SearchMatchArg != "" {
+		ts.MatchingSearch(opts.SearchMatchArg)
+	}
+
 	return ts
 }
 
 type TmapCopied struct {
 	*Query
+	builder *SelectBuilder
 }
 
 func NewTmapCopied(login_name string) *TmapCopied {
-	q := &TmapCopied{
-		Query: &Query{
-			Text: "WITH " + USER_COPIES_CTE + ",\n" +
-				TMAP_BASE_CTES + "," +
-				POSSIBLE_USER_CATS_CTE + "," +
-				POSSIBLE_USER_SUMMARY_CTE +
-				TMAP_BASE_FIELDS +
-				TMAP_FROM +
-				COPIED_JOIN +
-				TMAP_BASE_JOINS +
-				TMAP_NO_NSFW_CATS_WHERE +
-				COPIED_WHERE +
-				TMAP_DEFAULT_ORDER_BY,
-			Args: []any{
-				login_name, 
-				mutil.EARLIEST_LIKERS_AND_COPIERS_LIMIT, 
-				mutil.EARLIEST_LIKERS_AND_COPIERS_LIMIT, 
-				login_name,
-				login_name, 
-				login_name,
-			},
-		},
+	tc := &TmapCopied{
+		Query:   &Query{},
+		builder: NewTmapSectionBuilder(login_name),
 	}
+	tc.builder.
+		With("UserCopies", USER_COPIES_CTE).
+		Join("uc", "INNER JOIN UserCopies uc ON l.id = uc.link_id").
+		Where("section", "l.submitted_by != :login_name")
 
-	return q
+	return tc.build()
 }
 
-const COPIED_JOIN = `
-INNER JOIN UserCopies uc ON l.id = uc.link_id`
-
-const COPIED_WHERE = ` 
-AND l.submitted_by != ?`
+func (tc *TmapCopied) build() *TmapCopied {
+	tc.builder.BuildInto(tc.Query)
+	return tc
+}
 
 func (tc *TmapCopied) FromCats(cats []string) *TmapCopied {
-	tc.Query = FromUserOrGlobalCats(tc.Query, cats)
-	return tc
+	FromUserOrGlobalCats(tc.builder, cats)
+	return tc.build()
 }
 
 func (tc *TmapCopied) AsSignedInUser(req_user_id string) *TmapCopied {
-	fields_replacer := strings.NewReplacer(
-		TMAP_BASE_CTES, TMAP_BASE_CTES+","+TMAP_AUTH_CTES,
-		TMAP_BASE_FIELDS, TMAP_BASE_FIELDS+TMAP_AUTH_FIELDS,
-		COPIED_JOIN, COPIED_JOIN+TMAP_AUTH_JOINS,
-	)
-	tc.Text = fields_replacer.Replace(tc.Text)
-
-	new_args := make([]any, 0, len(tc.Args)+2)
-	
-	// login_name, earliest likers/copiers limit * 2
-	first_3_args := make([]any, 3)
-	copy(first_3_args, tc.Args[:3])
-
-	trailing_args := tc.Args[3:]
-
-	new_args = append(new_args, first_3_args...)
-	new_args = append(new_args, req_user_id, req_user_id)
-	new_args = append(new_args, trailing_args...)
-
-	tc.Args = new_args
-
-	return tc
+	WithSignedInUser(tc.builder, req_user_id)
+	return tc.build()
 }
 
 func (tc *TmapCopied) NSFW() *TmapCopied {
-	// Remove NSFW clause
-	tc.Text = strings.Replace(
-		tc.Text,
-		TMAP_NO_NSFW_CATS_WHERE,
-		"",
-		1,
-	)
-
-	// Swap AND to WHERE in WHERE clause
-	tc.Text = strings.Replace(
-		tc.Text,
-		"AND l.submitted_by !=",
-		"WHERE l.submitted_by !=",
-		1,
-	)
-	return tc
+	tc.builder.WithoutWhere("nsfw")
+	return tc.build()
 }
 
 func (tc *TmapCopied) SortByNewest() *TmapCopied {
-	tc.Text = strings.Replace(
-		tc.Text,
-		TMAP_DEFAULT_ORDER_BY,
-		TMAP_ORDER_BY_NEWEST,
-		1,
-	)
-
-	return tc
+	tc.builder.OrderBy(TMAP_ORDER_BY_NEWEST)
+	return tc.build()
 }
 
 func (tc *TmapCopied) DuringPeriod(period string) *TmapCopied {
-	if period == "all" {
-		return tc
-	}
-	
-	period_clause, err := GetPeriodClause(period)
-	if err != nil {
+	if err := WithTmapPeriod(tc.builder, period); err != nil {
 		tc.Error = err
 		return tc
 	}
 
-	period_clause = strings.Replace(
-		period_clause,
-		"submit_date",
-		"l.submit_date",
-		1,
-	)
-
-	for _, order_by := range []string{
-		TMAP_DEFAULT_ORDER_BY, 
-		TMAP_ORDER_BY_NEWEST,
-	} {
-		tc.Text = strings.Replace(
-			tc.Text,
-			order_by,
-			"\nAND " + period_clause + order_by,
-			1,
-		)
-	}
-
-	return tc
+	return tc.build()
 }
 
 func (tc *TmapCopied) WithURLContaining(snippet string) *TmapCopied {
-	for _, order_by := range []string{
-		TMAP_DEFAULT_ORDER_BY, 
-		TMAP_ORDER_BY_NEWEST,
-	} {
-		tc.Text = strings.Replace(
-			tc.Text,
-			order_by,
-			"\nAND " + "url LIKE ?" + order_by,
-			1,
-		)
-	} 
+	WithTmapURLContaining(tc.builder, snippet)
+	return tc.build()
+}
 
-	tc.Args = append(tc.Args, "%"+snippet+"%")
+func (tc *TmapCopied) MatchingSearch(match_arg string) *TmapCopied {
+	WithTmapSearch(tc.builder, match_arg)
+	return tc.build()
+}
 
-	return tc
+func (tc *TmapCopied) ForExport() *TmapCopied {
+	WithTmapExportFields(tc.builder)
+	return tc.build()
 }
 
 func (tc *TmapCopied) FromOptions(opts *model.TmapOptions) *TmapCopied {
@@ -589,195 +349,94 @@ func (tc *TmapCopied) FromOptions(opts *model.TmapOptions) *TmapCopied {
 		tc.WithURLContaining(opts.URLContains)
 	}
 
+	if opts.SearchMatchArg != "" {
+		tc.MatchingSearch(opts.SearchMatchArg)
+	}
+
 	return tc
 }
 
 type TmapTagged struct {
 	*Query
+	builder *SelectBuilder
 }
 
+// Links the user tagged but neither submitted nor copied: cats are
+// always the user's own
 func NewTmapTagged(login_name string) *TmapTagged {
-	q := &TmapTagged{
-		Query: &Query{
-			Text: "WITH " + TMAP_BASE_CTES + ",\n" +
-				USER_CATS_CTE + "," +
-				POSSIBLE_USER_SUMMARY_CTE + ",\n" +
-				USER_COPIES_CTE +
-				TAGGED_FIELDS +
-				TMAP_FROM +
-				TAGGED_JOINS +
-				TMAP_NO_NSFW_CATS_WHERE +
-				TAGGED_WHERE +
-				TMAP_DEFAULT_ORDER_BY,
-			// login_name used in UserCats, PossibleUserSummary, UserCopies, where
-			Args: []any{
-				mutil.EARLIEST_LIKERS_AND_COPIERS_LIMIT, 
-				mutil.EARLIEST_LIKERS_AND_COPIERS_LIMIT, 
-				login_name, 
-				login_name, 
-				login_name, 
-				login_name,
-			},
-		},
-	}
+	tt := &TmapTagged{
+		Query:   &Query{},
+		builder: NewTmapSectionBuilder(login_name),
+	}
+	tt.builder.
+		Without("PossibleUserCats").
+		With("UserCats", USER_CATS_CTE).
+		With("UserCopies", USER_COPIES_CTE).
+		Field("cats", "uct.user_cats").
+		Field("cats_from_user", "1").
+		WithoutJoin("puc").
+		Join("uct", "INNER JOIN UserCats uct ON l.id = uct.link_id").
+		Where("section", `l.submitted_by != :login_name
+AND l.id NOT IN
+	(SELECT link_id FROM UserCopies)`)
 
-	q.Text = strings.ReplaceAll(q.Text, "LOGIN_NAME", login_name)
-	return q
+	return tt.build()
 }
 
-var TAGGED_FIELDS = strings.Replace(
-	strings.Replace(
-		TMAP_BASE_FIELDS,
-		"COALESCE(puc.user_cats, l.global_cats) AS cats",
-		"uct.user_cats",
-		1,
-	),
-	`COALESCE(puc.cats_from_user,0) AS cats_from_user`,
-	"1 AS cats_from_user",
-	1,
-)
-
-var TAGGED_JOINS = strings.Replace(
-	TMAP_BASE_JOINS,
-	"LEFT JOIN PossibleUserCats puc ON l.id = puc.link_id",
-	"INNER JOIN UserCats uct ON l.id = uct.link_id",
-	1,
-) + strings.Replace(
-	COPIED_JOIN,
-	"INNER",
-	"LEFT",
-	1,
-)
-
-const TAGGED_WHERE = `
-AND l.submitted_by != ?
-AND l.id NOT IN
-	(SELECT link_id FROM UserCopies)`
+func (tt *TmapTagged) build() *TmapTagged {
+	tt.builder.BuildInto(tt.Query)
+	return tt
+}
 
 func (tt *TmapTagged) FromCats(cats []string) *TmapTagged {
 	if len(cats) == 0 || cats[0] == "" {
 		return tt
 	}
 
-	// Append MATCH clause
-	match_clause := `
-	AND uct.user_cats MATCH ?`
-
-	tt.Text = strings.Replace(
-		tt.Text,
-		TMAP_DEFAULT_ORDER_BY,
-		match_clause+TMAP_DEFAULT_ORDER_BY,
-		1,
-	)
-
-	// Append arg
-	match_arg := cats[0]
-	for i := 1; i < len(cats); i++ {
-		match_arg += " AND " + cats[i]
-	}
-	tt.Args = append(tt.Args, match_arg)
+	tt.builder.
+		Where("cats", "uct.user_cats MATCH :cats").
+		Arg("cats", strings.Join(cats, " AND "))
 
-	return tt
+	return tt.build()
 }
 
 func (tt *TmapTagged) AsSignedInUser(req_user_id string) *TmapTagged {
-	fields_replacer := strings.NewReplacer(
-		TMAP_BASE_CTES, TMAP_BASE_CTES+","+TMAP_AUTH_CTES,
-		TAGGED_FIELDS, TAGGED_FIELDS+TMAP_AUTH_FIELDS,
-		TAGGED_JOINS, TAGGED_JOINS+TMAP_AUTH_JOINS,
-	)
-	tt.Text = fields_replacer.Replace(tt.Text)
-
-	first_2_args := make([]any, 2)
-	copy(first_2_args, tt.Args[:2])
-
-	trailing_args := tt.Args[2:]
-
-	tt.Args = append(first_2_args, req_user_id, req_user_id)
-	tt.Args = append(tt.Args, trailing_args...)
-
-	return tt
+	WithSignedInUser(tt.builder, req_user_id)
+	return tt.build()
 }
 
 func (tt *TmapTagged) NSFW() *TmapTagged {
-	// Remove NSFW clause
-	tt.Text = strings.Replace(
-		tt.Text,
-		TMAP_NO_NSFW_CATS_WHERE,
-		"",
-		1,
-	)
-
-	// Swap AND to WHERE in WHERE clause
-	tt.Text = strings.Replace(
-		tt.Text,
-		"AND l.submitted_by !=",
-		"WHERE l.submitted_by !=",
-		1,
-	)
-	return tt
+	tt.builder.WithoutWhere("nsfw")
+	return tt.build()
 }
 
 func (tt *TmapTagged) SortByNewest() *TmapTagged {
-	tt.Text = strings.Replace(
-		tt.Text,
-		TMAP_DEFAULT_ORDER_BY,
-		TMAP_ORDER_BY_NEWEST,
-		1,
-	)
-
-	return tt
+	tt.builder.OrderBy(TMAP_ORDER_BY_NEWEST)
+	return tt.build()
 }
 
 func (tt *TmapTagged) DuringPeriod(period string) *TmapTagged {
-	if period == "all" {
-		return tt
-	}
-	
-	period_clause, err := GetPeriodClause(period)
-	if err != nil {
+	if err := WithTmapPeriod(tt.builder, period); err != nil {
 		tt.Error = err
 		return tt
 	}
 
-	period_clause = strings.Replace(
-		period_clause,
-		"submit_date",
-		"l.submit_date",
-		1,
-	)
-
-for _, order_by := range []string{
-		TMAP_DEFAULT_ORDER_BY, 
-		TMAP_ORDER_BY_NEWEST,
-	} {
-		tt.Text = strings.Replace(
-			tt.Text,
-			order_by,
-			"\nAND " + period_clause + order_by,
-			1,
-		)
-	}
-
-	return tt
+	return tt.build()
 }
 
 func (tt *TmapTagged) WithURLContaining(snippet string) *TmapTagged {
-	for _, order_by := range []string{
-		TMAP_DEFAULT_ORDER_BY, 
-		TMAP_ORDER_BY_NEWEST,
-	} {
-		tt.Text = strings.Replace(
-			tt.Text,
-			order_by,
-			"\nAND " + "url LIKE ?" + order_by,
-			1,
-		)
-	} 
+	WithTmapURLContaining(tt.builder, snippet)
+	return tt.build()
+}
 
-	tt.Args = append(tt.Args, "%"+snippet+"%")
+func (tt *TmapTagged) MatchingSearch(match_arg string) *TmapTagged {
+	WithTmapSearch(tt.builder, match_arg)
+	return tt.build()
+}
 
-	return tt
+func (tt *TmapTagged) ForExport() *TmapTagged {
+	WithTmapExportFields(tt.builder)
+	return tt.build()
 }
 
 func (tt *TmapTagged) FromOptions(opts *model.TmapOptions) *TmapTagged {
@@ -805,276 +464,152 @@ func (tt *TmapTagged) FromOptions(opts *model.TmapOptions) *TmapTagged {
 		tt.WithURLContaining(opts.URLContains)
 	}
 
+	if opts.SearchMatchArg != "" {
+		tt.MatchingSearch(opts.SearchMatchArg)
+	}
+
 	return tt
 }
 
-func FromUserOrGlobalCats(q *Query, cats []string) *Query {
+// Fields, CTEs and joins shared by all tmap sections, which add a
+// "section" WHERE condition (and TmapTagged swaps in UserCats)
+func NewTmapSectionBuilder(login_name string) *SelectBuilder {
+	b := NewSelectBuilder().
+		With("PossibleUserCats", POSSIBLE_USER_CATS_CTE).
+		With("PossibleUserSummary", POSSIBLE_USER_SUMMARY_CTE).
+		Field("link_id", "l.id").
+		Field("url", "l.url").
+		Field("login_name", "l.submitted_by").
+		Field("submit_date", "l.submit_date").
+		Field("cats", "COALESCE(puc.user_cats, l.global_cats)").
+		Field("cats_from_user", "COALESCE(puc.cats_from_user, 0)").
+		Field("summary", "COALESCE(pus.user_summary, l.global_summary, '')").
+		From("Links l").
+		Join("puc", "LEFT JOIN PossibleUserCats puc ON l.id = puc.link_id").
+		Join("pus", "LEFT JOIN PossibleUserSummary pus ON l.id = pus.link_id")
+
+	WithLinkCounts(b).
+		Field("img_file", "COALESCE(l.img_file, '')")
+
+	return WithLinkHealth(b).
+		Where("nsfw", LINKS_NO_NSFW_CATS_WHERE).
+		OrderBy(TMAP_DEFAULT_ORDER_BY).
+		Arg("login_name", login_name)
+}
+
+// Global cats and summary alongside the user's (or, failing those,
+// the same global) cats and summary, scanned last by ScanTmapExportLinks
+func WithTmapExportFields(b *SelectBuilder) *SelectBuilder {
+	return b.
+		Field("global_cats", "COALESCE(l.global_cats, '')").
+		Field("global_summary", "COALESCE(l.global_summary, '')")
+}
+
+// Cats come from the user's tag if they have one, otherwise from global
+// cats: either may match. Not for TmapTagged, where cats are always
+// the user's.
+func FromUserOrGlobalCats(b *SelectBuilder, cats []string) *SelectBuilder {
 	if len(cats) == 0 || cats[0] == "" {
-		return q
+		return b
 	}
 
-	// Append MATCH clause to PossibleUserCats CTE
-	PUC_WHERE := "WHERE submitted_by = ?"
-	q.Text = strings.Replace(
-		q.Text,
-		PUC_WHERE,
-		PUC_WHERE+`
-		AND cats MATCH ?`,
-		1,
-	)
-
-	// Insert GlobalCatsFTS CTE
-	q.Text = strings.Replace(
-		q.Text,
-		TMAP_BASE_FIELDS,
-		GLOBAL_CATS_CTE+TMAP_BASE_FIELDS,
-		1,
-	)
+	return b.
+		With("PossibleUserCats", POSSIBLE_USER_CATS_CTE+`
+	AND cats MATCH :cats`).
+		With("GlobalCatsFTS", `
+	SELECT
+		link_id,
+		global_cats
+	FROM global_cats_fts
+	WHERE global_cats MATCH :cats`).
+		Join("gc", "LEFT JOIN GlobalCatsFTS gc ON l.id = gc.link_id").
+		Where("cats", `(
+	gc.global_cats IS NOT NULL
+	OR
+	puc.user_cats IS NOT NULL
+)`).
+		Arg("cats", strings.Join(cats, " AND "))
+}
 
-	// Build MATCH arg
-	match_arg := cats[0]
-	for i := 1; i < len(cats); i++ {
-		match_arg += " AND " + cats[i]
+func WithTmapPeriod(b *SelectBuilder, period string) error {
+	if period == "all" {
+		return nil
 	}
 
-	// Rebuild args with match_arg * 2 (once for PossibleUserCats CTE, once
-	// for GlobalCatsFTS CTE)
-
-	// TmapSubmitted args order: likers/copiers limit, likers/copiers limit, login_name, MATCH, login_name, MATCH, login_name
-	// TmapCopied args order: login_name, likers/copiers limit, likers/copiers limit,  login_name, MATCH, login_name, MATCH, login_name
-
-	// (Only TmapCopied and TmapTagged contain USER_COPIES_CTE, and TmapTagged
-	// does not call this method, so can check for presence of USER_COPIES_CTE
-	// to determine whether TmapSubmitted or TmapCopied)
-
-	// 4th arg is login_name regardless
-	login_name := q.Args[3].(string)
-
-	// TmapCopied
-	if strings.Contains(q.Text, USER_COPIES_CTE) {
-		q.Args = []any{
-			login_name, 
-			mutil.EARLIEST_LIKERS_AND_COPIERS_LIMIT, 
-			mutil.EARLIEST_LIKERS_AND_COPIERS_LIMIT, 
-			login_name, 
-			match_arg, 
-			login_name, match_arg, 
-			login_name,
-		}
-		// TmapSubmitted
-	} else {
-		q.Args = []any{
-			mutil.EARLIEST_LIKERS_AND_COPIERS_LIMIT, 
-			mutil.EARLIEST_LIKERS_AND_COPIERS_LIMIT,
-			login_name, 
-			match_arg, 
-			login_name, 
-			match_arg, 
-			login_name,
-		}
+	period_clause, err := GetPeriodClause(period)
+	if err != nil {
+		return err
 	}
 
-	// Insert GLOBAL_CATS_JOIN
-	q.Text = strings.Replace(
-		q.Text,
-		TMAP_BASE_JOINS,
-		TMAP_BASE_JOINS+GLOBAL_CATS_JOIN,
-		1,
-	)
-
-	// Insert final AND clause
-	and_clause := `
-	AND (
-	gc.global_cats IS NOT NULL
-	OR
-	puc.user_cats IS NOT NULL
-)`
-	q.Text = strings.Replace(
-		q.Text,
-		TMAP_DEFAULT_ORDER_BY,
-		and_clause+TMAP_DEFAULT_ORDER_BY,
+	b.Where("period", strings.Replace(
+		period_clause,
+		"submit_date",
+		"l.submit_date",
 		1,
-	)
+	))
 
-	return q
+	return nil
 }
 
-const GLOBAL_CATS_CTE = `,
-	GlobalCatsFTS AS (
-		SELECT
-			link_id,
-			global_cats
-		FROM global_cats_fts
-		WHERE global_cats MATCH ?
-	)`
+func WithTmapURLContaining(b *SelectBuilder, snippet string) *SelectBuilder {
+	return b.
+		Where("url_contains", "url LIKE :url_contains").
+		Arg("url_contains", "%"+snippet+"%")
+}
 
-const GLOBAL_CATS_JOIN = `
-LEFT JOIN GlobalCatsFTS gc ON l.id = gc.link_id`
+// Ranking and snippets are applied in Go after scanning since tmap
+// sections are sorted and paginated there anyway
+func WithTmapSearch(b *SelectBuilder, match_arg string) *SelectBuilder {
+	return b.
+		Where("search", `l.id IN (
+	SELECT link_id 
+	FROM link_search_fts 
+	WHERE link_search_fts MATCH :search
+)`).
+		Arg("search", match_arg)
+}
 
-const USER_CATS_CTE = `UserCats AS (
+const USER_CATS_CTE = `
     SELECT link_id, cats as user_cats
     FROM user_cats_fts
-    WHERE submitted_by = ?
-)`
-
-// Base
-const TMAP_BASE_CTES = `SummaryCount AS (
-    SELECT link_id, COUNT(*) AS summary_count
-    FROM Summaries
-    GROUP BY link_id
-),
-LikeCount AS (
-    SELECT link_id, COUNT(*) AS like_count
-    FROM "Link Likes"
-    GROUP BY link_id
-),
-EarliestLikers AS (
-    SELECT 
-        link_id,
-        GROUP_CONCAT(login_name, ', ') AS earliest_likers
-    FROM (
-        SELECT 
-            ll.link_id,
-            u.login_name,
-            ROW_NUMBER() OVER (PARTITION BY ll.link_id ORDER BY ll.timestamp ASC) as row_num
-        FROM "Link Likes" ll
-        JOIN Users u ON ll.user_id = u.id
-		ORDER BY ll.timestamp ASC, u.login_name ASC
-    ) ranked
-    WHERE row_num <= ?
-    GROUP BY link_id
-),
-CopyCount AS (
-	SELECT link_id, COUNT(*) AS copy_count
-	FROM "Link Copies"
-	GROUP BY link_id
-),
-EarliestCopiers AS (
-    SELECT 
-        link_id,
-        GROUP_CONCAT(login_name, ', ') AS earliest_copiers
-    FROM (
-        SELECT 
-            lc.link_id,
-            u.login_name,
-            ROW_NUMBER() OVER (PARTITION BY lc.link_id ORDER BY lc.timestamp ASC) as row_num
-        FROM "Link Copies" lc
-        JOIN Users u ON lc.user_id = u.id
-		ORDER BY lc.timestamp ASC, u.login_name ASC
-    ) ranked
-    WHERE row_num <= ?
-    GROUP BY link_id
-),
-ClickCount AS (
-	SELECT link_id, count(*) AS click_count
-	FROM Clicks
-	GROUP BY link_id
-),
-TagCount AS (
-    SELECT link_id, COUNT(*) AS tag_count
-    FROM Tags
-    GROUP BY link_id
-)`
+    WHERE submitted_by = :login_name`
 
 const POSSIBLE_USER_CATS_CTE = `
-PossibleUserCats AS (
     SELECT 
 		link_id, 
 		cats AS user_cats,
 		(cats IS NOT NULL) AS cats_from_user
     FROM user_cats_fts
-    WHERE submitted_by = ?
-)`
+    WHERE submitted_by = :login_name`
 
 const POSSIBLE_USER_SUMMARY_CTE = `
-PossibleUserSummary AS (
     SELECT
         link_id, 
 		text as user_summary
     FROM Summaries
     INNER JOIN Users u ON u.id = submitted_by
-	WHERE u.login_name = ?
-)`
+	WHERE u.login_name = :login_name`
 
-const USER_COPIES_CTE = `UserCopies AS (
+const USER_COPIES_CTE = `
     SELECT lc.link_id
     FROM "Link Copies" lc
     INNER JOIN Users u ON u.id = lc.user_id
-    WHERE u.login_name = ?
-)`
-
-const TMAP_BASE_FIELDS = `
-SELECT 
-	l.id AS link_id,
-    l.url,
-    l.submitted_by AS login_name,
-    l.submit_date,
-    COALESCE(puc.user_cats, l.global_cats) AS cats,
-    COALESCE(puc.cats_from_user,0) AS cats_from_user,
-    COALESCE(pus.user_summary, l.global_summary, '') AS summary,
-    COALESCE(sc.summary_count, 0) AS summary_count,
-    COALESCE(lc.like_count, 0) AS like_count,
-	COALESCE(el.earliest_likers, '') AS earliest_likers,
-	COALESCE(cpc.copy_count, 0) AS copy_count,
-	COALESCE(ec.earliest_copiers, '') AS earliest_copiers,
-	COALESCE(clc.click_count, 0) AS click_count,
-    COALESCE(tc.tag_count, 0) AS tag_count,
-    COALESCE(l.img_file, '') AS img_file`
-
-const TMAP_FROM = LINKS_FROM
-
-const TMAP_BASE_JOINS = `
-LEFT JOIN PossibleUserCats puc ON l.id = puc.link_id
-LEFT JOIN PossibleUserSummary pus ON l.id = pus.link_id
-LEFT JOIN LikeCount lc ON l.id = lc.link_id
-LEFT JOIN EarliestLikers el ON l.id = el.link_id
-LEFT JOIN CopyCount cpc ON l.id = cpc.link_id
-LEFT JOIN EarliestCopiers ec ON l.id = ec.link_id
-LEFT JOIN ClickCount clc ON l.id = clc.link_id
-LEFT JOIN TagCount tc ON l.id = tc.link_id
-LEFT JOIN SummaryCount sc ON l.id = sc.link_id`
-
-const TMAP_NO_NSFW_CATS_WHERE = LINKS_NO_NSFW_CATS_WHERE
+    WHERE u.login_name = :login_name`
 
 const TMAP_DEFAULT_ORDER_BY = `
-ORDER BY 
-	lc.like_count DESC, 
-	cpc.copy_count DESC,
-	clc.click_count DESC,
-	tc.tag_count DESC,
-	sc.summary_count DESC, l.id DESC,
+	ls.like_count DESC, 
+	ls.copy_count DESC,
+	ls.click_count DESC,
+	ls.tag_count DESC,
+	ls.summary_count DESC, l.id DESC,
 	l.submit_date DESC,
-	l.id DESC;`
+	l.id DESC`
 
 const TMAP_ORDER_BY_NEWEST = `
-ORDER BY 
 	l.submit_date DESC, 
-	lc.like_count DESC, 
-	cpc.copy_count DESC,
-	clc.click_count DESC,
-	tc.tag_count DESC,
-	sc.summary_count DESC, 
-	l.id DESC;`
-
-// Authenticated
-const TMAP_AUTH_CTES = `
-IsLiked AS (
-	SELECT link_id, COUNT(*) AS is_liked
-	FROM "Link Likes"
-	WHERE user_id = ?
-	GROUP BY id
-),
-IsCopied AS (
-	SELECT link_id, COUNT(*) AS is_copied
-	FROM "Link Copies"
-	WHERE user_id = ?
-	GROUP BY id
-)`
-
-const TMAP_AUTH_FIELDS = `, 
-	COALESCE(is_liked,0) as is_liked, 
-	COALESCE(is_copied,0) as is_copied`
-
-const TMAP_AUTH_JOINS = `
-	LEFT JOIN IsLiked il ON l.id = il.link_id
-	LEFT JOIN IsCopied ic ON l.id = ic.link_id`
+	ls.like_count DESC, 
+	ls.copy_count DESC,
+	ls.click_count DESC,
+	ls.tag_count DESC,
+	ls.summary_count DESC, 
+	l.id DESC`
@@ -379,6 +379,7 @@ func TestNewTmapSubmitted(t *testing.T) {
 			&l.ClickCount,
 			&l.TagCount,
 			&l.PreviewImgFilename,
+			&l.Health,
 		); err != nil {
 			t.Fatal(err)
 		} else if l.SubmittedBy != TEST_REQ_LOGIN_NAME {
@@ -435,6 +436,7 @@ func TestTmapSubmittedFromCats(t *testing.T) {
 			&l.ClickCount,
 			&l.TagCount,
 			&l.PreviewImgFilename,
+			&l.Health,
 		); err != nil {
 			t.Fatal(err)
 		} else if !strings.Contains(l.Cats, test_cats[0]) || !strings.Contains(l.Cats, test_cats[1]) {
@@ -476,6 +478,7 @@ func TestTmapSubmittedAsSignedInUser(t *testing.T) {
 			&l.ClickCount,
 			&l.TagCount,
 			&l.PreviewImgFilename,
+			&l.Health,
 			&l.IsLiked,
 			&l.IsCopied,
 		); err != nil {
@@ -511,6 +514,7 @@ func TestTmapSubmittedNSFW(t *testing.T) {
 			&l.ClickCount,
 			&l.TagCount,
 			&l.PreviewImgFilename,
+			&l.Health,
 		); err != nil {
 			t.Fatal(err)
 		} else if strings.Contains(l.Cats, "NSFW") {
@@ -550,6 +554,7 @@ func TestTmapSubmittedDuringPeriod(t *testing.T) {
 			&l.ClickCount,
 			&l.TagCount,
 			&l.PreviewImgFilename,
+			&l.Health,
 		); err != nil {
 			t.Fatal(err)
 		} else {
@@ -579,6 +584,7 @@ func TestTmapSubmittedDuringPeriod(t *testing.T) {
 			&l.ClickCount,
 			&l.TagCount,
 			&l.PreviewImgFilename,
+			&l.Health,
 		); err != nil {
 			t.Fatal(err)
 		} else {
@@ -639,6 +645,7 @@ func TestTmapSubmittedDuringPeriod(t *testing.T) {
 			&l.ClickCount,
 			&l.TagCount,
 			&l.PreviewImgFilename,
+			&l.Health,
 		); err != nil {
 			t.Fatal(err)
 		} else {
@@ -694,6 +701,7 @@ func TestTmapSubmittedWithURLContaining(t *testing.T) {
 			&link.ClickCount,
 			&link.TagCount,
 			&link.PreviewImgFilename,
+			&link.Health,
 		)
 		if err != nil {
 			t.Fatal(err)
@@ -738,6 +746,7 @@ func TestNewTmapCopied(t *testing.T) {
 			&l.ClickCount,
 			&l.TagCount,
 			&l.PreviewImgFilename,
+			&l.Health,
 		); err != nil {
 			t.Fatal(err)
 		} else if l.TagCount == 0 {
@@ -791,6 +800,7 @@ func TestTmapCopiedFromCats(t *testing.T) {
 			&l.ClickCount,
 			&l.TagCount,
 			&l.PreviewImgFilename,
+			&l.Health,
 		); err != nil {
 			t.Fatal(err)
 		} else if !strings.Contains(l.Cats, test_cats[0]) || !strings.Contains(l.Cats, test_cats[1]) {
@@ -846,6 +856,7 @@ func TestTmapCopiedAsSignedInUser(t *testing.T) {
 			&l.ClickCount,
 			&l.TagCount,
 			&l.PreviewImgFilename,
+			&l.Health,
 			&l.IsLiked,
 			&l.IsCopied,
 		); err != nil {
@@ -934,6 +945,7 @@ func TestTmapCopiedAsSignedInUser(t *testing.T) {
 			&l.ClickCount,
 			&l.TagCount,
 			&l.PreviewImgFilename,
+			&l.Health,
 			&l.IsLiked,
 			&l.IsCopied,
 		); err != nil {
@@ -984,6 +996,7 @@ func TestTmapCopiedNSFW(t *testing.T) {
 			&l.ClickCount,
 			&l.TagCount,
 			&l.PreviewImgFilename,
+			&l.Health,
 		); err != nil {
 			t.Fatal(err)
 		} else if strings.Contains(l.Cats, "NSFW") {
@@ -1023,6 +1036,7 @@ func TestTmapCopiedDuringPeriod(t *testing.T) {
 			&l.ClickCount,
 			&l.TagCount,
 			&l.PreviewImgFilename,
+			&l.Health,
 		); err != nil {
 			t.Fatal(err)
 		}
@@ -1055,6 +1069,7 @@ func TestTmapCopiedDuringPeriod(t *testing.T) {
 			&l.ClickCount,
 			&l.TagCount,
 			&l.PreviewImgFilename,
+			&l.Health,
 		); err != nil {
 			t.Fatal(err)
 		}
@@ -1090,6 +1105,7 @@ func TestTmapCopiedDuringPeriod(t *testing.T) {
 			&l.ClickCount,
 			&l.TagCount,
 			&l.PreviewImgFilename,
+			&l.Health,
 		); err != nil {
 			t.Fatal(err)
 		}
@@ -1146,6 +1162,7 @@ func TestTmapCopiedWithURLContaining(t *testing.T) {
 			&link.ClickCount,
 			&link.TagCount,
 			&link.PreviewImgFilename,
+			&link.Health,
 		)
 		if err != nil {
 			t.Fatal(err)
@@ -1189,6 +1206,7 @@ func TestNewTmapTagged(t *testing.T) {
 			&l.ClickCount,
 			&l.TagCount,
 			&l.PreviewImgFilename,
+			&l.Health,
 		); err != nil {
 			t.Fatal(err)
 		} else if l.TagCount == 0 {
@@ -1238,6 +1256,7 @@ func TestTmapTaggedFromCats(t *testing.T) {
 			&l.ClickCount,
 			&l.TagCount,
 			&l.PreviewImgFilename,
+			&l.Health,
 		); err != nil {
 			t.Fatal(err)
 		} else if !strings.Contains(l.Cats, test_cats[0]) || !strings.Contains(l.Cats, test_cats[1]) {
@@ -1293,6 +1312,7 @@ func TestTmapTaggedAsSignedInUser(t *testing.T) {
 			&l.ClickCount,
 			&l.TagCount,
 			&l.PreviewImgFilename,
+			&l.Health,
 			&l.IsLiked,
 			&l.IsCopied,
 		); err != nil {
@@ -1329,6 +1349,7 @@ func TestTmapTaggedNSFW(t *testing.T) {
 			&l.ClickCount,
 			&l.TagCount,
 			&l.PreviewImgFilename,
+			&l.Health,
 		); err != nil {
 			t.Fatal(err)
 		} else if strings.Contains(l.Cats, "NSFW") {
@@ -1368,6 +1389,7 @@ func TestTmapTaggedDuringPeriod(t *testing.T) {
 			&l.ClickCount,
 			&l.TagCount,
 			&l.PreviewImgFilename,
+			&l.Health,
 		); err != nil {
 			t.Fatal(err)
 		}
@@ -1400,6 +1422,7 @@ func TestTmapTaggedDuringPeriod(t *testing.T) {
 			&l.ClickCount,
 			&l.TagCount,
 			&l.PreviewImgFilename,
+			&l.Health,
 		); err != nil {
 			t.Fatal(err)
 		}
@@ -1435,6 +1458,7 @@ func TestTmapTaggedDuringPeriod(t *testing.T) {
 			&l.ClickCount,
 			&l.TagCount,
 			&l.PreviewImgFilename,
+			&l.Health,
 		); err != nil {
 			t.Fatal(err)
 		}
@@ -1510,6 +1534,7 @@ func TestTmapTaggedWithURLContaining(t *testing.T) {
 			&link.ClickCount,
 			&link.TagCount,
 			&link.PreviewImgFilename,
+			&link.Health,
 		)
 		if err != nil {
 			t.Fatal(err)
@@ -1530,7 +1555,7 @@ func TestFromUserOrGlobalCats(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	tmap_submitted.Query = FromUserOrGlobalCats(tmap_submitted.Query, test_cats)
+	FromUserOrGlobalCats(tmap_submitted.builder, test_cats).BuildInto(tmap_submitted.Query)
 	rows, err := TestClient.Query(tmap_submitted.Text, tmap_submitted.Args...)
 	if err != nil {
 		t.Fatal(err)
@@ -1556,6 +1581,7 @@ func TestFromUserOrGlobalCats(t *testing.T) {
 			&l.ClickCount,
 			&l.TagCount,
 			&l.PreviewImgFilename,
+			&l.Health,
 		); err != nil {
 			t.Fatal(err)
 		} else if !strings.Contains(l.Cats, test_cats[0]) || !strings.Contains(l.Cats, test_cats[1]) {
@@ -1569,7 +1595,7 @@ func TestFromUserOrGlobalCats(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	tmap_copied.Query = FromUserOrGlobalCats(tmap_copied.Query, test_cats)
+	FromUserOrGlobalCats(tmap_copied.builder, test_cats).BuildInto(tmap_copied.Query)
 	rows, err = TestClient.Query(tmap_copied.Text, tmap_copied.Args...)
 	if err != nil {
 		t.Fatal(err)
@@ -1594,6 +1620,7 @@ func TestFromUserOrGlobalCats(t *testing.T) {
 			&l.ClickCount,
 			&l.TagCount,
 			&l.PreviewImgFilename,
+			&l.Health,
 		); err != nil {
 			t.Fatal(err)
 		} else if !strings.Contains(l.Cats, test_cats[0]) || !strings.Contains(l.Cats, test_cats[1]) {
@@ -1603,3 +1630,69 @@ func TestFromUserOrGlobalCats(t *testing.T) {
 
 	// TmapTagged does not use FromUserOrGlobalCats()
 }
+
+func TestWithTmapExportFields(t *testing.T) {
+	for _, section_sql := range []*Query{
+		NewTmapSubmitted(TEST_LOGIN_NAME).NSFW().ForExport().Query,
+		NewTmapCopied(TEST_LOGIN_NAME).NSFW().ForExport().Query,
+		NewTmapTagged(TEST_LOGIN_NAME).NSFW().ForExport().Query,
+	} {
+		if section_sql.Error != nil {
+			t.Fatal(section_sql.Error)
+		}
+
+		rows, err := TestClient.Query(section_sql.Text, section_sql.Args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var l model.TmapExportLink
+			if err := rows.Scan(
+				&l.ID,
+				&l.URL,
+				&l.SubmittedBy,
+				&l.SubmitDate,
+				&l.Cats,
+				&l.CatsFromUser,
+				&l.Summary,
+				&l.SummaryCount,
+				&l.LikeCount,
+				&l.EarliestLikers,
+				&l.CopyCount,
+				&l.EarliestCopiers,
+				&l.ClickCount,
+				&l.TagCount,
+				&l.PreviewImgFilename,
+				&l.Health,
+				&l.GlobalCats,
+				&l.GlobalSummary,
+			); err != nil {
+				t.Fatal(err)
+			}
+
+			// cats not from user: global cats
+			if !l.CatsFromUser && l.Cats != l.GlobalCats {
+				t.Fatalf("link %s: got cats %s, want global cats %s", l.ID, l.Cats, l.GlobalCats)
+			}
+
+			var global_cats, global_summary string
+			if err := TestClient.QueryRow(
+				"SELECT global_cats, COALESCE(global_summary, '') FROM Links WHERE id = ?;",
+				l.ID,
+			).Scan(&global_cats, &global_summary); err != nil {
+				t.Fatal(err)
+			} else if l.GlobalCats != global_cats || l.GlobalSummary != global_summary {
+				t.Fatalf(
+					"link %s: got global cats %s, summary %s, want %s, %s",
+					l.ID,
+					l.GlobalCats,
+					l.GlobalSummary,
+					global_cats,
+					global_summary,
+				)
+			}
+		}
+	}
+}